@@ -13,7 +13,8 @@ import (
 )
 
 // main wires up dependencies in order — config, database, cache, file store —
-// then runs the HTTP server, which blocks until shutdown.
+// then runs the HTTP server, which blocks until shutdown, and closes the file
+// store.
 func main() {
 	conf, err := config.LoadConfig(".")
 	if err != nil {
@@ -38,7 +39,12 @@ func main() {
 		Conf:  conf,
 	})
 
-	if err = server.Run(); err != nil {
+	err = server.Run()
+	// Saves made while serving may still be queued for the secondaries.
+	if cerr := fs.CloseStore(); cerr != nil {
+		log.Printf("failed to close file store: %s", cerr.Error())
+	}
+	if err != nil {
 		log.Fatalf("failed to start server: %s", err.Error())
 	}
 }
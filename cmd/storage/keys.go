@@ -0,0 +1,64 @@
+package main
+
+import (
+	"auxstream/internal/db"
+	fs "auxstream/internal/storage"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func migrateKeysCmd() *cobra.Command {
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "migrate-keys",
+		Short: "Rewrite S3 object URLs stored in the database as object keys",
+		Long: `Tracks uploaded to the s3 file store before it issued object keys hold the
+object's URL instead. Read and Remove still accept those, but this rewrites
+every such reference (track audio, thumbnails and track files) to the key, so
+references stay valid whichever endpoint or addressing style the bucket is
+reached through. URLs for other buckets or hosts are left alone. Safe to run
+more than once.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			database, err := connect()
+			if err != nil {
+				return err
+			}
+			defer db.CloseDB(database)
+
+			s3, ok := fs.PrimaryStore(fs.Store).(*fs.S3Store)
+			if !ok {
+				return errors.New("migrate-keys needs FILE_STORE=s3")
+			}
+
+			refs := db.NewBlobRefRepo(database)
+			var seen, rewritten int
+			err = refs.EachBlobRef(cmd.Context(), func(ref db.BlobRef) error {
+				seen++
+				key, ok := s3.KeyFromLocation(ref.File)
+				if !ok {
+					return nil
+				}
+				rewritten++
+				if dryRun {
+					fmt.Fprintf(cmd.OutOrStdout(), "%s %s: %s -> %s\n", ref.Owner, ref.ID, ref.File, key)
+					return nil
+				}
+				return refs.SetBlobFile(cmd.Context(), ref, key)
+			})
+			if err != nil {
+				return err
+			}
+			verb := "rewrote"
+			if dryRun {
+				verb = "would rewrite"
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s %d of %d references\n", verb, rewritten, seen)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the references that would be rewritten without changing them")
+	return cmd
+}
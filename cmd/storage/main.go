@@ -0,0 +1,55 @@
+package main
+
+import (
+	"auxstream/config"
+	"auxstream/internal/db"
+	fs "auxstream/internal/storage"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// configPath is the directory holding app.env, shared by every subcommand.
+var configPath string
+
+// connect loads the config, opens the database and selects the configured
+// file store. Query logging is silenced so reports written to stdout stay
+// machine-readable.
+func connect() (*gorm.DB, error) {
+	conf, err := loadStore()
+	if err != nil {
+		return nil, err
+	}
+	database := db.InitDB(conf)
+	database.Logger = gormlogger.Default.LogMode(gormlogger.Silent)
+	return database, nil
+}
+
+// loadStore loads the config and selects the configured file store, for
+// subcommands that do not need the database.
+func loadStore() (config.Config, error) {
+	conf, err := config.LoadConfig(configPath)
+	if err != nil {
+		return conf, fmt.Errorf("load config: %w", err)
+	}
+	if err := fs.SetFileStore(conf); err != nil {
+		return conf, fmt.Errorf("set file store: %w", err)
+	}
+	return conf, nil
+}
+
+func main() {
+	rootCmd := &cobra.Command{
+		Use:   "storage",
+		Short: "File store maintenance",
+	}
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", ".", "Path to config directory")
+	rootCmd.AddCommand(scrubCmd(), purgeCmd(), migrateCmd(), migrateKeysCmd(), rotateKeysCmd())
+
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
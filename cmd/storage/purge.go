@@ -0,0 +1,46 @@
+package main
+
+import (
+	"auxstream/internal/db"
+	"auxstream/internal/scrub"
+	fs "auxstream/internal/storage"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func purgeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "purge",
+		Short: "Remove blobs left by deleted tracks and replaced audio",
+		Long: `Removes the blobs scheduled for removal when tracks were deleted or their
+audio replaced, once their grace period is over. Blobs a live row names again
+are kept. The JSON report goes to stdout. The command exits non-zero when any
+blob could not be removed; those are retried by the next purge.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			database, err := connect()
+			if err != nil {
+				return err
+			}
+			defer db.CloseDB(database)
+
+			report, err := scrub.Purge(cmd.Context(), db.NewBlobRemovalRepo(database), fs.Store, time.Now())
+			if err != nil {
+				return err
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				return err
+			}
+			if n := report.Failed(); n > 0 {
+				return fmt.Errorf("%d blobs could not be removed", n)
+			}
+			return nil
+		},
+	}
+}
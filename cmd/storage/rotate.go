@@ -0,0 +1,54 @@
+package main
+
+import (
+	fs "auxstream/internal/storage"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func rotateKeysCmd() *cobra.Command {
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "rotate-keys",
+		Short: "Rewrap blob data keys with the active encryption key",
+		Long: `Each encrypted blob's data key is stored wrapped by a master key from
+ENCRYPTION_KEYS. After putting a new key first in ENCRYPTION_KEYS (keeping the
+old ones after it), this rewraps every data key still wrapped by an old key
+with the new one. Blobs themselves are not rewritten. Once it completes, the
+old keys can be dropped from ENCRYPTION_KEYS. Safe to run more than once.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if _, err := loadStore(); err != nil {
+				return err
+			}
+			stores := fs.EncryptedStores(fs.Store)
+			if len(stores) == 0 {
+				return errors.New("rotate-keys needs ENCRYPTION_KEYS")
+			}
+
+			var rotated, seen int
+			for _, st := range stores {
+				n, total, err := st.RotateKeys(cmd.Context(), dryRun, func(name, from, to string) {
+					if dryRun {
+						fmt.Fprintf(cmd.OutOrStdout(), "%s: %s -> %s\n", name, from, to)
+					}
+				})
+				rotated += n
+				seen += total
+				if err != nil {
+					return err
+				}
+			}
+			verb := "rewrapped"
+			if dryRun {
+				verb = "would rewrap"
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s %d of %d data keys\n", verb, rotated, seen)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the blobs whose keys would be rewrapped without changing them")
+	return cmd
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"auxstream/internal/db"
+	"auxstream/internal/scrub"
+	fs "auxstream/internal/storage"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// errUnhealthy makes the command exit non-zero once the report is written, so
+// schedulers can alert on a failed scrub.
+var errUnhealthy = errors.New("scrub found problems")
+
+func scrubCmd() *cobra.Command {
+	var (
+		output        string
+		orphans       string
+		quarantineDir string
+		concurrency   int
+		minAge        time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "scrub",
+		Short: "Verify stored blobs against the database and find orphans",
+		Long: `Reads every blob a track or track file refers to and checks it exists and
+matches its recorded size and SHA-256, then lists blobs no row refers to.
+The JSON report goes to stdout (or --output). The command exits non-zero when
+any problem or orphan is found.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			opts := scrub.Options{Orphans: orphans, Concurrency: concurrency, MinOrphanAge: minAge}
+			switch orphans {
+			case scrub.OrphanReport, scrub.OrphanDelete:
+			case scrub.OrphanQuarantine:
+				opts.Quarantine = fs.NewLocalStore(quarantineDir)
+			default:
+				return fmt.Errorf("unknown --orphans action %q", orphans)
+			}
+
+			database, err := connect()
+			if err != nil {
+				return err
+			}
+			defer db.CloseDB(database)
+
+			report, err := scrub.Run(cmd.Context(), db.NewBlobRefRepo(database), fs.Store, opts)
+			if err != nil {
+				return err
+			}
+
+			var out io.Writer = os.Stdout
+			if output != "-" {
+				f, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				out = f
+			}
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				return err
+			}
+			if !report.Healthy() {
+				return errUnhealthy
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "-", `Report file ("-" for stdout)`)
+	cmd.Flags().StringVar(&orphans, "orphans", scrub.OrphanReport, "What to do with unreferenced blobs: report, delete or quarantine")
+	cmd.Flags().StringVar(&quarantineDir, "quarantine-dir", "quarantine", "Local directory quarantined orphans are moved to")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 4, "Blobs verified in parallel")
+	cmd.Flags().DurationVar(&minAge, "min-orphan-age", time.Hour, "Leave unreferenced blobs younger than this alone (uploads in flight)")
+	return cmd
+}
@@ -18,11 +18,13 @@ import (
 
 // main runs the catalog indexer either as a one-shot job (-once) or as a
 // long-lived worker that re-indexes on a fixed interval until it receives
-// SIGINT/SIGTERM.
+// SIGINT/SIGTERM. With -uploads it instead processes queued bulk uploads.
 func main() {
 	intervalHours := flag.Int("interval", 24, "Indexing interval in hours")
 	configPath := flag.String("config", ".", "Path to config directory")
 	runOnce := flag.Bool("once", false, "Run indexing once and exit")
+	uploads := flag.Bool("uploads", false, "Process queued bulk uploads instead of indexing")
+	concurrency := flag.Int("concurrency", 2, "Upload jobs processed at once (with -uploads)")
 	flag.Parse()
 
 	conf, err := config.LoadConfig(*configPath)
@@ -39,6 +41,11 @@ func main() {
 	}
 	defer logger.Sync()
 
+	if *uploads {
+		runUploadWorker(conf, *concurrency)
+		return
+	}
+
 	logger.Info("Starting indexer worker",
 		zap.Int("interval_hours", *intervalHours),
 		zap.Bool("run_once", *runOnce),
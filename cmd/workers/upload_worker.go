@@ -0,0 +1,62 @@
+package main
+
+import (
+	"auxstream/config"
+	"auxstream/internal/db"
+	"auxstream/internal/ingest"
+	"auxstream/internal/logger"
+	fs "auxstream/internal/storage"
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// uploadPollInterval is how often an idle upload worker checks for new jobs.
+const uploadPollInterval = 2 * time.Second
+
+// runUploadWorker processes queued bulk uploads, concurrency jobs at a time,
+// until it receives SIGINT/SIGTERM, then finishes the jobs and track
+// processing under way before it returns. It must share the API's database,
+// file store and staging area (UPLOAD_STAGING_DIR, or the bucket when
+// FILE_STORE is s3).
+func runUploadWorker(conf config.Config, concurrency int) {
+	database := db.InitDB(conf)
+	if err := fs.SetFileStore(conf); err != nil {
+		logger.Fatal("Failed to set file store", zap.Error(err))
+	}
+
+	transcoder, err := ingest.NewTranscoder(conf.FFmpegPath)
+	if err != nil {
+		logger.Warn("Transcoding disabled: encoder not found",
+			zap.String("ffmpeg_path", conf.FFmpegPath),
+			zap.Error(err),
+		)
+	}
+	removals := db.NewBlobRemovalRepo(database)
+	ing := ingest.NewService(db.NewTrackFileRepo(database), removals, transcoder, 2)
+	queue := ingest.NewUploadQueue(
+		db.NewUploadJobRepo(database),
+		fs.NewStager(fs.Store, conf.UploadStagingDir),
+		db.NewTrackRepo(database),
+		db.NewArtistRepo(database),
+		db.NewAlbumRepo(database),
+		removals,
+		ing,
+	)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	logger.Info("Upload worker started", zap.Int("concurrency", concurrency))
+	queue.Run(ctx, concurrency, uploadPollInterval)
+	// Tracks created by the last jobs may still be packaging.
+	ing.Wait()
+	if err := fs.CloseStore(); err != nil {
+		logger.Error("Failed to close file store", zap.Error(err))
+	}
+	logger.Info("Upload worker stopped")
+}
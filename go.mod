@@ -25,6 +25,7 @@ require (
 	github.com/stretchr/testify v1.11.1
 	go.uber.org/zap v1.21.0
 	golang.org/x/crypto v0.42.0
+	golang.org/x/image v0.30.0
 	gopkg.in/yaml.v3 v3.0.1
 	gorm.io/driver/postgres v1.5.11
 	gorm.io/gorm v1.30.0
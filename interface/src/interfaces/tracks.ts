@@ -16,8 +16,10 @@ export interface Track {
   artist_id: string
   artist?: Artist
   file: string
+  stream_url?: string
   duration?: number
   thumbnail?: string
+  downloadable?: boolean
   created_at: string
   updated_at?: string
 }
@@ -39,12 +39,25 @@ export function fromTrack(t: Track): PlayableTrack {
     source: 'Local',
     durationSeconds: seconds,
     durationLabel: seconds > 0 ? formatDuration(seconds) : '0:00',
-    thumbnail: t.thumbnail || undefined,
-    streamUrl: getAudioUrl(t.file),
+    thumbnail: trackArtworkUrl(t),
+    // Signed, expiring URL issued by the API; the stream route rejects unsigned requests.
+    streamUrl: getAudioUrl(t.stream_url ?? ''),
     fruit: pickFruit(t.id || t.title),
   }
 }
 
+/**
+ * Thumbnails are either external URLs or file-store identifiers (e.g. cover art
+ * extracted at upload); the latter are only reachable through the artwork route.
+ */
+function trackArtworkUrl(t: Track): string | undefined {
+  if (!t.thumbnail) return undefined
+  if (t.thumbnail.startsWith('http://') || t.thumbnail.startsWith('https://')) {
+    return t.thumbnail
+  }
+  return getAudioUrl(`/api/v1/tracks/${t.id}/artwork`)
+}
+
 /** Map a multi-source search result to a PlayableTrack. */
 export function fromSearchResult(r: SearchResult): PlayableTrack {
   const source = SOURCE_LABEL[r.source] ?? 'Local'
@@ -637,6 +637,7 @@ export function getAudioUrl(filePathOrUrl: string): string {
     return filePathOrUrl
   }
 
-  // Otherwise, assume it's just a filename and construct the full URL
-  return `${BASE_URL}/serve/${filePathOrUrl}`
+  // Anything else is a bare file-store identifier, which no route serves
+  // unsigned; audio must come from the API's signed stream_url.
+  return ''
 }
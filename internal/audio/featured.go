@@ -0,0 +1,53 @@
+package audio
+
+import (
+	"regexp"
+	"strings"
+)
+
+// featuredCredit matches a featuring credit in a title or artist tag: one in
+// brackets anywhere, as in "Essence (feat. Tems)" or "Essence [ft Tems]", or
+// one running to the end, as in "Wizkid feat. Tems". Outside brackets "feat"
+// and "ft" need their dot, so that titles such as "Great Feat of Strength"
+// are left alone.
+var featuredCredit = regexp.MustCompile(`(?i)\s*[(\[]\s*(?:feat\.?|ft\.?|featuring)\s+([^)\]]+)[)\]]|\s+(?:feat\.|ft\.|featuring)\s+(.+)$`)
+
+// featuredSeparator splits a featuring credit naming several artists, as in
+// "Tems, Omah Lay & Burna Boy".
+var featuredSeparator = regexp.MustCompile(`\s*(?:,|&)\s*`)
+
+// SplitFeatured separates the featuring credits from s, returning s without
+// them and the artists they name, in order. A string without such credits is
+// returned unchanged, with no artists.
+func SplitFeatured(s string) (string, []string) {
+	var featured []string
+	rest := featuredCredit.ReplaceAllStringFunc(s, func(credit string) string {
+		m := featuredCredit.FindStringSubmatch(credit)
+		for _, name := range featuredSeparator.Split(m[1]+m[2], -1) {
+			if name = strings.TrimSpace(name); name != "" {
+				featured = append(featured, name)
+			}
+		}
+		return ""
+	})
+	if len(featured) == 0 {
+		return s, nil
+	}
+	return strings.TrimSpace(rest), featured
+}
+
+// splitFeaturedTags moves the featuring credits of m's title and artist into
+// m.Featured, the artist's first, dropping repeats.
+func splitFeaturedTags(m *Metadata) {
+	var fromArtist, fromTitle []string
+	m.Artist, fromArtist = SplitFeatured(m.Artist)
+	m.Title, fromTitle = SplitFeatured(m.Title)
+
+	seen := map[string]bool{strings.ToLower(m.Artist): true}
+	for _, name := range append(fromArtist, fromTitle...) {
+		if key := strings.ToLower(name); !seen[key] {
+			seen[key] = true
+			m.Featured = append(m.Featured, name)
+		}
+	}
+}
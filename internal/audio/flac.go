@@ -0,0 +1,45 @@
+package audio
+
+import (
+	"errors"
+	"io"
+)
+
+// readFLACMetadata walks the metadata blocks ahead of the audio frames:
+// STREAMINFO for the duration, VORBIS_COMMENT for tags and PICTURE for art.
+func readFLACMetadata(r io.ReaderAt, size int64, m *Metadata) error {
+	if magic := readAt(r, 0, 4); magic == nil || string(magic) != "fLaC" {
+		return errors.New("not a flac stream")
+	}
+
+	off := int64(4)
+	for off+4 <= size {
+		h := readAt(r, off, 4)
+		last, blockType := h[0]&0x80 != 0, h[0]&0x7F
+		n := int(h[1])<<16 | int(h[2])<<8 | int(h[3])
+		body := readAt(r, off+4, n)
+		if body == nil {
+			break
+		}
+
+		switch blockType {
+		case 0: // STREAMINFO
+			if len(body) >= 18 {
+				rate := int(body[10])<<12 | int(body[11])<<4 | int(body[12])>>4
+				total := int64(body[13]&0x0F)<<32 | int64(body[14])<<24 |
+					int64(body[15])<<16 | int64(body[16])<<8 | int64(body[17])
+				m.Duration = samplesDuration(total, rate)
+			}
+		case 4: // VORBIS_COMMENT
+			applyVorbisComments(parseVorbisComment(body), m)
+		case 6: // PICTURE
+			parseFLACPicture(body, m)
+		}
+
+		off += 4 + int64(n)
+		if last {
+			break
+		}
+	}
+	return nil
+}
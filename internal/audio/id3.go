@@ -0,0 +1,396 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// hlsTimestampOwner is the PRIV owner identifier HLS uses to timestamp packed
+// audio segments (RFC 8216, section 3.4).
+const hlsTimestampOwner = "com.apple.streaming.transportStreamTimestamp"
+
+// HLSTimestampTag builds the ID3v2.4 tag that must open every packed-audio HLS
+// segment, stamping the presentation time of its first sample. pts is in the
+// 90 kHz MPEG-2 clock and is truncated to its 33 significant bits.
+func HLSTimestampTag(pts uint64) []byte {
+	data := make([]byte, 0, len(hlsTimestampOwner)+9)
+	data = append(data, hlsTimestampOwner...)
+	data = append(data, 0)
+	data = binary.BigEndian.AppendUint64(data, pts&(1<<33-1))
+	return id3Tag(4, id3Frame(4, "PRIV", data))
+}
+
+// ID3Tags is the metadata RetagMP3 writes. Empty fields leave whatever the
+// file already carries in their place.
+type ID3Tags struct {
+	Title       string
+	Artist      string
+	Artwork     []byte // front cover image
+	ArtworkMIME string // e.g. "image/jpeg"
+}
+
+// RetagMP3 returns the MP3 in r with its leading ID3v2 tags replaced by a single
+// tag carrying tags, as a ReaderAt over the result and its length. The audio is
+// not copied: reads past the new tag are served from r. Frames of the old tag
+// that tags does not override (album, track number and so on) are carried over
+// when that tag is v2.3 or v2.4 and not unsynchronised, and the new tag keeps
+// the old tag's version so those frames stay valid.
+func RetagMP3(r io.ReaderAt, size int64, tags ID3Tags) (io.ReaderAt, int64) {
+	version, kept := readID3Frames(r, size)
+
+	var frames [][]byte
+	if tags.Title != "" {
+		frames = append(frames, id3Frame(version, "TIT2", id3Text(version, tags.Title)))
+	}
+	if tags.Artist != "" {
+		frames = append(frames, id3Frame(version, "TPE1", id3Text(version, tags.Artist)))
+	}
+	if len(tags.Artwork) > 0 {
+		pic := make([]byte, 0, len(tags.ArtworkMIME)+4+len(tags.Artwork))
+		pic = append(pic, 0) // ISO-8859-1 (empty) description
+		pic = append(pic, tags.ArtworkMIME...)
+		pic = append(pic, 0, 0x03, 0) // MIME terminator, "front cover", description terminator
+		pic = append(pic, tags.Artwork...)
+		frames = append(frames, id3Frame(version, "APIC", pic))
+	}
+	for _, f := range kept {
+		switch {
+		case f.id == "TIT2" && tags.Title != "",
+			f.id == "TPE1" && tags.Artist != "",
+			f.id == "APIC" && len(tags.Artwork) > 0:
+			continue
+		}
+		frames = append(frames, f.raw)
+	}
+
+	audioStart := ID3v2Size(r, size)
+	tag := id3Tag(version, frames...)
+	return &retaggedMP3{
+		tag:   tag,
+		audio: io.NewSectionReader(r, audioStart, size-audioStart),
+	}, int64(len(tag)) + size - audioStart
+}
+
+// retaggedMP3 presents a new tag followed by the untouched audio.
+type retaggedMP3 struct {
+	tag   []byte
+	audio io.ReaderAt
+}
+
+func (m *retaggedMP3) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	if off < int64(len(m.tag)) {
+		n = copy(p, m.tag[off:])
+		if n == len(p) {
+			return n, nil
+		}
+	}
+	k, err := m.audio.ReadAt(p[n:], off+int64(n)-int64(len(m.tag)))
+	return n + k, err
+}
+
+type id3RawFrame struct {
+	id  string
+	raw []byte // the whole frame, header included
+}
+
+// readID3Frames returns the version of the first ID3v2 tag in r (4 when there
+// is none or it cannot be carried over) and its frames.
+func readID3Frames(r io.ReaderAt, size int64) (byte, []id3RawFrame) {
+	hdr := make([]byte, 10)
+	if size < 10 {
+		return 4, nil
+	}
+	if _, err := r.ReadAt(hdr, 0); err != nil || string(hdr[:3]) != "ID3" {
+		return 4, nil
+	}
+	version, flags := hdr[3], hdr[5]
+	if (version != 3 && version != 4) || flags&0x80 != 0 {
+		return 4, nil
+	}
+	tagLen := int64(syncsafe(hdr[6:10]))
+	if 10+tagLen > size {
+		return 4, nil
+	}
+	body := make([]byte, tagLen)
+	if _, err := r.ReadAt(body, 10); err != nil {
+		return 4, nil
+	}
+
+	pos := 0
+	if flags&0x40 != 0 && len(body) >= 4 {
+		if version == 4 {
+			pos = int(syncsafe(body[:4])) // v2.4 counts the size field itself
+		} else {
+			pos = int(binary.BigEndian.Uint32(body[:4])) + 4
+		}
+	}
+
+	var frames []id3RawFrame
+	for pos+10 <= len(body) && body[pos] != 0 {
+		var n int
+		if version == 4 {
+			n = int(syncsafe(body[pos+4 : pos+8]))
+		} else {
+			n = int(binary.BigEndian.Uint32(body[pos+4 : pos+8]))
+		}
+		end := pos + 10 + n
+		if n < 0 || end > len(body) {
+			break
+		}
+		frames = append(frames, id3RawFrame{id: string(body[pos : pos+4]), raw: body[pos:end]})
+		pos = end
+	}
+	return version, frames
+}
+
+// id3Text encodes a text frame body: UTF-8 where the version allows it, else
+// Latin-1 for ASCII text and UTF-16 for anything wider.
+func id3Text(version byte, s string) []byte {
+	if version >= 4 {
+		return append([]byte{0x03}, s...)
+	}
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return append([]byte{0x00}, s...)
+	}
+	out := []byte{0x01, 0xFF, 0xFE} // UTF-16 with a little-endian BOM
+	for _, u := range utf16.Encode([]rune(s)) {
+		out = binary.LittleEndian.AppendUint16(out, u)
+	}
+	return out
+}
+
+// id3Frame encodes a single frame; v2.4 frame sizes are syncsafe, v2.3 ones
+// plain big-endian.
+func id3Frame(version byte, id string, data []byte) []byte {
+	frame := make([]byte, 10, 10+len(data))
+	copy(frame, id)
+	if version >= 4 {
+		putSyncsafe(frame[4:8], uint32(len(data)))
+	} else {
+		binary.BigEndian.PutUint32(frame[4:8], uint32(len(data)))
+	}
+	return append(frame, data...)
+}
+
+// id3Tag wraps frames in an ID3v2 tag header.
+func id3Tag(version byte, frames ...[]byte) []byte {
+	var body []byte
+	for _, f := range frames {
+		body = append(body, f...)
+	}
+	tag := make([]byte, 10, 10+len(body))
+	copy(tag, "ID3")
+	tag[3] = version // revision and flags stay zero
+	putSyncsafe(tag[6:10], uint32(len(body)))
+	return append(tag, body...)
+}
+
+func putSyncsafe(b []byte, v uint32) {
+	b[0] = byte(v>>21) & 0x7F
+	b[1] = byte(v>>14) & 0x7F
+	b[2] = byte(v>>7) & 0x7F
+	b[3] = byte(v) & 0x7F
+}
+
+// readID3v2Tag decodes the text, year and picture frames of the ID3v2 tag (v2.2
+// to v2.4) at the start of r into m. Compressed and encrypted frames are
+// skipped.
+func readID3v2Tag(r io.ReaderAt, size int64, m *Metadata) {
+	hdr := readAt(r, 0, 10)
+	if hdr == nil || string(hdr[:3]) != "ID3" {
+		return
+	}
+	version, flags := hdr[3], hdr[5]
+	tagLen := int64(syncsafe(hdr[6:10]))
+	if version < 2 || version > 4 || 10+tagLen > size {
+		return
+	}
+	body := readAt(r, 10, int(tagLen))
+	if body == nil {
+		return
+	}
+	// Before v2.4, unsynchronisation applies to the tag as a whole.
+	if flags&0x80 != 0 && version < 4 {
+		body = unsynchronise(body)
+	}
+
+	pos := 0
+	if flags&0x40 != 0 && version >= 3 && len(body) >= 4 {
+		if version == 4 {
+			pos = int(syncsafe(body[:4]))
+		} else {
+			pos = int(binary.BigEndian.Uint32(body[:4])) + 4
+		}
+	}
+
+	idLen, hdrLen := 4, 10
+	if version == 2 {
+		idLen, hdrLen = 3, 6
+	}
+	for pos+hdrLen <= len(body) && body[pos] != 0 {
+		id := string(body[pos : pos+idLen])
+		var n int
+		switch version {
+		case 2:
+			n = int(body[pos+3])<<16 | int(body[pos+4])<<8 | int(body[pos+5])
+		case 3:
+			n = int(binary.BigEndian.Uint32(body[pos+4 : pos+8]))
+		default:
+			n = int(syncsafe(body[pos+4 : pos+8]))
+		}
+		end := pos + hdrLen + n
+		if n < 0 || end > len(body) {
+			break
+		}
+		data := body[pos+hdrLen : end]
+		format := body[pos+hdrLen-1] // second flags byte; v2.2 has none
+		pos = end
+
+		switch version {
+		case 3:
+			if format&0xC0 != 0 { // compressed or encrypted
+				continue
+			}
+		case 4:
+			if format&0x0C != 0 { // compressed or encrypted
+				continue
+			}
+			if format&0x40 != 0 && len(data) >= 1 { // group id
+				data = data[1:]
+			}
+			if format&0x01 != 0 && len(data) >= 4 { // data length indicator
+				data = data[4:]
+			}
+			if format&0x02 != 0 || flags&0x80 != 0 {
+				data = unsynchronise(data)
+			}
+		}
+		applyID3Frame(id, data, m)
+	}
+}
+
+// applyID3Frame maps one frame (v2.2 or later ids) onto m.
+func applyID3Frame(id string, data []byte, m *Metadata) {
+	if len(data) == 0 {
+		return
+	}
+	text := func() string { return decodeText(data[0], data[1:]) }
+	switch id {
+	case "TIT2", "TT2":
+		setText(&m.Title, text())
+	case "TPE1", "TP1":
+		setText(&m.Artist, text())
+	case "TALB", "TAL":
+		setText(&m.Album, text())
+	case "TRCK", "TRK":
+		if m.TrackNumber == 0 {
+			m.TrackNumber = leadingInt(text())
+		}
+	case "TPOS", "TPA":
+		if m.DiscNumber == 0 {
+			m.DiscNumber = leadingInt(text())
+		}
+	case "TYER", "TYE", "TDRC", "TDOR", "TORY":
+		if m.Year == 0 {
+			m.Year = leadingInt(text())
+		}
+	case "APIC", "PIC":
+		parseID3Picture(id, data, m)
+	}
+}
+
+// parseID3Picture decodes an APIC (or v2.2 PIC) frame, preferring the front
+// cover over other pictures.
+func parseID3Picture(id string, data []byte, m *Metadata) {
+	enc := data[0]
+	rest := data[1:]
+	var mimeType string
+	if id == "PIC" {
+		if len(rest) < 3 {
+			return
+		}
+		mimeType = "image/" + strings.ToLower(string(rest[:3]))
+		rest = rest[3:]
+	} else {
+		i := bytes.IndexByte(rest, 0)
+		if i < 0 {
+			return
+		}
+		mimeType = string(rest[:i])
+		rest = rest[i+1:]
+	}
+	if len(rest) < 1 {
+		return
+	}
+	picType := rest[0]
+	rest = rest[1:]
+
+	// Skip the description, terminated by a NUL of the text encoding's width.
+	if enc == 1 || enc == 2 {
+		i := 0
+		for ; i+1 < len(rest); i += 2 {
+			if rest[i] == 0 && rest[i+1] == 0 {
+				break
+			}
+		}
+		if i+1 >= len(rest) {
+			return
+		}
+		rest = rest[i+2:]
+	} else {
+		i := bytes.IndexByte(rest, 0)
+		if i < 0 {
+			return
+		}
+		rest = rest[i+1:]
+	}
+
+	if len(rest) > 0 && (m.Artwork == nil || picType == 3) {
+		m.Artwork, m.ArtworkMIME = rest, mimeType
+	}
+}
+
+// readID3v1Tag fills the fields m still lacks from a trailing ID3v1 tag.
+func readID3v1Tag(r io.ReaderAt, size int64, m *Metadata) {
+	if size < 128 {
+		return
+	}
+	tag := readAt(r, size-128, 128)
+	if tag == nil || string(tag[:3]) != "TAG" {
+		return
+	}
+	field := func(b []byte) string { return strings.TrimRight(decodeText(0, b), " ") }
+	setText(&m.Title, field(tag[3:33]))
+	setText(&m.Artist, field(tag[33:63]))
+	setText(&m.Album, field(tag[63:93]))
+	if m.Year == 0 {
+		m.Year = leadingInt(string(tag[93:97]))
+	}
+	if m.TrackNumber == 0 && tag[125] == 0 { // ID3v1.1 track number
+		m.TrackNumber = int(tag[126])
+	}
+}
+
+// unsynchronise reverses ID3 unsynchronisation, dropping the 0x00 stuffed
+// after every 0xFF.
+func unsynchronise(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xFF && i+1 < len(b) && b[i+1] == 0 {
+			i++
+		}
+	}
+	return out
+}
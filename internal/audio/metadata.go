@@ -0,0 +1,261 @@
+package audio
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// ErrUnsupportedFormat is returned by ReadMetadata for formats it cannot parse.
+var ErrUnsupportedFormat = errors.New("unsupported audio format")
+
+// Metadata is what ReadMetadata recovers from an audio file. Fields the file
+// does not carry are left zero.
+type Metadata struct {
+	Title       string        `json:"title,omitempty"`
+	Artist      string        `json:"artist,omitempty"`
+	Featured    []string      `json:"featured,omitempty"` // featured artists, as credited in the title or artist
+	Album       string        `json:"album,omitempty"`
+	TrackNumber int           `json:"track_number,omitempty"`
+	DiscNumber  int           `json:"disc_number,omitempty"`
+	Year        int           `json:"year,omitempty"`
+	Duration    time.Duration `json:"-"`
+	Artwork     []byte        `json:"-"` // embedded front cover, if any
+	ArtworkMIME string        `json:"-"`
+}
+
+// ReadMetadata extracts tags, cover art and duration from the audio in r.
+// Featuring credits, as in "Essence (feat. Tems)", are taken out of the title
+// and artist and listed in Featured.
+// format is an extension as produced by upload sniffing: "mp3", "flac", "ogg",
+// "wav" or "m4a". Malformed tags are skipped rather than failing the whole
+// read: an error means the container itself could not be parsed.
+func ReadMetadata(r io.ReaderAt, size int64, format string) (*Metadata, error) {
+	m := &Metadata{}
+	var err error
+	switch format {
+	case "mp3":
+		err = readMP3Metadata(r, size, m)
+	case "flac":
+		err = readFLACMetadata(r, size, m)
+	case "ogg":
+		err = readOggMetadata(r, size, m)
+	case "wav":
+		err = readWAVMetadata(r, size, m)
+	case "m4a":
+		err = readMP4Metadata(r, size, m)
+	default:
+		err = ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.Title = strings.TrimSpace(m.Title)
+	m.Artist = strings.TrimSpace(m.Artist)
+	m.Album = strings.TrimSpace(m.Album)
+	splitFeaturedTags(m)
+	return m, nil
+}
+
+// setText assigns v to *dst unless a value is already there, so the first
+// (preferred) source of a field wins.
+func setText(dst *string, v string) {
+	if *dst == "" {
+		*dst = v
+	}
+}
+
+// leadingInt parses the number that opens s, as in "3/12" or "2019-04-01".
+func leadingInt(s string) int {
+	s = strings.TrimSpace(s)
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(s[:end])
+	return n
+}
+
+// applyVorbisComments maps the Vorbis comment fields FLAC and Ogg share onto m.
+func applyVorbisComments(comments []string, m *Metadata) {
+	for _, c := range comments {
+		key, value, ok := strings.Cut(c, "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			setText(&m.Title, value)
+		case "ARTIST":
+			setText(&m.Artist, value)
+		case "ALBUM":
+			setText(&m.Album, value)
+		case "TRACKNUMBER":
+			if m.TrackNumber == 0 {
+				m.TrackNumber = leadingInt(value)
+			}
+		case "DISCNUMBER":
+			if m.DiscNumber == 0 {
+				m.DiscNumber = leadingInt(value)
+			}
+		case "DATE", "YEAR":
+			if m.Year == 0 {
+				m.Year = leadingInt(value)
+			}
+		case "METADATA_BLOCK_PICTURE":
+			if m.Artwork == nil {
+				if pic, err := base64.StdEncoding.DecodeString(value); err == nil {
+					parseFLACPicture(pic, m)
+				}
+			}
+		}
+	}
+}
+
+// parseVorbisComment decodes a Vorbis comment block: a vendor string then a
+// counted list of "KEY=value" strings, all little-endian length-prefixed.
+func parseVorbisComment(b []byte) []string {
+	next := func() (string, bool) {
+		if len(b) < 4 {
+			return "", false
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if uint64(n) > uint64(len(b)-4) {
+			return "", false
+		}
+		s := string(b[4 : 4+n])
+		b = b[4+n:]
+		return s, true
+	}
+
+	if _, ok := next(); !ok { // vendor
+		return nil
+	}
+	if len(b) < 4 {
+		return nil
+	}
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	var comments []string
+	for i := uint32(0); i < count; i++ {
+		c, ok := next()
+		if !ok {
+			break
+		}
+		comments = append(comments, c)
+	}
+	return comments
+}
+
+// parseFLACPicture decodes a FLAC PICTURE block (also carried base64-encoded
+// in Vorbis comments), keeping it when it is the front cover or when no other
+// artwork has been found yet.
+func parseFLACPicture(b []byte, m *Metadata) {
+	field := func() ([]byte, bool) {
+		if len(b) < 4 {
+			return nil, false
+		}
+		n := binary.BigEndian.Uint32(b)
+		if uint64(n) > uint64(len(b)-4) {
+			return nil, false
+		}
+		v := b[4 : 4+n]
+		b = b[4+n:]
+		return v, true
+	}
+
+	if len(b) < 4 {
+		return
+	}
+	picType := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	mimeType, ok := field()
+	if !ok {
+		return
+	}
+	if _, ok := field(); !ok { // description
+		return
+	}
+	if len(b) < 16 {
+		return
+	}
+	b = b[16:] // width, height, depth, colours
+	data, ok := field()
+	if !ok || len(data) == 0 {
+		return
+	}
+	if m.Artwork == nil || picType == 3 {
+		m.Artwork, m.ArtworkMIME = data, string(mimeType)
+	}
+}
+
+// decodeText decodes an ID3v2 text field in the given encoding byte, trimming
+// terminators.
+func decodeText(enc byte, b []byte) string {
+	switch enc {
+	case 1, 2: // UTF-16 with BOM, UTF-16BE
+		bigEndian := enc == 2
+		if len(b) >= 2 {
+			if b[0] == 0xFF && b[1] == 0xFE {
+				bigEndian, b = false, b[2:]
+			} else if b[0] == 0xFE && b[1] == 0xFF {
+				bigEndian, b = true, b[2:]
+			}
+		}
+		u := make([]uint16, 0, len(b)/2)
+		for i := 0; i+1 < len(b); i += 2 {
+			var v uint16
+			if bigEndian {
+				v = binary.BigEndian.Uint16(b[i:])
+			} else {
+				v = binary.LittleEndian.Uint16(b[i:])
+			}
+			if v == 0 {
+				break
+			}
+			u = append(u, v)
+		}
+		return string(utf16.Decode(u))
+	case 3: // UTF-8
+		if i := strings.IndexByte(string(b), 0); i >= 0 {
+			b = b[:i]
+		}
+		return string(b)
+	default: // ISO-8859-1
+		if i := strings.IndexByte(string(b), 0); i >= 0 {
+			b = b[:i]
+		}
+		r := make([]rune, len(b))
+		for i, c := range b {
+			r[i] = rune(c)
+		}
+		return string(r)
+	}
+}
+
+// samplesDuration converts a sample count at rate Hz to a duration without
+// overflowing on long streams.
+func samplesDuration(samples int64, rate int) time.Duration {
+	if rate <= 0 || samples <= 0 {
+		return 0
+	}
+	r := int64(rate)
+	return time.Duration(samples/r)*time.Second + time.Duration(samples%r)*time.Second/time.Duration(r)
+}
+
+// readAt reads n bytes at off, or returns nil when they are not all there.
+func readAt(r io.ReaderAt, off int64, n int) []byte {
+	if n < 0 || off < 0 {
+		return nil
+	}
+	b := make([]byte, n)
+	if k, _ := r.ReadAt(b, off); k < n {
+		return nil
+	}
+	return b
+}
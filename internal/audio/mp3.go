@@ -0,0 +1,252 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNoFrames is returned when a stream holds no decodable MPEG audio frames.
+var ErrNoFrames = errors.New("no mpeg audio frames found")
+
+// MP3Frame locates one MPEG audio frame within a stream.
+type MP3Frame struct {
+	Offset     int64 // byte offset of the frame header
+	Length     int   // frame length in bytes, header included
+	Samples    int   // PCM samples per channel the frame decodes to
+	SampleRate int   // Hz
+	Bitrate    int   // kbps
+	Channels   int
+	Version    int // 1, 2, or 25 for MPEG-2.5
+	Layer      int // 1, 2 or 3
+}
+
+var mp3Bitrates = map[[2]int][16]int{
+	{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+	{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+	{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+	{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+}
+
+var mp3SampleRates = map[int][3]int{
+	1:  {44100, 48000, 32000},
+	2:  {22050, 24000, 16000},
+	25: {11025, 12000, 8000},
+}
+
+// ParseMP3FrameHeader decodes the 4-byte header at the start of h. Free-format
+// and reserved values are rejected, since their frame length is not derivable
+// from the header alone.
+func ParseMP3FrameHeader(h []byte) (MP3Frame, bool) {
+	if len(h) < 4 || h[0] != 0xFF || h[1]&0xE0 != 0xE0 {
+		return MP3Frame{}, false
+	}
+
+	var f MP3Frame
+	switch (h[1] >> 3) & 0x03 {
+	case 0:
+		f.Version = 25
+	case 2:
+		f.Version = 2
+	case 3:
+		f.Version = 1
+	default:
+		return MP3Frame{}, false
+	}
+	switch (h[1] >> 1) & 0x03 {
+	case 1:
+		f.Layer = 3
+	case 2:
+		f.Layer = 2
+	case 3:
+		f.Layer = 1
+	default:
+		return MP3Frame{}, false
+	}
+
+	tableVersion := f.Version
+	if tableVersion == 25 {
+		tableVersion = 2 // MPEG-2.5 shares MPEG-2's bitrate table
+	}
+	f.Bitrate = mp3Bitrates[[2]int{tableVersion, f.Layer}][h[2]>>4]
+	srIdx := (h[2] >> 2) & 0x03
+	if f.Bitrate == 0 || srIdx == 3 {
+		return MP3Frame{}, false
+	}
+	f.SampleRate = mp3SampleRates[f.Version][srIdx]
+	padding := int((h[2] >> 1) & 0x01)
+
+	f.Channels = 2
+	if h[3]>>6 == 3 {
+		f.Channels = 1
+	}
+
+	switch {
+	case f.Layer == 1:
+		f.Samples = 384
+		f.Length = (12*f.Bitrate*1000/f.SampleRate + padding) * 4
+	case f.Layer == 3 && f.Version != 1:
+		f.Samples = 576
+		f.Length = 72*f.Bitrate*1000/f.SampleRate + padding
+	default:
+		f.Samples = 1152
+		f.Length = 144*f.Bitrate*1000/f.SampleRate + padding
+	}
+	return f, true
+}
+
+// ID3v2Size reports how many bytes of leading ID3v2 tags precede the audio in
+// r (zero when there are none). Some encoders write more than one tag, so it
+// keeps skipping until the next bytes are not a tag header.
+func ID3v2Size(r io.ReaderAt, size int64) int64 {
+	var offset int64
+	hdr := make([]byte, 10)
+	for offset+10 <= size {
+		if _, err := r.ReadAt(hdr, offset); err != nil {
+			break
+		}
+		if string(hdr[:3]) != "ID3" {
+			break
+		}
+		tagLen := int64(syncsafe(hdr[6:10])) + 10
+		if hdr[5]&0x10 != 0 {
+			tagLen += 10 // footer present
+		}
+		offset += tagLen
+	}
+	if offset > size {
+		return size
+	}
+	return offset
+}
+
+// maxResync bounds how far WalkMP3Frames scans past junk (padding, stray
+// bytes) looking for the next frame before deciding the audio has ended.
+const maxResync = 64 << 10
+
+// WalkMP3Frames calls fn for each MPEG audio frame in r, in stream order. It
+// skips leading ID3v2 tags and resynchronises across small runs of junk, and
+// stops at a trailing ID3v1/APE tag or the end of the stream. An error from fn
+// ends the walk and is returned as is.
+func WalkMP3Frames(r io.ReaderAt, size int64, fn func(MP3Frame) error) error {
+	offset := ID3v2Size(r, size)
+	hdr := make([]byte, 4)
+	found := false
+	skipped := 0
+
+	for offset+4 <= size {
+		if _, err := r.ReadAt(hdr, offset); err != nil {
+			return err
+		}
+		if string(hdr[:3]) == "TAG" || string(hdr) == "APET" {
+			break
+		}
+
+		frame, ok := ParseMP3FrameHeader(hdr)
+		if !ok || offset+int64(frame.Length) > size {
+			offset++
+			skipped++
+			if skipped > maxResync {
+				break
+			}
+			continue
+		}
+		skipped = 0
+		found = true
+
+		frame.Offset = offset
+		if err := fn(frame); err != nil {
+			return err
+		}
+		offset += int64(frame.Length)
+	}
+
+	if !found {
+		return ErrNoFrames
+	}
+	return nil
+}
+
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7F)<<21 | uint32(b[1]&0x7F)<<14 | uint32(b[2]&0x7F)<<7 | uint32(b[3]&0x7F)
+}
+
+// errStopWalk ends a frame walk early without reporting an error.
+var errStopWalk = errors.New("stop walk")
+
+func readMP3Metadata(r io.ReaderAt, size int64, m *Metadata) error {
+	readID3v2Tag(r, size, m)
+	readID3v1Tag(r, size, m)
+
+	d, err := MP3Duration(r, size)
+	if err != nil {
+		return err
+	}
+	m.Duration = d
+	return nil
+}
+
+// MP3Duration returns the playing time of the MP3 in r. VBR files usually open
+// with a Xing/Info or VBRI header frame giving the total frame count, which is
+// used when present; otherwise every frame is walked and its samples summed,
+// which is exact for CBR and header-less VBR alike.
+func MP3Duration(r io.ReaderAt, size int64) (time.Duration, error) {
+	var (
+		duration   time.Duration
+		samples    int64
+		sampleRate int
+		first      = true
+	)
+	err := WalkMP3Frames(r, size, func(f MP3Frame) error {
+		if first {
+			first = false
+			if frames, ok := vbrFrameCount(r, f); ok {
+				duration = samplesDuration(int64(frames)*int64(f.Samples), f.SampleRate)
+				return errStopWalk
+			}
+		}
+		samples += int64(f.Samples)
+		sampleRate = f.SampleRate
+		return nil
+	})
+	if errors.Is(err, errStopWalk) {
+		return duration, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return samplesDuration(samples, sampleRate), nil
+}
+
+// vbrFrameCount reads the frame count from a Xing/Info or VBRI header held in
+// frame f, if it is one.
+func vbrFrameCount(r io.ReaderAt, f MP3Frame) (uint32, bool) {
+	// The Xing header follows the side information, whose size depends on
+	// the MPEG version and channel mode.
+	sideInfo := 32
+	switch {
+	case f.Version == 1 && f.Channels == 1:
+		sideInfo = 17
+	case f.Version != 1 && f.Channels == 2:
+		sideInfo = 17
+	case f.Version != 1:
+		sideInfo = 9
+	}
+	if b := readAt(r, f.Offset+4+int64(sideInfo), 12); b != nil {
+		if tag := string(b[:4]); tag == "Xing" || tag == "Info" {
+			if binary.BigEndian.Uint32(b[4:8])&0x1 != 0 { // frame count present
+				return binary.BigEndian.Uint32(b[8:12]), true
+			}
+			return 0, false
+		}
+	}
+
+	// VBRI sits at a fixed 32 bytes past the frame header.
+	if b := readAt(r, f.Offset+4+32, 18); b != nil && string(b[:4]) == "VBRI" {
+		return binary.BigEndian.Uint32(b[14:18]), true
+	}
+	return 0, false
+}
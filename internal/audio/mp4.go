@@ -0,0 +1,151 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// maxMP4MetaBox bounds how much of a metadata box is read into memory.
+const maxMP4MetaBox = 16 << 20
+
+// readMP4Metadata reads the duration from moov/mvhd and tags from the iTunes
+// item list at moov/udta/meta/ilst.
+func readMP4Metadata(r io.ReaderAt, size int64, m *Metadata) error {
+	found := false
+	err := walkMP4Boxes(r, 0, size, func(typ string, start, end int64) error {
+		if typ != "moov" {
+			return nil
+		}
+		found = true
+		return walkMP4Boxes(r, start, end, func(typ string, start, end int64) error {
+			switch typ {
+			case "mvhd":
+				readMVHD(r, start, end, m)
+			case "udta":
+				return walkMP4Boxes(r, start, end, func(typ string, start, end int64) error {
+					if typ != "meta" {
+						return nil
+					}
+					// meta is a full box in ISO files but a plain one in
+					// QuickTime files; a zero version/flags word tells them apart.
+					if v := readAt(r, start, 4); v != nil && binary.BigEndian.Uint32(v) == 0 {
+						start += 4
+					}
+					return walkMP4Boxes(r, start, end, func(typ string, start, end int64) error {
+						if typ == "ilst" {
+							readILST(r, start, end, m)
+						}
+						return nil
+					})
+				})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("no moov box found")
+	}
+	return nil
+}
+
+// walkMP4Boxes calls fn with the type and body range of each box between start
+// and end, reading only box headers.
+func walkMP4Boxes(r io.ReaderAt, start, end int64, fn func(typ string, start, end int64) error) error {
+	for off := start; off+8 <= end; {
+		h := readAt(r, off, 8)
+		if h == nil {
+			return nil
+		}
+		n, typ, hdr := int64(binary.BigEndian.Uint32(h[:4])), string(h[4:8]), int64(8)
+		switch n {
+		case 0: // extends to the end of the enclosing space
+			n = end - off
+		case 1: // 64-bit size follows
+			ext := readAt(r, off+8, 8)
+			if ext == nil {
+				return nil
+			}
+			n, hdr = int64(binary.BigEndian.Uint64(ext)), 16
+		}
+		if n < hdr || off+n > end {
+			return errors.New("malformed mp4 box")
+		}
+		if err := fn(typ, off+hdr, off+n); err != nil {
+			return err
+		}
+		off += n
+	}
+	return nil
+}
+
+func readMVHD(r io.ReaderAt, start, end int64, m *Metadata) {
+	b := readAt(r, start, int(min(end-start, 32)))
+	if b == nil || len(b) < 20 {
+		return
+	}
+	var timescale, duration int64
+	if b[0] == 1 {
+		if len(b) < 32 {
+			return
+		}
+		timescale = int64(binary.BigEndian.Uint32(b[20:24]))
+		duration = int64(binary.BigEndian.Uint64(b[24:32]))
+	} else {
+		timescale = int64(binary.BigEndian.Uint32(b[12:16]))
+		duration = int64(binary.BigEndian.Uint32(b[16:20]))
+	}
+	m.Duration = samplesDuration(duration, int(timescale))
+}
+
+// readILST maps the iTunes metadata items onto m. Each item box wraps a "data"
+// box holding a type indicator, a locale and the value.
+func readILST(r io.ReaderAt, start, end int64, m *Metadata) {
+	_ = walkMP4Boxes(r, start, end, func(item string, start, end int64) error {
+		return walkMP4Boxes(r, start, end, func(typ string, start, end int64) error {
+			if typ != "data" || end-start < 8 || end-start > maxMP4MetaBox {
+				return nil
+			}
+			b := readAt(r, start, int(end-start))
+			if b == nil {
+				return nil
+			}
+			dataType, value := binary.BigEndian.Uint32(b[:4])&0xFFFFFF, b[8:]
+
+			switch item {
+			case "\xa9nam":
+				setText(&m.Title, string(value))
+			case "\xa9ART", "aART":
+				setText(&m.Artist, string(value))
+			case "\xa9alb":
+				setText(&m.Album, string(value))
+			case "\xa9day":
+				if m.Year == 0 {
+					m.Year = leadingInt(string(value))
+				}
+			case "trkn":
+				if m.TrackNumber == 0 && len(value) >= 4 {
+					m.TrackNumber = int(binary.BigEndian.Uint16(value[2:4]))
+				}
+			case "disk":
+				if m.DiscNumber == 0 && len(value) >= 4 {
+					m.DiscNumber = int(binary.BigEndian.Uint16(value[2:4]))
+				}
+			case "covr":
+				if m.Artwork == nil && len(value) > 0 {
+					m.Artwork = value
+					switch dataType {
+					case 13:
+						m.ArtworkMIME = "image/jpeg"
+					case 14:
+						m.ArtworkMIME = "image/png"
+					}
+				}
+			}
+			return nil
+		})
+	})
+}
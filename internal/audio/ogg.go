@@ -0,0 +1,129 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// oggTailScan bounds how far from the end readOggMetadata looks for the last
+// page, whose granule position gives the stream length.
+const oggTailScan = 64 << 10
+
+// readOggMetadata reads the identification and comment packets of the first
+// logical stream (Vorbis or Opus) and takes the duration from the granule
+// position of its last page.
+func readOggMetadata(r io.ReaderAt, size int64, m *Metadata) error {
+	packets, serial, err := oggHeaderPackets(r, size, 2)
+	if err != nil {
+		return err
+	}
+
+	var rate, preSkip int64
+	id := packets[0]
+	switch {
+	case len(id) >= 16 && string(id[:7]) == "\x01vorbis":
+		rate = int64(binary.LittleEndian.Uint32(id[12:16]))
+	case len(id) >= 12 && string(id[:8]) == "OpusHead":
+		rate = 48000 // Opus granules always count 48 kHz samples
+		preSkip = int64(binary.LittleEndian.Uint16(id[10:12]))
+	default:
+		return errors.New("unsupported ogg codec")
+	}
+
+	if len(packets) > 1 {
+		c := packets[1]
+		switch {
+		case len(c) > 7 && string(c[:7]) == "\x03vorbis":
+			applyVorbisComments(parseVorbisComment(c[7:]), m)
+		case len(c) > 8 && string(c[:8]) == "OpusTags":
+			applyVorbisComments(parseVorbisComment(c[8:]), m)
+		}
+	}
+
+	if granule, ok := oggLastGranule(r, size, serial); ok && granule > preSkip {
+		m.Duration = samplesDuration(granule-preSkip, int(rate))
+	}
+	return nil
+}
+
+// oggHeaderPackets reassembles up to n leading packets of the first logical
+// stream in r, returning them with that stream's serial number.
+func oggHeaderPackets(r io.ReaderAt, size int64, n int) ([][]byte, uint32, error) {
+	var (
+		packets [][]byte
+		current []byte
+		serial  uint32
+		off     int64
+	)
+	for off < size && len(packets) < n {
+		h := readAt(r, off, 27)
+		if h == nil || string(h[:4]) != "OggS" {
+			break
+		}
+		pageSerial := binary.LittleEndian.Uint32(h[14:18])
+		if off == 0 {
+			serial = pageSerial
+		}
+		lacing := readAt(r, off+27, int(h[26]))
+		if lacing == nil {
+			break
+		}
+		total := 0
+		for _, l := range lacing {
+			total += int(l)
+		}
+		dataOff := off + 27 + int64(len(lacing))
+		off = dataOff + int64(total)
+		if pageSerial != serial {
+			continue
+		}
+
+		data := readAt(r, dataOff, total)
+		if data == nil {
+			break
+		}
+		p := 0
+		for _, l := range lacing {
+			current = append(current, data[p:p+int(l)]...)
+			p += int(l)
+			if l < 255 { // a lacing value below 255 ends the packet
+				packets = append(packets, current)
+				current = nil
+				if len(packets) == n {
+					break
+				}
+			}
+		}
+	}
+	if len(packets) == 0 {
+		return nil, 0, errors.New("no ogg packets found")
+	}
+	return packets, serial, nil
+}
+
+// oggLastGranule finds the granule position of the last page of stream serial
+// within the final oggTailScan bytes of r.
+func oggLastGranule(r io.ReaderAt, size int64, serial uint32) (int64, bool) {
+	start := size - oggTailScan
+	if start < 0 {
+		start = 0
+	}
+	tail := readAt(r, start, int(size-start))
+	for end := len(tail); end > 0; {
+		i := bytes.LastIndex(tail[:end], []byte("OggS"))
+		if i < 0 {
+			break
+		}
+		end = i
+		if i+27 > len(tail) || binary.LittleEndian.Uint32(tail[i+14:i+18]) != serial {
+			continue
+		}
+		granule := int64(binary.LittleEndian.Uint64(tail[i+6 : i+14]))
+		if granule >= 0 { // -1 marks pages where no packet ends
+			return granule, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,557 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// CorruptError reports why an audio file failed validation.
+type CorruptError struct {
+	Reason string
+}
+
+func (e *CorruptError) Error() string {
+	return "corrupt audio: " + e.Reason
+}
+
+func corrupt(format string, args ...any) error {
+	return &CorruptError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// Validate walks the container or frame structure of the audio in r and
+// returns a *CorruptError describing the first problem found: truncation,
+// broken headers, failed checksums, or chunk tables pointing past the end of
+// the file. It checks structure, not decodability, so it stays cheap enough to
+// run on every upload. format is an extension as produced by upload sniffing.
+func Validate(r io.ReaderAt, size int64, format string) error {
+	switch format {
+	case "mp3":
+		return validateMP3(r, size)
+	case "flac":
+		return validateFLAC(r, size)
+	case "ogg":
+		return validateOgg(r, size)
+	case "wav":
+		return validateWAV(r, size)
+	case "m4a":
+		return validateMP4(r, size)
+	}
+	return ErrUnsupportedFormat
+}
+
+// maxMP3Junk bounds the stray bytes tolerated between and after MP3 frames;
+// encoders pad, but corruption leaves far more.
+const maxMP3Junk = 4 << 10
+
+func validateMP3(r io.ReaderAt, size int64) error {
+	var (
+		frames  int
+		end     int64
+		junk    int64
+		vbr     uint32
+		haveVBR bool
+	)
+	err := WalkMP3Frames(r, size, func(f MP3Frame) error {
+		if frames == 0 {
+			vbr, haveVBR = vbrFrameCount(r, f)
+		} else {
+			junk += f.Offset - end
+		}
+		frames++
+		end = f.Offset + int64(f.Length)
+		return nil
+	})
+	if err == ErrNoFrames {
+		return corrupt("no mpeg audio frames found")
+	}
+	if err != nil {
+		return err
+	}
+	if junk > maxMP3Junk {
+		return corrupt("%d bytes of damaged frame data", junk)
+	}
+
+	// Whatever follows the last frame must be a trailing tag or a little
+	// padding. A header whose frame runs past the end means the file was cut.
+	if tail := size - end; tail > 0 {
+		b := readAt(r, end, int(min(tail, 10)))
+		switch {
+		case b == nil:
+		case bytes.HasPrefix(b, []byte("TAG")), bytes.HasPrefix(b, []byte("APETAGEX")), bytes.HasPrefix(b, []byte("LYRICS")):
+		default:
+			if _, ok := ParseMP3FrameHeader(b); ok {
+				return corrupt("truncated: last frame cut short at byte %d", size)
+			}
+			if tail > maxMP3Junk {
+				return corrupt("%d bytes of undecodable data after the last frame", tail)
+			}
+		}
+	}
+
+	// The Xing/VBRI count excludes the header frame itself.
+	if haveVBR && uint32(frames-1) < vbr {
+		return corrupt("truncated: header declares %d frames, found %d", vbr, frames-1)
+	}
+	return nil
+}
+
+func validateFLAC(r io.ReaderAt, size int64) error {
+	if magic := readAt(r, 0, 4); magic == nil || string(magic) != "fLaC" {
+		return corrupt("missing fLaC marker")
+	}
+
+	var (
+		total     int64
+		blockSize int
+		off       = int64(4)
+		first     = true
+	)
+	for {
+		h := readAt(r, off, 4)
+		if h == nil {
+			return corrupt("truncated metadata block at byte %d", off)
+		}
+		last, blockType := h[0]&0x80 != 0, h[0]&0x7F
+		n := int64(h[1])<<16 | int64(h[2])<<8 | int64(h[3])
+		if off+4+n > size {
+			return corrupt("truncated metadata block at byte %d", off)
+		}
+		if first != (blockType == 0) {
+			return corrupt("STREAMINFO must be the first metadata block")
+		}
+		if blockType == 127 {
+			return corrupt("invalid metadata block type")
+		}
+		if first {
+			info := readAt(r, off+4, int(n))
+			if len(info) < 34 {
+				return corrupt("STREAMINFO block too short")
+			}
+			minBlock, maxBlock := int(binary.BigEndian.Uint16(info[0:2])), int(binary.BigEndian.Uint16(info[2:4]))
+			if minBlock == maxBlock {
+				blockSize = maxBlock
+			}
+			total = int64(info[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(info[14:18]))
+			first = false
+		}
+		off += 4 + n
+		if last {
+			break
+		}
+	}
+
+	if h := readAt(r, off, 2); h == nil || h[0] != 0xFF || h[1]&0xFE != 0xF8 {
+		return corrupt("no audio frame after the metadata")
+	}
+	return validateFLACTail(r, off, size, total, blockSize)
+}
+
+// validateFLACTail finds the last frame in the stream and checks that it is
+// complete (its CRC-16 footer matches) and, where the stream length is known,
+// that it is the final frame, so a file cut at a frame boundary is caught too.
+func validateFLACTail(r io.ReaderAt, audioStart, size, total int64, blockSize int) error {
+	start := max(audioStart, size-int64(1<<20))
+	tail := readAt(r, start, int(size-start))
+	if tail == nil {
+		return corrupt("unreadable audio data")
+	}
+
+	for i := len(tail) - 2; i >= 0; i-- {
+		if tail[i] != 0xFF || tail[i+1]&0xFE != 0xF8 {
+			continue
+		}
+		number, samples, ok := parseFLACFrameHeader(tail[i:])
+		if !ok {
+			continue
+		}
+		frame := tail[i:]
+		if len(frame) < 2 || crc16FLAC(frame[:len(frame)-2]) != binary.BigEndian.Uint16(frame[len(frame)-2:]) {
+			return corrupt("truncated: last frame fails its checksum")
+		}
+		if total > 0 {
+			end := number + int64(samples) // variable-blocksize streams number by sample
+			if tail[i+1]&0x01 == 0 {       // fixed-blocksize streams number by frame
+				if blockSize == 0 {
+					return nil
+				}
+				end = number*int64(blockSize) + int64(samples)
+			}
+			if end < total {
+				return corrupt("truncated: audio ends at sample %d of %d", end, total)
+			}
+		}
+		return nil
+	}
+	return corrupt("no complete audio frame found")
+}
+
+// parseFLACFrameHeader decodes the frame or sample number and the block size
+// of the frame header at the start of b, verifying its CRC-8.
+func parseFLACFrameHeader(b []byte) (number int64, samples int, ok bool) {
+	if len(b) < 6 {
+		return 0, 0, false
+	}
+	blockCode, rateCode := b[2]>>4, b[2]&0x0F
+	if blockCode == 0 || rateCode == 15 || b[3]>>4 > 10 || b[3]&0x01 != 0 {
+		return 0, 0, false
+	}
+
+	// UTF-8 style coded number: the leading ones of the first byte give the
+	// number of continuation bytes.
+	pos := 4
+	lead := b[pos]
+	extra := 0
+	for mask := byte(0x80); lead&mask != 0; mask >>= 1 {
+		extra++
+	}
+	switch {
+	case extra == 0:
+		number = int64(lead)
+	case extra >= 2 && extra <= 7:
+		number = int64(lead & (0xFF >> (extra + 1)))
+		extra--
+	default:
+		return 0, 0, false
+	}
+	pos++
+	if pos+extra > len(b) {
+		return 0, 0, false
+	}
+	for _, c := range b[pos : pos+extra] {
+		if c&0xC0 != 0x80 {
+			return 0, 0, false
+		}
+		number = number<<6 | int64(c&0x3F)
+	}
+	pos += extra
+
+	switch {
+	case blockCode == 1:
+		samples = 192
+	case blockCode <= 5:
+		samples = 576 << (blockCode - 2)
+	case blockCode == 6:
+		if pos+1 > len(b) {
+			return 0, 0, false
+		}
+		samples = int(b[pos]) + 1
+		pos++
+	case blockCode == 7:
+		if pos+2 > len(b) {
+			return 0, 0, false
+		}
+		samples = int(binary.BigEndian.Uint16(b[pos:])) + 1
+		pos += 2
+	default:
+		samples = 256 << (blockCode - 8)
+	}
+	switch rateCode {
+	case 12:
+		pos++
+	case 13, 14:
+		pos += 2
+	}
+	if pos+1 > len(b) || crc8FLAC(b[:pos]) != b[pos] {
+		return 0, 0, false
+	}
+	return number, samples, true
+}
+
+func crc8FLAC(b []byte) byte {
+	var crc byte
+	for _, c := range b {
+		crc ^= c
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func crc16FLAC(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// oggCRCTable is the CRC-32 Ogg uses: polynomial 0x04C11DB7, unreflected.
+var oggCRCTable = func() (t [256]uint32) {
+	for i := range t {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04C11DB7
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}()
+
+func oggCRC(b []byte) uint32 {
+	var crc uint32
+	for _, c := range b {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^c]
+	}
+	return crc
+}
+
+// validateOgg checks every page: capture pattern, bounds and CRC, and that
+// each logical stream that began also ended.
+func validateOgg(r io.ReaderAt, size int64) error {
+	open := make(map[uint32]bool)
+	var off int64
+	for off < size {
+		h := readAt(r, off, 27)
+		if h == nil {
+			return corrupt("truncated page header at byte %d", off)
+		}
+		if string(h[:4]) != "OggS" || h[4] != 0 {
+			return corrupt("bad page header at byte %d", off)
+		}
+		lacing := readAt(r, off+27, int(h[26]))
+		if lacing == nil {
+			return corrupt("truncated page header at byte %d", off)
+		}
+		n := 0
+		for _, l := range lacing {
+			n += int(l)
+		}
+		pageLen := 27 + len(lacing) + n
+		page := readAt(r, off, pageLen)
+		if page == nil {
+			return corrupt("truncated: page at byte %d runs past the end of the file", off)
+		}
+		want := binary.LittleEndian.Uint32(page[22:26])
+		binary.LittleEndian.PutUint32(page[22:26], 0)
+		if oggCRC(page) != want {
+			return corrupt("page at byte %d fails its checksum", off)
+		}
+
+		serial, flags := binary.LittleEndian.Uint32(h[14:18]), h[5]
+		if flags&0x02 != 0 {
+			open[serial] = true
+		}
+		if flags&0x04 != 0 {
+			delete(open, serial)
+		}
+		off += int64(pageLen)
+	}
+	if off == 0 {
+		return corrupt("no ogg pages found")
+	}
+	if len(open) > 0 {
+		return corrupt("truncated: stream has no end-of-stream page")
+	}
+	return nil
+}
+
+// validateWAV checks the RIFF framing and that the format chunk describes
+// audio the data chunk can actually hold.
+func validateWAV(r io.ReaderAt, size int64) error {
+	h := readAt(r, 0, 12)
+	if h == nil || string(h[:4]) != "RIFF" || string(h[8:12]) != "WAVE" {
+		return corrupt("missing RIFF/WAVE header")
+	}
+	if riff := int64(binary.LittleEndian.Uint32(h[4:8])) + 8; riff > size {
+		return corrupt("truncated: RIFF header declares %d bytes, file has %d", riff, size)
+	}
+
+	var blockAlign int64
+	haveFmt, haveData := false, false
+	for off := int64(12); off+8 <= size; {
+		c := readAt(r, off, 8)
+		if c == nil {
+			return corrupt("unreadable chunk header at byte %d", off)
+		}
+		id, n := string(c[:4]), int64(binary.LittleEndian.Uint32(c[4:8]))
+		if off+8+n > size {
+			return corrupt("truncated: %q chunk runs past the end of the file", id)
+		}
+		switch id {
+		case "fmt ":
+			f := readAt(r, off+8, 16)
+			if n < 16 || f == nil {
+				return corrupt("format chunk too short")
+			}
+			channels := int64(binary.LittleEndian.Uint16(f[2:4]))
+			rate := int64(binary.LittleEndian.Uint32(f[4:8]))
+			byteRate := int64(binary.LittleEndian.Uint32(f[8:12]))
+			blockAlign = int64(binary.LittleEndian.Uint16(f[12:14]))
+			if channels == 0 || rate == 0 || blockAlign == 0 || byteRate != rate*blockAlign {
+				return corrupt("inconsistent format chunk")
+			}
+			haveFmt = true
+		case "data":
+			if !haveFmt {
+				return corrupt("data chunk precedes the format chunk")
+			}
+			if n == 0 || n%blockAlign != 0 {
+				return corrupt("data chunk holds a partial sample frame")
+			}
+			haveData = true
+		}
+		off += 8 + n + n&1
+	}
+	if !haveFmt {
+		return corrupt("missing format chunk")
+	}
+	if !haveData {
+		return corrupt("missing data chunk")
+	}
+	return nil
+}
+
+// validateMP4 checks the box structure and that every track's sample table
+// points at data inside the file.
+func validateMP4(r io.ReaderAt, size int64) error {
+	var types []string
+	var moov [2]int64
+	err := walkMP4Boxes(r, 0, size, func(typ string, start, end int64) error {
+		types = append(types, typ)
+		if typ == "moov" {
+			moov = [2]int64{start, end}
+		}
+		return nil
+	})
+	if err != nil {
+		return corrupt("truncated or malformed box structure")
+	}
+	if len(types) == 0 || types[0] != "ftyp" {
+		return corrupt("missing ftyp box")
+	}
+	if moov[1] == 0 {
+		return corrupt("missing moov box")
+	}
+
+	tracks := 0
+	err = walkMP4Boxes(r, moov[0], moov[1], func(typ string, start, end int64) error {
+		if typ != "trak" {
+			return nil
+		}
+		tracks++
+		stbl, ok := findMP4Box(r, start, end, "mdia", "minf", "stbl")
+		if !ok {
+			return corrupt("track without a sample table")
+		}
+		return validateSampleTable(r, stbl[0], stbl[1], size)
+	})
+	var ce *CorruptError
+	if err != nil && !errors.As(err, &ce) {
+		return corrupt("malformed moov box")
+	}
+	if err != nil {
+		return err
+	}
+	if tracks == 0 {
+		return corrupt("no tracks in moov")
+	}
+	return nil
+}
+
+// findMP4Box descends through path from the box body [start, end).
+func findMP4Box(r io.ReaderAt, start, end int64, path ...string) ([2]int64, bool) {
+	for _, want := range path {
+		found := false
+		_ = walkMP4Boxes(r, start, end, func(typ string, s, e int64) error {
+			if !found && typ == want {
+				start, end, found = s, e, true
+			}
+			return nil
+		})
+		if !found {
+			return [2]int64{}, false
+		}
+	}
+	return [2]int64{start, end}, true
+}
+
+// maxSampleTable bounds how much of a sample table box is read.
+const maxSampleTable = 32 << 20
+
+// validateSampleTable checks that the last chunk of a track ends inside the
+// file: its offset (stco/co64) plus the sizes (stsz) of the samples stsc
+// places in it.
+func validateSampleTable(r io.ReaderAt, start, end, size int64) error {
+	box := func(typ string) []byte {
+		b, ok := findMP4Box(r, start, end, typ)
+		if !ok || b[1]-b[0] > maxSampleTable {
+			return nil
+		}
+		return readAt(r, b[0], int(b[1]-b[0]))
+	}
+
+	var offsets []int64
+	if stco := box("stco"); len(stco) >= 8 {
+		n := int(binary.BigEndian.Uint32(stco[4:8]))
+		if 8+4*n > len(stco) {
+			return corrupt("chunk offset table too short")
+		}
+		for i := 0; i < n; i++ {
+			offsets = append(offsets, int64(binary.BigEndian.Uint32(stco[8+4*i:])))
+		}
+	} else if co64 := box("co64"); len(co64) >= 8 {
+		n := int(binary.BigEndian.Uint32(co64[4:8]))
+		if 8+8*n > len(co64) {
+			return corrupt("chunk offset table too short")
+		}
+		for i := 0; i < n; i++ {
+			offsets = append(offsets, int64(binary.BigEndian.Uint64(co64[8+8*i:])))
+		}
+	}
+	if len(offsets) == 0 {
+		return nil // fragmented files keep their samples in moof boxes
+	}
+
+	stsz, stsc := box("stsz"), box("stsc")
+	if len(stsz) < 12 || len(stsc) < 8 {
+		return corrupt("missing sample size or sample-to-chunk table")
+	}
+	uniform := int64(binary.BigEndian.Uint32(stsz[4:8]))
+	sampleCount := int(binary.BigEndian.Uint32(stsz[8:12]))
+	if uniform == 0 && 12+4*sampleCount > len(stsz) {
+		return corrupt("sample size table too short")
+	}
+	entries := int(binary.BigEndian.Uint32(stsc[4:8]))
+	if entries == 0 || 8+12*entries > len(stsc) {
+		return corrupt("sample-to-chunk table too short")
+	}
+
+	// The last stsc entry covers the last chunk.
+	lastEntry := stsc[8+12*(entries-1):]
+	perChunk := int(binary.BigEndian.Uint32(lastEntry[4:8]))
+	if perChunk > sampleCount {
+		return corrupt("sample-to-chunk table exceeds the sample count")
+	}
+
+	chunkEnd := offsets[len(offsets)-1]
+	for i := sampleCount - perChunk; i < sampleCount; i++ {
+		if uniform != 0 {
+			chunkEnd += uniform
+		} else {
+			chunkEnd += int64(binary.BigEndian.Uint32(stsz[12+4*i:]))
+		}
+	}
+	if chunkEnd > size {
+		return corrupt("truncated: sample data ends at byte %d, file has %d", chunkEnd, size)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+// maxWAVInfoChunk bounds how much of a LIST or id3 chunk is read for tags.
+const maxWAVInfoChunk = 1 << 20
+
+// readWAVMetadata walks the RIFF chunks: "fmt " and "data" for the duration, a
+// LIST/INFO chunk or an embedded "id3 " chunk for tags.
+func readWAVMetadata(r io.ReaderAt, size int64, m *Metadata) error {
+	if h := readAt(r, 0, 12); h == nil || string(h[:4]) != "RIFF" || string(h[8:12]) != "WAVE" {
+		return errors.New("not a wave file")
+	}
+
+	var byteRate, dataSize int64
+	off := int64(12)
+	for off+8 <= size {
+		h := readAt(r, off, 8)
+		id, n := string(h[:4]), int64(binary.LittleEndian.Uint32(h[4:8]))
+		body := off + 8
+		if n > size-body {
+			n = size - body
+		}
+
+		switch id {
+		case "fmt ":
+			if f := readAt(r, body, 12); f != nil {
+				byteRate = int64(binary.LittleEndian.Uint32(f[8:12]))
+			}
+		case "data":
+			dataSize = n
+		case "LIST":
+			if n <= maxWAVInfoChunk {
+				if list := readAt(r, body, int(n)); list != nil && len(list) >= 4 && string(list[:4]) == "INFO" {
+					readRIFFInfo(list[4:], m)
+				}
+			}
+		case "id3 ", "ID3 ":
+			if n <= maxWAVInfoChunk {
+				readID3v2Tag(io.NewSectionReader(r, body, n), n, m)
+			}
+		}
+		off = body + n + n&1 // chunks are word aligned
+	}
+
+	if byteRate > 0 {
+		m.Duration = samplesDuration(dataSize, int(byteRate))
+	}
+	return nil
+}
+
+// readRIFFInfo maps the subchunks of a LIST/INFO chunk onto m.
+func readRIFFInfo(b []byte, m *Metadata) {
+	for len(b) >= 8 {
+		id, n := string(b[:4]), int(binary.LittleEndian.Uint32(b[4:8]))
+		if n > len(b)-8 {
+			return
+		}
+		value := strings.TrimRight(decodeText(3, b[8:8+n]), " ")
+		switch id {
+		case "INAM":
+			setText(&m.Title, value)
+		case "IART":
+			setText(&m.Artist, value)
+		case "IPRD":
+			setText(&m.Album, value)
+		case "ICRD":
+			if m.Year == 0 {
+				m.Year = leadingInt(value)
+			}
+		case "ITRK", "IPRT":
+			if m.TrackNumber == 0 {
+				m.TrackNumber = leadingInt(value)
+			}
+		}
+		next := 8 + n + n&1
+		if next > len(b) {
+			return
+		}
+		b = b[next:]
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 const UserContextKey = "user"
@@ -63,3 +64,13 @@ func GetUserFromContext(c *gin.Context) (*JWTClaims, bool) {
 	claims, ok := c.Request.Context().Value(UserContextKey).(*JWTClaims)
 	return claims, ok
 }
+
+// UserIDFromContext returns the authenticated user's id from a request context,
+// for code below the gin layer that only sees a context.Context.
+func UserIDFromContext(ctx context.Context) (uuid.UUID, bool) {
+	claims, ok := ctx.Value(UserContextKey).(*JWTClaims)
+	if !ok {
+		return uuid.Nil, false
+	}
+	return claims.UserID, true
+}
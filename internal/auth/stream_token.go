@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"auxstream/internal/metrics"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// StreamContextKey is where StreamTokenMiddleware stores the verified claims.
+const StreamContextKey = "stream"
+
+var (
+	ErrInvalidStreamToken = errors.New("invalid stream token")
+	ErrExpiredStreamToken = errors.New("stream token expired")
+)
+
+// StreamTokenClaims is what a verified stream token asserts: the track it opens,
+// the listener it was issued to (uuid.Nil for anonymous playback) and its expiry.
+type StreamTokenClaims struct {
+	TrackID   uuid.UUID
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+}
+
+// StreamTokenService issues and verifies the short-lived, HMAC-signed tokens
+// that authorize fetching a track's audio. A token is bound to one track, so a
+// leaked URL cannot be replayed for other tracks and stops working at expiry.
+type StreamTokenService struct {
+	secretKey []byte
+	ttl       time.Duration
+}
+
+// NewStreamTokenService derives the signing key from secretKey under a fixed
+// label, so sharing the JWT secret never lets a stream token pass as a JWT (or
+// vice versa). ttl should comfortably exceed a track's length: players re-send
+// the URL on every seek.
+func NewStreamTokenService(secretKey string, ttl time.Duration) *StreamTokenService {
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte("auxstream-stream-token"))
+	return &StreamTokenService{secretKey: mac.Sum(nil), ttl: ttl}
+}
+
+// streamTokenPayloadLen is track id (16) + user id (16) + unix expiry (8).
+const streamTokenPayloadLen = 40
+
+// GenerateStreamToken issues a token for trackID and userID, returning it with
+// its expiry. The token is "<payload>.<signature>", both base64url encoded.
+func (s *StreamTokenService) GenerateStreamToken(trackID, userID uuid.UUID) (string, time.Time) {
+	expiresAt := time.Now().Add(s.ttl).Truncate(time.Second)
+
+	payload := make([]byte, 0, streamTokenPayloadLen)
+	payload = append(payload, trackID[:]...)
+	payload = append(payload, userID[:]...)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(expiresAt.Unix()))
+
+	metrics.RecordStreamToken()
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(s.sign(payload)), expiresAt
+}
+
+// ValidateStreamToken verifies the signature and expiry of token and that it was
+// issued for trackID. Every outcome is recorded in the validation metric.
+func (s *StreamTokenService) ValidateStreamToken(token string, trackID uuid.UUID) (*StreamTokenClaims, error) {
+	claims, err := s.parse(token, trackID)
+	metrics.RecordStreamTokenValidation(err == nil)
+	return claims, err
+}
+
+func (s *StreamTokenService) parse(token string, trackID uuid.UUID) (*StreamTokenClaims, error) {
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidStreamToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil || len(payload) != streamTokenPayloadLen {
+		return nil, ErrInvalidStreamToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil || !hmac.Equal(sig, s.sign(payload)) {
+		return nil, ErrInvalidStreamToken
+	}
+
+	claims := &StreamTokenClaims{
+		ExpiresAt: time.Unix(int64(binary.BigEndian.Uint64(payload[32:])), 0),
+	}
+	copy(claims.TrackID[:], payload[:16])
+	copy(claims.UserID[:], payload[16:32])
+
+	if claims.TrackID != trackID {
+		return nil, ErrInvalidStreamToken
+	}
+	if time.Now().After(claims.ExpiresAt) {
+		return nil, ErrExpiredStreamToken
+	}
+	return claims, nil
+}
+
+func (s *StreamTokenService) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.secretKey)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// SignedStreamURL returns the API path that streams trackID, carrying a fresh
+// token issued to userID.
+func (s *StreamTokenService) SignedStreamURL(trackID, userID uuid.UUID) string {
+	token, _ := s.GenerateStreamToken(trackID, userID)
+	return fmt.Sprintf("/api/v1/tracks/%s/stream?token=%s", trackID, url.QueryEscape(token))
+}
+
+// StreamTokenMiddleware guards audio-serving routes keyed by an ":id" track
+// param. It requires a valid "token" query parameter for that track, answering
+// 403 otherwise, and stores the claims for GetStreamClaimsFromContext. Query
+// strings are used (not headers) because <audio> elements cannot set headers.
+func (s *StreamTokenService) StreamTokenMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		trackID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid track ID format"})
+			c.Abort()
+			return
+		}
+
+		claims, err := s.ValidateStreamToken(c.Query("token"), trackID)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired stream token"})
+			c.Abort()
+			return
+		}
+
+		ctx := context.WithValue(c.Request.Context(), StreamContextKey, claims)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// GetStreamClaimsFromContext extracts verified stream token claims from context
+func GetStreamClaimsFromContext(c *gin.Context) (*StreamTokenClaims, bool) {
+	claims, ok := c.Request.Context().Value(StreamContextKey).(*StreamTokenClaims)
+	return claims, ok
+}
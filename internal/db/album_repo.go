@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type AlbumRepo interface {
+	CreateAlbum(ctx context.Context, album *Album) (*Album, error)
+	GetAlbumByID(ctx context.Context, id uuid.UUID) (*Album, error)
+	GetAlbumsByArtistID(ctx context.Context, artistId uuid.UUID, limit int, offset int) ([]*Album, error)
+	FillAlbumImages(ctx context.Context, id uuid.UUID, images Images) error
+	DeleteEmptyAlbum(ctx context.Context, id uuid.UUID) error
+}
+
+type albumRepo struct {
+	Db *gorm.DB
+}
+
+func NewAlbumRepo(db *gorm.DB) AlbumRepo {
+	return &albumRepo{
+		Db: db,
+	}
+}
+
+// CreateAlbum returns the artist's existing album with this title, or creates
+// album if there is none, so an upload naming an album twice files both under
+// one. The rest of album only applies to a new one.
+func (r *albumRepo) CreateAlbum(ctx context.Context, album *Album) (*Album, error) {
+	if album.ID == uuid.Nil {
+		album.ID = uuid.New()
+	}
+	if album.Type == "" {
+		album.Type = AlbumTypeAlbum
+	}
+	if err := validate.Struct(album); err != nil {
+		return nil, err
+	}
+
+	found := &Album{}
+	res := r.Db.WithContext(ctx).
+		Where("artist_id = ? AND lower(title) = lower(?)", album.ArtistID, album.Title).
+		Attrs(*album).
+		FirstOrCreate(found)
+
+	return found, res.Error
+}
+
+// GetAlbumByID returns the album with its artist and its tracks, in disc and
+// track order.
+func (r *albumRepo) GetAlbumByID(ctx context.Context, id uuid.UUID) (*Album, error) {
+	var album Album
+	res := r.Db.WithContext(ctx).
+		Preload("Artist").
+		Preload("Tracks", func(db *gorm.DB) *gorm.DB {
+			return db.Order("disc_number, track_number, created_at")
+		}).
+		Preload("Tracks.Artist").
+		First(&album, "id = ?", id)
+
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return &album, nil
+}
+
+// GetAlbumsByArtistID pages the artist's albums, newest release first; albums
+// without a release date come last.
+func (r *albumRepo) GetAlbumsByArtistID(ctx context.Context, artistId uuid.UUID, limit int, offset int) ([]*Album, error) {
+	var albums []*Album
+	res := r.Db.WithContext(ctx).
+		Where("artist_id = ?", artistId).
+		Order("release_date DESC NULLS LAST, created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&albums)
+
+	if res.Error != nil {
+		return albums, res.Error
+	}
+
+	return albums, nil
+}
+
+// FillAlbumImages gives the album images as its cover unless it has one, as
+// when its tracks' embedded artwork is the only cover there is.
+func (r *albumRepo) FillAlbumImages(ctx context.Context, id uuid.UUID, images Images) error {
+	return r.Db.WithContext(ctx).Model(&Album{}).
+		Where("id = ? AND images IS NULL", id).
+		Update("images", images).Error
+}
+
+// DeleteEmptyAlbum deletes the album unless a track is filed under it, as when
+// the upload that created it failed.
+func (r *albumRepo) DeleteEmptyAlbum(ctx context.Context, id uuid.UUID) error {
+	return r.Db.WithContext(ctx).
+		Where("id = ? AND NOT EXISTS (SELECT 1 FROM auxstream.tracks t WHERE t.album_id = albums.id AND t.deleted_at IS NULL)", id).
+		Delete(&Album{}).Error
+}
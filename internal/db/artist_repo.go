@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"errors"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -9,7 +10,45 @@ import (
 
 type ArtistRepo interface {
 	CreateArtist(ctx context.Context, name string) (*Artist, error)
+	CreateOwnedArtist(ctx context.Context, name string, owner uuid.UUID) (*Artist, error)
 	GetArtistById(ctx context.Context, id uuid.UUID) (*Artist, error)
+	UpdateArtist(ctx context.Context, id uuid.UUID, update ArtistUpdate) (*Artist, error)
+	SetArtistImages(ctx context.Context, id uuid.UUID, images Images) error
+	DeleteArtist(ctx context.Context, id uuid.UUID) error
+}
+
+// ErrArtistNameTaken is returned by UpdateArtist when another artist already
+// has the name asked for.
+var ErrArtistNameTaken = errors.New("an artist with this name already exists")
+
+// ArtistUpdate is a partial update of an artist's profile: nil fields are left
+// as they are.
+type ArtistUpdate struct {
+	Name     *string
+	Bio      *string
+	Country  *string
+	Links    *Links
+	Verified *bool
+}
+
+func (u ArtistUpdate) columns() map[string]any {
+	columns := map[string]any{}
+	if u.Name != nil {
+		columns["name"] = *u.Name
+	}
+	if u.Bio != nil {
+		columns["bio"] = *u.Bio
+	}
+	if u.Country != nil {
+		columns["country"] = *u.Country
+	}
+	if u.Links != nil {
+		columns["links"] = *u.Links
+	}
+	if u.Verified != nil {
+		columns["verified"] = *u.Verified
+	}
+	return columns
 }
 
 type artistRepo struct {
@@ -25,13 +64,38 @@ func NewArtistRepo(db *gorm.DB) ArtistRepo {
 // CreateArtist returns the existing artist with this name, or creates one if
 // none exists; it never produces a duplicate, so it is safe to call per upload.
 func (r *artistRepo) CreateArtist(ctx context.Context, name string) (*Artist, error) {
+	return r.findOrCreate(ctx, Artist{ID: uuid.New(), Name: name})
+}
+
+// CreateOwnedArtist is CreateArtist for a user creating a profile: an artist
+// it creates is managed by owner, while an existing one keeps its owner.
+func (r *artistRepo) CreateOwnedArtist(ctx context.Context, name string, owner uuid.UUID) (*Artist, error) {
+	return r.findOrCreate(ctx, Artist{ID: uuid.New(), Name: name, OwnerID: &owner})
+}
+
+// findOrCreate looks the name up and creates the artist in one transaction,
+// holding the lock on the name (see lockArtistName) so that concurrent calls
+// and renames to it cannot both find it free.
+func (r *artistRepo) findOrCreate(ctx context.Context, attrs Artist) (*Artist, error) {
 	artist := &Artist{}
 
-	res := r.Db.WithContext(ctx).Where("name = ?", name).
-		Attrs(Artist{ID: uuid.New(), Name: name}).
-		FirstOrCreate(artist)
+	err := r.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockArtistName(tx, attrs.Name); err != nil {
+			return err
+		}
+		return tx.Where("name = ?", attrs.Name).
+			Attrs(attrs).
+			FirstOrCreate(artist).Error
+	})
 
-	return artist, res.Error
+	return artist, err
+}
+
+// lockArtistName takes a lock on name until tx ends. No index keeps artist
+// names unique, so whatever gives an artist a name checks it is free while
+// holding this lock.
+func lockArtistName(tx *gorm.DB, name string) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "auxstream.artists.name:"+name).Error
 }
 
 func (r *artistRepo) GetArtistById(ctx context.Context, id uuid.UUID) (*Artist, error) {
@@ -39,3 +103,69 @@ func (r *artistRepo) GetArtistById(ctx context.Context, id uuid.UUID) (*Artist,
 	res := r.Db.WithContext(ctx).First(artist, id)
 	return artist, res.Error
 }
+
+// UpdateArtist applies update to the artist with id and returns the result,
+// or ErrArtistNameTaken when renaming it after another live artist. The check
+// is made in the transaction of the rename, holding the lock on the name (see
+// lockArtistName).
+func (r *artistRepo) UpdateArtist(ctx context.Context, id uuid.UUID, update ArtistUpdate) (*Artist, error) {
+	if columns := update.columns(); len(columns) > 0 {
+		err := r.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if update.Name != nil {
+				if err := lockArtistName(tx, *update.Name); err != nil {
+					return err
+				}
+				var taken int64
+				if err := tx.Model(&Artist{}).Where("name = ? AND id <> ?", *update.Name, id).Count(&taken).Error; err != nil {
+					return err
+				}
+				if taken > 0 {
+					return ErrArtistNameTaken
+				}
+			}
+			return tx.Model(&Artist{}).Where("id = ?", id).Updates(columns).Error
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r.GetArtistById(ctx, id)
+}
+
+// DeleteArtist soft-deletes the artist with id together with its albums and
+// the tracks it is the main artist of, which go as they do with DeleteTrack.
+// Tracks of other artists on its albums (as on a compilation) are kept as
+// loose tracks, and its credits and tags on everything else are dropped.
+// Deleting an artist that is already gone is not an error.
+func (r *artistRepo) DeleteArtist(ctx context.Context, id uuid.UUID) error {
+	return r.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var tracks []Track
+		if err := tx.Select(retiredTrackColumns).Where("artist_id = ?", id).Find(&tracks).Error; err != nil {
+			return err
+		}
+		if err := retireTracks(tx, tracks); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&Track{}).
+			Where("album_id IN (SELECT id FROM auxstream.albums WHERE artist_id = ?)", id).
+			Updates(map[string]any{"album_id": nil, "disc_number": 0, "track_number": 0}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("artist_id = ?", id).Delete(&Album{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("artist_id = ?", id).Delete(&TrackArtist{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("artist_id = ?", id).Delete(&ArtistTag{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Artist{}, "id = ?", id).Error
+	})
+}
+
+// SetArtistImages replaces the artist's photo.
+func (r *artistRepo) SetArtistImages(ctx context.Context, id uuid.UUID, images Images) error {
+	return r.Db.WithContext(ctx).Model(&Artist{}).Where("id = ?", id).Update("images", images).Error
+}
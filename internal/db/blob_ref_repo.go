@@ -0,0 +1,235 @@
+package db
+
+import (
+	"auxstream/internal/storage"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Owners of a BlobRef.
+const (
+	BlobOwnerTrack     = "track"      // a track's uploaded audio
+	BlobOwnerThumbnail = "thumbnail"  // a track's thumbnail
+	BlobOwnerTrackFile = "track_file" // an artifact derived from a track
+
+	// Renditions of a picture; the BlobRef's Variant names the size.
+	BlobOwnerTrackImage    = "track_image"
+	BlobOwnerArtistImage   = "artist_image"
+	BlobOwnerPlaylistImage = "playlist_image"
+	BlobOwnerAlbumImage    = "album_image"
+)
+
+// BlobRef is one stored blob a row points at, with what is known about the
+// blob's expected contents.
+type BlobRef struct {
+	Owner    string    `json:"owner"`             // one of the BlobOwner* values
+	ID       uuid.UUID `json:"id"`                // the owning row
+	TrackID  uuid.UUID `json:"track_id"`          // nil for artist, playlist and album images
+	Variant  string    `json:"variant,omitempty"` // the key of an image rendition within its row's Images
+	File     string    `json:"file"`              // store identifier
+	Size     int64     `json:"size"`              // expected size; 0 when unknown
+	Checksum string    `json:"checksum"`          // expected hex SHA-256; empty when unknown
+	Deleted  bool      `json:"deleted"`           // the row is soft-deleted
+}
+
+// Stored reports whether the file store holds r's blob. Thumbnails may instead
+// be external image URLs, which are only the store's when their name shows the
+// store wrote them.
+func (r BlobRef) Stored() bool {
+	if r.Owner != BlobOwnerThumbnail {
+		return true
+	}
+	if strings.HasPrefix(r.File, "http://") || strings.HasPrefix(r.File, "https://") {
+		return storage.IsChecksum(storage.BlobKey(r.File))
+	}
+	return true
+}
+
+// BlobMove repoints the row Ref came from at File.
+type BlobMove struct {
+	Ref  BlobRef
+	File string
+}
+
+// BlobRefRepo enumerates every blob reference held in the database, for
+// storage maintenance. Soft-deleted rows are included (flagged Deleted), since
+// their blobs may still be wanted until the row is purged.
+type BlobRefRepo interface {
+	EachBlobRef(ctx context.Context, fn func(BlobRef) error) error
+	// SetBlobFile points the row ref came from at file in place of ref.File.
+	SetBlobFile(ctx context.Context, ref BlobRef, file string) error
+	// SetBlobFiles applies moves in one transaction.
+	SetBlobFiles(ctx context.Context, moves []BlobMove) error
+}
+
+type blobRefRepo struct {
+	Db *gorm.DB
+}
+
+func NewBlobRefRepo(db *gorm.DB) BlobRefRepo {
+	return &blobRefRepo{Db: db}
+}
+
+// blobRefBatch is how many rows EachBlobRef loads at a time.
+const blobRefBatch = 500
+
+// EachBlobRef calls fn for each track's audio, non-empty thumbnail and cover
+// art, then for each track file, then for each artist's, playlist's and
+// album's images, loading rows in batches. An error from fn stops the walk
+// and is returned.
+func (r *blobRefRepo) EachBlobRef(ctx context.Context, fn func(BlobRef) error) error {
+	var tracks []Track
+	res := r.Db.WithContext(ctx).Unscoped().
+		Select("id", "file", "thumbnail", "images", "checksum", "size", "deleted_at").
+		FindInBatches(&tracks, blobRefBatch, func(tx *gorm.DB, _ int) error {
+			for _, t := range tracks {
+				deleted := t.DeletedAt.Valid
+				if err := fn(BlobRef{Owner: BlobOwnerTrack, ID: t.ID, TrackID: t.ID, File: t.File,
+					Size: t.Size, Checksum: t.Checksum, Deleted: deleted}); err != nil {
+					return err
+				}
+				if t.Thumbnail != "" {
+					if err := fn(BlobRef{Owner: BlobOwnerThumbnail, ID: t.ID, TrackID: t.ID, File: t.Thumbnail,
+						Deleted: deleted}); err != nil {
+						return err
+					}
+				}
+				if err := eachImageRef(BlobOwnerTrackImage, t.ID, t.ID, t.Images, deleted, fn); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	var files []TrackFile
+	res = r.Db.WithContext(ctx).Unscoped().
+		Select("id", "track_id", "file", "size", "deleted_at").
+		FindInBatches(&files, blobRefBatch, func(tx *gorm.DB, _ int) error {
+			for _, f := range files {
+				if err := fn(BlobRef{Owner: BlobOwnerTrackFile, ID: f.ID, TrackID: f.TrackID, File: f.File,
+					Size: f.Size, Deleted: f.DeletedAt.Valid}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	var artists []Artist
+	res = r.Db.WithContext(ctx).Unscoped().
+		Select("id", "images", "deleted_at").
+		Where("images IS NOT NULL").
+		FindInBatches(&artists, blobRefBatch, func(tx *gorm.DB, _ int) error {
+			for _, a := range artists {
+				if err := eachImageRef(BlobOwnerArtistImage, a.ID, uuid.Nil, a.Images, a.DeletedAt.Valid, fn); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	var playlists []Playlist
+	res = r.Db.WithContext(ctx).Unscoped().
+		Select("id", "images", "deleted_at").
+		Where("images IS NOT NULL").
+		FindInBatches(&playlists, blobRefBatch, func(tx *gorm.DB, _ int) error {
+			for _, p := range playlists {
+				if err := eachImageRef(BlobOwnerPlaylistImage, p.ID, uuid.Nil, p.Images, p.DeletedAt.Valid, fn); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+
+	var albums []Album
+	res = r.Db.WithContext(ctx).Unscoped().
+		Select("id", "images", "deleted_at").
+		Where("images IS NOT NULL").
+		FindInBatches(&albums, blobRefBatch, func(tx *gorm.DB, _ int) error {
+			for _, a := range albums {
+				if err := eachImageRef(BlobOwnerAlbumImage, a.ID, uuid.Nil, a.Images, a.DeletedAt.Valid, fn); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	return res.Error
+}
+
+// eachImageRef calls fn for each rendition in images, in size order so walks
+// are repeatable.
+func eachImageRef(owner string, id, trackID uuid.UUID, images Images, deleted bool, fn func(BlobRef) error) error {
+	keys := make([]string, 0, len(images))
+	for key := range images {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if err := fn(BlobRef{Owner: owner, ID: id, TrackID: trackID, Variant: key, File: images[key],
+			Deleted: deleted}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *blobRefRepo) SetBlobFile(ctx context.Context, ref BlobRef, file string) error {
+	return setBlobFile(r.Db.WithContext(ctx), ref, file)
+}
+
+func (r *blobRefRepo) SetBlobFiles(ctx context.Context, moves []BlobMove) error {
+	return r.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, m := range moves {
+			if err := setBlobFile(tx, m.Ref, m.File); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func setBlobFile(db *gorm.DB, ref BlobRef, file string) error {
+	tx := db.Unscoped()
+	switch ref.Owner {
+	case BlobOwnerTrack:
+		tx = tx.Model(&Track{}).Where("id = ?", ref.ID).Update("file", file)
+	case BlobOwnerThumbnail:
+		tx = tx.Model(&Track{}).Where("id = ?", ref.ID).Update("thumbnail", file)
+	case BlobOwnerTrackFile:
+		tx = tx.Model(&TrackFile{}).Where("id = ?", ref.ID).Update("file", file)
+	case BlobOwnerTrackImage:
+		tx = tx.Model(&Track{}).Where("id = ?", ref.ID).Update("images", setImage(ref.Variant, file))
+	case BlobOwnerArtistImage:
+		tx = tx.Model(&Artist{}).Where("id = ?", ref.ID).Update("images", setImage(ref.Variant, file))
+	case BlobOwnerPlaylistImage:
+		tx = tx.Model(&Playlist{}).Where("id = ?", ref.ID).Update("images", setImage(ref.Variant, file))
+	case BlobOwnerAlbumImage:
+		tx = tx.Model(&Album{}).Where("id = ?", ref.ID).Update("images", setImage(ref.Variant, file))
+	default:
+		return fmt.Errorf("unknown blob owner %q", ref.Owner)
+	}
+	return tx.Error
+}
+
+// setImage is an update expression pointing one rendition of an images column
+// at file, leaving the others be.
+func setImage(variant, file string) clause.Expr {
+	return gorm.Expr("jsonb_set(images, ARRAY[?]::text[], to_jsonb(?::text))", variant, file)
+}
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"auxstream/internal/logger"
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BlobRemovalGrace is how long a blob is kept after the row naming it lets go
+// of it, before a purge may remove it from the file store.
+var BlobRemovalGrace = 7 * 24 * time.Hour
+
+// BlobRemovalRepo tracks the blobs scheduled for removal when tracks are
+// deleted or their audio replaced, for the purge that removes them.
+type BlobRemovalRepo interface {
+	// EachDueBlobRemoval calls fn for each removal whose grace period was
+	// over by now, oldest first. An error from fn stops the walk and is
+	// returned.
+	EachDueBlobRemoval(ctx context.Context, now time.Time, fn func(BlobRemoval) error) error
+	// ScheduleBlobRemovals schedules files for removal once BlobRemovalGrace
+	// has passed, for blobs that were stored but never recorded. They may be
+	// shared with other rows, which a purge checks for.
+	ScheduleBlobRemovals(ctx context.Context, files []string) error
+	// BlobInUse reports whether a row that is not deleted still names file,
+	// as when the same audio was uploaded again after a delete.
+	BlobInUse(ctx context.Context, file string) (bool, error)
+	// HoldBlobRemoval calls fn holding the removal with id locked, telling it
+	// whether the removal is still scheduled and whether its blob is in use
+	// (see BlobInUse). The removal is dropped when fn reports it finished.
+	// Creating a row that names the blob cancels the removal, and waits for
+	// fn to return to do so, so fn may remove a blob not in use.
+	HoldBlobRemoval(ctx context.Context, id uuid.UUID, fn func(scheduled, inUse bool) (finished bool)) error
+}
+
+type blobRemovalRepo struct {
+	Db *gorm.DB
+}
+
+func NewBlobRemovalRepo(db *gorm.DB) BlobRemovalRepo {
+	return &blobRemovalRepo{Db: db}
+}
+
+func (r *blobRemovalRepo) EachDueBlobRemoval(ctx context.Context, now time.Time, fn func(BlobRemoval) error) error {
+	var removals []BlobRemoval
+	return r.Db.WithContext(ctx).
+		Where("remove_after <= ?", now).
+		FindInBatches(&removals, blobRefBatch, func(tx *gorm.DB, _ int) error {
+			for _, removal := range removals {
+				if err := fn(removal); err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+}
+
+func (r *blobRemovalRepo) ScheduleBlobRemovals(ctx context.Context, files []string) error {
+	return scheduleBlobRemovals(r.Db.WithContext(ctx), files)
+}
+
+// blobInUse is true when a live row of any table EachBlobRef walks names the
+// blob @file.
+const blobInUse = `SELECT
+	EXISTS (SELECT 1 FROM auxstream.tracks t WHERE t.deleted_at IS NULL
+		AND (t.file = @file OR t.thumbnail = @file
+			OR EXISTS (SELECT 1 FROM jsonb_each_text(t.images) i WHERE i.value = @file)))
+	OR EXISTS (SELECT 1 FROM auxstream.track_files f WHERE f.deleted_at IS NULL AND f.file = @file)
+	OR EXISTS (SELECT 1 FROM auxstream.artists a WHERE a.deleted_at IS NULL
+		AND EXISTS (SELECT 1 FROM jsonb_each_text(a.images) i WHERE i.value = @file))
+	OR EXISTS (SELECT 1 FROM auxstream.playlists p WHERE p.deleted_at IS NULL
+		AND EXISTS (SELECT 1 FROM jsonb_each_text(p.images) i WHERE i.value = @file))
+	OR EXISTS (SELECT 1 FROM auxstream.albums al WHERE al.deleted_at IS NULL
+		AND EXISTS (SELECT 1 FROM jsonb_each_text(al.images) i WHERE i.value = @file))`
+
+func (r *blobRemovalRepo) BlobInUse(ctx context.Context, file string) (bool, error) {
+	var inUse bool
+	err := r.Db.WithContext(ctx).Raw(blobInUse, sql.Named("file", file)).Scan(&inUse).Error
+	return inUse, err
+}
+
+func (r *blobRemovalRepo) HoldBlobRemoval(ctx context.Context, id uuid.UUID, fn func(scheduled, inUse bool) bool) error {
+	return r.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var removal BlobRemoval
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).Take(&removal).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			fn(false, false)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var inUse bool
+		if err := tx.Raw(blobInUse, sql.Named("file", removal.File)).Scan(&inUse).Error; err != nil {
+			return err
+		}
+		if !fn(true, inUse) {
+			return nil
+		}
+		return tx.Delete(&BlobRemoval{}, "id = ?", id).Error
+	})
+}
+
+// scheduleBlobRemovals records files for removal once BlobRemovalGrace has
+// passed, skipping blanks and repeats.
+func scheduleBlobRemovals(tx *gorm.DB, files []string) error {
+	removeAfter := time.Now().Add(BlobRemovalGrace)
+	var removals []BlobRemoval
+	seen := make(map[string]bool, len(files))
+	for _, file := range files {
+		if file == "" || seen[file] {
+			continue
+		}
+		seen[file] = true
+		removals = append(removals, BlobRemoval{ID: uuid.New(), File: file, RemoveAfter: removeAfter})
+	}
+	if len(removals) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(removals, 100).Error
+}
+
+// cancelBlobRemovals drops the removals scheduled for files, which a row
+// being written in tx names again.
+func cancelBlobRemovals(tx *gorm.DB, files []string) error {
+	if len(files) == 0 {
+		return nil
+	}
+	return tx.Where("file IN ?", files).Delete(&BlobRemoval{}).Error
+}
+
+// discardTrackBlobs schedules the blobs of tracks, stored for them but never
+// recorded, for removal. A purge keeps those another row names. Failing to is
+// only logged; scrub still finds them as orphans.
+func discardTrackBlobs(ctx context.Context, db *gorm.DB, tracks []Track) {
+	var files []string
+	for _, t := range tracks {
+		files = append(files, trackBlobs(t)...)
+	}
+	if err := scheduleBlobRemovals(db.WithContext(ctx), files); err != nil {
+		logger.Error("schedule blob removals failed", zap.Strings("files", files), zap.Error(err))
+	}
+}
+
+// trackBlobs lists the blobs of t's audio and cover art, skipping a thumbnail
+// that is a link to an image held elsewhere.
+func trackBlobs(t Track) []string {
+	files := []string{t.File}
+	if thumb := (BlobRef{Owner: BlobOwnerThumbnail, File: t.Thumbnail}); thumb.Stored() {
+		files = append(files, t.Thumbnail)
+	}
+	variants := make([]string, 0, len(t.Images))
+	for variant := range t.Images {
+		variants = append(variants, variant)
+	}
+	slices.Sort(variants)
+	for _, variant := range variants {
+		files = append(files, t.Images[variant])
+	}
+	return files
+}
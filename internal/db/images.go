@@ -0,0 +1,104 @@
+package db
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ImageBaseURL is where the API serves stored images (GET /api/v1/images/*name);
+// Images are rendered in JSON as URLs under it.
+const ImageBaseURL = "/api/v1/images/"
+
+// Images are the stored renditions of one picture (cover art, an artist photo,
+// a playlist cover), keyed by their edge in pixels: each is a square that many
+// pixels wide. Values are store identifiers, as returned by Save. In JSON the
+// identifiers are replaced by the URLs the images are served at.
+type Images map[string]string
+
+// Size returns the rendition px wide, if there is one.
+func (im Images) Size(px int) (string, bool) {
+	name, ok := im[strconv.Itoa(px)]
+	return name, ok
+}
+
+// Largest returns the biggest rendition, or "" when there are none.
+func (im Images) Largest() string {
+	best, largest := -1, ""
+	for key, name := range im {
+		if px, err := strconv.Atoi(key); err == nil && px > best {
+			best, largest = px, name
+		}
+	}
+	return largest
+}
+
+// ImageURL returns the URL a stored image is served at. Stores that hand out
+// URLs of their own (Cloudinary) are linked to directly.
+func ImageURL(name string) string {
+	if strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://") {
+		return name
+	}
+	return ImageBaseURL + name
+}
+
+func (im Images) MarshalJSON() ([]byte, error) {
+	if im == nil {
+		return []byte("null"), nil
+	}
+	urls := make(map[string]string, len(im))
+	for key, name := range im {
+		urls[key] = ImageURL(name)
+	}
+	return json.Marshal(urls)
+}
+
+// UnmarshalJSON reverses MarshalJSON, so Images survive a round trip through
+// a cache.
+func (im *Images) UnmarshalJSON(data []byte) error {
+	var urls map[string]string
+	if err := json.Unmarshal(data, &urls); err != nil {
+		return err
+	}
+	if urls == nil {
+		*im = nil
+		return nil
+	}
+	*im = make(Images, len(urls))
+	for key, url := range urls {
+		(*im)[key] = strings.TrimPrefix(url, ImageBaseURL)
+	}
+	return nil
+}
+
+// Value stores im as a JSON object of store identifiers.
+func (im Images) Value() (driver.Value, error) {
+	if im == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(map[string]string(im))
+	return string(raw), err
+}
+
+func (im *Images) Scan(src any) error {
+	var raw []byte
+	switch v := src.(type) {
+	case nil:
+		*im = nil
+		return nil
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return errors.New("images: unsupported column type")
+	}
+	var m map[string]string
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return err
+	}
+	*im = m
+	return nil
+}
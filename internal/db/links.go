@@ -0,0 +1,42 @@
+package db
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+)
+
+// Links are an artist's profiles on other sites, keyed by the site's name in
+// lower case ("instagram", "x", "spotify", ...) and holding absolute http(s)
+// URLs.
+type Links map[string]string
+
+// Value stores l as a JSON object.
+func (l Links) Value() (driver.Value, error) {
+	if l == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(map[string]string(l))
+	return string(raw), err
+}
+
+func (l *Links) Scan(src any) error {
+	var raw []byte
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return errors.New("links: unsupported column type")
+	}
+	var m map[string]string
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return err
+	}
+	*l = m
+	return nil
+}
@@ -8,36 +8,133 @@ import (
 )
 
 type User struct {
-	ID           uuid.UUID      `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
-	Email        string         `json:"email" gorm:"uniqueIndex" validate:"required,email"`
-	PasswordHash string         `json:"password_hash" gorm:""`
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+	ID            uuid.UUID      `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	Email         string         `json:"email" gorm:"uniqueIndex" validate:"required,email"`
+	PasswordHash  string         `json:"password_hash" gorm:""`
+	StreamQuality string         `json:"stream_quality" gorm:"type:varchar(16);default:''"`    // rendition streamed when a request names none; blank means the original
+	Role          string         `json:"role" gorm:"type:varchar(16);not null;default:'user'"` // selects the storage quota; one of the Role* values
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt `json:"deleted_at" gorm:"index"`
 }
 
 func (User) TableName() string {
 	return "auxstream.users"
 }
 
+// User roles. Unknown roles are treated as RoleUser.
+const (
+	RoleUser   = "user"
+	RoleArtist = "artist"
+	RoleAdmin  = "admin"
+)
+
+// StorageUsage is the ledger of what one user's uploads hold in the file
+// store. It is adjusted in the same transaction as the tracks it counts.
+type StorageUsage struct {
+	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;primaryKey"`
+	Bytes     int64     `json:"bytes" gorm:"not null;default:0"`
+	Tracks    int       `json:"tracks" gorm:"not null;default:0"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (StorageUsage) TableName() string {
+	return "auxstream.storage_usage"
+}
+
 type Track struct {
-	ID        uuid.UUID      `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
-	Title     string         `json:"title" gorm:"not null" validate:"required"`
-	ArtistID  uuid.UUID      `json:"artist_id" gorm:"type:uuid;not null"`
-	Artist    Artist         `json:"artist" gorm:"foreignKey:ArtistID" validate:"-"`
-	File      string         `json:"file" gorm:"not null"`
-	Duration  int            `json:"duration" gorm:"default:0"`
-	Thumbnail string         `json:"thumbnail" gorm:"type:text"`
-	PlayCount int            `json:"play_count" gorm:"default:0;index"` // indexed: used as the trending-sort key
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+	ID           uuid.UUID      `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	Title        string         `json:"title" gorm:"not null" validate:"required"`
+	ArtistID     uuid.UUID      `json:"artist_id" gorm:"type:uuid;not null"`
+	Artist       Artist         `json:"artist" gorm:"foreignKey:ArtistID" validate:"-"`
+	File         string         `json:"file" gorm:"not null"`
+	Duration     int            `json:"duration" gorm:"default:0"`
+	Thumbnail    string         `json:"thumbnail" gorm:"type:text"`
+	Images       Images         `json:"images,omitempty" gorm:"type:jsonb"`           // cover art at the standard sizes; Thumbnail names the largest
+	PlayCount    int            `json:"play_count" gorm:"default:0;index"`            // indexed: used as the trending-sort key
+	Downloadable bool           `json:"downloadable" gorm:"default:false"`            // lets signed-in users fetch the file via the download route
+	Checksum     string         `json:"checksum" gorm:"type:varchar(64);index"`       // hex SHA-256 of the uploaded audio; spots duplicate uploads
+	Size         int64          `json:"size" gorm:"default:0"`                        // bytes of the uploaded audio; 0 when unknown
+	UploaderID   *uuid.UUID     `json:"uploader_id,omitempty" gorm:"type:uuid;index"` // user charged for the audio in storage_usage; nil for tracks predating the ledger
+	AlbumID      *uuid.UUID     `json:"album_id,omitempty" gorm:"type:uuid;index"`    // release the track appears on; nil for loose tracks
+	DiscNumber   int            `json:"disc_number,omitempty" gorm:"default:0"`       // disc within the album, from 1; 0 when not on an album
+	TrackNumber  int            `json:"track_number,omitempty" gorm:"default:0"`      // position on its disc, from 1; 0 when unknown
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+	// Credits are the artists credited on the track besides Artist, such as
+	// featured artists; filled in only where a response lists them.
+	Credits []TrackArtist `json:"credits,omitempty" gorm:"foreignKey:TrackID" validate:"-"`
+	// StreamURL is a signed, expiring playback URL filled in per response; never stored.
+	StreamURL string `json:"stream_url,omitempty" gorm:"-"`
 }
 
 func (Track) TableName() string {
 	return "auxstream.tracks"
 }
 
+// Kinds of TrackFile artifact.
+const (
+	TrackFileHLSPlaylist = "hls_playlist"
+	TrackFileHLSSegment  = "hls_segment"
+	TrackFileAudio       = "audio" // a complete encoding, streamed as one file
+)
+
+// RenditionOriginal names the rendition packaged straight from the uploaded audio.
+const RenditionOriginal = "original"
+
+// Transcoded renditions, which double as the stream qualities a listener can
+// ask for.
+const (
+	RenditionLow    = "low"
+	RenditionMedium = "medium"
+	RenditionHigh   = "high"
+)
+
+// IsStreamQuality reports whether q names a rendition a listener may select.
+func IsStreamQuality(q string) bool {
+	switch q {
+	case RenditionOriginal, RenditionLow, RenditionMedium, RenditionHigh:
+		return true
+	}
+	return false
+}
+
+// TrackFile is one stored artifact derived from a track's uploaded audio, such as
+// an HLS media playlist or one of its segments. Track.File keeps pointing at the
+// original upload; these rows record everything produced from it.
+type TrackFile struct {
+	ID         uuid.UUID      `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	TrackID    uuid.UUID      `json:"track_id" gorm:"type:uuid;not null"`
+	Kind       string         `json:"kind" gorm:"not null"`      // one of the TrackFile* kinds
+	Rendition  string         `json:"rendition" gorm:"not null"` // e.g. RenditionOriginal
+	Sequence   int            `json:"sequence" gorm:"default:0"` // segment index within its playlist
+	DurationMs int            `json:"duration_ms" gorm:"default:0"`
+	File       string         `json:"file" gorm:"not null"` // store identifier, as returned by Save
+	Size       int64          `json:"size" gorm:"default:0"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+}
+
+func (TrackFile) TableName() string {
+	return "auxstream.track_files"
+}
+
+// BlobRemoval is a stored blob due to be removed once RemoveAfter has passed,
+// because the row naming it was deleted or now names another blob. Until then
+// the blob stays, so a mistaken delete can still be undone.
+type BlobRemoval struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	File        string    `json:"file" gorm:"not null"`               // store identifier, as returned by Save
+	RemoveAfter time.Time `json:"remove_after" gorm:"not null;index"` // end of the grace period
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (BlobRemoval) TableName() string {
+	return "auxstream.blob_removals"
+}
+
 // TrackSource represents external or local track sources
 type TrackSource struct {
 	ID         uuid.UUID      `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
@@ -64,6 +161,7 @@ type Playlist struct {
 	Name        string          `json:"name" gorm:"not null" validate:"required"`
 	Description string          `json:"description" gorm:"type:text"`
 	IsPublic    bool            `json:"is_public" gorm:"default:false"`
+	Images      Images          `json:"images,omitempty" gorm:"type:jsonb"` // cover at the standard sizes
 	CreatedAt   time.Time       `json:"created_at"`
 	UpdatedAt   time.Time       `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt  `json:"deleted_at" gorm:"index"`
@@ -106,9 +204,18 @@ func (PlaybackHistory) TableName() string {
 	return "auxstream.playback_history"
 }
 
+// Artist is a performer tracks are filed under. Its profile is managed by its
+// owner, the user who created it, or by an admin; artists created from upload
+// tags have no owner and are left to admins.
 type Artist struct {
 	ID        uuid.UUID      `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
-	Name      string         `json:"name" gorm:"uniqueIndex;not null" validate:"required"`
+	Name      string         `json:"name" gorm:"index;not null" validate:"required"`
+	Images    Images         `json:"images,omitempty" gorm:"type:jsonb"` // photo at the standard sizes
+	OwnerID   *uuid.UUID     `json:"owner_id,omitempty" gorm:"type:uuid;index"`
+	Bio       string         `json:"bio,omitempty" gorm:"type:text"`
+	Country   string         `json:"country,omitempty" gorm:"type:varchar(2)"` // ISO 3166-1 alpha-2 code, upper case
+	Links     Links          `json:"links,omitempty" gorm:"type:jsonb"`        // profiles elsewhere, by site
+	Verified  bool           `json:"verified" gorm:"default:false"`            // vouched for by an admin
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index"`
@@ -119,14 +226,198 @@ func (Artist) TableName() string {
 	return "auxstream.artists"
 }
 
+// Roles of a TrackArtist.
+const (
+	ArtistRolePrimary  = "primary"  // a lead artist alongside Track.Artist, as on a duet
+	ArtistRoleFeatured = "featured" // credited with "feat."
+	ArtistRoleRemixer  = "remixer"
+	ArtistRoleProducer = "producer"
+)
+
+// IsArtistRole reports whether r is one of the ArtistRole* values.
+func IsArtistRole(r string) bool {
+	switch r {
+	case ArtistRolePrimary, ArtistRoleFeatured, ArtistRoleRemixer, ArtistRoleProducer:
+		return true
+	}
+	return false
+}
+
+// TrackArtist credits an artist on a track in a role. Track.ArtistID stays the
+// track's main artist and is not repeated here; these are everyone else, so
+// an artist's tracks are those they are the main artist of or credited on.
+type TrackArtist struct {
+	TrackID   uuid.UUID `json:"-" gorm:"type:uuid;primaryKey"`
+	ArtistID  uuid.UUID `json:"artist_id" gorm:"type:uuid;primaryKey;index"`
+	Artist    *Artist   `json:"artist,omitempty" gorm:"foreignKey:ArtistID" validate:"-"`
+	Role      string    `json:"role" gorm:"type:varchar(16);primaryKey"` // one of the ArtistRole* values
+	Position  int       `json:"position" gorm:"default:0"`               // order among the track's credits
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (TrackArtist) TableName() string {
+	return "auxstream.track_artists"
+}
+
+// Types of Album.
+const (
+	AlbumTypeSingle      = "single"
+	AlbumTypeEP          = "ep"
+	AlbumTypeAlbum       = "album"
+	AlbumTypeCompilation = "compilation"
+)
+
+// IsAlbumType reports whether t is one of the AlbumType* values.
+func IsAlbumType(t string) bool {
+	switch t {
+	case AlbumTypeSingle, AlbumTypeEP, AlbumTypeAlbum, AlbumTypeCompilation:
+		return true
+	}
+	return false
+}
+
+// Album is a release by one artist: a single, EP, album or compilation. Its
+// tracks point at it through Track.AlbumID and are ordered by disc and track
+// number.
+type Album struct {
+	ID          uuid.UUID      `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	Title       string         `json:"title" gorm:"not null" validate:"required"`
+	ArtistID    uuid.UUID      `json:"artist_id" gorm:"type:uuid;not null;index"`
+	Artist      *Artist        `json:"artist,omitempty" gorm:"foreignKey:ArtistID" validate:"-"`
+	Type        string         `json:"type" gorm:"type:varchar(16);not null;default:'album'"` // one of the AlbumType* values
+	ReleaseDate *time.Time     `json:"release_date" gorm:"type:date"`                         // nil when unknown
+	Images      Images         `json:"images,omitempty" gorm:"type:jsonb"`                    // cover at the standard sizes
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+	Tracks      []Track        `json:"tracks,omitempty" gorm:"foreignKey:AlbumID" validate:"-"`
+}
+
+func (Album) TableName() string {
+	return "auxstream.albums"
+}
+
+// Kinds of Tag.
+const (
+	TagKindGenre = "genre" // a musical genre, from a shared vocabulary
+	TagKindTag   = "tag"   // a free-form label
+)
+
+// Tag is a genre or a free-form label attached to tracks and artists, through
+// TrackTag and ArtistTag. Tags are matched by Slug, so "Hip Hop" and "hip-hop"
+// are one genre; Name keeps the spelling it was first given.
+type Tag struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	Kind      string    `json:"kind" gorm:"type:varchar(8);not null;uniqueIndex:idx_tags_kind_slug"` // one of the TagKind* values
+	Name      string    `json:"name" gorm:"not null"`
+	Slug      string    `json:"slug" gorm:"not null;uniqueIndex:idx_tags_kind_slug"` // see TagSlug
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (Tag) TableName() string {
+	return "auxstream.tags"
+}
+
+// TrackTag attaches a Tag to a track.
+type TrackTag struct {
+	TrackID   uuid.UUID `json:"track_id" gorm:"type:uuid;primaryKey"`
+	TagID     uuid.UUID `json:"tag_id" gorm:"type:uuid;primaryKey;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (TrackTag) TableName() string {
+	return "auxstream.track_tags"
+}
+
+// ArtistTag attaches a Tag to an artist.
+type ArtistTag struct {
+	ArtistID  uuid.UUID `json:"artist_id" gorm:"type:uuid;primaryKey"`
+	TagID     uuid.UUID `json:"tag_id" gorm:"type:uuid;primaryKey;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (ArtistTag) TableName() string {
+	return "auxstream.artist_tags"
+}
+
+// Statuses of an UploadJob.
+const (
+	UploadJobQueued     = "queued"     // waiting for a worker, or for a retry
+	UploadJobProcessing = "processing" // claimed by a worker
+	UploadJobDone       = "done"       // every file was saved or rejected
+	UploadJobFailed     = "failed"     // gave up after repeated errors
+)
+
+// Statuses of an UploadJobFile.
+const (
+	UploadFileQueued   = "queued"
+	UploadFileSaved    = "saved"    // stored and its track created, or found on a track already
+	UploadFileRejected = "rejected" // refused, e.g. unsupported or damaged audio
+	UploadFileFailed   = "failed"   // could not be processed; see its error
+)
+
+// UploadJob is a bulk upload accepted for processing by the upload workers.
+// Its files are staged when the job is created and turned into tracks later.
+type UploadJob struct {
+	ID           uuid.UUID       `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	UserID       *uuid.UUID      `json:"user_id" gorm:"type:uuid"`            // uploader; nil when anonymous
+	ArtistID     *uuid.UUID      `json:"artist_id" gorm:"type:uuid"`          // every track's artist; nil files each track under its tagged artist
+	AlbumID      *uuid.UUID      `json:"album_id,omitempty" gorm:"type:uuid"` // album every track is added to; nil for loose tracks
+	Downloadable bool            `json:"downloadable" gorm:"default:false"`
+	Quota        UsageLimit      `json:"-" gorm:"embedded;embeddedPrefix:quota_"`       // the uploader's when queued, which the tracks must fit
+	Status       string          `json:"status" gorm:"type:varchar(16);not null;index"` // one of the UploadJob* statuses
+	Attempts     int             `json:"attempts" gorm:"default:0"`
+	Error        string          `json:"error,omitempty" gorm:"type:text"` // last processing error
+	AvailableAt  time.Time       `json:"-"`                                // not claimed before this, backing off retries
+	StartedAt    *time.Time      `json:"started_at"`
+	FinishedAt   *time.Time      `json:"finished_at"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	Files        []UploadJobFile `json:"files" gorm:"foreignKey:JobID"`
+}
+
+func (UploadJob) TableName() string {
+	return "auxstream.upload_jobs"
+}
+
+// UploadJobFile is one file of an UploadJob and its outcome.
+type UploadJobFile struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	JobID     uuid.UUID  `json:"-" gorm:"type:uuid;not null;index"`
+	Position  int        `json:"index" gorm:"not null"` // position among the uploaded files
+	Filename  string     `json:"filename"`
+	Title     string     `json:"title"` // as given by the uploader; the track's may come from tags
+	Size      int64      `json:"size" gorm:"default:0"`
+	Stage     string     `json:"-" gorm:"type:text"`                      // staged bytes' state, see storage.Stager
+	Status    string     `json:"status" gorm:"type:varchar(16);not null"` // one of the UploadFile* statuses
+	Error     string     `json:"error,omitempty" gorm:"type:text"`
+	TrackID   *uuid.UUID `json:"track_id" gorm:"type:uuid"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+func (UploadJobFile) TableName() string {
+	return "auxstream.upload_job_files"
+}
+
 // ModelTypeRegistry maps a model's type name to a zero-value instance, letting
 // callers resolve a model from a string (e.g. for generic migration/seeding).
 var ModelTypeRegistry = map[string]any{
 	"User":            User{},
+	"StorageUsage":    StorageUsage{},
 	"Track":           Track{},
 	"Artist":          Artist{},
+	"TrackArtist":     TrackArtist{},
+	"Album":           Album{},
+	"Tag":             Tag{},
+	"TrackTag":        TrackTag{},
+	"ArtistTag":       ArtistTag{},
 	"TrackSource":     TrackSource{},
+	"TrackFile":       TrackFile{},
+	"BlobRemoval":     BlobRemoval{},
 	"Playlist":        Playlist{},
 	"PlaylistTrack":   PlaylistTrack{},
 	"PlaybackHistory": PlaybackHistory{},
+	"UploadJob":       UploadJob{},
+	"UploadJobFile":   UploadJobFile{},
 }
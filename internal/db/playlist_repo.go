@@ -22,6 +22,7 @@ type PlaylistRepo interface {
 	CreatePlaylist(ctx context.Context, userID uuid.UUID, name, description string, isPublic bool) (*Playlist, error)
 	UpdatePlaylist(ctx context.Context, id uuid.UUID, name, description string, isPublic bool) (*Playlist, error)
 	DeletePlaylist(ctx context.Context, id uuid.UUID) error
+	SetPlaylistImages(ctx context.Context, id uuid.UUID, images Images) error
 	AddTrack(ctx context.Context, playlistID, trackID uuid.UUID) error
 	RemoveTrack(ctx context.Context, playlistID, trackID uuid.UUID) error
 	ReorderTracks(ctx context.Context, playlistID uuid.UUID, orderedTrackIDs []uuid.UUID) error
@@ -152,6 +153,11 @@ func (r *playlistRepo) UpdatePlaylist(ctx context.Context, id uuid.UUID, name, d
 	return r.GetPlaylistByID(ctx, id)
 }
 
+// SetPlaylistImages replaces the playlist's cover.
+func (r *playlistRepo) SetPlaylistImages(ctx context.Context, id uuid.UUID, images Images) error {
+	return r.Db.WithContext(ctx).Model(&Playlist{}).Where("id = ?", id).Update("images", images).Error
+}
+
 // DeletePlaylist soft-deletes the playlist and its track entries in one transaction.
 func (r *playlistRepo) DeletePlaylist(ctx context.Context, id uuid.UUID) error {
 	return r.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
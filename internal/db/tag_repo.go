@@ -0,0 +1,161 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TagRepo interface {
+	ListTags(ctx context.Context, kind string, prefix string, limit int) ([]*Tag, error)
+	GetTrackTags(ctx context.Context, trackId uuid.UUID) ([]*Tag, error)
+	SetTrackTags(ctx context.Context, trackId uuid.UUID, kind string, names []string) ([]*Tag, error)
+	GetArtistTags(ctx context.Context, artistId uuid.UUID) ([]*Tag, error)
+	SetArtistTags(ctx context.Context, artistId uuid.UUID, kind string, names []string) ([]*Tag, error)
+}
+
+type tagRepo struct {
+	Db *gorm.DB
+}
+
+func NewTagRepo(db *gorm.DB) TagRepo {
+	return &tagRepo{
+		Db: db,
+	}
+}
+
+// TagSlug is the key tag names are matched by: lowercased, with each run of
+// spaces, hyphens and underscores made a single hyphen.
+func TagSlug(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return unicode.IsSpace(r) || r == '-' || r == '_'
+	})
+	return strings.Join(words, "-")
+}
+
+// ListTags returns up to limit tags of kind in name order, only those whose
+// slug starts with the slug of prefix when it is not empty.
+func (r *tagRepo) ListTags(ctx context.Context, kind string, prefix string, limit int) ([]*Tag, error) {
+	var tags []*Tag
+	query := r.Db.WithContext(ctx).Where("kind = ?", kind)
+	if slug := TagSlug(prefix); slug != "" {
+		query = query.Where("slug LIKE ?", escapeLike(slug)+"%")
+	}
+	res := query.Order("name").Limit(limit).Find(&tags)
+
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return tags, nil
+}
+
+func (r *tagRepo) GetTrackTags(ctx context.Context, trackId uuid.UUID) ([]*Tag, error) {
+	return r.getTags(ctx, "auxstream.track_tags", "track_id", trackId)
+}
+
+// SetTrackTags replaces the track's tags of kind with the ones named, creating
+// those that do not exist yet, and returns them. No names clears them.
+func (r *tagRepo) SetTrackTags(ctx context.Context, trackId uuid.UUID, kind string, names []string) ([]*Tag, error) {
+	return r.setTags(ctx, "auxstream.track_tags", "track_id", trackId, kind, names, func(tags []*Tag) any {
+		links := make([]TrackTag, len(tags))
+		for i, tag := range tags {
+			links[i] = TrackTag{TrackID: trackId, TagID: tag.ID}
+		}
+		return links
+	})
+}
+
+func (r *tagRepo) GetArtistTags(ctx context.Context, artistId uuid.UUID) ([]*Tag, error) {
+	return r.getTags(ctx, "auxstream.artist_tags", "artist_id", artistId)
+}
+
+// SetArtistTags is SetTrackTags for an artist.
+func (r *tagRepo) SetArtistTags(ctx context.Context, artistId uuid.UUID, kind string, names []string) ([]*Tag, error) {
+	return r.setTags(ctx, "auxstream.artist_tags", "artist_id", artistId, kind, names, func(tags []*Tag) any {
+		links := make([]ArtistTag, len(tags))
+		for i, tag := range tags {
+			links[i] = ArtistTag{ArtistID: artistId, TagID: tag.ID}
+		}
+		return links
+	})
+}
+
+// getTags returns the tags linked to id through the owner column of table,
+// genres first, each kind in name order.
+func (r *tagRepo) getTags(ctx context.Context, table, owner string, id uuid.UUID) ([]*Tag, error) {
+	var tags []*Tag
+	res := r.Db.WithContext(ctx).
+		Joins("JOIN "+table+" link ON link.tag_id = tags.id").
+		Where("link."+owner+" = ?", id).
+		Order("kind, name").
+		Find(&tags)
+
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return tags, nil
+}
+
+// setTags replaces the links in table from id to tags of kind with links to
+// the tags named, which links builds.
+func (r *tagRepo) setTags(ctx context.Context, table, owner string, id uuid.UUID, kind string, names []string, links func([]*Tag) any) ([]*Tag, error) {
+	var tags []*Tag
+	err := r.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if tags, err = resolveTags(tx, kind, names); err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM "+table+" WHERE "+owner+" = ? AND tag_id IN (SELECT id FROM auxstream.tags WHERE kind = ?)", id, kind).Error; err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			return nil
+		}
+		return tx.Create(links(tags)).Error
+	})
+
+	return tags, err
+}
+
+// resolveTags returns the tags of kind with the names given, in name order,
+// creating those that do not exist. Names that differ only in spelling (see
+// TagSlug) are one tag.
+func resolveTags(tx *gorm.DB, kind string, names []string) ([]*Tag, error) {
+	var fresh []*Tag
+	var slugs []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.Join(strings.Fields(name), " ")
+		slug := TagSlug(name)
+		if slug == "" || seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		slugs = append(slugs, slug)
+		fresh = append(fresh, &Tag{ID: uuid.New(), Kind: kind, Name: name, Slug: slug})
+	}
+	if len(fresh) == 0 {
+		return nil, nil
+	}
+
+	// Tags created concurrently, or long ago, are kept as they are.
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&fresh).Error; err != nil {
+		return nil, err
+	}
+	var tags []*Tag
+	if err := tx.Where("kind = ? AND slug IN ?", kind, slugs).Order("name").Find(&tags).Error; err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type TrackFileRepo interface {
+	ReplaceRendition(ctx context.Context, trackID uuid.UUID, rendition string, files []TrackFile) error
+	GetTrackFile(ctx context.Context, trackID uuid.UUID, kind, rendition string, sequence int) (*TrackFile, error)
+}
+
+type trackFileRepo struct {
+	Db *gorm.DB
+}
+
+func NewTrackFileRepo(db *gorm.DB) TrackFileRepo {
+	return &trackFileRepo{Db: db}
+}
+
+// ReplaceRendition swaps every artifact of one rendition for files in a single
+// transaction, so re-packaging a track never leaves readers a half-old mix. The
+// superseded rows are soft-deleted; their blobs are left in the store. Removals
+// scheduled for the blobs of files are cancelled.
+func (r *trackFileRepo) ReplaceRendition(ctx context.Context, trackID uuid.UUID, rendition string, files []TrackFile) error {
+	return r.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("track_id = ? AND rendition = ?", trackID, rendition).
+			Delete(&TrackFile{}).Error; err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return nil
+		}
+		for i := range files {
+			if files[i].ID == uuid.Nil {
+				files[i].ID = uuid.New()
+			}
+			files[i].TrackID = trackID
+			files[i].Rendition = rendition
+		}
+		if err := tx.CreateInBatches(files, 100).Error; err != nil {
+			return err
+		}
+		names := make([]string, len(files))
+		for i, f := range files {
+			names[i] = f.File
+		}
+		return cancelBlobRemovals(tx, names)
+	})
+}
+
+func (r *trackFileRepo) GetTrackFile(ctx context.Context, trackID uuid.UUID, kind, rendition string, sequence int) (*TrackFile, error) {
+	var file TrackFile
+	res := r.Db.WithContext(ctx).
+		Where("track_id = ? AND kind = ? AND rendition = ? AND sequence = ?", trackID, kind, rendition, sequence).
+		First(&file)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return &file, nil
+}
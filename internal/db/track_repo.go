@@ -3,28 +3,109 @@ package db
 import (
 	"auxstream/internal/logger"
 	"context"
+	"errors"
 	"go.uber.org/zap"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// ErrDuplicateTrack is returned by CreateTrack, along with the track, when a
+// live track already holds the audio of the one being created.
+var ErrDuplicateTrack = errors.New("a track with this audio already exists")
+
+// trackChecksumIndex keeps the checksums of live tracks unique.
+const trackChecksumIndex = "idx_tracks_checksum_live"
+
 type TrackRepo interface {
-	CreateTrack(ctx context.Context, title string, artistId uuid.UUID, filePath string, duration int, thumbnail string) (*Track, error)
-	GetTracks(ctx context.Context, limit int, offset int) ([]*Track, error)
-	GetTrendingTracks(ctx context.Context, limit int, offset int, days int) ([]*Track, error)
-	GetRecentTracks(ctx context.Context, limit int, offset int) ([]*Track, error)
+	CreateTrack(ctx context.Context, track *Track, quota UsageLimit) (*Track, error)
+	GetTracks(ctx context.Context, limit int, offset int, filter TrackFilter) ([]*Track, error)
+	GetTrendingTracks(ctx context.Context, limit int, offset int, days int, filter TrackFilter) ([]*Track, error)
+	GetRecentTracks(ctx context.Context, limit int, offset int, filter TrackFilter) ([]*Track, error)
 	GetTrackByID(ctx context.Context, id uuid.UUID) (*Track, error)
+	GetTrackByChecksum(ctx context.Context, checksum string) (*Track, error)
 	GetTrackByTitle(ctx context.Context, title string) ([]*Track, error)
 	GetTrackByArtist(ctx context.Context, artist string) ([]*Track, error)
 	GetTracksByArtistId(ctx context.Context, artistId uuid.UUID, limit int, offset int) ([]*Track, error)
 	SearchTracks(ctx context.Context, query string) ([]*Track, error)
+	GetTrackGenres(ctx context.Context, trackIds []uuid.UUID) (map[uuid.UUID][]string, error)
+	GetTrackCredits(ctx context.Context, trackIds []uuid.UUID) (map[uuid.UUID][]TrackArtist, error)
 	BulkCreateTracks(ctx context.Context, inputs []BulkTrackInput, artistId uuid.UUID) (int64, error)
+	SetTrackImages(ctx context.Context, trackId uuid.UUID, images Images) error
+	UpdateTrack(ctx context.Context, trackId uuid.UUID, update TrackUpdate) (*Track, error)
+	DeleteTrack(ctx context.Context, trackId uuid.UUID) error
 	IncrementPlayCount(ctx context.Context, trackId uuid.UUID) error
 	RecordPlayback(ctx context.Context, userId uuid.UUID, trackId uuid.UUID, durationPlayed int) error
 }
 
+// TrackFilter narrows a track listing to the tracks carrying a genre and a
+// tag, each matched by slug (see TagSlug). Empty fields match every track.
+type TrackFilter struct {
+	Genre string
+	Tag   string
+}
+
+// apply adds f's conditions to a query over tracks.
+func (f TrackFilter) apply(query *gorm.DB) *gorm.DB {
+	for _, want := range []struct{ kind, name string }{{TagKindGenre, f.Genre}, {TagKindTag, f.Tag}} {
+		if want.name == "" {
+			continue
+		}
+		query = query.Where(`EXISTS (SELECT 1 FROM auxstream.track_tags tt JOIN auxstream.tags t ON t.id = tt.tag_id
+			WHERE tt.track_id = tracks.id AND t.kind = ? AND t.slug = ?)`, want.kind, TagSlug(want.name))
+	}
+	return query
+}
+
+// TrackUpdate is a partial update of a track: nil fields are left as they
+// are.
+type TrackUpdate struct {
+	Title     *string
+	ArtistID  *uuid.UUID
+	Duration  *int
+	Thumbnail *string
+	Audio     *TrackAudio
+}
+
+// TrackAudio is newly stored audio replacing a track's.
+type TrackAudio struct {
+	File     string
+	Checksum string
+	Size     int64
+	Quota    UsageLimit // the uploader's, which larger audio must fit
+}
+
+func (u TrackUpdate) columns() map[string]any {
+	columns := map[string]any{}
+	if u.Title != nil {
+		columns["title"] = *u.Title
+	}
+	if u.ArtistID != nil {
+		columns["artist_id"] = *u.ArtistID
+	}
+	if u.Duration != nil {
+		columns["duration"] = *u.Duration
+	}
+	if u.Thumbnail != nil {
+		columns["thumbnail"] = *u.Thumbnail
+	}
+	if u.Audio != nil {
+		columns["file"] = u.Audio.File
+		columns["checksum"] = u.Audio.Checksum
+		columns["size"] = u.Audio.Size
+	}
+	return columns
+}
+
+// retiredTrackColumns are the columns retireTracks needs loaded.
+var retiredTrackColumns = []string{"id", "file", "thumbnail", "images", "size", "uploader_id"}
+
+// creditedOn is the condition that the artist with the id it takes is
+// credited on the track (see TrackArtist).
+const creditedOn = "EXISTS (SELECT 1 FROM auxstream.track_artists ta WHERE ta.track_id = tracks.id AND ta.artist_id = ?)"
+
 type trackRepo struct {
 	Db *gorm.DB
 }
@@ -35,29 +116,54 @@ func NewTrackRepo(db *gorm.DB) TrackRepo {
 	}
 }
 
-func (r *trackRepo) CreateTrack(ctx context.Context, title string, artistId uuid.UUID, filePath string, duration int, thumbnail string) (*Track, error) {
-	track := &Track{
-		ID:        uuid.New(),
-		Title:     title,
-		ArtistID:  artistId,
-		File:      filePath,
-		Duration:  duration,
-		Thumbnail: thumbnail,
+// CreateTrack validates and inserts track, assigning an ID when it has none.
+// Removals scheduled for its blobs, stored again by this upload, are
+// cancelled, and a track with an uploader is charged to that user's storage
+// usage, all in one transaction, which fails with a *QuotaError when the
+// charge would take them past quota. When the track is not created its blobs
+// are discarded (see discardTrackBlobs). Should a live track hold the same
+// audio by then, as when the same file was uploaded twice at once, that track
+// is returned with ErrDuplicateTrack.
+func (r *trackRepo) CreateTrack(ctx context.Context, track *Track, quota UsageLimit) (*Track, error) {
+	if track.ID == uuid.Nil {
+		track.ID = uuid.New()
 	}
 
-	if err := validate.Struct(track); err != nil {
+	err := validate.Struct(track)
+	if err == nil {
+		err = r.createTrack(ctx, track, quota)
+	}
+	if err != nil {
+		discardTrackBlobs(ctx, r.Db, []Track{*track})
+		if isUniqueViolation(err) && strings.Contains(err.Error(), trackChecksumIndex) {
+			if existing, ferr := r.GetTrackByChecksum(ctx, track.Checksum); ferr == nil {
+				return existing, ErrDuplicateTrack
+			}
+		}
 		return nil, err
 	}
+	return track, nil
+}
 
-	res := r.Db.WithContext(ctx).Create(track)
-
-	return track, res.Error
+func (r *trackRepo) createTrack(ctx context.Context, track *Track, quota UsageLimit) error {
+	return r.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(track).Error; err != nil {
+			return err
+		}
+		if err := cancelBlobRemovals(tx, trackBlobs(*track)); err != nil {
+			return err
+		}
+		if track.UploaderID == nil {
+			return nil
+		}
+		return chargeUsage(tx, *track.UploaderID, track.Size, 1, quota)
+	})
 }
 
-func (r *trackRepo) GetTracks(ctx context.Context, limit int, offset int) ([]*Track, error) {
+func (r *trackRepo) GetTracks(ctx context.Context, limit int, offset int, filter TrackFilter) ([]*Track, error) {
 	var tracks []*Track
 
-	res := r.Db.WithContext(ctx).
+	res := filter.apply(r.Db.WithContext(ctx)).
 		Preload("Artist", func(db *gorm.DB) *gorm.DB {
 			return db.Select("id", "name", "created_at", "updated_at")
 		}).
@@ -83,10 +189,13 @@ func (r *trackRepo) GetTrackByTitle(ctx context.Context, title string) ([]*Track
 	return tracks, nil
 }
 
+// GetTrackByArtist returns the tracks of every artist whose name contains
+// artist, including those they are only credited on.
 func (r *trackRepo) GetTrackByArtist(ctx context.Context, artist string) ([]*Track, error) {
 	var tracks []*Track
-	res := r.Db.WithContext(ctx).Joins("JOIN auxstream.artists ON auxstream.tracks.artist_id = auxstream.artists.id").
-		Where("auxstream.artists.name ILIKE ?", "%"+artist+"%").
+	res := r.Db.WithContext(ctx).
+		Where(`EXISTS (SELECT 1 FROM auxstream.artists a WHERE a.name ILIKE ? AND (a.id = tracks.artist_id OR
+			EXISTS (SELECT 1 FROM auxstream.track_artists ta WHERE ta.track_id = tracks.id AND ta.artist_id = a.id)))`, "%"+artist+"%").
 		Find(&tracks)
 
 	if res.Error != nil {
@@ -96,11 +205,18 @@ func (r *trackRepo) GetTrackByArtist(ctx context.Context, artist string) ([]*Tra
 	return tracks, nil
 }
 
+// GetTracksByArtistId pages the tracks of the artist with artistId, newest
+// first: those they are the main artist of and those they are credited on,
+// each with its credits.
 func (r *trackRepo) GetTracksByArtistId(ctx context.Context, artistId uuid.UUID, limit int, offset int) ([]*Track, error) {
 	var tracks []*Track
 	res := r.Db.WithContext(ctx).
 		Preload("Artist").
-		Where("artist_id = ?", artistId).
+		Preload("Credits", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position")
+		}).
+		Preload("Credits.Artist").
+		Where("artist_id = ? OR "+creditedOn, artistId, artistId).
 		Limit(limit).
 		Offset(offset).
 		Order("created_at DESC").
@@ -124,7 +240,24 @@ func (r *trackRepo) GetTrackByID(ctx context.Context, id uuid.UUID) (*Track, err
 	return &track, nil
 }
 
-// SearchTracks performs a combined title/artist fuzzy search. It is the
+// GetTrackByChecksum returns the oldest track whose audio hashes to checksum,
+// or gorm.ErrRecordNotFound when none does.
+func (r *trackRepo) GetTrackByChecksum(ctx context.Context, checksum string) (*Track, error) {
+	var track Track
+	res := r.Db.WithContext(ctx).Preload("Artist").
+		Where("checksum = ?", checksum).
+		Order("created_at").
+		First(&track)
+
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return &track, nil
+}
+
+// SearchTracks performs a combined title/artist fuzzy search, matching artists
+// credited on a track as well as its own. It is the
 // intended single entry point for local search (see .todo: search feature is
 // still being developed) and currently complements the aggregator's per-field
 // lookups.
@@ -134,7 +267,8 @@ func (r *trackRepo) SearchTracks(ctx context.Context, query string) ([]*Track, e
 	res := r.Db.WithContext(ctx).
 		Preload("Artist").
 		Where("LOWER(title) LIKE LOWER(?)", "%"+query+"%").
-		Or("EXISTS (SELECT 1 FROM auxstream.artists WHERE id = tracks.artist_id AND LOWER(name) LIKE LOWER(?))", "%"+query+"%").
+		Or(`EXISTS (SELECT 1 FROM auxstream.artists a WHERE LOWER(a.name) LIKE LOWER(?) AND (a.id = tracks.artist_id OR
+			EXISTS (SELECT 1 FROM auxstream.track_artists ta WHERE ta.track_id = tracks.id AND ta.artist_id = a.id)))`, "%"+query+"%").
 		Limit(20).
 		Find(&tracks)
 
@@ -147,10 +281,83 @@ func (r *trackRepo) SearchTracks(ctx context.Context, query string) ([]*Track, e
 
 // BulkTrackInput is a single title/stored-file pair for a bulk upload. Using an
 // ordered slice (rather than a title-keyed map) preserves every track even when
-// titles repeat.
+// titles repeat. ID is optional; callers that need to refer to the created
+// tracks afterwards assign it up front, otherwise one is generated. ArtistID,
+// when set, overrides the batch artist for this track. UploaderID, when set, is
+// charged for the track's storage. AlbumID, when set, adds the track to that
+// album at DiscNumber and TrackNumber. Credits are created with the track.
 type BulkTrackInput struct {
-	Title string `json:"title"`
-	File  string `json:"file"`
+	ID           uuid.UUID     `json:"id"`
+	Title        string        `json:"title"`
+	File         string        `json:"file"`
+	Duration     int           `json:"duration"`
+	Thumbnail    string        `json:"thumbnail"`
+	Images       Images        `json:"images"`
+	Downloadable bool          `json:"downloadable"`
+	ArtistID     uuid.UUID     `json:"artist_id"`
+	Checksum     string        `json:"checksum"`
+	Size         int64         `json:"size"`
+	UploaderID   uuid.UUID     `json:"uploader_id"`
+	Quota        UsageLimit    `json:"-"` // the uploader's
+	AlbumID      uuid.UUID     `json:"album_id"`
+	DiscNumber   int           `json:"disc_number"`
+	TrackNumber  int           `json:"track_number"`
+	Credits      []TrackArtist `json:"credits"`
+}
+
+// GetTrackGenres returns the names of the genres of each of the tracks, in
+// name order. Tracks without genres are absent from the map.
+func (r *trackRepo) GetTrackGenres(ctx context.Context, trackIds []uuid.UUID) (map[uuid.UUID][]string, error) {
+	genres := make(map[uuid.UUID][]string)
+	if len(trackIds) == 0 {
+		return genres, nil
+	}
+
+	var rows []struct {
+		TrackID uuid.UUID
+		Name    string
+	}
+	res := r.Db.WithContext(ctx).
+		Table("auxstream.track_tags AS tt").
+		Select("tt.track_id, t.name").
+		Joins("JOIN auxstream.tags t ON t.id = tt.tag_id").
+		Where("t.kind = ? AND tt.track_id IN ?", TagKindGenre, trackIds).
+		Order("t.name").
+		Scan(&rows)
+
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	for _, row := range rows {
+		genres[row.TrackID] = append(genres[row.TrackID], row.Name)
+	}
+	return genres, nil
+}
+
+// GetTrackCredits returns the credits of each of the tracks, with their
+// artists, in credit order. Tracks without credits are absent from the map.
+func (r *trackRepo) GetTrackCredits(ctx context.Context, trackIds []uuid.UUID) (map[uuid.UUID][]TrackArtist, error) {
+	credits := make(map[uuid.UUID][]TrackArtist)
+	if len(trackIds) == 0 {
+		return credits, nil
+	}
+
+	var rows []TrackArtist
+	res := r.Db.WithContext(ctx).
+		Preload("Artist").
+		Where("track_id IN ?", trackIds).
+		Order("position").
+		Find(&rows)
+
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	for _, row := range rows {
+		credits[row.TrackID] = append(credits[row.TrackID], row)
+	}
+	return credits, nil
 }
 
 func (r *trackRepo) BulkCreateTracks(ctx context.Context, inputs []BulkTrackInput, artistId uuid.UUID) (int64, error) {
@@ -159,13 +366,49 @@ func (r *trackRepo) BulkCreateTracks(ctx context.Context, inputs []BulkTrackInpu
 	}
 
 	tracks := make([]Track, 0, len(inputs))
+	type charge struct {
+		bytes  int64
+		tracks int
+		quota  UsageLimit
+	}
+	charges := make(map[uuid.UUID]*charge)
 	for _, in := range inputs {
+		id := in.ID
+		if id == uuid.Nil {
+			id = uuid.New()
+		}
+		artist := in.ArtistID
+		if artist == uuid.Nil {
+			artist = artistId
+		}
 		tracks = append(tracks, Track{
-			ID:       uuid.New(),
-			Title:    in.Title,
-			ArtistID: artistId,
-			File:     in.File,
+			ID:           id,
+			Title:        in.Title,
+			ArtistID:     artist,
+			File:         in.File,
+			Duration:     in.Duration,
+			Thumbnail:    in.Thumbnail,
+			Images:       in.Images,
+			Downloadable: in.Downloadable,
+			Checksum:     in.Checksum,
+			Size:         in.Size,
+			DiscNumber:   in.DiscNumber,
+			TrackNumber:  in.TrackNumber,
+			Credits:      in.Credits,
 		})
+		if in.AlbumID != uuid.Nil {
+			album := in.AlbumID
+			tracks[len(tracks)-1].AlbumID = &album
+		}
+		if in.UploaderID != uuid.Nil {
+			uploader := in.UploaderID
+			tracks[len(tracks)-1].UploaderID = &uploader
+			if charges[uploader] == nil {
+				charges[uploader] = &charge{quota: in.Quota}
+			}
+			charges[uploader].bytes += in.Size
+			charges[uploader].tracks++
+		}
 	}
 
 	logger.Info("bulk create tracks",
@@ -173,18 +416,153 @@ func (r *trackRepo) BulkCreateTracks(ctx context.Context, inputs []BulkTrackInpu
 		zap.Int("count", len(tracks)),
 	)
 
-	res := r.Db.WithContext(ctx).CreateInBatches(tracks, 100)
+	var created int64
+	err := r.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.CreateInBatches(tracks, 100)
+		if res.Error != nil {
+			return res.Error
+		}
+		created = res.RowsAffected
+		var files []string
+		for _, t := range tracks {
+			files = append(files, trackBlobs(t)...)
+		}
+		if err := cancelBlobRemovals(tx, files); err != nil {
+			return err
+		}
+		for uploader, c := range charges {
+			if err := chargeUsage(tx, uploader, c.bytes, c.tracks, c.quota); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return created, err
+}
+
+// SetTrackImages replaces the track's cover art, pointing its thumbnail at the
+// largest rendition.
+func (r *trackRepo) SetTrackImages(ctx context.Context, trackId uuid.UUID, images Images) error {
+	return r.Db.WithContext(ctx).Model(&Track{}).Where("id = ?", trackId).
+		Updates(map[string]any{"images": images, "thumbnail": images.Largest()}).Error
+}
+
+// UpdateTrack applies update to the track with trackId and returns the
+// result. Replacing the audio also drops the artifacts packaged from the old
+// audio, charges the size difference to the uploader (failing with a
+// *QuotaError past update.Audio.Quota), schedules the old blobs for removal
+// and cancels any removal scheduled for the new one, all in one transaction;
+// the caller repackages the track.
+func (r *trackRepo) UpdateTrack(ctx context.Context, trackId uuid.UUID, update TrackUpdate) (*Track, error) {
+	columns := update.columns()
+	var err error
+	switch {
+	case update.Audio != nil:
+		err = r.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var track Track
+			if err := tx.Select("id", "file", "size", "uploader_id").Where("id = ?", trackId).Take(&track).Error; err != nil {
+				return err
+			}
+			var files []string
+			if err := tx.Model(&TrackFile{}).Where("track_id = ?", trackId).Pluck("file", &files).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&Track{}).Where("id = ?", trackId).Updates(columns).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("track_id = ?", trackId).Delete(&TrackFile{}).Error; err != nil {
+				return err
+			}
+			if err := cancelBlobRemovals(tx, []string{update.Audio.File}); err != nil {
+				return err
+			}
+			if track.UploaderID != nil {
+				if err := chargeUsage(tx, *track.UploaderID, update.Audio.Size-track.Size, 0, update.Audio.Quota); err != nil {
+					return err
+				}
+			}
+			return scheduleBlobRemovals(tx, append([]string{track.File}, files...))
+		})
+	case len(columns) > 0:
+		err = r.Db.WithContext(ctx).Model(&Track{}).Where("id = ?", trackId).Updates(columns).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.GetTrackByID(ctx, trackId)
+}
+
+// DeleteTrack soft-deletes the track with trackId as retireTracks does.
+// Deleting a track that is already gone is not an error.
+func (r *trackRepo) DeleteTrack(ctx context.Context, trackId uuid.UUID) error {
+	return r.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var track Track
+		if err := tx.Select(retiredTrackColumns).Where("id = ?", trackId).Take(&track).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		return retireTracks(tx, []Track{track})
+	})
+}
+
+// retireTracks soft-deletes tracks, loaded with retiredTrackColumns, along with
+// the artifacts packaged from them and their playlist entries, gives their
+// storage back to their uploaders and schedules their blobs for removal.
+func retireTracks(tx *gorm.DB, tracks []Track) error {
+	if len(tracks) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(tracks))
+	var files []string
+	type refund struct {
+		bytes  int64
+		tracks int
+	}
+	refunds := make(map[uuid.UUID]*refund)
+	for i, t := range tracks {
+		ids[i] = t.ID
+		files = append(files, trackBlobs(t)...)
+		if t.UploaderID == nil {
+			continue
+		}
+		if refunds[*t.UploaderID] == nil {
+			refunds[*t.UploaderID] = &refund{}
+		}
+		refunds[*t.UploaderID].bytes += t.Size
+		refunds[*t.UploaderID].tracks++
+	}
 
-	return res.RowsAffected, res.Error
+	var derived []string
+	if err := tx.Model(&TrackFile{}).Where("track_id IN ?", ids).Pluck("file", &derived).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("id IN ?", ids).Delete(&Track{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("track_id IN ?", ids).Delete(&TrackFile{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("track_id IN ?", ids).Delete(&PlaylistTrack{}).Error; err != nil {
+		return err
+	}
+	for uploader, rf := range refunds {
+		if err := addUsage(tx, uploader, -rf.bytes, -rf.tracks); err != nil {
+			return err
+		}
+	}
+	return scheduleBlobRemovals(tx, append(files, derived...))
 }
 
 // GetTrendingTracks returns tracks ordered by play count, then newest first to
 // break ties. A positive days restricts to tracks created within that window;
 // days <= 0 spans all time.
-func (r *trackRepo) GetTrendingTracks(ctx context.Context, limit int, offset int, days int) ([]*Track, error) {
+func (r *trackRepo) GetTrendingTracks(ctx context.Context, limit int, offset int, days int, filter TrackFilter) ([]*Track, error) {
 	var tracks []*Track
 
-	query := r.Db.WithContext(ctx).
+	query := filter.apply(r.Db.WithContext(ctx)).
 		Preload("Artist", func(db *gorm.DB) *gorm.DB {
 			return db.Select("id", "name", "created_at", "updated_at")
 		})
@@ -209,10 +587,10 @@ func (r *trackRepo) GetTrendingTracks(ctx context.Context, limit int, offset int
 }
 
 // GetRecentTracks returns tracks newest first by creation time.
-func (r *trackRepo) GetRecentTracks(ctx context.Context, limit int, offset int) ([]*Track, error) {
+func (r *trackRepo) GetRecentTracks(ctx context.Context, limit int, offset int, filter TrackFilter) ([]*Track, error) {
 	var tracks []*Track
 
-	res := r.Db.WithContext(ctx).
+	res := filter.apply(r.Db.WithContext(ctx)).
 		Preload("Artist", func(db *gorm.DB) *gorm.DB {
 			return db.Select("id", "name", "created_at", "updated_at")
 		}).
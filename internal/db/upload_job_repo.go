@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UploadJobRepo is the durable queue behind asynchronous bulk uploads. Jobs
+// are claimed with row locks that skip rows other workers hold, so any number
+// of workers can share the queue without taking the same job twice.
+type UploadJobRepo interface {
+	CreateJob(ctx context.Context, job *UploadJob) error
+	GetJob(ctx context.Context, id uuid.UUID) (*UploadJob, error)
+	ClaimJob(ctx context.Context, staleAfter time.Duration) (*UploadJob, error)
+	UpdateJob(ctx context.Context, job *UploadJob) error
+	UpdateJobFile(ctx context.Context, file *UploadJobFile) error
+}
+
+type uploadJobRepo struct {
+	Db *gorm.DB
+}
+
+func NewUploadJobRepo(db *gorm.DB) UploadJobRepo {
+	return &uploadJobRepo{Db: db}
+}
+
+// CreateJob inserts job and its files in one transaction, queued for
+// processing straight away. IDs are assigned where missing.
+func (r *uploadJobRepo) CreateJob(ctx context.Context, job *UploadJob) error {
+	if job.ID == uuid.Nil {
+		job.ID = uuid.New()
+	}
+	job.Status = UploadJobQueued
+	job.AvailableAt = time.Now()
+	for i := range job.Files {
+		if job.Files[i].ID == uuid.Nil {
+			job.Files[i].ID = uuid.New()
+		}
+		job.Files[i].JobID = job.ID
+	}
+
+	return r.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Files").Create(job).Error; err != nil {
+			return err
+		}
+		if len(job.Files) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(job.Files, 100).Error
+	})
+}
+
+// GetJob returns the job with its files in upload order.
+func (r *uploadJobRepo) GetJob(ctx context.Context, id uuid.UUID) (*UploadJob, error) {
+	var job UploadJob
+	res := r.Db.WithContext(ctx).
+		Preload("Files", func(db *gorm.DB) *gorm.DB { return db.Order("position") }).
+		First(&job, "id = ?", id)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return &job, nil
+}
+
+// ClaimJob marks the oldest job that is ready to run as processing and
+// returns it with its files, or nil when there is none. A job still marked
+// processing after staleAfter is taken to have lost its worker and is claimed
+// again.
+func (r *uploadJobRepo) ClaimJob(ctx context.Context, staleAfter time.Duration) (*UploadJob, error) {
+	var job UploadJob
+	now := time.Now()
+	err := r.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("(status = ? AND available_at <= ?) OR (status = ? AND started_at < ?)",
+				UploadJobQueued, now, UploadJobProcessing, now.Add(-staleAfter)).
+			Order("available_at").
+			Limit(1).
+			Find(&job)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		job.Status = UploadJobProcessing
+		job.StartedAt = &now
+		job.Attempts++
+		return tx.Model(&job).Updates(map[string]any{
+			"status":     job.Status,
+			"started_at": now,
+			"attempts":   job.Attempts,
+		}).Error
+	})
+	if err != nil || job.ID == uuid.Nil {
+		return nil, err
+	}
+
+	if err := r.Db.WithContext(ctx).
+		Where("job_id = ?", job.ID).
+		Order("position").
+		Find(&job.Files).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// UpdateJob saves the job's status and scheduling fields; its files are left
+// alone.
+func (r *uploadJobRepo) UpdateJob(ctx context.Context, job *UploadJob) error {
+	return r.Db.WithContext(ctx).Model(job).
+		Select("status", "attempts", "error", "available_at", "started_at", "finished_at").
+		Updates(job).Error
+}
+
+// UpdateJobFile saves one file's outcome.
+func (r *uploadJobRepo) UpdateJobFile(ctx context.Context, file *UploadJobFile) error {
+	return r.Db.WithContext(ctx).Model(file).
+		Select("status", "error", "track_id", "stage").
+		Updates(file).Error
+}
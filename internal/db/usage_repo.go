@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UsageLimit caps what one user's uploads may hold; a zero field is no limit.
+type UsageLimit struct {
+	Bytes  int64
+	Tracks int
+}
+
+// Allows reports whether a user holding usage stays within l after adding
+// the given number of tracks, totalling bytes.
+func (l UsageLimit) Allows(usage *StorageUsage, bytes int64, tracks int) bool {
+	if l.Bytes > 0 && usage.Bytes+bytes > l.Bytes {
+		return false
+	}
+	if l.Tracks > 0 && usage.Tracks+tracks > l.Tracks {
+		return false
+	}
+	return true
+}
+
+// QuotaError refuses a charge of Tracks tracks totalling Bytes that would take
+// a user holding Usage past Limit.
+type QuotaError struct {
+	Usage  StorageUsage
+	Limit  UsageLimit
+	Bytes  int64
+	Tracks int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("storage quota exceeded: %d bytes in %d tracks used, %d bytes in %d tracks more refused",
+		e.Usage.Bytes, e.Usage.Tracks, e.Bytes, e.Tracks)
+}
+
+type UsageRepo interface {
+	GetUsage(ctx context.Context, userId uuid.UUID) (*StorageUsage, error)
+}
+
+type usageRepo struct {
+	Db *gorm.DB
+}
+
+func NewUsageRepo(db *gorm.DB) UsageRepo {
+	return &usageRepo{
+		Db: db,
+	}
+}
+
+// GetUsage returns what userId's uploads hold; a user with no ledger row yet
+// holds nothing.
+func (r *usageRepo) GetUsage(ctx context.Context, userId uuid.UUID) (*StorageUsage, error) {
+	usage := &StorageUsage{}
+	err := r.Db.WithContext(ctx).Where("user_id = ?", userId).Take(usage).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &StorageUsage{UserID: userId}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// addUsage adjusts userId's ledger by bytes and tracks, which are negative
+// when tracks are removed, creating the row on first use. Totals never drop
+// below zero, so a ledger that missed an upload cannot go negative.
+func addUsage(tx *gorm.DB, userId uuid.UUID, bytes int64, tracks int) error {
+	return tx.Exec(`INSERT INTO "auxstream"."storage_usage" (user_id, bytes, tracks, updated_at)
+		VALUES (?, GREATEST(?, 0), GREATEST(?, 0), now())
+		ON CONFLICT (user_id) DO UPDATE SET
+			bytes = GREATEST("storage_usage".bytes + ?, 0),
+			tracks = GREATEST("storage_usage".tracks + ?, 0),
+			updated_at = now()`,
+		userId, bytes, tracks, bytes, tracks).Error
+}
+
+// chargeUsage is addUsage for a charge that must fit limit. The ledger row is
+// locked for the rest of tx, so concurrent charges to userId are checked one
+// after the other against what the earlier ones left; a charge that does not
+// fit is refused with a *QuotaError.
+func chargeUsage(tx *gorm.DB, userId uuid.UUID, bytes int64, tracks int, limit UsageLimit) error {
+	if limit == (UsageLimit{}) || (bytes <= 0 && tracks <= 0) {
+		return addUsage(tx, userId, bytes, tracks)
+	}
+	if err := tx.Exec(`INSERT INTO "auxstream"."storage_usage" (user_id, bytes, tracks, updated_at)
+		VALUES (?, 0, 0, now()) ON CONFLICT (user_id) DO NOTHING`, userId).Error; err != nil {
+		return err
+	}
+	var usage StorageUsage
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userId).Take(&usage).Error; err != nil {
+		return err
+	}
+	if !limit.Allows(&usage, bytes, tracks) {
+		return &QuotaError{Usage: usage, Limit: limit, Bytes: bytes, Tracks: tracks}
+	}
+	return addUsage(tx, userId, bytes, tracks)
+}
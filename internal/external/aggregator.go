@@ -1,26 +1,35 @@
 package external
 
 import (
+	"auxstream/internal/auth"
 	"auxstream/internal/db"
 	"auxstream/internal/logger"
 	"context"
 	"fmt"
 	"sync"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
 // SearchResult represents a unified search result from any source
 type SearchResult struct {
-	ID          string `json:"id"`
-	Title       string `json:"title"`
-	Artist      string `json:"artist"`
-	Duration    int    `json:"duration"` // in seconds
-	Thumbnail   string `json:"thumbnail"`
-	Source      string `json:"source"` // "local", "youtube", "soundcloud"
-	ExternalID  string `json:"external_id,omitempty"`
-	StreamURL   string `json:"stream_url"`
-	Description string `json:"description,omitempty"`
+	ID          string   `json:"id"`
+	Title       string   `json:"title"`
+	Artist      string   `json:"artist"`
+	Duration    int      `json:"duration"` // in seconds
+	Thumbnail   string   `json:"thumbnail"`
+	Source      string   `json:"source"` // "local", "youtube", "soundcloud"
+	ExternalID  string   `json:"external_id,omitempty"`
+	StreamURL   string   `json:"stream_url"`
+	Description string   `json:"description,omitempty"`
+	Genres      []string `json:"genres,omitempty"`
+}
+
+// StreamURLSigner issues the signed, expiring URL a listener uses to fetch a
+// local track's audio (see auth.StreamTokenService).
+type StreamURLSigner interface {
+	SignedStreamURL(trackID, userID uuid.UUID) string
 }
 
 // Aggregator combines search results from multiple sources
@@ -28,14 +37,16 @@ type Aggregator struct {
 	youtubeClient    *YouTubeClient
 	soundcloudClient *SoundCloudClient
 	trackRepo        db.TrackRepo
+	signer           StreamURLSigner
 }
 
 // NewAggregator creates a new search aggregator
-func NewAggregator(youtubeClient *YouTubeClient, soundcloudClient *SoundCloudClient, trackRepo db.TrackRepo) *Aggregator {
+func NewAggregator(youtubeClient *YouTubeClient, soundcloudClient *SoundCloudClient, trackRepo db.TrackRepo, signer StreamURLSigner) *Aggregator {
 	return &Aggregator{
 		youtubeClient:    youtubeClient,
 		soundcloudClient: soundcloudClient,
 		trackRepo:        trackRepo,
+		signer:           signer,
 	}
 }
 
@@ -44,8 +55,9 @@ func NewAggregator(youtubeClient *YouTubeClient, soundcloudClient *SoundCloudCli
 // sources (local plus whichever external clients are configured), with a floor
 // of 5 per source. A failure in any one source is logged and skipped, not
 // returned, so partial results are normal; the error is non-nil only for a
-// systemic failure.
-func (a *Aggregator) Search(ctx context.Context, query string, maxResults int) ([]SearchResult, error) {
+// systemic failure. A non-empty genre keeps only results of that genre, which
+// leaves out YouTube, whose results carry none.
+func (a *Aggregator) Search(ctx context.Context, query string, genre string, maxResults int) ([]SearchResult, error) {
 	var (
 		wg      sync.WaitGroup
 		mu      sync.Mutex
@@ -53,7 +65,8 @@ func (a *Aggregator) Search(ctx context.Context, query string, maxResults int) (
 	)
 
 	availableSources := 1 // the local database is always searchable
-	if a.youtubeClient != nil && a.youtubeClient.apiKey != "" {
+	searchYouTube := genre == "" && a.youtubeClient != nil && a.youtubeClient.apiKey != ""
+	if searchYouTube {
 		availableSources++
 	}
 	if a.soundcloudClient != nil && a.soundcloudClient.clientID != "" {
@@ -70,7 +83,7 @@ func (a *Aggregator) Search(ctx context.Context, query string, maxResults int) (
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		localResults, err := a.searchLocal(ctx, query, resultsPerSource)
+		localResults, err := a.searchLocal(ctx, query, genre, resultsPerSource)
 		if err != nil {
 			logger.Error("Error searching local database", zap.Error(err))
 			return
@@ -80,7 +93,7 @@ func (a *Aggregator) Search(ctx context.Context, query string, maxResults int) (
 		mu.Unlock()
 	}()
 
-	if a.youtubeClient != nil && a.youtubeClient.apiKey != "" {
+	if searchYouTube {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -99,7 +112,7 @@ func (a *Aggregator) Search(ctx context.Context, query string, maxResults int) (
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			scResults, err := a.searchSoundCloud(ctx, query, resultsPerSource)
+			scResults, err := a.searchSoundCloud(ctx, query, genre, resultsPerSource)
 			if err != nil {
 				logger.Error("Error searching SoundCloud", zap.Error(err))
 				return
@@ -120,9 +133,11 @@ func (a *Aggregator) Search(ctx context.Context, query string, maxResults int) (
 }
 
 // searchLocal matches query against both title and artist in the local DB and
-// merges the two result sets. A title-lookup failure aborts; an artist-lookup
-// failure is tolerated (title hits alone are still useful).
-func (a *Aggregator) searchLocal(ctx context.Context, query string, maxResults int) ([]SearchResult, error) {
+// merges the two result sets, keeping only tracks of genre when it is set. A
+// title-lookup failure aborts; an artist-lookup failure is tolerated (title
+// hits alone are still useful), and so is failing to look up genres unless
+// they are filtered on.
+func (a *Aggregator) searchLocal(ctx context.Context, query string, genre string, maxResults int) ([]SearchResult, error) {
 	tracks, err := a.trackRepo.GetTrackByTitle(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search local tracks: %w", err)
@@ -145,11 +160,26 @@ func (a *Aggregator) searchLocal(ctx context.Context, query string, maxResults i
 		}
 	}
 
-	var results []SearchResult
+	ids := make([]uuid.UUID, len(uniqueTracks))
 	for i, track := range uniqueTracks {
-		if i >= maxResults {
+		ids[i] = track.ID
+	}
+	genres, err := a.trackRepo.GetTrackGenres(ctx, ids)
+	if err != nil {
+		if genre != "" {
+			return nil, fmt.Errorf("failed to look up genres: %w", err)
+		}
+		logger.Error("Error looking up track genres", zap.Error(err))
+	}
+
+	var results []SearchResult
+	for _, track := range uniqueTracks {
+		if len(results) >= maxResults {
 			break
 		}
+		if genre != "" && !hasGenre(genres[track.ID], genre) {
+			continue
+		}
 
 		results = append(results, SearchResult{
 			ID:        track.ID.String(),
@@ -158,13 +188,34 @@ func (a *Aggregator) searchLocal(ctx context.Context, query string, maxResults i
 			Duration:  track.Duration,
 			Thumbnail: track.Thumbnail,
 			Source:    "local",
-			StreamURL: fmt.Sprintf("/api/v1/serve/%s", track.File),
+			Genres:    genres[track.ID],
 		})
 	}
 
+	a.SignLocalResults(ctx, results)
 	return results, nil
 }
 
+// SignLocalResults sets a fresh signed stream URL on every local result, issued
+// to the user authenticated on ctx (anonymous otherwise). Signed URLs expire,
+// so results served from a cache must be re-signed before they are returned.
+func (a *Aggregator) SignLocalResults(ctx context.Context, results []SearchResult) {
+	if a.signer == nil {
+		return
+	}
+	userID, _ := auth.UserIDFromContext(ctx)
+	for i := range results {
+		if results[i].Source != "local" {
+			continue
+		}
+		trackID, err := uuid.Parse(results[i].ID)
+		if err != nil {
+			continue
+		}
+		results[i].StreamURL = a.signer.SignedStreamURL(trackID, userID)
+	}
+}
+
 // searchYouTube queries the YouTube client and normalizes its results into the
 // unified SearchResult shape.
 func (a *Aggregator) searchYouTube(ctx context.Context, query string, maxResults int) ([]SearchResult, error) {
@@ -193,8 +244,8 @@ func (a *Aggregator) searchYouTube(ctx context.Context, query string, maxResults
 
 // searchSoundCloud queries the SoundCloud client and normalizes its results
 // into the unified SearchResult shape.
-func (a *Aggregator) searchSoundCloud(ctx context.Context, query string, maxResults int) ([]SearchResult, error) {
-	scResults, err := a.soundcloudClient.Search(ctx, query, maxResults)
+func (a *Aggregator) searchSoundCloud(ctx context.Context, query string, genre string, maxResults int) ([]SearchResult, error) {
+	scResults, err := a.soundcloudClient.Search(ctx, query, genre, maxResults)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search SoundCloud: %w", err)
 	}
@@ -212,6 +263,9 @@ func (a *Aggregator) searchSoundCloud(ctx context.Context, query string, maxResu
 			StreamURL:   scResult.StreamURL,
 			Description: scResult.Description,
 		})
+		if scResult.Genre != "" {
+			results[len(results)-1].Genres = []string{scResult.Genre}
+		}
 	}
 
 	return results, nil
@@ -219,22 +273,38 @@ func (a *Aggregator) searchSoundCloud(ctx context.Context, query string, maxResu
 
 // SearchBySource queries a single source ("local", "youtube", or "soundcloud").
 // Unlike Search it does not swallow failures: an unconfigured external source or
-// an unknown source name is returned as an error.
-func (a *Aggregator) SearchBySource(ctx context.Context, query string, source string, maxResults int) ([]SearchResult, error) {
+// an unknown source name is returned as an error. A genre filters as in Search,
+// so YouTube then finds nothing.
+func (a *Aggregator) SearchBySource(ctx context.Context, query string, source string, genre string, maxResults int) ([]SearchResult, error) {
 	switch source {
 	case "local":
-		return a.searchLocal(ctx, query, maxResults)
+		return a.searchLocal(ctx, query, genre, maxResults)
 	case "youtube":
 		if a.youtubeClient == nil || a.youtubeClient.apiKey == "" {
 			return nil, fmt.Errorf("youtube client not configured")
 		}
+		if genre != "" {
+			return nil, nil
+		}
 		return a.searchYouTube(ctx, query, maxResults)
 	case "soundcloud":
 		if a.soundcloudClient == nil || a.soundcloudClient.clientID == "" {
 			return nil, fmt.Errorf("soundcloud client not configured")
 		}
-		return a.searchSoundCloud(ctx, query, maxResults)
+		return a.searchSoundCloud(ctx, query, genre, maxResults)
 	default:
 		return nil, fmt.Errorf("unsupported source: %s", source)
 	}
 }
+
+// hasGenre reports whether genres include genre, however either is spelled
+// (see db.TagSlug).
+func hasGenre(genres []string, genre string) bool {
+	want := db.TagSlug(genre)
+	for _, g := range genres {
+		if db.TagSlug(g) == want {
+			return true
+		}
+	}
+	return false
+}
@@ -28,6 +28,7 @@ type SoundCloudSearchResult struct {
 	ExternalID  string `json:"external_id"`
 	StreamURL   string `json:"stream_url"`
 	Description string `json:"description"`
+	Genre       string `json:"genre"`
 }
 
 // SoundCloudAPIResponse represents the raw API response
@@ -43,6 +44,7 @@ type SoundCloudAPIResponse struct {
 		ArtworkURL   string `json:"artwork_url"`
 		PermalinkURL string `json:"permalink_url"`
 		Streamable   bool   `json:"streamable"`
+		Genre        string `json:"genre"`
 	} `json:"collection"`
 	NextHref string `json:"next_href"`
 }
@@ -60,8 +62,9 @@ func NewSoundCloudClient(clientID string) *SoundCloudClient {
 
 // Search returns up to maxResults streamable tracks matching query, normalized
 // with durations in seconds and thumbnails upgraded to 500x500. Non-streamable
-// tracks are filtered out, so fewer than maxResults may come back.
-func (s *SoundCloudClient) Search(ctx context.Context, query string, maxResults int) ([]SoundCloudSearchResult, error) {
+// tracks are filtered out, so fewer than maxResults may come back. A non-empty
+// genre keeps only tracks of that genre.
+func (s *SoundCloudClient) Search(ctx context.Context, query string, genre string, maxResults int) ([]SoundCloudSearchResult, error) {
 	if s.clientID == "" {
 		return nil, fmt.Errorf("soundcloud client ID not configured")
 	}
@@ -72,6 +75,10 @@ func (s *SoundCloudClient) Search(ctx context.Context, query string, maxResults
 	params.Add("limit", fmt.Sprintf("%d", maxResults))
 	params.Add("linked_partitioning", "1")
 
+	if genre != "" {
+		params.Add("genres", genre)
+	}
+
 	searchURL := fmt.Sprintf("%s/tracks?%s", s.baseURL, params.Encode())
 
 	req, err := http.NewRequestWithContext(ctx, "GET", searchURL, nil)
@@ -122,6 +129,7 @@ func (s *SoundCloudClient) Search(ctx context.Context, query string, maxResults
 			ExternalID:  fmt.Sprintf("%d", track.ID),
 			StreamURL:   track.PermalinkURL,
 			Description: track.Description,
+			Genre:       track.Genre,
 		})
 
 		// Defensive cap: the API limit already bounds the collection, but enforce
@@ -174,6 +182,7 @@ func (s *SoundCloudClient) GetTrack(ctx context.Context, trackID string) (*Sound
 		ArtworkURL   string `json:"artwork_url"`
 		PermalinkURL string `json:"permalink_url"`
 		Streamable   bool   `json:"streamable"`
+		Genre        string `json:"genre"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&track); err != nil {
@@ -203,6 +212,7 @@ func (s *SoundCloudClient) GetTrack(ctx context.Context, trackID string) (*Sound
 		ExternalID:  fmt.Sprintf("%d", track.ID),
 		StreamURL:   track.PermalinkURL,
 		Description: track.Description,
+		Genre:       track.Genre,
 	}, nil
 }
 
@@ -248,6 +258,7 @@ func (s *SoundCloudClient) ResolveURL(ctx context.Context, soundcloudURL string)
 		ArtworkURL   string `json:"artwork_url"`
 		PermalinkURL string `json:"permalink_url"`
 		Streamable   bool   `json:"streamable"`
+		Genre        string `json:"genre"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&track); err != nil {
@@ -281,6 +292,7 @@ func (s *SoundCloudClient) ResolveURL(ctx context.Context, soundcloudURL string)
 		ExternalID:  fmt.Sprintf("%d", track.ID),
 		StreamURL:   track.PermalinkURL,
 		Description: track.Description,
+		Genre:       track.Genre,
 	}, nil
 }
 
@@ -381,6 +393,7 @@ func (s *SoundCloudClient) SearchTrending(ctx context.Context, genre string, max
 			ExternalID:  fmt.Sprintf("%d", track.ID),
 			StreamURL:   track.PermalinkURL,
 			Description: track.Description,
+			Genre:       track.Genre,
 		})
 
 		if len(results) >= maxResults {
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"auxstream/internal/auth"
+	"auxstream/internal/db"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// GetAlbumHandler returns an album with its artist and its tracks in disc and
+// track order; responds 400 on a malformed UUID and 404 when no such album
+// exists.
+func GetAlbumHandler(c *gin.Context, albums db.AlbumRepo, tokens *auth.StreamTokenService) {
+	albumId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("invalid album ID format"))
+		return
+	}
+
+	album, err := albums.GetAlbumByID(c, albumId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("album not found"))
+		return
+	}
+
+	tracks := make([]*db.Track, len(album.Tracks))
+	for i := range album.Tracks {
+		tracks[i] = &album.Tracks[i]
+	}
+	signStreamURLs(c, tokens, tracks...)
+	c.JSON(http.StatusOK, gin.H{
+		"data": album,
+	})
+}
+
+// GetArtistAlbumsHandler pages an artist's albums, newest release first
+// (pagesize/pagenumber query params, defaulting to 20/1). A missing artist
+// yields 404 before any album lookup.
+func GetArtistAlbumsHandler(c *gin.Context, albums db.AlbumRepo, artistRepo db.ArtistRepo) {
+	artistId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("invalid artist ID format"))
+		return
+	}
+
+	if _, err := artistRepo.GetArtistById(c, artistId); err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("artist not found"))
+		return
+	}
+
+	var params GetArtistTracksQueryParams
+	params.PageSize = 20
+	params.PageNum = 1
+	if err := c.ShouldBindQuery(&params); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+
+	limit := params.PageSize
+	offset := (params.PageNum - 1) * params.PageSize
+
+	list, err := albums.GetAlbumsByArtistID(c, artistId, limit, offset)
+	if err != nil {
+		log.Printf("GetAlbumsByArtistID error: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to fetch albums"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": list,
+		"meta": gin.H{
+			"page":      params.PageNum,
+			"page_size": params.PageSize,
+			"artist_id": artistId,
+		},
+	})
+}
+
+// albumFields name the album an upload adds its tracks to: an existing one by
+// AlbumID, or else the one titled Album under the tracks' artist, created with
+// AlbumType and ReleaseDate if the artist has none by that title.
+type albumFields struct {
+	AlbumID     uuid.UUID  `json:"album_id"`
+	Album       string     `json:"album"`
+	AlbumType   string     `json:"album_type"`
+	ReleaseDate *time.Time `json:"release_date"`
+}
+
+// newAlbumFields reads albumFields from the album_id, album, album_type and
+// release_date fields of an upload form, any of which may be empty.
+func newAlbumFields(albumID, album, albumType, releaseDate string) (albumFields, error) {
+	var a albumFields
+	for _, field := range [][2]string{
+		{"album_id", albumID},
+		{"album", album},
+		{"album_type", albumType},
+		{"release_date", releaseDate},
+	} {
+		if field[1] == "" {
+			continue
+		}
+		if err := a.set(field[0], field[1]); err != nil {
+			return a, err
+		}
+	}
+	return a, nil
+}
+
+// set reads the album field key from value, ignoring keys that are not album
+// fields.
+func (a *albumFields) set(key, value string) error {
+	switch key {
+	case "album_id":
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return fmt.Errorf("album id should be a valid uuid string not %s", value)
+		}
+		a.AlbumID = id
+	case "album":
+		a.Album = value
+	case "album_type":
+		if !db.IsAlbumType(value) {
+			return fmt.Errorf("album_type should be single, ep, album or compilation not %s", value)
+		}
+		a.AlbumType = value
+	case "release_date":
+		date, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return fmt.Errorf("release_date should be a date such as 2006-01-02 not %s", value)
+		}
+		a.ReleaseDate = &date
+	}
+	return nil
+}
+
+// findUploadAlbum looks up the existing album a names, if any: nil without an
+// album id, and an uploadError with 404 for an id no album has.
+func findUploadAlbum(c *gin.Context, albums db.AlbumRepo, a albumFields) (*db.Album, error) {
+	if a.AlbumID == uuid.Nil {
+		return nil, nil
+	}
+	album, err := albums.GetAlbumByID(c, a.AlbumID)
+	if err != nil {
+		return nil, &uploadError{http.StatusNotFound, fmt.Sprintf("album with id (%s) does not exist", a.AlbumID)}
+	}
+	return album, nil
+}
+
+// createUploadAlbum finds or creates the album titled a.Album under artistID.
+func createUploadAlbum(c *gin.Context, albums db.AlbumRepo, a albumFields, artistID uuid.UUID) (*db.Album, error) {
+	album, err := albums.CreateAlbum(c, &db.Album{
+		Title:       a.Album,
+		ArtistID:    artistID,
+		Type:        a.AlbumType,
+		ReleaseDate: a.ReleaseDate,
+	})
+	if err != nil {
+		log.Printf("create album error: %v", err)
+		return nil, errors.New("failed to resolve album")
+	}
+	return album, nil
+}
@@ -1,9 +1,16 @@
 package handlers
 
 import (
+	"auxstream/internal/auth"
+	"auxstream/internal/cache"
 	"auxstream/internal/db"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -14,7 +21,8 @@ type CreateArtistRequest struct {
 }
 
 // CreateArtistHandler reads a JSON body with a required name and responds 201
-// with the created artist.
+// with the created artist, whose profile the caller then manages. An artist
+// of that name that already exists is returned as it is.
 func CreateArtistHandler(c *gin.Context, r db.ArtistRepo) {
 	var req CreateArtistRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -22,7 +30,13 @@ func CreateArtistHandler(c *gin.Context, r db.ArtistRepo) {
 		return
 	}
 
-	artist, err := r.CreateArtist(c, req.Name)
+	var artist *db.Artist
+	var err error
+	if userID, ok := currentUserID(c); ok {
+		artist, err = r.CreateOwnedArtist(c, req.Name, userID)
+	} else {
+		artist, err = r.CreateArtist(c, req.Name)
+	}
 	if err != nil {
 		log.Printf("CreateArtist error: %v", err)
 		c.JSON(http.StatusInternalServerError, errorResponse("failed to create artist"))
@@ -60,10 +74,11 @@ type GetArtistTracksQueryParams struct {
 	PageNum  int `form:"pagenumber" binding:"gte=1"`
 }
 
-// GetArtistTracksHandler pages an artist's tracks (pagesize/pagenumber query
-// params, defaulting to 20/1). The artist id comes from the path; a missing
+// GetArtistTracksHandler pages an artist's tracks, including those they are
+// featured or otherwise credited on (pagesize/pagenumber query params,
+// defaulting to 20/1). The artist id comes from the path; a missing
 // artist yields 404 before any track lookup.
-func GetArtistTracksHandler(c *gin.Context, trackRepo db.TrackRepo, artistRepo db.ArtistRepo) {
+func GetArtistTracksHandler(c *gin.Context, trackRepo db.TrackRepo, artistRepo db.ArtistRepo, tokens *auth.StreamTokenService) {
 	artistIdStr := c.Param("id")
 	artistId, err := uuid.Parse(artistIdStr)
 	if err != nil {
@@ -97,6 +112,8 @@ func GetArtistTracksHandler(c *gin.Context, trackRepo db.TrackRepo, artistRepo d
 		return
 	}
 
+	signStreamURLs(c, tokens, tracks...)
+
 	c.JSON(http.StatusOK, gin.H{
 		"data": tracks,
 		"meta": gin.H{
@@ -106,3 +123,155 @@ func GetArtistTracksHandler(c *gin.Context, trackRepo db.TrackRepo, artistRepo d
 		},
 	})
 }
+
+const (
+	// maxArtistNameLength bounds an artist's name, in characters.
+	maxArtistNameLength = 100
+	// maxArtistBioLength bounds an artist's bio, in characters.
+	maxArtistBioLength = 2000
+	// maxArtistLinks bounds how many profiles elsewhere an artist may link.
+	maxArtistLinks = 10
+)
+
+// UpdateArtistRequest changes an artist's profile: fields left out keep what
+// is there, and an empty bio, country or set of links clears it. Country is an
+// ISO 3166-1 alpha-2 code; links map a site's name to an http(s) URL.
+type UpdateArtistRequest struct {
+	Name     *string   `json:"name"`
+	Bio      *string   `json:"bio"`
+	Country  *string   `json:"country"`
+	Links    *db.Links `json:"links"`
+	Verified *bool     `json:"verified"` // admins only
+}
+
+// update checks req and returns the db.ArtistUpdate it asks for, tidied up.
+func (req UpdateArtistRequest) update() (db.ArtistUpdate, error) {
+	u := db.ArtistUpdate{Bio: req.Bio, Verified: req.Verified}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return u, errors.New("name must not be blank")
+		}
+		if utf8.RuneCountInString(name) > maxArtistNameLength {
+			return u, fmt.Errorf("name exceeds %d characters", maxArtistNameLength)
+		}
+		u.Name = &name
+	}
+	if req.Bio != nil && utf8.RuneCountInString(*req.Bio) > maxArtistBioLength {
+		return u, fmt.Errorf("bio exceeds %d characters", maxArtistBioLength)
+	}
+	if req.Country != nil {
+		country := strings.ToUpper(strings.TrimSpace(*req.Country))
+		if country != "" && (len(country) != 2 || strings.Trim(country, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "") {
+			return u, fmt.Errorf("country should be a two-letter ISO 3166-1 code not %s", *req.Country)
+		}
+		u.Country = &country
+	}
+	if req.Links != nil {
+		if len(*req.Links) > maxArtistLinks {
+			return u, fmt.Errorf("at most %d links are allowed", maxArtistLinks)
+		}
+		links := make(db.Links, len(*req.Links))
+		for site, link := range *req.Links {
+			site = strings.ToLower(strings.TrimSpace(site))
+			if site == "" || len(site) > 32 {
+				return u, fmt.Errorf("link names should be 1 to 32 characters not %q", site)
+			}
+			parsed, err := url.Parse(strings.TrimSpace(link))
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return u, fmt.Errorf("%s link should be an http or https URL not %q", site, link)
+			}
+			links[site] = parsed.String()
+		}
+		u.Links = &links
+	}
+	return u, nil
+}
+
+// UpdateArtistHandler changes an artist's profile as an UpdateArtistRequest
+// says and returns the result. Only the artist's owner or an admin may change
+// it, and only an admin may verify it; anyone else gets 403. Renaming it after
+// another artist gets 409.
+func UpdateArtistHandler(c *gin.Context, r db.ArtistRepo, users db.UserRepo) {
+	artistId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("invalid artist ID format"))
+		return
+	}
+	var req UpdateArtistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	update, err := req.update()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	artist, err := r.GetArtistById(c, artistId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("artist not found"))
+		return
+	}
+	if !canManage(c, users, artist.OwnerID) {
+		c.JSON(http.StatusForbidden, errorResponse("only the artist's owner or an admin may change its profile"))
+		return
+	}
+	if update.Verified != nil && !isAdmin(c, users) {
+		c.JSON(http.StatusForbidden, errorResponse("only an admin may verify an artist"))
+		return
+	}
+
+	updated, err := r.UpdateArtist(c, artistId, update)
+	if errors.Is(err, db.ErrArtistNameTaken) {
+		c.JSON(http.StatusConflict, errorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("UpdateArtist error: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to update artist"))
+		return
+	}
+	forgetArtist(c, artistId)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": updated,
+	})
+}
+
+// DeleteArtistHandler deletes an artist along with its albums and the tracks
+// it is the main artist of (see db.ArtistRepo.DeleteArtist). Only the artist's
+// owner or an admin may delete it; anyone else gets 403.
+func DeleteArtistHandler(c *gin.Context, r db.ArtistRepo, users db.UserRepo) {
+	artistId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("invalid artist ID format"))
+		return
+	}
+	artist, err := r.GetArtistById(c, artistId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("artist not found"))
+		return
+	}
+	if !canManage(c, users, artist.OwnerID) {
+		c.JSON(http.StatusForbidden, errorResponse("only the artist's owner or an admin may delete it"))
+		return
+	}
+
+	if err := r.DeleteArtist(c, artistId); err != nil {
+		log.Printf("DeleteArtist error: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to delete artist"))
+		return
+	}
+	forgetArtist(c, artistId)
+
+	c.JSON(http.StatusOK, gin.H{"message": "artist deleted"})
+}
+
+// forgetArtist drops the cached copy of an artist that uploads resolve artist
+// ids through (see resolveUploadArtist), after it changes.
+func forgetArtist(c *gin.Context, id uuid.UUID) {
+	if cacheClient, ok := c.Request.Context().Value(CacheContextKey).(cache.Cache); ok {
+		_ = cacheClient.Del(fmt.Sprintf("artist-id-%s", id))
+	}
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"auxstream/internal/db"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// creditRoles are the upload fields naming artists to credit on a track,
+// each with the role it credits them in.
+var creditRoles = []struct{ field, role string }{
+	{"artist_ids", db.ArtistRolePrimary},
+	{"featured_artist_ids", db.ArtistRoleFeatured},
+	{"remixer_ids", db.ArtistRoleRemixer},
+	{"producer_ids", db.ArtistRoleProducer},
+}
+
+// creditFields name the artists an upload credits besides its main artist, by
+// id and role, in the order given.
+type creditFields []db.TrackArtist
+
+// newCreditFields reads creditFields from the credit fields of an upload form,
+// keyed by field name; each value may repeat and may list several ids,
+// separated by commas.
+func newCreditFields(values map[string][]string) (creditFields, error) {
+	var cr creditFields
+	for _, credit := range creditRoles {
+		for _, value := range values[credit.field] {
+			if err := cr.set(credit.field, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return cr, nil
+}
+
+// set adds the artists listed in value, separated by commas, under the role
+// of the credit field key, ignoring keys that are not credit fields.
+func (cr *creditFields) set(key, value string) error {
+	for _, credit := range creditRoles {
+		if credit.field != key {
+			continue
+		}
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			id, err := uuid.Parse(s)
+			if err != nil {
+				return fmt.Errorf("%s should list valid uuid strings not %s", key, s)
+			}
+			*cr = append(*cr, db.TrackArtist{ArtistID: id, Role: credit.role})
+		}
+	}
+	return nil
+}
+
+// resolveCredits turns the credits of an upload into those of its track,
+// whose main artist is main. Each credited artist must exist (an uploadError
+// with 404 otherwise). Unless credits name a featured artist, the featured
+// artists read from the file's tags are credited, created if absent. Credits
+// of main and repeated credits are dropped.
+func resolveCredits(c *gin.Context, artistRepo db.ArtistRepo, main uuid.UUID, credits creditFields, featured []string) ([]db.TrackArtist, error) {
+	type key struct {
+		artist uuid.UUID
+		role   string
+	}
+	var out []db.TrackArtist
+	seen := map[key]bool{}
+	add := func(artist *db.Artist, role string) {
+		k := key{artist.ID, role}
+		if artist.ID == main || seen[k] {
+			return
+		}
+		seen[k] = true
+		out = append(out, db.TrackArtist{ArtistID: artist.ID, Role: role, Position: len(out)})
+	}
+
+	givesFeatured := false
+	for _, credit := range credits {
+		artist, err := resolveUploadArtist(c, artistRepo, credit.ArtistID, "")
+		if err != nil {
+			return nil, err
+		}
+		givesFeatured = givesFeatured || credit.Role == db.ArtistRoleFeatured
+		add(artist, credit.Role)
+	}
+	if givesFeatured {
+		return out, nil
+	}
+	for _, name := range featured {
+		artist, err := resolveUploadArtist(c, artistRepo, uuid.Nil, name)
+		if err != nil {
+			return nil, err
+		}
+		add(artist, db.ArtistRoleFeatured)
+	}
+	return out, nil
+}
+
+// fillCredits sets the credits of tracks, for responses that list them.
+// Failing to is only logged: the tracks are still worth returning.
+func fillCredits(c *gin.Context, r db.TrackRepo, tracks ...*db.Track) {
+	if len(tracks) == 0 {
+		return
+	}
+	ids := make([]uuid.UUID, len(tracks))
+	for i, t := range tracks {
+		ids[i] = t.ID
+	}
+	credits, err := r.GetTrackCredits(c, ids)
+	if err != nil {
+		log.Printf("GetTrackCredits error: %v", err)
+		return
+	}
+	for _, t := range tracks {
+		t.Credits = credits[t.ID]
+	}
+}
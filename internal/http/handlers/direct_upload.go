@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"auxstream/internal/cache"
+	"auxstream/internal/db"
+	"auxstream/internal/ingest"
+	"auxstream/internal/metrics"
+	fs "auxstream/internal/storage"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Direct uploads take audio around the API when the file store is S3: the
+// client asks for an upload, PUTs the file to the presigned URL it is given,
+// then completes the upload. Completing checks the object with a HEAD and a
+// ranged read of its first bytes, so the API never downloads it; the structure
+// checks and tag reading the other upload paths do would need the whole file,
+// so direct uploads take their title and artist from the request alone.
+
+// directUploadURLTTL is how long a presigned upload URL stays valid.
+const directUploadURLTTL = time.Hour
+
+// directUploadTTL is how long a direct upload can wait to be completed.
+const directUploadTTL = 24 * time.Hour
+
+// directUploadSniffBytes is how much of an uploaded object is read to detect
+// its format.
+const directUploadSniffBytes = 512
+
+// directUpload is the state of one direct upload, as kept in the cache.
+type directUpload struct {
+	ID           string     `json:"id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	Quota        Quota      `json:"quota"` // UserID's when the upload was created
+	Size         int64      `json:"size"`
+	Checksum     string     `json:"checksum"`
+	Filename     string     `json:"filename"`
+	Title        string     `json:"title"`
+	ArtistID     uuid.UUID  `json:"artist_id"`
+	Artist       string     `json:"artist"`
+	Duration     int        `json:"duration"`
+	Thumbnail    string     `json:"thumbnail"`
+	Downloadable bool       `json:"downloadable"`
+	TrackID      *uuid.UUID `json:"track_id,omitempty"`
+}
+
+func directUploadKey(id string) string {
+	return fmt.Sprintf("direct-upload-%s", id)
+}
+
+func directUploadLockKey(id string) string {
+	return fmt.Sprintf("direct-lock-%s", id)
+}
+
+type DirectUploadForm struct {
+	Filename     string `json:"filename" binding:"required"` // the track's title defaults to its stem
+	Size         int64  `json:"size" binding:"required,gt=0"`
+	SHA256       string `json:"sha256" binding:"required"` // hex SHA-256 of the file, which the bucket holds the PUT to
+	Title        string `json:"title"`
+	ArtistId     string `json:"artist_id"` // artist_id or artist is required
+	Artist       string `json:"artist"`
+	Duration     int    `json:"duration"`
+	Thumbnail    string `json:"thumbnail"`
+	Downloadable bool   `json:"downloadable"`
+}
+
+// CreateDirectUploadHandler starts a direct upload of the file described by a
+// DirectUploadForm, responding 201 with the presigned URL to PUT it to and the
+// headers the PUT must carry, or 501 when the file store is not S3. Files
+// over MaxUploadBytes (or what one PUT can carry) get 413, and files that would
+// take the caller past their storage quota 403.
+func CreateDirectUploadHandler(c *gin.Context, users db.UserRepo, usage db.UsageRepo) {
+	s3, ok := fs.PrimaryStore(fs.Store).(*fs.S3Store)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, errorResponse("direct uploads need the s3 file store"))
+		return
+	}
+	store, ok := uploadCache(c)
+	if !ok {
+		return
+	}
+
+	var form DirectUploadForm
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	if form.Size > min(MaxUploadBytes, fs.MaxDirectUpload) {
+		c.JSON(http.StatusRequestEntityTooLarge, errorResponse(fmt.Sprintf("audio exceeds the maximum allowed size of %d bytes", min(MaxUploadBytes, fs.MaxDirectUpload))))
+		return
+	}
+	quota, err := checkQuota(c, users, usage, form.Size, 1)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+	sum := strings.ToLower(form.SHA256)
+	if !fs.IsChecksum(sum) {
+		c.JSON(http.StatusBadRequest, errorResponse("sha256 should be the hex SHA-256 of the file"))
+		return
+	}
+	up := &directUpload{
+		ID:           uuid.NewString(),
+		Quota:        quota,
+		Size:         form.Size,
+		Checksum:     sum,
+		Filename:     form.Filename,
+		Title:        form.Title,
+		Artist:       form.Artist,
+		Duration:     form.Duration,
+		Thumbnail:    form.Thumbnail,
+		Downloadable: form.Downloadable,
+	}
+	if form.ArtistId != "" {
+		var err error
+		if up.ArtistID, err = uuid.Parse(form.ArtistId); err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse(fmt.Sprintf("artist id should be a valid uuid string not %s", form.ArtistId)))
+			return
+		}
+	} else if strings.TrimSpace(form.Artist) == "" {
+		c.JSON(http.StatusBadRequest, errorResponse("artist_id or artist is required"))
+		return
+	}
+	up.UserID, _ = currentUserID(c)
+
+	url, header, err := s3.PresignUpload(up.ID, up.Checksum, directUploadURLTTL)
+	if err != nil {
+		log.Printf("presign upload: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to create upload"))
+		return
+	}
+	if err := store.Set(directUploadKey(up.ID), up, directUploadTTL); err != nil {
+		log.Printf("save upload state: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to create upload"))
+		return
+	}
+
+	headers := make(map[string]string, len(header))
+	for k, v := range header {
+		headers[http.CanonicalHeaderKey(k)] = strings.Join(v, ",")
+	}
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+up.ID)
+	c.JSON(http.StatusCreated, gin.H{
+		"data": gin.H{
+			"id":         up.ID,
+			"method":     http.MethodPut,
+			"url":        url,
+			"headers":    headers,
+			"expires_at": time.Now().Add(directUploadURLTTL),
+		},
+	})
+}
+
+// CompleteDirectUploadHandler creates the track for a direct upload once its
+// file is in the bucket: 409 if it has not arrived, 422 if it is not the file
+// that was declared, 400 if it is not a supported format. An upload whose
+// audio a track already holds is answered with that track, flagged
+// "duplicate", as AddTrackHandler does. Completing again returns the track.
+func CompleteDirectUploadHandler(c *gin.Context, r db.TrackRepo, artistRepo db.ArtistRepo, ing *ingest.Service) {
+	s3, ok := fs.PrimaryStore(fs.Store).(*fs.S3Store)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, errorResponse("direct uploads need the s3 file store"))
+		return
+	}
+	store, ok := uploadCache(c)
+	if !ok {
+		return
+	}
+	unlock, ok := lockUpload(c, store, directUploadLockKey(c.Param("id")))
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	up, ok := loadDirectUpload(c, store)
+	if !ok {
+		return
+	}
+	if up.TrackID != nil {
+		track, err := r.GetTrackByID(c, *up.TrackID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, errorResponse("track not found"))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": track})
+		return
+	}
+
+	info, err := s3.StatUpload(c, up.ID)
+	if errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusConflict, errorResponse("the file has not been uploaded yet"))
+		return
+	}
+	if err != nil {
+		log.Printf("stat direct upload %s: %v", up.ID, err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to read upload"))
+		return
+	}
+	if info.Size != up.Size || (info.Checksum != "" && info.Checksum != up.Checksum) {
+		rejectDirectUpload(c, s3, store, up, &uploadError{http.StatusUnprocessableEntity,
+			fmt.Sprintf("uploaded file (%d bytes) does not match the declared size and checksum", info.Size)})
+		return
+	}
+
+	head, err := s3.ReadUploadRange(c, up.ID, 0, directUploadSniffBytes)
+	if err != nil {
+		log.Printf("read direct upload %s: %v", up.ID, err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to read upload"))
+		return
+	}
+	ext, ok := ingest.SniffFormat(bytes.NewReader(head))
+	if !ok {
+		rejectDirectUpload(c, s3, store, up, &uploadError{http.StatusBadRequest, "unsupported audio format (use mp3, flac, wav, m4a or ogg)"})
+		return
+	}
+
+	if existing, err := r.GetTrackByChecksum(c, up.Checksum); err == nil {
+		_ = s3.DiscardUpload(c, up.ID)
+		saveDirectUploadTrack(store, up, existing.ID)
+		c.JSON(http.StatusOK, gin.H{
+			"data":      existing,
+			"duplicate": true,
+		})
+		return
+	}
+
+	artist, err := resolveUploadArtist(c, artistRepo, up.ArtistID, up.Artist)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+	name, err := s3.CommitUpload(c, up.ID, up.Checksum, ext)
+	if err != nil {
+		log.Printf("commit direct upload %s: %v", up.ID, err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to store audio"))
+		return
+	}
+	fs.Replicate(fs.Store, name)
+
+	track, err := r.CreateTrack(c, &db.Track{
+		Title:        firstNonEmpty(up.Title, ingest.FileStem(up.Filename)),
+		ArtistID:     artist.ID,
+		File:         name,
+		Duration:     up.Duration,
+		Thumbnail:    up.Thumbnail,
+		Downloadable: up.Downloadable,
+		Checksum:     up.Checksum,
+		Size:         up.Size,
+		UploaderID:   uploaderRef(up.UserID),
+	}, db.UsageLimit(up.Quota))
+	if errors.Is(err, db.ErrDuplicateTrack) {
+		// Another upload of the same audio got in first.
+		saveDirectUploadTrack(store, up, track.ID)
+		c.JSON(http.StatusOK, gin.H{
+			"data":      track,
+			"duplicate": true,
+		})
+		return
+	}
+	var quotaErr *db.QuotaError
+	if errors.As(err, &quotaErr) {
+		respondUploadError(c, quotaExceeded(quotaErr))
+		return
+	}
+	if err != nil {
+		log.Printf("create track error: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to save track"))
+		return
+	}
+	metrics.RecordTrackUpload()
+	if ing != nil {
+		ing.Submit(track.ID, track.File)
+	}
+	saveDirectUploadTrack(store, up, track.ID)
+	c.JSON(http.StatusOK, gin.H{"data": track})
+}
+
+// loadDirectUpload fetches the direct upload named by the id path parameter,
+// responding 404 to anyone but its creator, as loadResumableUpload does.
+func loadDirectUpload(c *gin.Context, store cache.Cache) (*directUpload, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("upload not found"))
+		return nil, false
+	}
+	up := &directUpload{}
+	if err := store.Get(directUploadKey(id.String()), up); err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("upload not found"))
+		return nil, false
+	}
+	if userID, _ := currentUserID(c); userID != up.UserID {
+		c.JSON(http.StatusNotFound, errorResponse("upload not found"))
+		return nil, false
+	}
+	return up, true
+}
+
+// rejectDirectUpload drops an upload whose file was refused, along with the
+// file, and reports why.
+func rejectDirectUpload(c *gin.Context, s3 *fs.S3Store, store cache.Cache, up *directUpload, err error) {
+	if discardErr := s3.DiscardUpload(c, up.ID); discardErr != nil {
+		log.Printf("discard direct upload %s: %v", up.ID, discardErr)
+	}
+	_ = store.Del(directUploadKey(up.ID))
+	respondUploadError(c, err)
+}
+
+// saveDirectUploadTrack records the track an upload became, so completing it
+// again answers with that track.
+func saveDirectUploadTrack(store cache.Cache, up *directUpload, trackID uuid.UUID) {
+	up.TrackID = &trackID
+	if err := store.Set(directUploadKey(up.ID), up, directUploadTTL); err != nil {
+		log.Printf("save upload state: %v", err)
+	}
+}
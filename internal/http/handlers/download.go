@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"auxstream/internal/audio"
+	"auxstream/internal/db"
+	fs "auxstream/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxArtworkBytes caps the cover image embedded into downloads.
+const maxArtworkBytes = 5 << 20
+
+// DownloadTrackHandler sends a track's stored file as an attachment named
+// "<artist> - <title>.<ext>". MP3s get their ID3v2 tag rewritten on the way out
+// with the catalogue's title, artist and thumbnail artwork, so the file is
+// labelled correctly wherever it ends up; other formats are sent as stored.
+// Range requests are honoured so interrupted downloads can resume. Responds
+// 403 unless the track is marked downloadable; authentication is enforced
+// upstream.
+func DownloadTrackHandler(c *gin.Context, r db.TrackRepo) {
+	trackId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("invalid track ID format"))
+		return
+	}
+
+	track, err := r.GetTrackByID(c, trackId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("track not found"))
+		return
+	}
+	if !track.Downloadable {
+		c.JSON(http.StatusForbidden, errorResponse("track is not available for download"))
+		return
+	}
+
+	file, ok := openBlob(c, track.File)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	ext := strings.TrimPrefix(path.Ext(track.File), ".")
+	var content io.ReadSeeker = file
+	if ext == "mp3" {
+		artwork, artworkMIME := loadArtwork(c.Request.Context(), track.Thumbnail)
+		retagged, size := audio.RetagMP3(file, file.Size(), audio.ID3Tags{
+			Title:       track.Title,
+			Artist:      track.Artist.Name,
+			Artwork:     artwork,
+			ArtworkMIME: artworkMIME,
+		})
+		content = io.NewSectionReader(retagged, 0, size)
+	}
+
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": downloadFilename(track.Artist.Name, track.Title, ext),
+	}))
+	c.Header("Content-Type", audioContentType(ext))
+	c.Header("Accept-Ranges", "bytes")
+	http.ServeContent(c.Writer, c.Request, "", track.UpdatedAt, content)
+}
+
+// downloadFilename builds "<artist> - <title>.<ext>", dropping characters that
+// are path separators or otherwise invalid in common filesystems.
+func downloadFilename(artist, title, ext string) string {
+	name := title
+	if artist != "" {
+		name = artist + " - " + title
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "track"
+	}
+	return name + "." + ext
+}
+
+// loadArtwork fetches the image a track thumbnail points at: an http(s) URL or
+// a file store identifier. Any failure, including content that is not a JPEG
+// or PNG, yields no artwork rather than failing the download.
+func loadArtwork(ctx context.Context, thumbnail string) ([]byte, string) {
+	if thumbnail == "" {
+		return nil, ""
+	}
+
+	var body io.ReadCloser
+	if strings.HasPrefix(thumbnail, "http://") || strings.HasPrefix(thumbnail, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, thumbnail, nil)
+		if err != nil {
+			return nil, ""
+		}
+		resp, err := artworkClient.Do(req)
+		if err != nil {
+			log.Printf("fetch artwork %q: %v", thumbnail, err)
+			return nil, ""
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, ""
+		}
+		body = resp.Body
+	} else {
+		file, err := fs.Store.Read(thumbnail)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Printf("read artwork %q: %v", thumbnail, err)
+			}
+			return nil, ""
+		}
+		body = file
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, maxArtworkBytes+1))
+	if err != nil || len(data) > maxArtworkBytes {
+		return nil, ""
+	}
+	switch mimeType := http.DetectContentType(data); mimeType {
+	case "image/jpeg", "image/png":
+		return data, mimeType
+	}
+	return nil, ""
+}
+
+// artworkClient fetches remote thumbnails. Thumbnail URLs come from uploaders,
+// so it refuses to connect to loopback, private and link-local addresses,
+// keeping downloads from being used to probe the internal network.
+var artworkClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 3 * time.Second,
+			Control: func(_, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				ip := net.ParseIP(host)
+				if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+					ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+					return fmt.Errorf("artwork host %s is not public", host)
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"auxstream/internal/db"
+	"auxstream/internal/images"
+	fs "auxstream/internal/storage"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// imageContentTypes maps the extensions images.Render yields to their MIME
+// types.
+var imageContentTypes = map[string]string{
+	".jpg": "image/jpeg",
+	".png": "image/png",
+}
+
+// ImageHandler serves a stored image rendition, as linked from the images of
+// tracks, artists and playlists. Only content-addressed JPEG and PNG blobs
+// are served, so the route cannot be used to fetch audio, and since their
+// names pin their bytes they are cached for good.
+func ImageHandler(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("name"), "/")
+	contentType, ok := imageContentTypes[path.Ext(name)]
+	if !ok || !fs.IsChecksum(fs.BlobKey(name)) {
+		c.JSON(http.StatusNotFound, errorResponse("image not found"))
+		return
+	}
+
+	file, ok := openBlob(c, name)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	c.Header("Content-Type", contentType)
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("ETag", blobETag(name))
+	http.ServeContent(c.Writer, c.Request, name, file.ModTime(), file)
+}
+
+// readImageUpload reads the "image" part of a multipart form and stores it at
+// the standard sizes, writing the error response and reporting false when
+// that fails: 400 without an image or for one that is not a JPEG, PNG or WebP,
+// 413 over images.MaxBytes, and 422 for one that cannot be decoded.
+func readImageUpload(c *gin.Context) (db.Images, bool) {
+	fh, err := c.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("image is required"))
+		return nil, false
+	}
+	if fh.Size > images.MaxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, errorResponse(fmt.Sprintf("image exceeds the maximum allowed size of %d bytes", images.MaxBytes)))
+		return nil, false
+	}
+	src, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("unable to access image"))
+		return nil, false
+	}
+	defer src.Close()
+	data, err := io.ReadAll(io.LimitReader(src, images.MaxBytes))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("unable to read image"))
+		return nil, false
+	}
+
+	img, err := images.Decode(data)
+	if errors.Is(err, images.ErrUnsupported) {
+		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errorResponse(err.Error()))
+		return nil, false
+	}
+	stored, err := images.Save(fs.Store, img)
+	if err != nil {
+		log.Printf("store image error: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to store image"))
+		return nil, false
+	}
+	return stored, true
+}
+
+// UploadTrackArtworkHandler replaces a track's cover art with the multipart
+// "image" (see readImageUpload), which also becomes its thumbnail. Only the
+// track's uploader or an admin may change it; anyone else gets 403.
+func UploadTrackArtworkHandler(c *gin.Context, r db.TrackRepo, users db.UserRepo) {
+	trackId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("invalid track ID format"))
+		return
+	}
+	track, err := r.GetTrackByID(c, trackId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("track not found"))
+		return
+	}
+	if !canManage(c, users, track.UploaderID) {
+		c.JSON(http.StatusForbidden, errorResponse("only the track's uploader or an admin may change its artwork"))
+		return
+	}
+
+	stored, ok := readImageUpload(c)
+	if !ok {
+		return
+	}
+	if err := r.SetTrackImages(c, trackId, stored); err != nil {
+		log.Printf("SetTrackImages error: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to save artwork"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": stored})
+}
+
+// UploadArtistImageHandler replaces an artist's photo with the multipart
+// "image" (see readImageUpload). Only the artist's owner or an admin may
+// change it; anyone else gets 403.
+func UploadArtistImageHandler(c *gin.Context, r db.ArtistRepo, users db.UserRepo) {
+	artistId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("invalid artist ID format"))
+		return
+	}
+	artist, err := r.GetArtistById(c, artistId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("artist not found"))
+		return
+	}
+	if !canManage(c, users, artist.OwnerID) {
+		c.JSON(http.StatusForbidden, errorResponse("only the artist's owner or an admin may change its image"))
+		return
+	}
+
+	stored, ok := readImageUpload(c)
+	if !ok {
+		return
+	}
+	if err := r.SetArtistImages(c, artistId, stored); err != nil {
+		log.Printf("SetArtistImages error: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to save image"))
+		return
+	}
+	forgetArtist(c, artistId)
+
+	c.JSON(http.StatusOK, gin.H{"data": stored})
+}
+
+// UploadPlaylistCoverHandler replaces the cover of one of the caller's
+// playlists with the multipart "image" (see readImageUpload).
+func UploadPlaylistCoverHandler(c *gin.Context, r db.PlaylistRepo) {
+	p, ok := ownedPlaylistOr404(c, r)
+	if !ok {
+		return
+	}
+
+	stored, ok := readImageUpload(c)
+	if !ok {
+		return
+	}
+	if err := r.SetPlaylistImages(c, p.ID, stored); err != nil {
+		log.Printf("SetPlaylistImages error: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to save cover"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": stored})
+}
+
+// canManage reports whether the caller may change something owned by owner:
+// they are its owner, or an admin. Things with no owner are left to admins.
+func canManage(c *gin.Context, users db.UserRepo, owner *uuid.UUID) bool {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return false
+	}
+	if owner != nil && *owner == userID {
+		return true
+	}
+	user, err := users.GetUserById(c, userID)
+	return err == nil && user.Role == db.RoleAdmin
+}
+
+// isAdmin reports whether the caller is an admin.
+func isAdmin(c *gin.Context, users db.UserRepo) bool {
+	return canManage(c, users, nil)
+}
@@ -18,6 +18,7 @@ type playlistResponse struct {
 	Description string    `json:"description"`
 	IsPublic    bool      `json:"is_public"`
 	TrackCount  int64     `json:"track_count"`
+	Images      db.Images `json:"images,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 }
 
@@ -34,6 +35,7 @@ func toPlaylistResponse(p *db.Playlist, trackCount int64) playlistResponse {
 		Description: p.Description,
 		IsPublic:    p.IsPublic,
 		TrackCount:  trackCount,
+		Images:      p.Images,
 		CreatedAt:   p.CreatedAt,
 	}
 }
@@ -135,7 +137,7 @@ func CreatePlaylistHandler(c *gin.Context, r db.PlaylistRepo) {
 
 // GetPlaylistHandler returns a playlist with its tracks. Readable by the owner, or by
 // anyone when the playlist is public (shared link). Uses optional auth.
-func GetPlaylistHandler(c *gin.Context, r db.PlaylistRepo) {
+func GetPlaylistHandler(c *gin.Context, r db.PlaylistRepo, tokens *auth.StreamTokenService) {
 	id, ok := parsePlaylistID(c)
 	if !ok {
 		return
@@ -159,6 +161,7 @@ func GetPlaylistHandler(c *gin.Context, r db.PlaylistRepo) {
 		c.JSON(http.StatusInternalServerError, errorResponse("failed to fetch playlist"))
 		return
 	}
+	signStreamURLs(c, tokens, tracks...)
 
 	c.JSON(http.StatusOK, gin.H{"data": playlistDetailResponse{
 		playlistResponse: toPlaylistResponse(p, int64(len(tracks))),
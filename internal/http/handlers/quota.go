@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"auxstream/internal/db"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Quota caps the storage one user's uploads may fill; a zero field is no
+// limit.
+type Quota struct {
+	Bytes  int64 `json:"bytes"`
+	Tracks int   `json:"tracks"`
+}
+
+// defaultQuotas are the quotas of each role unless configured otherwise.
+var defaultQuotas = map[string]Quota{
+	db.RoleUser:  {Bytes: 5 << 30, Tracks: 1000},
+	db.RoleAdmin: {},
+}
+
+// Quotas maps each role to its quota; roles without an entry get the
+// db.RoleUser one. It is overridden at startup from configuration
+// (STORAGE_QUOTAS, see ParseQuotas).
+var Quotas = maps.Clone(defaultQuotas)
+
+// ParseQuotas reads quotas written as comma-separated role:bytes:tracks
+// entries, e.g. "user:5368709120:1000,admin:0:0", over the default ones:
+// roles the spec leaves out keep their default quota.
+func ParseQuotas(spec string) (map[string]Quota, error) {
+	quotas := maps.Clone(defaultQuotas)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("quota %q: want role:bytes:tracks", entry)
+		}
+		bytes, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || bytes < 0 {
+			return nil, fmt.Errorf("quota %q: bytes must be a non-negative integer", entry)
+		}
+		tracks, err := strconv.Atoi(parts[2])
+		if err != nil || tracks < 0 {
+			return nil, fmt.Errorf("quota %q: tracks must be a non-negative integer", entry)
+		}
+		quotas[parts[0]] = Quota{Bytes: bytes, Tracks: tracks}
+	}
+	return quotas, nil
+}
+
+// quotaFor returns the quota of role.
+func quotaFor(role string) Quota {
+	if q, ok := Quotas[role]; ok {
+		return q
+	}
+	return Quotas[db.RoleUser]
+}
+
+// String describes q for error messages.
+func (q Quota) String() string {
+	limit := func(n int64, unit string) string {
+		if n == 0 {
+			return "unlimited " + unit
+		}
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return limit(q.Bytes, "bytes") + " and " + limit(int64(q.Tracks), "tracks")
+}
+
+// checkQuota refuses, with an uploadError carrying 403, an upload of tracks
+// tracks totalling bytes that would take the caller past the quota of their
+// role, which it returns for the repository to enforce when the upload is
+// charged: the check here only spares storing an upload bound to be refused.
+// Anonymous uploads are charged to no one and always pass.
+func checkQuota(c *gin.Context, users db.UserRepo, usage db.UsageRepo, bytes int64, tracks int) (Quota, error) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return Quota{}, nil
+	}
+	return checkQuotaOf(c, users, usage, userID, bytes, tracks)
+}
+
+// checkQuotaOf is checkQuota for an upload charged to userID.
+func checkQuotaOf(c *gin.Context, users db.UserRepo, usage db.UsageRepo, userID uuid.UUID, bytes int64, tracks int) (Quota, error) {
+	role := db.RoleUser
+	if user, err := users.GetUserById(c, userID); err == nil {
+		role = user.Role
+	}
+	quota := quotaFor(role)
+	used, err := usage.GetUsage(c, userID)
+	if err != nil {
+		log.Printf("GetUsage error: %v", err)
+		return quota, errors.New("failed to check storage quota")
+	}
+	if !db.UsageLimit(quota).Allows(used, bytes, tracks) {
+		return quota, quotaExceeded(&db.QuotaError{Usage: *used, Limit: db.UsageLimit(quota), Bytes: bytes, Tracks: tracks})
+	}
+	return quota, nil
+}
+
+// quotaExceeded turns a refused charge into an uploadError carrying 403.
+func quotaExceeded(e *db.QuotaError) error {
+	return &uploadError{http.StatusForbidden, fmt.Sprintf(
+		"storage quota exceeded: %d bytes in %d tracks used of %s; this upload needs %d bytes in %d tracks",
+		e.Usage.Bytes, e.Usage.Tracks, Quota(e.Limit), e.Bytes, e.Tracks)}
+}
+
+type usageResponse struct {
+	Role   string `json:"role"`
+	Bytes  int64  `json:"bytes"`
+	Tracks int    `json:"tracks"`
+	Quota  Quota  `json:"quota"` // zero fields are unlimited
+}
+
+// GetUsageHandler reports the storage the caller's uploads hold, with the
+// quota of their role.
+func GetUsageHandler(c *gin.Context, users db.UserRepo, usage db.UsageRepo) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, errorResponse("authentication required"))
+		return
+	}
+	user, err := users.GetUserById(c, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("user not found"))
+		return
+	}
+	used, err := usage.GetUsage(c, userID)
+	if err != nil {
+		log.Printf("GetUsage error: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to fetch storage usage"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": usageResponse{
+		Role:   user.Role,
+		Bytes:  used.Bytes,
+		Tracks: used.Tracks,
+		Quota:  quotaFor(user.Role),
+	}})
+}
@@ -10,6 +10,8 @@ import (
 )
 
 // SearchHandler handles unified search requests across all configured sources.
+// The "genre" query param narrows the results to one genre; every response
+// counts its results by genre under facets.
 func SearchHandler(c *gin.Context, searchService *search.Service) {
 	query := c.Query("q")
 	if query == "" {
@@ -30,6 +32,7 @@ func SearchHandler(c *gin.Context, searchService *search.Service) {
 		Query:      query,
 		MaxResults: maxResults,
 		Source:     source,
+		Genre:      c.Query("genre"),
 	}
 
 	results, err := searchService.Search(c.Request.Context(), searchReq)
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"auxstream/internal/auth"
+	"auxstream/internal/db"
+	fs "auxstream/internal/storage"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// StreamTrackHandler serves a track's audio from the configured file store, so
+// playback works the same for every backend. Range requests get 206 Partial
+// Content (letting players seek), and the ETag/Last-Modified validators let
+// clients revalidate cached audio. The "quality" query param picks a
+// transcoded rendition; without it the token holder's saved preference
+// applies. A rendition that has not been produced yet falls back to the
+// original upload. Responds 400 on a malformed id or unknown quality and 404
+// when either the track or its blob is missing. Access is gated upstream by
+// auth.StreamTokenMiddleware.
+func StreamTrackHandler(c *gin.Context, r db.TrackRepo, trackFiles db.TrackFileRepo, users db.UserRepo) {
+	trackId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("invalid track ID format"))
+		return
+	}
+
+	quality := c.Query("quality")
+	if quality != "" && !db.IsStreamQuality(quality) {
+		c.JSON(http.StatusBadRequest, errorResponse("quality must be one of original, low, medium or high"))
+		return
+	}
+
+	track, err := r.GetTrackByID(c, trackId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("track not found"))
+		return
+	}
+
+	identifier, modified := track.File, track.UpdatedAt
+	if quality == "" {
+		quality = preferredQuality(c, users)
+	}
+	if quality != "" && quality != db.RenditionOriginal {
+		if rendition, err := trackFiles.GetTrackFile(c, trackId, db.TrackFileAudio, quality, 0); err == nil {
+			identifier, modified = rendition.File, rendition.CreatedAt
+		}
+	}
+
+	file, err := fs.Open(fs.Store, identifier)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			c.JSON(http.StatusNotFound, errorResponse("track audio not found"))
+			return
+		}
+		log.Printf("stream open error for track %s: %v", track.ID, err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to open track audio"))
+		return
+	}
+	defer file.Close()
+
+	// ServeContent owns Range/If-Range/If-None-Match handling; it only needs the
+	// validators and type set up front (it would otherwise sniff the bytes).
+	// The blob is fetched lazily, so a HEAD costs a Stat and a Range request
+	// only the bytes in range.
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("ETag", blobETag(identifier))
+	c.Header("Content-Type", audioContentType(strings.TrimPrefix(path.Ext(identifier), ".")))
+	http.ServeContent(c.Writer, c.Request, "", modified, file)
+}
+
+// preferredQuality returns the saved stream quality of the listener the stream
+// token was issued to, or "" for anonymous tokens and unknown users.
+func preferredQuality(c *gin.Context, users db.UserRepo) string {
+	claims, ok := auth.GetStreamClaimsFromContext(c)
+	if !ok || claims.UserID == uuid.Nil {
+		return ""
+	}
+	user, err := users.GetUserById(c, claims.UserID)
+	if err != nil {
+		return ""
+	}
+	return user.StreamQuality
+}
+
+// HLSHandler serves a track's HLS packaging under /tracks/:id/hls/*path:
+// "index.m3u8" is the media playlist and "<rendition>/<seq>.<ext>" its
+// segments. Segment URIs in the playlist are rewritten to carry the caller's
+// stream token, since players fetch them as plain relative URLs. Responds 404
+// until the track has been packaged. Only mp3 uploads are packaged, and only
+// as the original rendition: other formats would need transcoding first, and
+// the transcoded renditions are served whole by StreamTrackHandler. Those
+// tracks get a 404 saying so, and play from the stream route instead. Access
+// is gated upstream by auth.StreamTokenMiddleware.
+func HLSHandler(c *gin.Context, trackFiles db.TrackFileRepo) {
+	trackId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("invalid track ID format"))
+		return
+	}
+
+	p := strings.TrimPrefix(c.Param("path"), "/")
+	if p == "index.m3u8" {
+		serveHLSPlaylist(c, trackFiles, trackId)
+		return
+	}
+
+	rendition, name := path.Split(p)
+	rendition = strings.TrimSuffix(rendition, "/")
+	ext := path.Ext(name)
+	seq, err := strconv.Atoi(strings.TrimSuffix(name, ext))
+	if rendition == "" || strings.Contains(rendition, "/") || err != nil || seq < 0 {
+		c.JSON(http.StatusNotFound, errorResponse("hls resource not found"))
+		return
+	}
+
+	segment, err := trackFiles.GetTrackFile(c, trackId, db.TrackFileHLSSegment, rendition, seq)
+	if err != nil || path.Ext(segment.File) != ext {
+		c.JSON(http.StatusNotFound, errorResponse("hls resource not found"))
+		return
+	}
+
+	file, ok := openBlob(c, segment.File)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("ETag", blobETag(segment.File))
+	c.Header("Content-Type", audioContentType(strings.TrimPrefix(ext, ".")))
+	http.ServeContent(c.Writer, c.Request, "", segment.CreatedAt, file)
+}
+
+func serveHLSPlaylist(c *gin.Context, trackFiles db.TrackFileRepo, trackId uuid.UUID) {
+	playlist, err := trackFiles.GetTrackFile(c, trackId, db.TrackFileHLSPlaylist, db.RenditionOriginal, 0)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("track has not been packaged for hls; only mp3 uploads are, other tracks play from /tracks/:id/stream"))
+		return
+	}
+
+	file, ok := openBlob(c, playlist.File)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	body, err := io.ReadAll(file)
+	if err != nil {
+		log.Printf("hls playlist read error for track %s: %v", trackId, err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to read hls playlist"))
+		return
+	}
+
+	// The token is echoed per response, so the rewritten playlist must not be
+	// shared between listeners by intermediate caches.
+	c.Header("Cache-Control", "private, no-cache")
+	c.Data(http.StatusOK, "application/vnd.apple.mpegurl",
+		withTokenQuery(body, c.Query("token")))
+}
+
+// withTokenQuery appends "?token=" to every URI line of an m3u8 playlist.
+func withTokenQuery(playlist []byte, token string) []byte {
+	lines := strings.Split(string(playlist), "\n")
+	for i, line := range lines {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines[i] = line + "?token=" + url.QueryEscape(token)
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// openBlob opens identifier in the file store for ranged reading, answering 404
+// when the blob is missing and 500 on other failures; ok reports whether the
+// caller may proceed.
+func openBlob(c *gin.Context, identifier string) (fs.Blob, bool) {
+	file, err := fs.Open(fs.Store, identifier)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			c.JSON(http.StatusNotFound, errorResponse("audio not found"))
+			return nil, false
+		}
+		log.Printf("file store read error for %s: %v", identifier, err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to open audio"))
+		return nil, false
+	}
+	return file, true
+}
+
+// blobETag derives a strong ETag from a store identifier. Stored blobs are never
+// rewritten in place (a new upload gets a new identifier), so the identifier
+// alone pins the bytes.
+func blobETag(identifier string) string {
+	sum := sha256.Sum256([]byte(identifier))
+	return fmt.Sprintf("%q", hex.EncodeToString(sum[:16]))
+}
+
+// signStreamURLs stamps each track with a signed stream URL issued to the caller
+// (anonymous callers get a token bound to no user). Clients must play tracks
+// through this URL: the stream route rejects requests without a valid token.
+func signStreamURLs(c *gin.Context, tokens *auth.StreamTokenService, tracks ...*db.Track) {
+	if tokens == nil {
+		return
+	}
+	userID, _ := currentUserID(c)
+	for _, track := range tracks {
+		track.StreamURL = tokens.SignedStreamURL(track.ID, userID)
+	}
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"auxstream/internal/db"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxTagsPerKind bounds the genres, and separately the tags, one track or
+	// artist may carry.
+	maxTagsPerKind = 20
+	// maxTagNameLength bounds a genre or tag name, in characters.
+	maxTagNameLength = 64
+)
+
+type ListTagsQueryParams struct {
+	Query string `form:"q"`                             // Optional: only names starting with this
+	Limit int    `form:"limit" binding:"gte=0,lte=200"` // Optional: defaults to 50
+}
+
+// ListGenresHandler lists the genres in name order; "q" narrows them to those
+// starting with it, for autocompletion.
+func ListGenresHandler(c *gin.Context, tags db.TagRepo) {
+	listTags(c, tags, db.TagKindGenre)
+}
+
+// ListTagsHandler lists the free-form tags as ListGenresHandler does genres.
+func ListTagsHandler(c *gin.Context, tags db.TagRepo) {
+	listTags(c, tags, db.TagKindTag)
+}
+
+func listTags(c *gin.Context, tags db.TagRepo, kind string) {
+	params := ListTagsQueryParams{Limit: 50}
+	if err := c.ShouldBindQuery(&params); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	if params.Limit == 0 {
+		params.Limit = 50
+	}
+
+	list, err := tags.ListTags(c, kind, params.Query, params.Limit)
+	if err != nil {
+		log.Printf("ListTags error: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to fetch "+kind+"s"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": list,
+	})
+}
+
+// taxonomy is what a track or artist is filed under.
+type taxonomy struct {
+	Genres []*db.Tag `json:"genres"`
+	Tags   []*db.Tag `json:"tags"`
+}
+
+func newTaxonomy(tags []*db.Tag) taxonomy {
+	t := taxonomy{Genres: []*db.Tag{}, Tags: []*db.Tag{}}
+	for _, tag := range tags {
+		if tag.Kind == db.TagKindGenre {
+			t.Genres = append(t.Genres, tag)
+		} else {
+			t.Tags = append(t.Tags, tag)
+		}
+	}
+	return t
+}
+
+// SetTagsRequest names the genres and tags to file something under. A field
+// left out keeps what is there; an empty list clears it.
+type SetTagsRequest struct {
+	Genres *[]string `json:"genres"`
+	Tags   *[]string `json:"tags"`
+}
+
+// tagNames are the names a SetTagsRequest gives for one kind of tag; nil when
+// it leaves that kind out.
+type tagNames struct {
+	kind  string
+	names *[]string
+}
+
+func (req SetTagsRequest) byKind() []tagNames {
+	return []tagNames{{db.TagKindGenre, req.Genres}, {db.TagKindTag, req.Tags}}
+}
+
+// validate reports what is wrong with req, if anything.
+func (req SetTagsRequest) validate() error {
+	for _, field := range req.byKind() {
+		if field.names == nil {
+			continue
+		}
+		if len(*field.names) > maxTagsPerKind {
+			return fmt.Errorf("at most %d %ss are allowed", maxTagsPerKind, field.kind)
+		}
+		for _, name := range *field.names {
+			if db.TagSlug(name) == "" {
+				return fmt.Errorf("%s names must not be blank", field.kind)
+			}
+			if utf8.RuneCountInString(name) > maxTagNameLength {
+				return fmt.Errorf("%s %q exceeds %d characters", field.kind, name, maxTagNameLength)
+			}
+		}
+	}
+	return nil
+}
+
+// GetTrackTagsHandler returns the genres and tags of a track; 404 when no such
+// track exists.
+func GetTrackTagsHandler(c *gin.Context, r db.TrackRepo, tags db.TagRepo) {
+	trackId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("invalid track ID format"))
+		return
+	}
+	if _, err := r.GetTrackByID(c, trackId); err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("track not found"))
+		return
+	}
+
+	list, err := tags.GetTrackTags(c, trackId)
+	if err != nil {
+		log.Printf("GetTrackTags error: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to fetch tags"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": newTaxonomy(list),
+	})
+}
+
+// SetTrackTagsHandler files a track under the genres and tags of a
+// SetTagsRequest, creating any that are new, and returns all it is filed
+// under. Only the track's uploader or an admin may change them; anyone else
+// gets 403.
+func SetTrackTagsHandler(c *gin.Context, r db.TrackRepo, tags db.TagRepo, users db.UserRepo) {
+	trackId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("invalid track ID format"))
+		return
+	}
+	var req SetTagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	track, err := r.GetTrackByID(c, trackId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("track not found"))
+		return
+	}
+	if !canManage(c, users, track.UploaderID) {
+		c.JSON(http.StatusForbidden, errorResponse("only the track's uploader or an admin may change its tags"))
+		return
+	}
+
+	setTags(c, req, trackId, tags.SetTrackTags, tags.GetTrackTags)
+}
+
+// GetArtistTagsHandler returns the genres and tags of an artist; 404 when no
+// such artist exists.
+func GetArtistTagsHandler(c *gin.Context, artistRepo db.ArtistRepo, tags db.TagRepo) {
+	artistId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("invalid artist ID format"))
+		return
+	}
+	if _, err := artistRepo.GetArtistById(c, artistId); err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("artist not found"))
+		return
+	}
+
+	list, err := tags.GetArtistTags(c, artistId)
+	if err != nil {
+		log.Printf("GetArtistTags error: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to fetch tags"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": newTaxonomy(list),
+	})
+}
+
+// SetArtistTagsHandler files an artist under the genres and tags of a
+// SetTagsRequest, as SetTrackTagsHandler does a track. Only the artist's owner
+// or an admin may change them; anyone else gets 403.
+func SetArtistTagsHandler(c *gin.Context, artistRepo db.ArtistRepo, tags db.TagRepo, users db.UserRepo) {
+	artistId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("invalid artist ID format"))
+		return
+	}
+	var req SetTagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	artist, err := artistRepo.GetArtistById(c, artistId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("artist not found"))
+		return
+	}
+	if !canManage(c, users, artist.OwnerID) {
+		c.JSON(http.StatusForbidden, errorResponse("only the artist's owner or an admin may change its tags"))
+		return
+	}
+
+	setTags(c, req, artistId, tags.SetArtistTags, tags.GetArtistTags)
+}
+
+// setTags applies req to id through set and responds with all id is filed
+// under, as get reports it.
+func setTags(c *gin.Context, req SetTagsRequest, id uuid.UUID,
+	set func(ctx context.Context, id uuid.UUID, kind string, names []string) ([]*db.Tag, error),
+	get func(ctx context.Context, id uuid.UUID) ([]*db.Tag, error)) {
+	for _, field := range req.byKind() {
+		if field.names == nil {
+			continue
+		}
+		if _, err := set(c, id, field.kind, *field.names); err != nil {
+			log.Printf("set %ss error: %v", field.kind, err)
+			c.JSON(http.StatusInternalServerError, errorResponse("failed to save tags"))
+			return
+		}
+	}
+
+	list, err := get(c, id)
+	if err != nil {
+		log.Printf("get tags error: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to fetch tags"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": newTaxonomy(list),
+	})
+}
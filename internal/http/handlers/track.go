@@ -1,14 +1,21 @@
 package handlers
 
 import (
+	"auxstream/internal/audio"
+	"auxstream/internal/auth"
 	"auxstream/internal/cache"
 	"auxstream/internal/db"
+	"auxstream/internal/ingest"
+	"auxstream/internal/metrics"
 	fs "auxstream/internal/storage"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,8 +24,9 @@ import (
 )
 
 // GetTrackByIDHandler reads the track id from the URL path; responds 400 on a
-// malformed UUID and 404 when no such track exists.
-func GetTrackByIDHandler(c *gin.Context, r db.TrackRepo) {
+// malformed UUID and 404 when no such track exists. The track is returned with
+// its credits.
+func GetTrackByIDHandler(c *gin.Context, r db.TrackRepo, tokens *auth.StreamTokenService) {
 	trackIdStr := c.Param("id")
 	trackId, err := uuid.Parse(trackIdStr)
 	if err != nil {
@@ -32,14 +40,58 @@ func GetTrackByIDHandler(c *gin.Context, r db.TrackRepo) {
 		return
 	}
 
+	fillCredits(c, r, track)
+	signStreamURLs(c, tokens, track)
 	c.JSON(http.StatusOK, gin.H{
 		"data": track,
 	})
 }
 
+// TrackArtworkHandler serves a track's thumbnail, or with ?size= the rendition
+// of its cover art that many pixels wide (see images.Sizes). Thumbnails held in
+// the file store (such as cover art extracted at upload) are sent with
+// long-lived caching, since stored blobs never change; external URLs are
+// redirected to. Responds 404 when the track has no such artwork.
+func TrackArtworkHandler(c *gin.Context, r db.TrackRepo) {
+	trackId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("invalid track ID format"))
+		return
+	}
+
+	track, err := r.GetTrackByID(c, trackId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("artwork not found"))
+		return
+	}
+	artwork := track.Thumbnail
+	if size := c.Query("size"); size != "" {
+		px, _ := strconv.Atoi(size)
+		artwork, _ = track.Images.Size(px)
+	}
+	if artwork == "" {
+		c.JSON(http.StatusNotFound, errorResponse("artwork not found"))
+		return
+	}
+	if strings.HasPrefix(artwork, "http://") || strings.HasPrefix(artwork, "https://") {
+		c.Redirect(http.StatusFound, artwork)
+		return
+	}
+
+	file, ok := openBlob(c, artwork)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	c.Header("Cache-Control", "public, max-age=604800, immutable")
+	c.Header("ETag", blobETag(artwork))
+	http.ServeContent(c.Writer, c.Request, artwork, track.UpdatedAt, file)
+}
+
 // FetchTracksByArtistHandler matches on the "artist" query string (the repo caps
 // results below 100). An empty query is passed through, not rejected.
-func FetchTracksByArtistHandler(c *gin.Context, r db.TrackRepo) {
+func FetchTracksByArtistHandler(c *gin.Context, r db.TrackRepo, tokens *auth.StreamTokenService) {
 	artist := c.Query("artist")
 	tracks, err := r.GetTrackByArtist(c, artist)
 	if err != nil {
@@ -48,6 +100,7 @@ func FetchTracksByArtistHandler(c *gin.Context, r db.TrackRepo) {
 		return
 	}
 
+	signStreamURLs(c, tokens, tracks...)
 	c.JSON(http.StatusOK, gin.H{
 		"data": tracks,
 	})
@@ -57,14 +110,18 @@ func FetchTracksByArtistHandler(c *gin.Context, r db.TrackRepo) {
 type FetchTrackQueryParams struct {
 	PageSize int    `form:"pagesize" binding:"gte=0"`
 	PageNum  int    `form:"pagenumber" binding:"gte=1"`
-	Sort     string `form:"sort"` // "trending", "recent", or default
-	Days     int    `form:"days"` // For trending within last N days (0 = all time)
+	Sort     string `form:"sort"`  // "trending", "recent", or default
+	Days     int    `form:"days"`  // For trending within last N days (0 = all time)
+	Genre    string `form:"genre"` // Optional: only tracks of this genre
+	Tag      string `form:"tag"`   // Optional: only tracks with this tag
 }
 
 // FetchTracksHandler paginates via the pagesize/pagenumber query params. The
 // "sort" param selects trending or recent ordering (default is unordered); for
-// trending, "days" bounds the window and defaults to 30 when zero.
-func FetchTracksHandler(c *gin.Context, r db.TrackRepo) {
+// trending, "days" bounds the window and defaults to 30 when zero. Under any
+// sort, "genre" and "tag" keep only the tracks carrying them (matched however
+// they are spelled, see db.TagSlug).
+func FetchTracksHandler(c *gin.Context, r db.TrackRepo, tokens *auth.StreamTokenService) {
 	var reqParams FetchTrackQueryParams
 
 	if err := c.ShouldBindQuery(&reqParams); err != nil {
@@ -77,6 +134,7 @@ func FetchTracksHandler(c *gin.Context, r db.TrackRepo) {
 
 	var tracks []*db.Track
 	var err error
+	filter := db.TrackFilter{Genre: reqParams.Genre, Tag: reqParams.Tag}
 
 	switch reqParams.Sort {
 	case "trending":
@@ -84,11 +142,11 @@ func FetchTracksHandler(c *gin.Context, r db.TrackRepo) {
 		if days == 0 {
 			days = 30 // trending window defaults to the last 30 days
 		}
-		tracks, err = r.GetTrendingTracks(c, limit, offset, days)
+		tracks, err = r.GetTrendingTracks(c, limit, offset, days, filter)
 	case "recent":
-		tracks, err = r.GetRecentTracks(c, limit, offset)
+		tracks, err = r.GetRecentTracks(c, limit, offset, filter)
 	default:
-		tracks, err = r.GetTracks(c, limit, offset)
+		tracks, err = r.GetTracks(c, limit, offset, filter)
 	}
 
 	if err != nil {
@@ -97,6 +155,7 @@ func FetchTracksHandler(c *gin.Context, r db.TrackRepo) {
 		return
 	}
 
+	signStreamURLs(c, tokens, tracks...)
 	c.JSON(http.StatusOK, gin.H{
 		"data": tracks,
 	})
@@ -104,33 +163,73 @@ func FetchTracksHandler(c *gin.Context, r db.TrackRepo) {
 }
 
 type AddTrackForm struct {
-	Title     string                `form:"title" binding:"required"`
-	ArtistId  string                `form:"artist_id" binding:"required"`
-	Audio     *multipart.FileHeader `form:"audio" binding:"required"`
-	Duration  int                   `form:"duration"`  // Optional: duration in seconds
-	Thumbnail string                `form:"thumbnail"` // Optional: thumbnail URL or path
+	Title        string                `form:"title"`     // Optional: falls back to the file's tags, then its name
+	ArtistId     string                `form:"artist_id"` // Optional: an existing artist; see Artist
+	Artist       string                `form:"artist"`    // Optional: artist name, used when artist_id is absent
+	Audio        *multipart.FileHeader `form:"audio" binding:"required"`
+	Duration     int                   `form:"duration"`            // Optional: duration in seconds
+	Thumbnail    string                `form:"thumbnail"`           // Optional: thumbnail URL or path
+	Downloadable bool                  `form:"downloadable"`        // Optional: allow signed-in users to download the file
+	AlbumId      string                `form:"album_id"`            // Optional: an existing album to add the track to
+	Album        string                `form:"album"`               // Optional: album title, found or created under the track's artist
+	AlbumType    string                `form:"album_type"`          // Optional: single, ep, album (default) or compilation, for a new album
+	ReleaseDate  string                `form:"release_date"`        // Optional: 2006-01-02, for a new album
+	DiscNumber   int                   `form:"disc_number"`         // Optional: falls back to the file's tags, then 1
+	TrackNumber  int                   `form:"track_number"`        // Optional: falls back to the file's tags
+	ArtistIds    []string              `form:"artist_ids"`          // Optional: further lead artists, as on a duet
+	FeaturedIds  []string              `form:"featured_artist_ids"` // Optional: featured artists; falls back to the file's "feat." credits
+	RemixerIds   []string              `form:"remixer_ids"`         // Optional: artists credited with a remix
+	ProducerIds  []string              `form:"producer_ids"`        // Optional: artists credited as producers
 }
 
-// AddTrackHandler ingests one track from a multipart form (title, artist_id,
-// audio, optional duration/thumbnail). The format is sniffed from the bytes, not
-// the filename, and rejected if unsupported; uploads over MaxUploadBytes get 413.
-// The artist is resolved from cache first, falling back to the repo (and 404 if
-// absent).
-func AddTrackHandler(c *gin.Context, r db.TrackRepo, artistRepo db.ArtistRepo) {
+// AddTrackHandler ingests one track from a multipart form (audio, plus optional
+// title, artist_id/artist, duration, thumbnail). The format is sniffed from the
+// bytes, not the filename, and rejected if unsupported; uploads over
+// MaxUploadBytes get 413, uploads that would take the caller past their storage
+// quota get 403, and files whose structure is damaged or truncated get 422 with
+// the reason. Audio identical to an existing track's (by SHA-256) is
+// not stored again: that track is returned instead, flagged "duplicate". Tags read from the file fill in any field the form
+// leaves out, embedded cover art becomes the thumbnail, and the duration
+// defaults to the one measured from the audio. An artist_id is resolved from
+// cache first, falling back to the repo (and 404 if absent); without one the
+// artist named by the form or the file's tags is looked up or created. An
+// album_id (404 if absent) or album title adds the track to that album, see
+// albumFields. Further artists are credited by id and role (see
+// creditFields); without featured_artist_ids, those credited with "feat." in
+// the file's tags are. The stored track is then handed to ing (when non-nil)
+// for HLS packaging in the background.
+func AddTrackHandler(c *gin.Context, r db.TrackRepo, artistRepo db.ArtistRepo, albums db.AlbumRepo, users db.UserRepo, usage db.UsageRepo, ing *ingest.Service) {
 	var reqForm AddTrackForm
 	if err := c.ShouldBind(&reqForm); err != nil {
 		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
 		return
 	}
-
-	trackTitle := reqForm.Title
-
-	trackArtistID, err := uuid.Parse(reqForm.ArtistId)
+	album, err := newAlbumFields(reqForm.AlbumId, reqForm.Album, reqForm.AlbumType, reqForm.ReleaseDate)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, errorResponse(fmt.Sprintf("artist id should be a valid uuid string not %s", reqForm.ArtistId)))
+		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	credits, err := newCreditFields(map[string][]string{
+		"artist_ids":          reqForm.ArtistIds,
+		"featured_artist_ids": reqForm.FeaturedIds,
+		"remixer_ids":         reqForm.RemixerIds,
+		"producer_ids":        reqForm.ProducerIds,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
 		return
 	}
 
+	var trackArtistID uuid.UUID
+	if reqForm.ArtistId != "" {
+		var err error
+		trackArtistID, err = uuid.Parse(reqForm.ArtistId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse(fmt.Sprintf("artist id should be a valid uuid string not %s", reqForm.ArtistId)))
+			return
+		}
+	}
+
 	file := reqForm.Audio
 
 	if file.Size <= 0 {
@@ -143,166 +242,654 @@ func AddTrackHandler(c *gin.Context, r db.TrackRepo, artistRepo db.ArtistRepo) {
 		return
 	}
 
+	quota, err := checkQuota(c, users, usage, file.Size, 1)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	// The upload is inspected and stored straight from the request's multipart
+	// file (spilled to disk when large), never read whole into memory.
 	audioFile, err := file.Open()
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse("unable to access track audio"))
 		return
 	}
 	defer audioFile.Close()
-	// io.ReadFull guarantees the whole file is read; a bare Read may return fewer
-	// bytes than requested and silently truncate the stored audio.
-	audioBytes := make([]byte, file.Size)
-	if _, readErr := io.ReadFull(audioFile, audioBytes); readErr != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse("unable to read track audio"))
+
+	uploaderID, _ := currentUserID(c)
+	track, meta, duplicate, err := ingestUpload(c, r, artistRepo, albums, ing, trackUpload{
+		UploaderID:   uploaderID,
+		Quota:        quota,
+		Audio:        audioFile,
+		Size:         file.Size,
+		Filename:     file.Filename,
+		Title:        reqForm.Title,
+		ArtistID:     trackArtistID,
+		Artist:       reqForm.Artist,
+		Duration:     reqForm.Duration,
+		Thumbnail:    reqForm.Thumbnail,
+		Downloadable: reqForm.Downloadable,
+		Album:        album,
+		DiscNumber:   reqForm.DiscNumber,
+		TrackNumber:  reqForm.TrackNumber,
+		Credits:      credits,
+	})
+	if err != nil {
+		respondUploadError(c, err)
 		return
 	}
-
-	ext, ok := detectAudioFormat(audioBytes)
-	if !ok {
-		c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse("unsupported audio format (use mp3, flac, wav, m4a or ogg)"))
+	if duplicate {
+		c.JSON(http.StatusOK, gin.H{
+			"data":      track,
+			"duplicate": true,
+		})
 		return
 	}
-	filePath, err := fs.Store.Save(audioBytes, ext)
+	c.JSON(http.StatusOK, gin.H{
+		"data":     track,
+		"metadata": meta,
+	})
+}
+
+// trackUpload is the audio of one track, readable at random, along with the
+// fields its uploader gave; any of those may be left zero. The track is charged
+// to UploaderID's storage usage unless it is nil.
+type trackUpload struct {
+	UploaderID   uuid.UUID
+	Quota        Quota // the uploader's, which the track must fit
+	Audio        io.ReaderAt
+	Size         int64
+	Filename     string
+	Title        string
+	ArtistID     uuid.UUID
+	Artist       string // used when ArtistID is nil
+	Duration     int
+	Thumbnail    string
+	Downloadable bool
+	Album        albumFields // the zero value for a loose track
+	DiscNumber   int
+	TrackNumber  int
+	Credits      creditFields // artists credited besides the main one
+}
+
+// uploadError is an upload refused for a reason the client is told, under the
+// given status.
+type uploadError struct {
+	status int
+	msg    string
+}
+
+func (e *uploadError) Error() string { return e.msg }
+
+// respondUploadError reports an error from ingestUpload, as a 500 unless it is
+// an uploadError.
+func respondUploadError(c *gin.Context, err error) {
+	var uerr *uploadError
+	if !errors.As(err, &uerr) {
+		uerr = &uploadError{http.StatusInternalServerError, err.Error()}
+	}
+	c.AbortWithStatusJSON(uerr.status, errorResponse(uerr.msg))
+}
+
+// ingestUpload turns a single uploaded file, once all of it is in, into a
+// track: the format is sniffed and the structure validated, audio already
+// held by a track is answered with that track (duplicate set), and otherwise
+// the audio is stored and a track created from u, with the file's tags filling
+// in whatever u leaves out. A track added to an existing album is filed under
+// the album's artist unless u names one (or the album is a compilation, whose
+// tracks keep their tagged artists). Other artists are credited as
+// resolveCredits says. The new track is handed to ing (when
+// non-nil) for HLS packaging. When no track is created, an album created for
+// it is deleted again and its stored blobs discarded by CreateTrack; that
+// includes losing a race with an upload of the same audio, which is then
+// answered as a duplicate. Both AddTrackHandler and resumable uploads end here.
+func ingestUpload(c *gin.Context, r db.TrackRepo, artistRepo db.ArtistRepo, albums db.AlbumRepo, ing *ingest.Service, u trackUpload) (track *db.Track, meta *audio.Metadata, duplicate bool, err error) {
+	ext, checksum, err := inspectAudio(u.Audio, u.Size)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if existing, err := r.GetTrackByChecksum(c, checksum); err == nil {
+		return existing, nil, true, nil
+	}
+	meta = ingest.ReadMetadata(u.Audio, u.Size, ext)
+
+	album, err := findUploadAlbum(c, albums, u.Album)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	artistID, artistName := u.ArtistID, firstNonEmpty(u.Artist, meta.Artist)
+	if album != nil && artistID == uuid.Nil && u.Artist == "" && (album.Type != db.AlbumTypeCompilation || artistName == "") {
+		artistID = album.ArtistID
+	}
+	artist, err := resolveUploadArtist(c, artistRepo, artistID, artistName)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if album == nil && u.Album.Album != "" {
+		if album, err = createUploadAlbum(c, albums, u.Album, artist.ID); err != nil {
+			return nil, nil, false, err
+		}
+		defer func() {
+			if err == nil && !duplicate {
+				return
+			}
+			if derr := albums.DeleteEmptyAlbum(c, album.ID); derr != nil {
+				log.Printf("DeleteEmptyAlbum error: %v", derr)
+			}
+		}()
+	}
+	credits, err := resolveCredits(c, artistRepo, artist.ID, u.Credits, meta.Featured)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	filePath, err := fs.Store.SaveStream(io.NewSectionReader(u.Audio, 0, u.Size), u.Size, ext)
 	if err != nil {
 		log.Printf("store audio error: %v", err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, errorResponse("failed to store audio"))
-		return
+		return nil, nil, false, errors.New("failed to store audio")
 	}
 
-	artist := &db.Artist{ID: trackArtistID}
+	duration := u.Duration
+	if duration == 0 {
+		duration = ingest.DurationSeconds(meta)
+	}
+	thumbnail := u.Thumbnail
+	var artwork db.Images
+	if thumbnail == "" {
+		artwork = ingest.StoreArtwork(meta)
+		thumbnail = artwork.Largest()
+	}
 
-	ctx := c.Request.Context()
-	cacheClient, ok := ctx.Value(CacheContextKey).(cache.Cache)
+	newTrack := &db.Track{
+		Title:        firstNonEmpty(u.Title, meta.Title, ingest.FileStem(u.Filename)),
+		ArtistID:     artist.ID,
+		File:         filePath,
+		Duration:     duration,
+		Thumbnail:    thumbnail,
+		Images:       artwork,
+		Downloadable: u.Downloadable,
+		Checksum:     checksum,
+		Size:         u.Size,
+		UploaderID:   uploaderRef(u.UploaderID),
+		Credits:      credits,
+	}
+	if album != nil {
+		newTrack.AlbumID = &album.ID
+		newTrack.DiscNumber = max(firstPositive(u.DiscNumber, meta.DiscNumber), 1)
+		newTrack.TrackNumber = firstPositive(u.TrackNumber, meta.TrackNumber)
+	}
+	track, err = r.CreateTrack(c, newTrack, db.UsageLimit(u.Quota))
+	if errors.Is(err, db.ErrDuplicateTrack) {
+		return track, nil, true, nil
+	}
+	var quotaErr *db.QuotaError
+	if errors.As(err, &quotaErr) {
+		return nil, nil, false, quotaExceeded(quotaErr)
+	}
+	if err != nil {
+		log.Printf("create track error: %v", err)
+		return nil, nil, false, errors.New("failed to save track")
+	}
+	if album != nil && album.Images == nil && artwork != nil {
+		if err := albums.FillAlbumImages(c, album.ID, artwork); err != nil {
+			log.Printf("FillAlbumImages error: %v", err)
+		}
+	}
+	metrics.RecordTrackUpload()
+	if ing != nil {
+		ing.Submit(track.ID, track.File)
+	}
+	return track, meta, false, nil
+}
 
-	artistCacheKey := fmt.Sprintf("artist-id-%s", trackArtistID)
+// inspectAudio sniffs the format of size bytes of audio and validates their
+// structure, returning the format's extension and the audio's SHA-256. What
+// is wrong with audio that fails is told in an uploadError.
+func inspectAudio(a io.ReaderAt, size int64) (ext, checksum string, err error) {
+	ext, ok := ingest.SniffFormat(a)
+	if !ok {
+		return "", "", &uploadError{http.StatusBadRequest, "unsupported audio format (use mp3, flac, wav, m4a or ogg)"}
+	}
+	if err := audio.Validate(a, size, ext); err != nil {
+		return "", "", &uploadError{http.StatusUnprocessableEntity, err.Error()}
+	}
+	checksum, err = fs.ChecksumReader(io.NewSectionReader(a, 0, size))
+	if err != nil {
+		return "", "", &uploadError{http.StatusBadRequest, "unable to read track audio"}
+	}
+	return ext, checksum, nil
+}
+
+// uploaderRef returns a reference to id for Track.UploaderID, or nil for no
+// uploader.
+func uploaderRef(id uuid.UUID) *uuid.UUID {
+	if id == uuid.Nil {
+		return nil
+	}
+	return &id
+}
+
+// resolveUploadArtist finds the artist an upload is filed under: the one with
+// id, looked up in the cache before the repo (an uploadError with 404 if there
+// is none), or without an id the artist called name, created if absent.
+func resolveUploadArtist(c *gin.Context, artistRepo db.ArtistRepo, id uuid.UUID, name string) (*db.Artist, error) {
+	if id == uuid.Nil {
+		if name == "" {
+			return nil, &uploadError{http.StatusBadRequest, "artist_id or artist is required when the audio carries no artist tag"}
+		}
+		artist, err := artistRepo.CreateArtist(c, name)
+		if err != nil {
+			log.Printf("create artist error: %v", err)
+			return nil, errors.New("failed to resolve artist")
+		}
+		return artist, nil
+	}
+
+	artist := &db.Artist{ID: id}
+	cacheClient, ok := c.Request.Context().Value(CacheContextKey).(cache.Cache)
+	artistCacheKey := fmt.Sprintf("artist-id-%s", id)
 	if ok {
 		if cacheErr := cacheClient.Get(artistCacheKey, artist); cacheErr != nil {
 			log.Printf("(Get artist id from cache) failed: %s\n", cacheErr.Error())
 		}
 	}
-
 	if artist.Name == "" {
-		artist, err = artistRepo.GetArtistById(c, trackArtistID)
+		var err error
+		artist, err = artistRepo.GetArtistById(c, id)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusNotFound, errorResponse(fmt.Sprintf("artist with id (%s) does not exist: %s", trackArtistID, err.Error())))
-			return
+			return nil, &uploadError{http.StatusNotFound, fmt.Sprintf("artist with id (%s) does not exist: %s", id, err.Error())}
 		}
 		if ok {
 			_ = cacheClient.Set(artistCacheKey, artist, 10*time.Hour)
 		}
 	}
-
-	track, err := r.CreateTrack(c, trackTitle, trackArtistID, filePath, reqForm.Duration, reqForm.Thumbnail)
-	if err != nil {
-		log.Printf("create track error: %v", err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, errorResponse("failed to save track"))
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{
-		"data": track,
-	})
+	return artist, nil
 }
 
 type BulkTrackUploadForm struct {
-	Titles   []string                `form:"track_titles" binding:"required"`
-	Files    []*multipart.FileHeader `form:"track_files" binding:"required"`
-	ArtistId string                  `form:"artist_id" binding:"required"`
+	Titles       []string                `form:"track_titles"` // Optional, by position: falls back to each file's tags
+	Files        []*multipart.FileHeader `form:"track_files" binding:"required"`
+	ArtistId     string                  `form:"artist_id"`    // Optional: without it each file's artist tag is used
+	Downloadable bool                    `form:"downloadable"` // Optional: applies to every track in the batch
+	AlbumId      string                  `form:"album_id"`     // Optional: an existing album to add every track to
+	Album        string                  `form:"album"`        // Optional: album title, found or created under artist_id
+	AlbumType    string                  `form:"album_type"`   // Optional: single, ep, album (default) or compilation, for a new album
+	ReleaseDate  string                  `form:"release_date"` // Optional: 2006-01-02, for a new album
 }
 
-// BulkTrackUploadHandler ingests parallel track_titles/track_files arrays
-// correlated by position. Files that are oversized or fail format sniffing are
-// silently skipped; a 400 results only when nothing valid remains.
-func BulkTrackUploadHandler(c *gin.Context, r db.TrackRepo) {
+// BulkTrackUploadHandler accepts parallel track_titles/track_files arrays,
+// correlated by position, as an upload job and responds 202 as soon as the
+// files are staged; the upload workers then validate and store each file and
+// create its track (see ingest.UploadQueue), and GetUploadJobHandler reports
+// how each file fared. Missing titles, durations and thumbnails are taken from
+// each file's tags, and without an artist_id every track is filed under the
+// artist its tags name (created if absent). An album_id (404 if absent) adds
+// every track to that album, filed under its artist unless artist_id says
+// otherwise; an album title instead finds or creates the album under
+// artist_id, which it then requires, so a whole release is uploaded in one
+// go. Tracks are numbered by their tags, or else by their position. Files
+// outside the size limit are rejected at once; a 400 results when no file
+// remains to process, and a 403 when the files within the limit would
+// together take the caller past their storage quota.
+func BulkTrackUploadHandler(c *gin.Context, queue *ingest.UploadQueue, artistRepo db.ArtistRepo, albums db.AlbumRepo, users db.UserRepo, usage db.UsageRepo) {
 	var reqForm BulkTrackUploadForm
 
 	if err := c.ShouldBind(&reqForm); err != nil {
 		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
 		return
 	}
-
-	artistID, err := uuid.Parse(reqForm.ArtistId)
+	album, err := newAlbumFields(reqForm.AlbumId, reqForm.Album, reqForm.AlbumType, reqForm.ReleaseDate)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, errorResponse(fmt.Sprintf("invalid artist id: %s", reqForm.ArtistId)))
+		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
 		return
 	}
 
-	inputs := processFiles(reqForm.Files, reqForm.Titles)
-	if len(inputs) == 0 {
-		c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse("no valid audio files within the size limit were uploaded"))
+	job := &db.UploadJob{ID: uuid.New(), Downloadable: reqForm.Downloadable}
+	if reqForm.ArtistId != "" {
+		artistID, err := uuid.Parse(reqForm.ArtistId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse(fmt.Sprintf("invalid artist id: %s", reqForm.ArtistId)))
+			return
+		}
+		job.ArtistID = &artistID
+	}
+	if err := resolveBulkAlbum(c, artistRepo, albums, album, job); err != nil {
+		respondUploadError(c, err)
 		return
 	}
+	if userID, ok := currentUserID(c); ok {
+		job.UserID = &userID
+	}
 
-	rows, err := r.BulkCreateTracks(c, inputs, artistID)
+	var batchBytes int64
+	batchTracks := 0
+	for _, fh := range reqForm.Files {
+		if fh.Size > 0 && fh.Size <= MaxUploadBytes {
+			batchBytes += fh.Size
+			batchTracks++
+		}
+	}
+	quota, err := checkQuota(c, users, usage, batchBytes, batchTracks)
 	if err != nil {
-		log.Printf("bulk create tracks error: %v", err)
+		respondUploadError(c, err)
+		return
+	}
+	job.Quota = db.UsageLimit(quota)
+
+	queued := 0
+	for idx, fh := range reqForm.Files {
+		file := db.UploadJobFile{Position: idx, Filename: fh.Filename, Size: fh.Size}
+		if idx < len(reqForm.Titles) {
+			file.Title = reqForm.Titles[idx]
+		}
+		if err := stageUploadFile(c, queue, &file, fh); err != nil {
+			log.Printf("bulk reject file %q: %s", fh.Filename, err)
+			file.Status = db.UploadFileRejected
+			file.Error = err.Error()
+		} else {
+			queued++
+		}
+		job.Files = append(job.Files, file)
+	}
+	if queued == 0 {
+		res := errorResponse("no valid audio files within the size limit were uploaded")
+		res["data"] = job
+		c.AbortWithStatusJSON(http.StatusBadRequest, res)
+		return
+	}
+
+	if err := queue.Enqueue(c, job); err != nil {
+		log.Printf("enqueue upload job error: %v", err)
 		c.AbortWithStatusJSON(http.StatusInternalServerError, errorResponse("audio upload failed"))
 		return
 	}
 
+	c.Header("Location", "/api/v1/uploads/"+job.ID.String())
+	c.JSON(http.StatusAccepted, gin.H{
+		"data": job,
+	})
+}
+
+// resolveBulkAlbum sets the album of a bulk upload job from a, if a names
+// one: an existing album, whose artist the job defaults to, or one titled
+// a.Album under the job's artist, which must exist.
+func resolveBulkAlbum(c *gin.Context, artistRepo db.ArtistRepo, albums db.AlbumRepo, a albumFields, job *db.UploadJob) error {
+	album, err := findUploadAlbum(c, albums, a)
+	if err != nil {
+		return err
+	}
+	if album == nil && a.Album != "" {
+		if job.ArtistID == nil {
+			return &uploadError{http.StatusBadRequest, "artist_id is required to create an album"}
+		}
+		artist, err := resolveUploadArtist(c, artistRepo, *job.ArtistID, "")
+		if err != nil {
+			return err
+		}
+		if album, err = createUploadAlbum(c, albums, a, artist.ID); err != nil {
+			return err
+		}
+	}
+	if album == nil {
+		return nil
+	}
+	job.AlbumID = &album.ID
+	if job.ArtistID == nil && album.Type != db.AlbumTypeCompilation {
+		job.ArtistID = &album.ArtistID
+	}
+	return nil
+}
+
+// stageUploadFile stages one file of a bulk upload for the workers, or
+// explains why it cannot be accepted.
+func stageUploadFile(c *gin.Context, queue *ingest.UploadQueue, file *db.UploadJobFile, fh *multipart.FileHeader) error {
+	if fh.Size <= 0 || fh.Size > MaxUploadBytes {
+		return fmt.Errorf("size %d is outside the allowed 1..%d bytes", fh.Size, MaxUploadBytes)
+	}
+	src, err := fh.Open()
+	if err != nil {
+		return errors.New("unable to access audio")
+	}
+	defer src.Close()
+	if err := queue.Stage(c, file, src, fh.Size); err != nil {
+		log.Printf("stage file %q: %v", fh.Filename, err)
+		return errors.New("failed to stage audio")
+	}
+	return nil
+}
+
+// GetUploadJobHandler reports the status of a bulk upload job and of each of
+// its files, including their errors and the ids of the tracks created. Jobs
+// are visible only to their uploader; anyone else gets 404.
+func GetUploadJobHandler(c *gin.Context, jobs db.UploadJobRepo) {
+	jobID, err := uuid.Parse(c.Param("jobId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("invalid job ID format"))
+		return
+	}
+	job, err := jobs.GetJob(c, jobID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("upload job not found"))
+		return
+	}
+	var owner uuid.UUID
+	if job.UserID != nil {
+		owner = *job.UserID
+	}
+	if userID, _ := currentUserID(c); userID != owner {
+		c.JSON(http.StatusNotFound, errorResponse("upload job not found"))
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"data": map[string]any{
-			"saved": inputs,
-			"rows":  rows,
-		},
+		"data": job,
 	})
 }
 
-// processFiles reads, validates, and stores each uploaded file, returning one
-// entry per successfully saved track. Each file's title is carried by position
-// in the request, so duplicate filenames or titles never collapse onto one
-// another (the previous filename-keyed map silently dropped such uploads).
-func processFiles(files []*multipart.FileHeader, titles []string) []db.BulkTrackInput {
-	var toSave []fs.FileMeta
+// UpdateTrackForm is a partial update of a track, sent as JSON or, to replace
+// the audio, as a multipart form. Fields left out are left as they are.
+type UpdateTrackForm struct {
+	Title     *string               `form:"title" json:"title"`
+	ArtistId  *string               `form:"artist_id" json:"artist_id"` // an existing artist
+	Artist    *string               `form:"artist" json:"artist"`       // artist name, found or created; used when artist_id is absent
+	Duration  *int                  `form:"duration" json:"duration"`   // seconds; new audio defaults it to the measured one
+	Thumbnail *string               `form:"thumbnail" json:"thumbnail"` // thumbnail URL or path
+	Audio     *multipart.FileHeader `form:"audio" json:"-"`             // replacement audio
+}
 
-	for idx, file := range files {
-		if idx >= len(titles) {
-			break // no matching title for this file; ignore the extra
+// UpdateTrackHandler changes a track as an UpdateTrackForm says and returns
+// the result. Only the track's uploader or an admin may change it; anyone
+// else gets 403. Replacement audio goes through the checks AddTrackHandler
+// makes, and is charged to the uploader for the size it adds; audio that is
+// already another track's gets 409. The old audio and everything packaged
+// from it are removed from the store after db.BlobRemovalGrace, and the new
+// audio is handed to ing (when non-nil) for packaging.
+func UpdateTrackHandler(c *gin.Context, r db.TrackRepo, artistRepo db.ArtistRepo, users db.UserRepo, usage db.UsageRepo, ing *ingest.Service) {
+	trackId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("invalid track ID format"))
+		return
+	}
+	var form UpdateTrackForm
+	if err := c.ShouldBind(&form); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	var update db.TrackUpdate
+	if form.Title != nil {
+		title := strings.TrimSpace(*form.Title)
+		if title == "" {
+			c.JSON(http.StatusBadRequest, errorResponse("title must not be blank"))
+			return
 		}
-
-		if file.Size <= 0 || file.Size > MaxUploadBytes {
-			log.Printf("bulk skip file %q: size %d out of bounds", file.Filename, file.Size)
-			continue
+		update.Title = &title
+	}
+	if form.Duration != nil {
+		if *form.Duration < 0 {
+			c.JSON(http.StatusBadRequest, errorResponse("duration must not be negative"))
+			return
 		}
+		update.Duration = form.Duration
+	}
+	if form.Thumbnail != nil {
+		thumbnail := strings.TrimSpace(*form.Thumbnail)
+		update.Thumbnail = &thumbnail
+	}
+	var artistID uuid.UUID
+	if form.ArtistId != nil {
+		if artistID, err = uuid.Parse(*form.ArtistId); err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse(fmt.Sprintf("artist id should be a valid uuid string not %s", *form.ArtistId)))
+			return
+		}
+	} else if form.Artist != nil && strings.TrimSpace(*form.Artist) == "" {
+		c.JSON(http.StatusBadRequest, errorResponse("artist must not be blank"))
+		return
+	}
 
-		audioFile, fileErr := file.Open()
-		if fileErr != nil {
-			log.Printf("bulk open file %q: %v", file.Filename, fileErr)
-			continue
+	track, err := r.GetTrackByID(c, trackId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("track not found"))
+		return
+	}
+	if !canManage(c, users, track.UploaderID) {
+		c.JSON(http.StatusForbidden, errorResponse("only the track's uploader or an admin may change it"))
+		return
+	}
+
+	if form.ArtistId != nil || form.Artist != nil {
+		var name string
+		if form.Artist != nil {
+			name = strings.TrimSpace(*form.Artist)
 		}
-		audioBytes := make([]byte, file.Size)
-		_, readErr := io.ReadFull(audioFile, audioBytes)
-		_ = audioFile.Close()
-		if readErr != nil {
-			log.Printf("bulk read file %q: %v", file.Filename, readErr)
-			continue
+		artist, err := resolveUploadArtist(c, artistRepo, artistID, name)
+		if err != nil {
+			respondUploadError(c, err)
+			return
 		}
-		ext, ok := detectAudioFormat(audioBytes)
-		if !ok {
-			log.Printf("bulk reject file %q: unsupported audio format", file.Filename)
-			continue
+		update.ArtistID = &artist.ID
+	}
+	if form.Audio != nil {
+		if err := replaceTrackAudio(c, r, users, usage, track, form.Audio, &update); err != nil {
+			respondUploadError(c, err)
+			return
 		}
+	}
+
+	updated, err := r.UpdateTrack(c, trackId, update)
+	var quotaErr *db.QuotaError
+	if errors.As(err, &quotaErr) {
+		respondUploadError(c, quotaExceeded(quotaErr))
+		return
+	}
+	if err != nil {
+		log.Printf("UpdateTrack error: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to update track"))
+		return
+	}
+	if update.Audio != nil && ing != nil {
+		ing.Submit(updated.ID, updated.File)
+	}
+	fillCredits(c, r, updated)
 
-		// Carry the track title (not the filename) so results stay correlated
-		// even when two files share a name.
-		toSave = append(toSave, fs.FileMeta{AudioTitle: titles[idx], Content: audioBytes, Ext: ext})
+	c.JSON(http.StatusOK, gin.H{
+		"data": updated,
+	})
+}
+
+// replaceTrackAudio checks and stores fh as the new audio of track, setting
+// update.Audio, and update.Duration unless it is set already. Audio larger
+// than track's must fit the quota of its uploader, who is charged for it.
+// Audio identical to track's is not stored again, leaving update.Audio nil.
+func replaceTrackAudio(c *gin.Context, r db.TrackRepo, users db.UserRepo, usage db.UsageRepo, track *db.Track, fh *multipart.FileHeader, update *db.TrackUpdate) error {
+	if fh.Size <= 0 {
+		return &uploadError{http.StatusBadRequest, "audio for track not found"}
+	}
+	if fh.Size > MaxUploadBytes {
+		return &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("audio exceeds the maximum allowed size of %d bytes", MaxUploadBytes)}
+	}
+	var quota Quota
+	if grows := fh.Size - track.Size; grows > 0 && track.UploaderID != nil {
+		var err error
+		if quota, err = checkQuotaOf(c, users, usage, *track.UploaderID, grows, 0); err != nil {
+			return err
+		}
 	}
 
-	if len(toSave) == 0 {
+	audioFile, err := fh.Open()
+	if err != nil {
+		return &uploadError{http.StatusBadRequest, "unable to access track audio"}
+	}
+	defer audioFile.Close()
+
+	ext, checksum, err := inspectAudio(audioFile, fh.Size)
+	if err != nil {
+		return err
+	}
+	if checksum == track.Checksum {
 		return nil
 	}
+	if existing, err := r.GetTrackByChecksum(c, checksum); err == nil {
+		return &uploadError{http.StatusConflict, fmt.Sprintf("this audio is already track %s", existing.ID)}
+	}
 
-	resultCh := make(chan fs.FileMeta, len(toSave))
-	fs.Store.BulkSave(resultCh, toSave)
+	filePath, err := fs.Store.SaveStream(io.NewSectionReader(audioFile, 0, fh.Size), fh.Size, ext)
+	if err != nil {
+		log.Printf("store audio error: %v", err)
+		return errors.New("failed to store audio")
+	}
+	update.Audio = &db.TrackAudio{File: filePath, Checksum: checksum, Size: fh.Size, Quota: db.UsageLimit(quota)}
+	if update.Duration == nil {
+		duration := ingest.DurationSeconds(ingest.ReadMetadata(audioFile, fh.Size, ext))
+		update.Duration = &duration
+	}
+	return nil
+}
 
-	inputs := make([]db.BulkTrackInput, 0, len(toSave))
-	for meta := range resultCh {
-		if meta.Name == "" {
-			continue
+// DeleteTrackHandler deletes a track (see db.TrackRepo.DeleteTrack): it
+// leaves listings and playlists at once, and its blobs are removed from the
+// store after db.BlobRemovalGrace. Only the track's uploader or an admin may
+// delete it; anyone else gets 403.
+func DeleteTrackHandler(c *gin.Context, r db.TrackRepo, users db.UserRepo) {
+	trackId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse("invalid track ID format"))
+		return
+	}
+	track, err := r.GetTrackByID(c, trackId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("track not found"))
+		return
+	}
+	if !canManage(c, users, track.UploaderID) {
+		c.JSON(http.StatusForbidden, errorResponse("only the track's uploader or an admin may delete it"))
+		return
+	}
+
+	if err := r.DeleteTrack(c, trackId); err != nil {
+		log.Printf("DeleteTrack error: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to delete track"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "track deleted"})
+}
+
+func firstPositive(values ...int) int {
+	for _, v := range values {
+		if v > 0 {
+			return v
 		}
-		inputs = append(inputs, db.BulkTrackInput{Title: meta.AudioTitle, File: meta.Name})
 	}
+	return 0
+}
 
-	return inputs
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
 }
 
 type TrackPlayRequest struct {
@@ -0,0 +1,454 @@
+package handlers
+
+import (
+	"auxstream/internal/cache"
+	"auxstream/internal/db"
+	"auxstream/internal/ingest"
+	fs "auxstream/internal/storage"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Resumable uploads follow the tus protocol (https://tus.io/protocols/resumable-upload),
+// version 1.0.0 with the creation and termination extensions: POST creates an
+// upload of a declared length, PATCH appends bytes at an offset, HEAD reports
+// how much has arrived so an interrupted client can resume, and DELETE
+// abandons it. The bytes are held by a storage.Stager and the upload's state
+// in the cache, so any API instance can serve any request of an upload. When
+// the last byte arrives the file is ingested exactly as AddTrackHandler would,
+// and GET on the upload then reports the track it became.
+
+const tusVersion = "1.0.0"
+
+// resumableUploadTTL is how long an upload may sit idle before it expires.
+// Every PATCH extends it.
+const resumableUploadTTL = 24 * time.Hour
+
+// resumableUpload is the state of one resumable upload, as kept in the cache.
+type resumableUpload struct {
+	ID        string     `json:"id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	Quota     Quota      `json:"quota"` // UserID's when the upload was created
+	Length    int64      `json:"length"`
+	Offset    int64      `json:"offset"`
+	Stage     string     `json:"stage"` // the Stager's state
+	TrackID   *uuid.UUID `json:"track_id,omitempty"`
+	ExpiresAt time.Time  `json:"expires_at"`
+
+	// Track fields from the Upload-Metadata header.
+	Filename     string       `json:"filename"`
+	Title        string       `json:"title"`
+	ArtistID     uuid.UUID    `json:"artist_id"`
+	Artist       string       `json:"artist"`
+	Duration     int          `json:"duration"`
+	Thumbnail    string       `json:"thumbnail"`
+	Downloadable bool         `json:"downloadable"`
+	DiscNumber   int          `json:"disc_number"`
+	TrackNumber  int          `json:"track_number"`
+	Credits      creditFields `json:"credits"`
+	albumFields
+}
+
+func resumableUploadKey(id string) string {
+	return fmt.Sprintf("tus-upload-%s", id)
+}
+
+func resumableUploadLockKey(id string) string {
+	return fmt.Sprintf("tus-lock-%s", id)
+}
+
+// TusOptionsHandler advertises the protocol version, extensions and the
+// largest upload accepted.
+func TusOptionsHandler(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", "creation,termination")
+	c.Header("Tus-Max-Size", strconv.FormatInt(MaxUploadBytes, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// CreateResumableUploadHandler starts an upload of Upload-Length bytes.
+// Upload-Metadata may carry the fields AddTrackForm takes (filename, title,
+// artist_id, artist, duration, thumbnail, downloadable, the album fields,
+// disc_number, track_number and the credit fields); they are checked now
+// so a bad one fails before any audio is sent. Responds 201 with the upload's
+// URL in Location, 413 when the length exceeds MaxUploadBytes, or 403 when it
+// would take the caller past their storage quota.
+func CreateResumableUploadHandler(c *gin.Context, stager fs.Stager, users db.UserRepo, usage db.UsageRepo) {
+	if !tusRequest(c) {
+		return
+	}
+	store, ok := uploadCache(c)
+	if !ok {
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 1 {
+		c.JSON(http.StatusBadRequest, errorResponse("Upload-Length must be a positive number of bytes"))
+		return
+	}
+	if length > MaxUploadBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, errorResponse(fmt.Sprintf("audio exceeds the maximum allowed size of %d bytes", MaxUploadBytes)))
+		return
+	}
+	quota, err := checkQuota(c, users, usage, length, 1)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	up := &resumableUpload{ID: uuid.NewString(), Length: length, Quota: quota}
+	if err := up.setMetadata(c.GetHeader("Upload-Metadata")); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	up.UserID, _ = currentUserID(c)
+
+	up.Stage, err = stager.Begin(c, up.ID, length)
+	if err != nil {
+		log.Printf("begin staged upload: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to create upload"))
+		return
+	}
+	if err := saveResumableUpload(store, up); err != nil {
+		log.Printf("save upload state: %v", err)
+		_ = stager.Discard(c, up.ID, up.Stage)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to create upload"))
+		return
+	}
+
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+up.ID)
+	c.Header("Upload-Expires", up.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+// ResumableUploadOffsetHandler answers HEAD with how many bytes of the upload
+// have been received, which is where the client resumes.
+func ResumableUploadOffsetHandler(c *gin.Context) {
+	if !tusRequest(c) {
+		return
+	}
+	store, ok := uploadCache(c)
+	if !ok {
+		return
+	}
+	up, ok := loadResumableUpload(c, store)
+	if !ok {
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(up.Length, 10))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+}
+
+// GetResumableUploadHandler reports an upload's progress and, once it has
+// been ingested, the id of the track it became.
+func GetResumableUploadHandler(c *gin.Context) {
+	store, ok := uploadCache(c)
+	if !ok {
+		return
+	}
+	up, ok := loadResumableUpload(c, store)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"id":         up.ID,
+			"offset":     up.Offset,
+			"length":     up.Length,
+			"complete":   up.TrackID != nil,
+			"track_id":   up.TrackID,
+			"expires_at": up.ExpiresAt,
+		},
+	})
+}
+
+// ResumableUploadPatchHandler appends the request body to the upload at
+// Upload-Offset, which must be where the upload stands (409 otherwise). When
+// the body completes the upload, the file is ingested before responding: a
+// file rejected as unsupported or damaged gets the same status
+// AddTrackHandler gives it and the upload is dropped, while a failure on the
+// server's side keeps the bytes, and an empty PATCH at the final offset
+// retries the ingest. Requests that overlap on one upload get 423.
+func ResumableUploadPatchHandler(c *gin.Context, stager fs.Stager, r db.TrackRepo, artistRepo db.ArtistRepo, albums db.AlbumRepo, ing *ingest.Service) {
+	if !tusRequest(c) {
+		return
+	}
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, errorResponse("Content-Type must be application/offset+octet-stream"))
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, errorResponse("Upload-Offset must be a non-negative number of bytes"))
+		return
+	}
+	store, ok := uploadCache(c)
+	if !ok {
+		return
+	}
+	unlock, ok := lockResumableUpload(c, store)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	up, ok := loadResumableUpload(c, store)
+	if !ok {
+		return
+	}
+	if offset != up.Offset {
+		c.JSON(http.StatusConflict, errorResponse(fmt.Sprintf("Upload-Offset %d does not match the upload's offset %d", offset, up.Offset)))
+		return
+	}
+	if up.TrackID != nil {
+		c.Header("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	stage, n, err := stager.Append(c, up.ID, up.Stage, offset, io.LimitReader(c.Request.Body, up.Length-up.Offset))
+	up.Stage = stage
+	up.Offset += n
+	if saveErr := saveResumableUpload(store, up); saveErr != nil {
+		log.Printf("save upload state: %v", saveErr)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to record upload progress"))
+		return
+	}
+	if errors.Is(err, fs.ErrChunkTooSmall) {
+		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		// Usually the client going away mid-chunk; what arrived is kept.
+		log.Printf("append to upload %s: %v", up.ID, err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to receive upload"))
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+
+	if up.Offset == up.Length {
+		if !finishResumableUpload(c, store, stager, up, r, artistRepo, albums, ing) {
+			return
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// finishResumableUpload ingests a fully received upload, writing the error
+// response and reporting false when that fails.
+func finishResumableUpload(c *gin.Context, store cache.Cache, stager fs.Stager, up *resumableUpload, r db.TrackRepo, artistRepo db.ArtistRepo, albums db.AlbumRepo, ing *ingest.Service) bool {
+	file, err := stager.Open(c, up.ID, up.Stage)
+	if err != nil {
+		log.Printf("open staged upload %s: %v", up.ID, err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to read upload"))
+		return false
+	}
+	defer file.Close()
+
+	track, _, _, err := ingestUpload(c, r, artistRepo, albums, ing, trackUpload{
+		UploaderID:   up.UserID,
+		Quota:        up.Quota,
+		Audio:        file,
+		Size:         up.Length,
+		Filename:     up.Filename,
+		Title:        up.Title,
+		ArtistID:     up.ArtistID,
+		Artist:       up.Artist,
+		Duration:     up.Duration,
+		Thumbnail:    up.Thumbnail,
+		Downloadable: up.Downloadable,
+		Album:        up.albumFields,
+		DiscNumber:   up.DiscNumber,
+		TrackNumber:  up.TrackNumber,
+		Credits:      up.Credits,
+	})
+	var uerr *uploadError
+	if errors.As(err, &uerr) {
+		// The file itself was refused; resending it will not help.
+		_ = stager.Discard(c, up.ID, up.Stage)
+		_ = store.Del(resumableUploadKey(up.ID))
+	}
+	if err != nil {
+		respondUploadError(c, err)
+		return false
+	}
+
+	if err := stager.Discard(c, up.ID, up.Stage); err != nil {
+		log.Printf("discard staged upload %s: %v", up.ID, err)
+	}
+	up.Stage = ""
+	up.TrackID = &track.ID
+	if err := saveResumableUpload(store, up); err != nil {
+		log.Printf("save upload state: %v", err)
+	}
+	return true
+}
+
+// DeleteResumableUploadHandler abandons an upload, discarding what it has
+// received.
+func DeleteResumableUploadHandler(c *gin.Context, stager fs.Stager) {
+	if !tusRequest(c) {
+		return
+	}
+	store, ok := uploadCache(c)
+	if !ok {
+		return
+	}
+	unlock, ok := lockResumableUpload(c, store)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	up, ok := loadResumableUpload(c, store)
+	if !ok {
+		return
+	}
+	if err := stager.Discard(c, up.ID, up.Stage); err != nil {
+		log.Printf("discard staged upload %s: %v", up.ID, err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to delete upload"))
+		return
+	}
+	_ = store.Del(resumableUploadKey(up.ID))
+	c.Status(http.StatusNoContent)
+}
+
+// tusRequest marks the response as tus and checks the client speaks the same
+// version, responding 412 when it does not.
+func tusRequest(c *gin.Context) bool {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.JSON(http.StatusPreconditionFailed, errorResponse("unsupported Tus-Resumable version"))
+		return false
+	}
+	return true
+}
+
+func uploadCache(c *gin.Context) (cache.Cache, bool) {
+	store, ok := c.Request.Context().Value(CacheContextKey).(cache.Cache)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, errorResponse("upload state is unavailable"))
+	}
+	return store, ok
+}
+
+// loadResumableUpload fetches the upload named by the id path parameter.
+// Uploads belong to whoever created them; anyone else gets the same 404 as
+// for an upload that does not exist or has expired.
+func loadResumableUpload(c *gin.Context, store cache.Cache) (*resumableUpload, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("upload not found"))
+		return nil, false
+	}
+	up := &resumableUpload{}
+	if err := store.Get(resumableUploadKey(id.String()), up); err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("upload not found"))
+		return nil, false
+	}
+	if userID, _ := currentUserID(c); userID != up.UserID {
+		c.JSON(http.StatusNotFound, errorResponse("upload not found"))
+		return nil, false
+	}
+	return up, true
+}
+
+func saveResumableUpload(store cache.Cache, up *resumableUpload) error {
+	up.ExpiresAt = time.Now().Add(resumableUploadTTL)
+	return store.Set(resumableUploadKey(up.ID), up, resumableUploadTTL)
+}
+
+// lockResumableUpload claims the upload named by the id path parameter for
+// this request, responding 423 when another request holds it.
+func lockResumableUpload(c *gin.Context, store cache.Cache) (unlock func(), ok bool) {
+	return lockUpload(c, store, resumableUploadLockKey(c.Param("id")))
+}
+
+// lockUpload claims the lock at key for this request, responding 423 when
+// another request holds it. The claim lapses on its own should its holder die
+// without releasing it.
+func lockUpload(c *gin.Context, store cache.Cache, key string) (unlock func(), ok bool) {
+	n, err := store.Incr(c, key)
+	if err != nil {
+		log.Printf("lock upload: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("upload state is unavailable"))
+		return nil, false
+	}
+	if n != 1 {
+		c.JSON(http.StatusLocked, errorResponse("upload is busy with another request"))
+		return nil, false
+	}
+	_ = store.Expire(c, key, time.Hour)
+	return func() { _ = store.Del(key) }, true
+}
+
+// setMetadata reads the track fields from an Upload-Metadata header: comma
+// separated pairs of a key and its base64-encoded value.
+func (up *resumableUpload) setMetadata(header string) error {
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("Upload-Metadata value for %q is not base64", key)
+		}
+		value := string(raw)
+
+		switch key {
+		case "filename":
+			up.Filename = value
+		case "title":
+			up.Title = value
+		case "artist":
+			up.Artist = value
+		case "thumbnail":
+			up.Thumbnail = value
+		case "artist_id":
+			if up.ArtistID, err = uuid.Parse(value); err != nil {
+				return fmt.Errorf("artist id should be a valid uuid string not %s", value)
+			}
+		case "duration":
+			if up.Duration, err = strconv.Atoi(value); err != nil {
+				return fmt.Errorf("duration should be a whole number of seconds not %s", value)
+			}
+		case "downloadable":
+			if up.Downloadable, err = strconv.ParseBool(value); err != nil {
+				return fmt.Errorf("downloadable should be true or false not %s", value)
+			}
+		case "disc_number":
+			if up.DiscNumber, err = strconv.Atoi(value); err != nil || up.DiscNumber < 0 {
+				return fmt.Errorf("disc_number should be a positive whole number not %s", value)
+			}
+		case "track_number":
+			if up.TrackNumber, err = strconv.Atoi(value); err != nil || up.TrackNumber < 0 {
+				return fmt.Errorf("track_number should be a positive whole number not %s", value)
+			}
+		default:
+			if err := up.albumFields.set(key, value); err != nil {
+				return err
+			}
+			if err := up.Credits.set(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"auxstream/internal/db"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type preferencesResponse struct {
+	StreamQuality string `json:"stream_quality"`
+}
+
+// GetPreferencesHandler returns the authenticated caller's playback preferences.
+func GetPreferencesHandler(c *gin.Context, users db.UserRepo) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, errorResponse("authentication required"))
+		return
+	}
+	user, err := users.GetUserById(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("user not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": preferencesResponse{StreamQuality: user.StreamQuality}})
+}
+
+type updatePreferencesRequest struct {
+	StreamQuality *string `json:"stream_quality"`
+}
+
+// UpdatePreferencesHandler changes the caller's playback preferences from a
+// JSON body; omitted fields are left as they are. stream_quality names the
+// rendition streamed when a request does not pick one ("" resets to the
+// original upload); any other unknown value is a 400.
+func UpdatePreferencesHandler(c *gin.Context, users db.UserRepo) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, errorResponse("authentication required"))
+		return
+	}
+	var req updatePreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+	if req.StreamQuality != nil && *req.StreamQuality != "" && !db.IsStreamQuality(*req.StreamQuality) {
+		c.JSON(http.StatusBadRequest, errorResponse("stream_quality must be one of original, low, medium or high"))
+		return
+	}
+
+	user, err := users.GetUserById(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse("user not found"))
+		return
+	}
+	if req.StreamQuality != nil {
+		user.StreamQuality = *req.StreamQuality
+	}
+	if _, err := users.UpdateUser(c.Request.Context(), user); err != nil {
+		log.Printf("UpdateUser error: %v", err)
+		c.JSON(http.StatusInternalServerError, errorResponse("failed to update preferences"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": preferencesResponse{StreamQuality: user.StreamQuality}})
+}
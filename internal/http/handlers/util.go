@@ -1,36 +1,35 @@
 package handlers
 
-import "github.com/gin-gonic/gin"
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
 
 // MaxUploadBytes is the maximum allowed size of a single uploaded audio file.
-// It defaults to 5 MiB and is overridden at startup from configuration
+// It defaults to 500 MiB and is overridden at startup from configuration
 // (MAX_UPLOAD_BYTES). Enforced per file on both the single and bulk paths to
-// bound memory use and keep the upload surface from being abused.
-var MaxUploadBytes int64 = 5 << 20
-
-// detectAudioFormat reports the audio format of a payload from its leading magic
-// bytes, returning the canonical file extension and whether it is a supported type.
-// This is a content check, so renamed/non-audio payloads are rejected.
-func detectAudioFormat(head []byte) (ext string, ok bool) {
-	if len(head) >= 3 && head[0] == 'I' && head[1] == 'D' && head[2] == '3' {
-		return "mp3", true
-	}
-	if len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0 {
-		return "mp3", true
-	}
-	if len(head) >= 4 && string(head[0:4]) == "fLaC" {
-		return "flac", true
-	}
-	if len(head) >= 4 && string(head[0:4]) == "OggS" {
-		return "ogg", true
-	}
-	if len(head) >= 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "WAVE" {
-		return "wav", true
-	}
-	if len(head) >= 8 && string(head[4:8]) == "ftyp" {
-		return "m4a", true
+// keep the upload surface from being abused; uploads are streamed to the file
+// store, so the limit no longer bounds memory use.
+var MaxUploadBytes int64 = 500 << 20
+
+// audioContentTypes maps each extension ingest.DetectFormat can yield to the MIME
+// type browsers expect for it.
+var audioContentTypes = map[string]string{
+	"mp3":  "audio/mpeg",
+	"flac": "audio/flac",
+	"ogg":  "audio/ogg",
+	"wav":  "audio/wav",
+	"m4a":  "audio/mp4",
+}
+
+// audioContentType returns the MIME type for an audio extension (without the
+// leading dot), falling back to a generic binary type for anything unknown.
+func audioContentType(ext string) string {
+	if ct, ok := audioContentTypes[strings.ToLower(ext)]; ok {
+		return ct
 	}
-	return "", false
+	return "application/octet-stream"
 }
 
 // contextKey is a private type for request-context keys, avoiding collisions
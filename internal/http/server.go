@@ -8,9 +8,16 @@ import (
 	"auxstream/internal/external"
 	"auxstream/internal/http/handlers"
 	"auxstream/internal/http/middleware"
+	"auxstream/internal/ingest"
 	"auxstream/internal/logger"
 	"auxstream/internal/search"
+	fs "auxstream/internal/storage"
 	"context"
+	"log"
+	nethttp "net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -20,6 +27,10 @@ import (
 	"gorm.io/gorm"
 )
 
+// shutdownTimeout is how long requests in flight get to finish once the server
+// is told to stop.
+const shutdownTimeout = 30 * time.Second
+
 type Server interface {
 	Run() error
 	SetupRouter(mock bool) *gin.Engine
@@ -36,6 +47,10 @@ type server struct {
 	cache         cache.Cache
 	conf          config.Config
 	jwtService    *auth.JWTService
+	streamTokens  *auth.StreamTokenService
+	ingest        *ingest.Service
+	stager        fs.Stager
+	uploadQueue   *ingest.UploadQueue
 	authService   *handlers.AuthService
 	searchService *search.Service
 	rateLimiter   *middleware.RateLimiter
@@ -50,6 +65,14 @@ func NewServer(serverConfig ServerConfig) Server {
 
 	refreshService := auth.NewRefreshTokenService(serverConfig.Cache, jwtService)
 
+	streamSecret := serverConfig.Conf.StreamTokenSecret
+	if streamSecret == "" {
+		streamSecret = serverConfig.Conf.JWTSecret
+	}
+	// Long enough to outlast a track (players re-send the URL on every seek),
+	// short enough that a copied link soon goes dead.
+	streamTokens := auth.NewStreamTokenService(streamSecret, 6*time.Hour)
+
 	authService := handlers.NewAuthService(
 		db.NewUserRepo(serverConfig.DB),
 		jwtService,
@@ -59,7 +82,7 @@ func NewServer(serverConfig ServerConfig) Server {
 	youtubeClient := external.NewYouTubeClient(serverConfig.Conf.YouTubeAPIKey)
 	soundcloudClient := external.NewSoundCloudClient(serverConfig.Conf.SoundCloudClientID)
 
-	aggregator := external.NewAggregator(youtubeClient, soundcloudClient, db.NewTrackRepo(serverConfig.DB))
+	aggregator := external.NewAggregator(youtubeClient, soundcloudClient, db.NewTrackRepo(serverConfig.DB), streamTokens)
 	searchService := search.NewService(aggregator, serverConfig.Cache)
 
 	rateLimiter := middleware.NewRateLimiter(serverConfig.Cache, middleware.RateLimitConfig{
@@ -67,28 +90,58 @@ func NewServer(serverConfig ServerConfig) Server {
 		Window:      time.Minute,
 	})
 
+	transcoder, err := ingest.NewTranscoder(serverConfig.Conf.FFmpegPath)
+	if err != nil {
+		// The zap logger is only set up in Run, so report this one directly.
+		log.Printf("transcoding disabled: encoder %q not found: %v", serverConfig.Conf.FFmpegPath, err)
+	}
+
 	if serverConfig.Conf.MaxUploadBytes > 0 {
 		handlers.MaxUploadBytes = serverConfig.Conf.MaxUploadBytes
 	}
+	if serverConfig.Conf.StorageQuotas != "" {
+		quotas, err := handlers.ParseQuotas(serverConfig.Conf.StorageQuotas)
+		if err != nil {
+			log.Fatalf("STORAGE_QUOTAS: %v", err)
+		}
+		handlers.Quotas = quotas
+	}
+
+	// Bulk uploads are only staged and queued here; the upload workers
+	// (cmd/workers -uploads) process them.
+	stager := fs.NewStager(fs.Store, serverConfig.Conf.UploadStagingDir)
+	uploadQueue := ingest.NewUploadQueue(db.NewUploadJobRepo(serverConfig.DB), stager, nil, nil, nil, nil, nil)
 
 	return &server{
 		db:            serverConfig.DB,
 		cache:         serverConfig.Cache,
 		conf:          serverConfig.Conf,
 		jwtService:    jwtService,
+		streamTokens:  streamTokens,
+		ingest:        ingest.NewService(db.NewTrackFileRepo(serverConfig.DB), db.NewBlobRemovalRepo(serverConfig.DB), transcoder, 2),
+		stager:        stager,
+		uploadQueue:   uploadQueue,
 		authService:   authService,
 		searchService: searchService,
 		rateLimiter:   rateLimiter,
 	}
 }
 
-func NewMockServer(db *gorm.DB, cache cache.Cache) Server {
+func NewMockServer(database *gorm.DB, cache cache.Cache) Server {
+	stager := fs.NewLocalStager(fs.DefaultStagingDir())
 	return &server{
-		db:    db,
-		cache: cache,
+		db:           database,
+		cache:        cache,
+		jwtService:   auth.NewJWTService("test-secret", time.Hour, time.Hour),
+		streamTokens: auth.NewStreamTokenService("test-secret", time.Hour),
+		stager:       stager,
+		uploadQueue:  ingest.NewUploadQueue(db.NewUploadJobRepo(database), stager, nil, nil, nil, nil, nil),
 	}
 }
 
+// Run serves until SIGINT/SIGTERM, then stops taking requests and waits for
+// those in flight (up to shutdownTimeout) and for the processing of tracks they
+// uploaded.
 func (s *server) Run() error {
 	environment := "production"
 	if s.conf.GinMode == "debug" || s.conf.GinMode == "development" {
@@ -117,7 +170,24 @@ func (s *server) Run() error {
 		zap.String("port", s.conf.Port),
 	)
 
-	return router.Run(s.conf.Addr + ":" + s.conf.Port)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &nethttp.Server{Addr: s.conf.Addr + ":" + s.conf.Port, Handler: router}
+	served := make(chan error, 1)
+	go func() { served <- srv.ListenAndServe() }()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("Shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = srv.Shutdown(shutdownCtx)
+	// Uploads just taken may still be packaging.
+	s.ingest.Wait()
+	return err
 }
 
 func (s *server) SetupRouter(mock bool) *gin.Engine {
@@ -132,14 +202,16 @@ func (s *server) setupRouter() *gin.Engine {
 	r := gin.New()
 	r.Use(gin.Recovery())
 
-	r.MaxMultipartMemory = 500 << 20 // 500 miB
+	// Multipart parts beyond this spill to temporary files, which the upload
+	// handlers stream into the file store, so large uploads use constant memory.
+	r.MaxMultipartMemory = 8 << 20 // 8 MiB
 
 	// Hard ceiling on total request body size, applied to the upload routes
 	// below. Bounds whole-request size for DoS protection; the per-file size
 	// limit in the handlers bounds each file within an allowed request.
 	maxReq := s.conf.MaxRequestBytes
 	if maxReq <= 0 {
-		maxReq = 100 << 20 // 100 MiB
+		maxReq = 1 << 30 // 1 GiB
 	}
 	uploadLimit := middleware.MaxBodySize(maxReq)
 
@@ -148,9 +220,9 @@ func (s *server) setupRouter() *gin.Engine {
 	corsConfig := cors.New(cors.Config{
 		// Dev server (:3000) and the preview/prod SPA (:8080).
 		AllowOrigins:     []string{"http://localhost:3000", "http://localhost:8080"},
-		AllowMethods:     []string{"PUT", "PATCH", "POST", "GET", "OPTIONS", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"PUT", "PATCH", "POST", "GET", "HEAD", "OPTIONS", "DELETE"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata"},
+		ExposeHeaders:    []string{"Content-Length", "Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length", "Upload-Expires"},
 		AllowCredentials: true,
 	})
 	r.Use(corsConfig)
@@ -171,25 +243,85 @@ func (s *server) setupRouter() *gin.Engine {
 		artists.GET("/:id", func(c *gin.Context) {
 			handlers.GetArtistByIdHandler(c, db.NewArtistRepo(s.db))
 		})
-		artists.GET("/:id/tracks", func(c *gin.Context) {
-			handlers.GetArtistTracksHandler(c, db.NewTrackRepo(s.db), db.NewArtistRepo(s.db))
+		artists.GET("/:id/tracks", s.jwtService.OptionalJWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.GetArtistTracksHandler(c, db.NewTrackRepo(s.db), db.NewArtistRepo(s.db), s.streamTokens)
+		})
+		artists.GET("/:id/albums", func(c *gin.Context) {
+			handlers.GetArtistAlbumsHandler(c, db.NewAlbumRepo(s.db), db.NewArtistRepo(s.db))
+		})
+		artists.GET("/:id/tags", func(c *gin.Context) {
+			handlers.GetArtistTagsHandler(c, db.NewArtistRepo(s.db), db.NewTagRepo(s.db))
 		})
-		artists.GET("/search", func(c *gin.Context) {
-			handlers.FetchTracksByArtistHandler(c, db.NewTrackRepo(s.db))
+		artists.GET("/search", s.jwtService.OptionalJWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.FetchTracksByArtistHandler(c, db.NewTrackRepo(s.db), s.streamTokens)
 		})
 
 		artists.POST("", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
 			handlers.CreateArtistHandler(c, db.NewArtistRepo(s.db))
 		})
+		artists.PATCH("/:id", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.UpdateArtistHandler(c, db.NewArtistRepo(s.db), db.NewUserRepo(s.db))
+		})
+		artists.DELETE("/:id", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.DeleteArtistHandler(c, db.NewArtistRepo(s.db), db.NewUserRepo(s.db))
+		})
+		artists.PUT("/:id/image", s.rateLimiter.Middleware(), s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.UploadArtistImageHandler(c, db.NewArtistRepo(s.db), db.NewUserRepo(s.db))
+		})
+		artists.PUT("/:id/tags", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.SetArtistTagsHandler(c, db.NewArtistRepo(s.db), db.NewTagRepo(s.db), db.NewUserRepo(s.db))
+		})
 	}
 
+	v1.GET("/albums/:id", s.jwtService.OptionalJWTAuthMiddleware(), func(c *gin.Context) {
+		handlers.GetAlbumHandler(c, db.NewAlbumRepo(s.db), s.streamTokens)
+	})
+
+	// Genres and free-form tags, as filed under with PUT /tracks/:id/tags and
+	// PUT /artists/:id/tags and filtered on by GET /tracks.
+	v1.GET("/genres", func(c *gin.Context) {
+		handlers.ListGenresHandler(c, db.NewTagRepo(s.db))
+	})
+	v1.GET("/tags", func(c *gin.Context) {
+		handlers.ListTagsHandler(c, db.NewTagRepo(s.db))
+	})
+
 	tracks := v1.Group("/tracks")
 	{
-		tracks.GET("", func(c *gin.Context) {
-			handlers.FetchTracksHandler(c, db.NewTrackRepo(s.db))
+		// Optional auth on listings binds each signed stream URL to the caller.
+		tracks.GET("", s.jwtService.OptionalJWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.FetchTracksHandler(c, db.NewTrackRepo(s.db), s.streamTokens)
+		})
+		tracks.GET("/:id", s.jwtService.OptionalJWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.GetTrackByIDHandler(c, db.NewTrackRepo(s.db), s.streamTokens)
+		})
+		// HEAD lets players probe length and range support before fetching audio.
+		tracks.GET("/:id/stream", s.streamTokens.StreamTokenMiddleware(), func(c *gin.Context) {
+			handlers.StreamTrackHandler(c, db.NewTrackRepo(s.db), db.NewTrackFileRepo(s.db), db.NewUserRepo(s.db))
+		})
+		tracks.HEAD("/:id/stream", s.streamTokens.StreamTokenMiddleware(), func(c *gin.Context) {
+			handlers.StreamTrackHandler(c, db.NewTrackRepo(s.db), db.NewTrackFileRepo(s.db), db.NewUserRepo(s.db))
+		})
+		tracks.GET("/:id/artwork", func(c *gin.Context) {
+			handlers.TrackArtworkHandler(c, db.NewTrackRepo(s.db))
+		})
+		tracks.PUT("/:id/artwork", s.rateLimiter.Middleware(), s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.UploadTrackArtworkHandler(c, db.NewTrackRepo(s.db), db.NewUserRepo(s.db))
+		})
+		tracks.GET("/:id/download", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.DownloadTrackHandler(c, db.NewTrackRepo(s.db))
+		})
+		tracks.GET("/:id/tags", func(c *gin.Context) {
+			handlers.GetTrackTagsHandler(c, db.NewTrackRepo(s.db), db.NewTagRepo(s.db))
+		})
+		tracks.PUT("/:id/tags", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.SetTrackTagsHandler(c, db.NewTrackRepo(s.db), db.NewTagRepo(s.db), db.NewUserRepo(s.db))
 		})
-		tracks.GET("/:id", func(c *gin.Context) {
-			handlers.GetTrackByIDHandler(c, db.NewTrackRepo(s.db))
+		tracks.GET("/:id/hls/*path", s.streamTokens.StreamTokenMiddleware(), func(c *gin.Context) {
+			handlers.HLSHandler(c, db.NewTrackFileRepo(s.db))
+		})
+		tracks.HEAD("/:id/hls/*path", s.streamTokens.StreamTokenMiddleware(), func(c *gin.Context) {
+			handlers.HLSHandler(c, db.NewTrackFileRepo(s.db))
 		})
 
 		tracks.POST("/play", func(c *gin.Context) {
@@ -197,10 +329,54 @@ func (s *server) setupRouter() *gin.Engine {
 		})
 
 		tracks.POST("", uploadLimit, s.rateLimiter.Middleware(), s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
-			handlers.AddTrackHandler(c, db.NewTrackRepo(s.db), db.NewArtistRepo(s.db))
+			handlers.AddTrackHandler(c, db.NewTrackRepo(s.db), db.NewArtistRepo(s.db), db.NewAlbumRepo(s.db), db.NewUserRepo(s.db), db.NewUsageRepo(s.db), s.ingest)
 		})
 		tracks.POST("/bulk", uploadLimit, s.rateLimiter.Middleware(), s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
-			handlers.BulkTrackUploadHandler(c, db.NewTrackRepo(s.db))
+			handlers.BulkTrackUploadHandler(c, s.uploadQueue, db.NewArtistRepo(s.db), db.NewAlbumRepo(s.db), db.NewUserRepo(s.db), db.NewUsageRepo(s.db))
+		})
+		tracks.PATCH("/:id", uploadLimit, s.rateLimiter.Middleware(), s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.UpdateTrackHandler(c, db.NewTrackRepo(s.db), db.NewArtistRepo(s.db), db.NewUserRepo(s.db), db.NewUsageRepo(s.db), s.ingest)
+		})
+		tracks.DELETE("/:id", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.DeleteTrackHandler(c, db.NewTrackRepo(s.db), db.NewUserRepo(s.db))
+		})
+	}
+
+	uploads := v1.Group("/uploads")
+	{
+		// Status of a bulk upload (POST /tracks/bulk) being processed.
+		uploads.GET("/:jobId", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.GetUploadJobHandler(c, db.NewUploadJobRepo(s.db))
+		})
+	}
+
+	// Direct uploads (FILE_STORE=s3): the client PUTs the file to the bucket
+	// through a presigned URL, so it never passes through the API.
+	direct := v1.Group("/uploads/direct")
+	{
+		direct.POST("", s.rateLimiter.Middleware(), s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.CreateDirectUploadHandler(c, db.NewUserRepo(s.db), db.NewUsageRepo(s.db))
+		})
+		direct.POST("/:id/complete", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.CompleteDirectUploadHandler(c, db.NewTrackRepo(s.db), db.NewArtistRepo(s.db), s.ingest)
+		})
+	}
+
+	// Resumable (tus) uploads, for files too large to send reliably in one
+	// request. Chunks are not rate limited: one upload takes many of them.
+	tus := v1.Group("/uploads/tus")
+	{
+		tus.OPTIONS("", handlers.TusOptionsHandler)
+		tus.POST("", s.rateLimiter.Middleware(), s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.CreateResumableUploadHandler(c, s.stager, db.NewUserRepo(s.db), db.NewUsageRepo(s.db))
+		})
+		tus.HEAD("/:id", s.jwtService.JWTAuthMiddleware(), handlers.ResumableUploadOffsetHandler)
+		tus.GET("/:id", s.jwtService.JWTAuthMiddleware(), handlers.GetResumableUploadHandler)
+		tus.PATCH("/:id", uploadLimit, s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.ResumableUploadPatchHandler(c, s.stager, db.NewTrackRepo(s.db), db.NewArtistRepo(s.db), db.NewAlbumRepo(s.db), s.ingest)
+		})
+		tus.DELETE("/:id", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.DeleteResumableUploadHandler(c, s.stager)
 		})
 	}
 
@@ -214,7 +390,7 @@ func (s *server) setupRouter() *gin.Engine {
 		})
 		// Public-shareable: optional auth so anonymous visitors can view a public playlist.
 		playlists.GET("/:id", s.jwtService.OptionalJWTAuthMiddleware(), func(c *gin.Context) {
-			handlers.GetPlaylistHandler(c, db.NewPlaylistRepo(s.db))
+			handlers.GetPlaylistHandler(c, db.NewPlaylistRepo(s.db), s.streamTokens)
 		})
 		playlists.PATCH("/:id", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
 			handlers.UpdatePlaylistHandler(c, db.NewPlaylistRepo(s.db))
@@ -222,6 +398,9 @@ func (s *server) setupRouter() *gin.Engine {
 		playlists.DELETE("/:id", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
 			handlers.DeletePlaylistHandler(c, db.NewPlaylistRepo(s.db))
 		})
+		playlists.PUT("/:id/cover", s.rateLimiter.Middleware(), s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+			handlers.UploadPlaylistCoverHandler(c, db.NewPlaylistRepo(s.db))
+		})
 		playlists.POST("/:id/tracks", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
 			handlers.AddTrackToPlaylistHandler(c, db.NewPlaylistRepo(s.db), db.NewTrackRepo(s.db))
 		})
@@ -233,21 +412,36 @@ func (s *server) setupRouter() *gin.Engine {
 		})
 	}
 
+	// Renditions of uploaded pictures, linked from the images of tracks,
+	// artists and playlists (see db.ImageBaseURL).
+	v1.GET("/images/*name", handlers.ImageHandler)
+
+	me := v1.Group("/me", s.jwtService.JWTAuthMiddleware())
+	{
+		me.GET("/preferences", func(c *gin.Context) {
+			handlers.GetPreferencesHandler(c, db.NewUserRepo(s.db))
+		})
+		me.PATCH("/preferences", func(c *gin.Context) {
+			handlers.UpdatePreferencesHandler(c, db.NewUserRepo(s.db))
+		})
+		me.GET("/usage", func(c *gin.Context) {
+			handlers.GetUsageHandler(c, db.NewUserRepo(s.db), db.NewUsageRepo(s.db))
+		})
+	}
+
 	// Deprecated: prefer POST /tracks and POST /tracks/bulk. These flat aliases
 	// are retained for backwards compatibility with existing clients.
 	v1.POST("/upload_track", uploadLimit, s.rateLimiter.Middleware(), s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
-		handlers.AddTrackHandler(c, db.NewTrackRepo(s.db), db.NewArtistRepo(s.db))
+		handlers.AddTrackHandler(c, db.NewTrackRepo(s.db), db.NewArtistRepo(s.db), db.NewAlbumRepo(s.db), db.NewUserRepo(s.db), db.NewUsageRepo(s.db), s.ingest)
 	})
 	v1.POST("/upload_batch_track", uploadLimit, s.rateLimiter.Middleware(), s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
-		handlers.BulkTrackUploadHandler(c, db.NewTrackRepo(s.db))
+		handlers.BulkTrackUploadHandler(c, s.uploadQueue, db.NewArtistRepo(s.db), db.NewAlbumRepo(s.db), db.NewUserRepo(s.db), db.NewUsageRepo(s.db))
 	})
 
-	v1.GET("/search", s.rateLimiter.Middleware(), func(c *gin.Context) {
+	v1.GET("/search", s.rateLimiter.Middleware(), s.jwtService.OptionalJWTAuthMiddleware(), func(c *gin.Context) {
 		handlers.SearchHandler(c, s.searchService)
 	})
 
-	v1.Static("/serve", "./uploads")
-
 	return r
 }
 
@@ -262,17 +456,90 @@ func (s *server) healthCheck(c *gin.Context) {
 func (s *server) setupMockRouter() *gin.Engine {
 	r := gin.Default()
 	r.Use(injectCache(s.cache))
-	r.POST("/upload_track", func(c *gin.Context) {
-		handlers.AddTrackHandler(c, db.NewTrackRepo(s.db), db.NewArtistRepo(s.db))
+	// Uploads are anonymous unless a bearer token names the uploader.
+	r.POST("/upload_track", s.jwtService.OptionalJWTAuthMiddleware(), func(c *gin.Context) {
+		handlers.AddTrackHandler(c, db.NewTrackRepo(s.db), db.NewArtistRepo(s.db), db.NewAlbumRepo(s.db), db.NewUserRepo(s.db), db.NewUsageRepo(s.db), s.ingest)
+	})
+	r.POST("/upload_batch_track", s.jwtService.OptionalJWTAuthMiddleware(), func(c *gin.Context) {
+		handlers.BulkTrackUploadHandler(c, s.uploadQueue, db.NewArtistRepo(s.db), db.NewAlbumRepo(s.db), db.NewUserRepo(s.db), db.NewUsageRepo(s.db))
+	})
+	r.GET("/uploads/:jobId", func(c *gin.Context) {
+		handlers.GetUploadJobHandler(c, db.NewUploadJobRepo(s.db))
+	})
+	r.POST("/uploads/direct", func(c *gin.Context) {
+		handlers.CreateDirectUploadHandler(c, db.NewUserRepo(s.db), db.NewUsageRepo(s.db))
 	})
-	r.POST("/upload_batch_track", func(c *gin.Context) {
-		handlers.BulkTrackUploadHandler(c, db.NewTrackRepo(s.db))
+	r.POST("/uploads/direct/:id/complete", func(c *gin.Context) {
+		handlers.CompleteDirectUploadHandler(c, db.NewTrackRepo(s.db), db.NewArtistRepo(s.db), s.ingest)
+	})
+	r.POST("/uploads/tus", func(c *gin.Context) {
+		handlers.CreateResumableUploadHandler(c, s.stager, db.NewUserRepo(s.db), db.NewUsageRepo(s.db))
+	})
+	r.HEAD("/uploads/tus/:id", handlers.ResumableUploadOffsetHandler)
+	r.GET("/uploads/tus/:id", handlers.GetResumableUploadHandler)
+	r.PATCH("/uploads/tus/:id", func(c *gin.Context) {
+		handlers.ResumableUploadPatchHandler(c, s.stager, db.NewTrackRepo(s.db), db.NewArtistRepo(s.db), db.NewAlbumRepo(s.db), s.ingest)
+	})
+	r.DELETE("/uploads/tus/:id", func(c *gin.Context) {
+		handlers.DeleteResumableUploadHandler(c, s.stager)
+	})
+	r.PUT("/tracks/:id/artwork", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+		handlers.UploadTrackArtworkHandler(c, db.NewTrackRepo(s.db), db.NewUserRepo(s.db))
+	})
+	r.PUT("/playlists/:id/cover", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+		handlers.UploadPlaylistCoverHandler(c, db.NewPlaylistRepo(s.db))
+	})
+	r.GET("/images/*name", handlers.ImageHandler)
+	r.GET("/me/usage", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+		handlers.GetUsageHandler(c, db.NewUserRepo(s.db), db.NewUsageRepo(s.db))
 	})
 	r.GET("/tracks", func(c *gin.Context) {
-		handlers.FetchTracksHandler(c, db.NewTrackRepo(s.db))
+		handlers.FetchTracksHandler(c, db.NewTrackRepo(s.db), s.streamTokens)
 	})
 	r.GET("/search", func(c *gin.Context) {
-		handlers.FetchTracksByArtistHandler(c, db.NewTrackRepo(s.db))
+		handlers.FetchTracksByArtistHandler(c, db.NewTrackRepo(s.db), s.streamTokens)
+	})
+	r.GET("/albums/:id", func(c *gin.Context) {
+		handlers.GetAlbumHandler(c, db.NewAlbumRepo(s.db), s.streamTokens)
+	})
+	r.GET("/artists/:id/albums", func(c *gin.Context) {
+		handlers.GetArtistAlbumsHandler(c, db.NewAlbumRepo(s.db), db.NewArtistRepo(s.db))
+	})
+	r.GET("/genres", func(c *gin.Context) {
+		handlers.ListGenresHandler(c, db.NewTagRepo(s.db))
+	})
+	r.GET("/tracks/:id/tags", func(c *gin.Context) {
+		handlers.GetTrackTagsHandler(c, db.NewTrackRepo(s.db), db.NewTagRepo(s.db))
+	})
+	r.PUT("/tracks/:id/tags", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+		handlers.SetTrackTagsHandler(c, db.NewTrackRepo(s.db), db.NewTagRepo(s.db), db.NewUserRepo(s.db))
+	})
+	r.PUT("/artists/:id/tags", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+		handlers.SetArtistTagsHandler(c, db.NewArtistRepo(s.db), db.NewTagRepo(s.db), db.NewUserRepo(s.db))
+	})
+	r.PATCH("/artists/:id", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+		handlers.UpdateArtistHandler(c, db.NewArtistRepo(s.db), db.NewUserRepo(s.db))
+	})
+	r.DELETE("/artists/:id", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+		handlers.DeleteArtistHandler(c, db.NewArtistRepo(s.db), db.NewUserRepo(s.db))
+	})
+	r.PATCH("/tracks/:id", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+		handlers.UpdateTrackHandler(c, db.NewTrackRepo(s.db), db.NewArtistRepo(s.db), db.NewUserRepo(s.db), db.NewUsageRepo(s.db), s.ingest)
+	})
+	r.DELETE("/tracks/:id", s.jwtService.JWTAuthMiddleware(), func(c *gin.Context) {
+		handlers.DeleteTrackHandler(c, db.NewTrackRepo(s.db), db.NewUserRepo(s.db))
+	})
+	r.GET("/tracks/:id/stream", s.streamTokens.StreamTokenMiddleware(), func(c *gin.Context) {
+		handlers.StreamTrackHandler(c, db.NewTrackRepo(s.db), db.NewTrackFileRepo(s.db), db.NewUserRepo(s.db))
+	})
+	r.HEAD("/tracks/:id/stream", s.streamTokens.StreamTokenMiddleware(), func(c *gin.Context) {
+		handlers.StreamTrackHandler(c, db.NewTrackRepo(s.db), db.NewTrackFileRepo(s.db), db.NewUserRepo(s.db))
+	})
+	r.GET("/tracks/:id/download", func(c *gin.Context) {
+		handlers.DownloadTrackHandler(c, db.NewTrackRepo(s.db))
+	})
+	r.GET("/tracks/:id/hls/*path", s.streamTokens.StreamTokenMiddleware(), func(c *gin.Context) {
+		handlers.HLSHandler(c, db.NewTrackFileRepo(s.db))
 	})
 
 	return r
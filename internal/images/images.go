@@ -0,0 +1,151 @@
+// Package images validates uploaded pictures (cover art, artist photos,
+// playlist covers) and renders them as squares at the standard sizes, in pure
+// Go, for the file store.
+package images
+
+import (
+	"auxstream/internal/db"
+	"auxstream/internal/storage"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strconv"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// Sizes are the edges, in pixels, of the squares every picture is rendered at.
+var Sizes = []int{64, 300, 640}
+
+// MaxBytes bounds an uploaded picture.
+const MaxBytes = 10 << 20 // 10 MiB
+
+// maxPixels bounds the decoded size of a picture, so a small file cannot
+// claim a huge canvas and exhaust memory when decoded.
+const maxPixels = 50_000_000
+
+// ErrUnsupported is returned for data that is not a JPEG, PNG or WebP image.
+var ErrUnsupported = errors.New("unsupported image format (use jpeg, png or webp)")
+
+// Format sniffs data's format from its leading bytes: "jpeg", "png" or
+// "webp".
+func Format(data []byte) (string, bool) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return "jpeg", true
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png", true
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "webp", true
+	}
+	return "", false
+}
+
+// Decode validates data as a JPEG, PNG or WebP image and decodes it. Data in
+// none of those formats yields ErrUnsupported; a damaged or oversized image,
+// an error saying so.
+func Decode(data []byte) (image.Image, error) {
+	format, ok := Format(data)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	decodeConfig, decode := jpeg.DecodeConfig, jpeg.Decode
+	switch format {
+	case "png":
+		decodeConfig, decode = png.DecodeConfig, png.Decode
+	case "webp":
+		decodeConfig, decode = webp.DecodeConfig, webp.Decode
+	}
+	cfg, err := decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s image: %w", format, err)
+	}
+	if cfg.Width < 1 || cfg.Height < 1 || cfg.Width*cfg.Height > maxPixels {
+		return nil, fmt.Errorf("image of %dx%d pixels is too large", cfg.Width, cfg.Height)
+	}
+	img, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s image: %w", format, err)
+	}
+	return img, nil
+}
+
+// Rendition is a picture rendered at one of Sizes.
+type Rendition struct {
+	Size int
+	Ext  string // "jpg", or "png" for pictures with transparency
+	Data []byte
+}
+
+// Render crops img to its central square and scales that to each of Sizes.
+// Opaque pictures are encoded as JPEG and the rest as PNG, keeping their
+// transparency.
+func Render(img image.Image) ([]Rendition, error) {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2))
+	opaque := isOpaque(img)
+
+	out := make([]Rendition, 0, len(Sizes))
+	for _, size := range Sizes {
+		dst := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Src, nil)
+
+		var buf bytes.Buffer
+		r := Rendition{Size: size, Ext: "png"}
+		var err error
+		if opaque {
+			r.Ext = "jpg"
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, dst)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("encode %dpx image: %w", size, err)
+		}
+		r.Data = buf.Bytes()
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// isOpaque reports whether img has no transparent pixels, as far as its type
+// lets that be told cheaply.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return true
+}
+
+// Store validates data (see Decode) and saves it at the standard sizes (see
+// Save).
+func Store(store storage.FileSystem, data []byte) (db.Images, error) {
+	img, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return Save(store, img)
+}
+
+// Save renders img (see Render) and saves each rendition to store, returning
+// them as Images.
+func Save(store storage.FileSystem, img image.Image) (db.Images, error) {
+	renditions, err := Render(img)
+	if err != nil {
+		return nil, err
+	}
+	stored := make(db.Images, len(renditions))
+	for _, r := range renditions {
+		name, err := store.Save(r.Data, r.Ext)
+		if err != nil {
+			return nil, fmt.Errorf("store %dpx image: %w", r.Size, err)
+		}
+		stored[strconv.Itoa(r.Size)] = name
+	}
+	return stored, nil
+}
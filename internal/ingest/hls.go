@@ -0,0 +1,130 @@
+package ingest
+
+import (
+	"auxstream/internal/audio"
+	"auxstream/internal/db"
+	"auxstream/internal/storage"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// hlsSegmentSeconds is the nominal length of each HLS segment. Six seconds is
+// Apple's recommendation: short enough for quick starts and seeks, long enough
+// to keep per-segment request overhead down.
+const hlsSegmentSeconds = 6
+
+// ErrUnsupportedFormat reports audio that cannot be packaged as HLS packed
+// audio as is (it would first need transcoding).
+var ErrUnsupportedFormat = errors.New("format cannot be packaged for hls")
+
+// PackageHLS splits src into roughly hlsSegmentSeconds-long packed-audio
+// segments on frame boundaries, stores each segment and then the media playlist
+// through store, and returns the TrackFile rows describing them (playlist
+// last). Segment URIs in the playlist are relative, "<rendition>/<seq>.<ext>",
+// so the playlist resolves against whatever route serves it. On failure the
+// rows of the blobs stored so far are returned with the error. They are not
+// removed here: the store names blobs by their content, so other tracks may
+// share them, and the caller schedules them for removal instead.
+func PackageHLS(store storage.FileSystem, src io.ReaderAt, size int64, rendition, format string) (files []db.TrackFile, err error) {
+	if format != "mp3" {
+		return nil, ErrUnsupportedFormat
+	}
+
+	var (
+		buf          []byte
+		segSamples   int
+		totalSamples int64
+		sampleRate   int
+	)
+
+	flush := func() error {
+		if len(buf) == 0 {
+			return nil
+		}
+		// 90 kHz MPEG-2 clock, as the HLS timestamp tag requires.
+		startSamples := totalSamples - int64(segSamples)
+		pts := uint64(startSamples) * 90000 / uint64(sampleRate)
+		data := append(audio.HLSTimestampTag(pts), buf...)
+
+		name, err := store.Save(data, format)
+		if err != nil {
+			return fmt.Errorf("store segment %d: %w", len(files), err)
+		}
+		files = append(files, db.TrackFile{
+			Kind:       db.TrackFileHLSSegment,
+			Sequence:   len(files),
+			DurationMs: segSamples * 1000 / sampleRate,
+			File:       name,
+			Size:       int64(len(data)),
+		})
+		buf, segSamples = buf[:0], 0
+		return nil
+	}
+
+	err = audio.WalkMP3Frames(src, size, func(frame audio.MP3Frame) error {
+		if sampleRate == 0 {
+			sampleRate = frame.SampleRate
+		}
+		start := len(buf)
+		buf = append(buf, make([]byte, frame.Length)...)
+		if _, err := src.ReadAt(buf[start:], frame.Offset); err != nil {
+			return err
+		}
+		segSamples += frame.Samples
+		totalSamples += int64(frame.Samples)
+		if segSamples >= hlsSegmentSeconds*sampleRate {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return files, err
+	}
+
+	playlist := mediaPlaylist(rendition, format, files)
+	name, err := store.Save(playlist, "m3u8")
+	if err != nil {
+		return files, fmt.Errorf("store playlist: %w", err)
+	}
+
+	var totalMs int
+	for _, f := range files {
+		totalMs += f.DurationMs
+	}
+	files = append(files, db.TrackFile{
+		Kind:       db.TrackFileHLSPlaylist,
+		DurationMs: totalMs,
+		File:       name,
+		Size:       int64(len(playlist)),
+	})
+	return files, nil
+}
+
+// mediaPlaylist renders a VOD media playlist over segments.
+func mediaPlaylist(rendition, ext string, segments []db.TrackFile) []byte {
+	target := 0
+	for _, s := range segments {
+		if d := int(math.Ceil(float64(s.DurationMs) / 1000)); d > target {
+			target = d
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", target)
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	for _, s := range segments {
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n", float64(s.DurationMs)/1000)
+		fmt.Fprintf(&b, "%s/%d.%s\n", rendition, s.Sequence, ext)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return []byte(b.String())
+}
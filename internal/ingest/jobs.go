@@ -0,0 +1,441 @@
+package ingest
+
+import (
+	"auxstream/internal/audio"
+	"auxstream/internal/db"
+	"auxstream/internal/logger"
+	"auxstream/internal/metrics"
+	"auxstream/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxJobAttempts is how many times a job is tried before it is failed.
+	maxJobAttempts = 3
+	// jobRetryDelay is how long a failed attempt backs off, per attempt made.
+	jobRetryDelay = 30 * time.Second
+	// staleJobAfter is how long a job may stay claimed before its worker is
+	// presumed dead and the job is claimed again.
+	staleJobAfter = 30 * time.Minute
+)
+
+// UploadQueue runs bulk uploads as jobs: the upload request stages the files
+// and records a job, and workers later validate and store each file and create
+// its track, recording per-file outcomes on the job. Staged files must be
+// reachable by both the API and the workers (see storage.NewStager).
+type UploadQueue struct {
+	jobs     db.UploadJobRepo
+	stager   storage.Stager
+	tracks   db.TrackRepo
+	artists  db.ArtistRepo
+	albums   db.AlbumRepo
+	removals db.BlobRemovalRepo
+	ingest   *Service // nil skips HLS packaging of created tracks
+}
+
+// NewUploadQueue returns a queue over jobs. The API needs only jobs and
+// stager; workers need the rest too. Blobs stored for tracks that could not be
+// created are scheduled for removal through removals.
+func NewUploadQueue(jobs db.UploadJobRepo, stager storage.Stager, tracks db.TrackRepo, artists db.ArtistRepo, albums db.AlbumRepo, removals db.BlobRemovalRepo, ingest *Service) *UploadQueue {
+	return &UploadQueue{jobs: jobs, stager: stager, tracks: tracks, artists: artists, albums: albums, removals: removals, ingest: ingest}
+}
+
+// Stage stores the size bytes of r for file until a worker processes it,
+// marking the file queued.
+func (q *UploadQueue) Stage(ctx context.Context, file *db.UploadJobFile, r io.Reader, size int64) error {
+	if file.ID == uuid.Nil {
+		file.ID = uuid.New()
+	}
+	state, err := q.stager.Begin(ctx, file.ID.String(), size)
+	if err != nil {
+		return err
+	}
+	state, n, err := q.stager.Append(ctx, file.ID.String(), state, 0, r)
+	if err == nil && n != size {
+		err = fmt.Errorf("staged %d bytes, expected %d", n, size)
+	}
+	if err != nil {
+		_ = q.stager.Discard(ctx, file.ID.String(), state)
+		return err
+	}
+	file.Stage = state
+	file.Size = size
+	file.Status = db.UploadFileQueued
+	return nil
+}
+
+// Enqueue records job, whose queued files have been staged, for the workers.
+// The staged files are discarded if it cannot be recorded.
+func (q *UploadQueue) Enqueue(ctx context.Context, job *db.UploadJob) error {
+	if err := q.jobs.CreateJob(ctx, job); err != nil {
+		q.discard(ctx, job, db.UploadFileQueued)
+		return err
+	}
+	return nil
+}
+
+// Run processes jobs on workers goroutines until ctx is cancelled, polling
+// for new jobs every poll when the queue is empty. A job already claimed is
+// seen through before Run returns.
+func (q *UploadQueue) Run(ctx context.Context, workers int, poll time.Duration) {
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				found, err := q.ProcessNext(context.WithoutCancel(ctx))
+				if err != nil {
+					logger.Error("upload job claim failed", zap.Error(err))
+				}
+				if found && err == nil {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(poll):
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// ProcessNext claims and processes the next ready job, reporting whether there
+// was one. A job whose processing fails is retried after a back-off, and
+// failed once maxJobAttempts have been made; the error returned is only for
+// failing to reach the queue.
+func (q *UploadQueue) ProcessNext(ctx context.Context) (bool, error) {
+	job, err := q.jobs.ClaimJob(ctx, staleJobAfter)
+	if err != nil || job == nil {
+		return false, err
+	}
+
+	start := time.Now()
+	err = q.Process(ctx, job)
+	now := time.Now()
+	switch {
+	case err == nil:
+		job.Status = db.UploadJobDone
+		job.Error = ""
+		job.FinishedAt = &now
+	case job.Attempts >= maxJobAttempts:
+		job.Status = db.UploadJobFailed
+		job.Error = err.Error()
+		job.FinishedAt = &now
+		q.failRemaining(ctx, job, err)
+	default:
+		job.Status = db.UploadJobQueued
+		job.Error = err.Error()
+		job.AvailableAt = now.Add(time.Duration(job.Attempts) * jobRetryDelay)
+	}
+	if err != nil {
+		logger.Error("upload job failed",
+			zap.String("job_id", job.ID.String()),
+			zap.Int("attempt", job.Attempts),
+			zap.Error(err),
+		)
+	} else {
+		logger.Info("upload job done",
+			zap.String("job_id", job.ID.String()),
+			zap.Int("files", len(job.Files)),
+			zap.Duration("took", now.Sub(start)),
+		)
+	}
+	return true, q.jobs.UpdateJob(ctx, job)
+}
+
+// Process turns each queued file of job into a track. Files are checked one at
+// a time; those that are unsupported, damaged, or name no artist are rejected
+// with the reason. Audio a live track already holds is not stored again: the
+// file is saved as that track, and a repeat of a file earlier in the job is
+// rejected. The rest are stored concurrently and their tracks created
+// together, charged to the uploader against the quota recorded with the job. A
+// job with an album adds every track to it, numbered by the files' tags or else
+// by their position in the upload, and gives the album the first embedded
+// artwork as its cover if it has none. Artists credited with "feat." in a
+// file's tags are credited on its track, created if absent. Files the quota has
+// no room for are rejected, since retrying would not help. Outcomes are saved
+// per file as they are decided, so an error (from the store or the database)
+// leaves only the undecided files queued for the next attempt; the blobs it
+// stored are discarded, and a retry storing them again keeps them.
+func (q *UploadQueue) Process(ctx context.Context, job *db.UploadJob) error {
+	type accepted struct {
+		file     *db.UploadJobFile
+		src      storage.File
+		ext      string
+		checksum string
+		meta     *audio.Metadata
+		artist   uuid.UUID
+		credits  []db.TrackArtist
+	}
+	var toSave []accepted
+	defer func() {
+		for _, a := range toSave {
+			_ = a.src.Close()
+		}
+	}()
+
+	resolveArtist := q.taggedArtistResolver(ctx)
+	positions := make(map[string]int) // of the files accepted, by checksum
+
+	for i := range job.Files {
+		file := &job.Files[i]
+		if file.Status != db.UploadFileQueued {
+			continue
+		}
+		src, err := q.stager.Open(ctx, file.ID.String(), file.Stage)
+		if err != nil {
+			return fmt.Errorf("open staged file %d: %w", file.Position, err)
+		}
+
+		var (
+			reason   string
+			checksum string
+			meta     *audio.Metadata
+			artist   uuid.UUID
+			credits  []db.TrackArtist
+		)
+		ext, ok := SniffFormat(src)
+		if !ok {
+			reason = "unsupported audio format"
+		} else if err := audio.Validate(src, file.Size, ext); err != nil {
+			reason = err.Error()
+		} else if checksum, err = storage.ChecksumReader(io.NewSectionReader(src, 0, file.Size)); err != nil {
+			_ = src.Close()
+			return fmt.Errorf("read staged file %d: %w", file.Position, err)
+		} else if existing, err := q.tracks.GetTrackByChecksum(ctx, checksum); err == nil {
+			_ = src.Close()
+			if err := q.settle(ctx, file, db.UploadFileSaved, "", &existing.ID); err != nil {
+				return err
+			}
+			continue
+		} else if pos, ok := positions[checksum]; ok {
+			reason = fmt.Sprintf("same audio as file %d", pos)
+		} else {
+			meta = ReadMetadata(src, file.Size, ext)
+			main := job.ArtistID
+			if main == nil {
+				if artist, err = resolveArtist(meta.Artist); err != nil {
+					reason = err.Error()
+				}
+				main = &artist
+			}
+			if reason == "" {
+				if credits, err = featuredCredits(meta.Featured, *main, resolveArtist); err != nil {
+					reason = err.Error()
+				}
+			}
+		}
+		if reason != "" {
+			_ = src.Close()
+			if err := q.settle(ctx, file, db.UploadFileRejected, reason, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		positions[checksum] = file.Position
+		toSave = append(toSave, accepted{file: file, src: src, ext: ext, checksum: checksum, meta: meta, artist: artist, credits: credits})
+	}
+	if len(toSave) == 0 {
+		return nil
+	}
+
+	inputs := make([]db.BulkTrackInput, len(toSave))
+	errs := make([]error, len(toSave))
+	var wg sync.WaitGroup
+	for i, a := range toSave {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name, err := storage.Store.SaveStream(io.NewSectionReader(a.src, 0, a.file.Size), a.file.Size, a.ext)
+			if err != nil {
+				errs[i] = fmt.Errorf("store file %d: %w", a.file.Position, err)
+				return
+			}
+			artwork := StoreArtwork(a.meta)
+			inputs[i] = db.BulkTrackInput{
+				ID:           uuid.New(),
+				Title:        firstNonEmpty(a.file.Title, a.meta.Title, FileStem(a.file.Filename)),
+				File:         name,
+				Duration:     DurationSeconds(a.meta),
+				Thumbnail:    artwork.Largest(),
+				Images:       artwork,
+				Downloadable: job.Downloadable,
+				ArtistID:     a.artist,
+				Checksum:     a.checksum,
+				Size:         a.file.Size,
+				Credits:      a.credits,
+			}
+			if job.UserID != nil {
+				inputs[i].UploaderID = *job.UserID
+				inputs[i].Quota = job.Quota
+			}
+			if job.AlbumID != nil {
+				inputs[i].AlbumID = *job.AlbumID
+				inputs[i].DiscNumber = max(a.meta.DiscNumber, 1)
+				inputs[i].TrackNumber = a.meta.TrackNumber
+				if inputs[i].TrackNumber == 0 {
+					inputs[i].TrackNumber = a.file.Position + 1
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		q.discardBlobs(ctx, inputs)
+		return err
+	}
+
+	var batchArtist uuid.UUID
+	if job.ArtistID != nil {
+		batchArtist = *job.ArtistID
+	}
+	if _, err := q.tracks.BulkCreateTracks(ctx, inputs, batchArtist); err != nil {
+		q.discardBlobs(ctx, inputs)
+		var quotaErr *db.QuotaError
+		if !errors.As(err, &quotaErr) {
+			return fmt.Errorf("create tracks: %w", err)
+		}
+		for _, a := range toSave {
+			if err := q.settle(ctx, a.file, db.UploadFileRejected, quotaErr.Error(), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if job.AlbumID != nil {
+		q.fillAlbumCover(ctx, *job.AlbumID, inputs)
+	}
+
+	for i, a := range toSave {
+		metrics.RecordTrackUpload()
+		if q.ingest != nil {
+			q.ingest.Submit(inputs[i].ID, inputs[i].File)
+		}
+		if err := q.settle(ctx, a.file, db.UploadFileSaved, "", &inputs[i].ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillAlbumCover gives the album the artwork of the first of inputs that has
+// any, unless it has a cover already. Failing to is only logged: the tracks
+// are in.
+func (q *UploadQueue) fillAlbumCover(ctx context.Context, albumID uuid.UUID, inputs []db.BulkTrackInput) {
+	for _, in := range inputs {
+		if len(in.Images) == 0 {
+			continue
+		}
+		if err := q.albums.FillAlbumImages(ctx, albumID, in.Images); err != nil {
+			logger.Error("fill album cover failed", zap.String("album_id", albumID.String()), zap.Error(err))
+		}
+		return
+	}
+}
+
+// settle records a file's outcome and then discards its staged bytes, which
+// are no longer needed either way. A file whose outcome could not be recorded
+// keeps them for the next attempt.
+func (q *UploadQueue) settle(ctx context.Context, file *db.UploadJobFile, status, reason string, trackID *uuid.UUID) error {
+	stage := file.Stage
+	file.Status = status
+	file.Error = reason
+	file.TrackID = trackID
+	file.Stage = ""
+	if err := q.jobs.UpdateJobFile(ctx, file); err != nil {
+		return fmt.Errorf("record file %d: %w", file.Position, err)
+	}
+	if err := q.stager.Discard(ctx, file.ID.String(), stage); err != nil {
+		logger.Error("discard staged upload failed", zap.String("file_id", file.ID.String()), zap.Error(err))
+	}
+	return nil
+}
+
+// failRemaining fails every file of job still queued, with cause.
+func (q *UploadQueue) failRemaining(ctx context.Context, job *db.UploadJob, cause error) {
+	for i := range job.Files {
+		if job.Files[i].Status == db.UploadFileQueued {
+			_ = q.settle(ctx, &job.Files[i], db.UploadFileFailed, cause.Error(), nil)
+		}
+	}
+}
+
+// discardBlobs schedules the blobs stored for inputs, whose tracks were not
+// created, for removal. Failing to is only logged; scrub still finds them as
+// orphans.
+func (q *UploadQueue) discardBlobs(ctx context.Context, inputs []db.BulkTrackInput) {
+	var names []string
+	for _, in := range inputs {
+		if in.File != "" {
+			names = append(names, in.File)
+		}
+		for _, name := range in.Images {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	if err := q.removals.ScheduleBlobRemovals(ctx, names); err != nil {
+		logger.Error("schedule blob removals failed", zap.Strings("files", names), zap.Error(err))
+	}
+}
+
+// discard removes the staged bytes of every file of job in status.
+func (q *UploadQueue) discard(ctx context.Context, job *db.UploadJob, status string) {
+	for _, f := range job.Files {
+		if f.Status == status {
+			_ = q.stager.Discard(ctx, f.ID.String(), f.Stage)
+		}
+	}
+}
+
+// featuredCredits credits the featured artists named, resolved to ids by
+// resolveArtist, on a track whose main artist is main.
+func featuredCredits(names []string, main uuid.UUID, resolveArtist func(name string) (uuid.UUID, error)) ([]db.TrackArtist, error) {
+	var credits []db.TrackArtist
+	for _, name := range names {
+		id, err := resolveArtist(name)
+		if err != nil {
+			return nil, err
+		}
+		if id == main || slices.ContainsFunc(credits, func(c db.TrackArtist) bool { return c.ArtistID == id }) {
+			continue
+		}
+		credits = append(credits, db.TrackArtist{ArtistID: id, Role: db.ArtistRoleFeatured, Position: len(credits)})
+	}
+	return credits, nil
+}
+
+// taggedArtistResolver returns a function mapping the artist name read from a
+// file's tags to an artist id, creating artists as needed and remembering each
+// name it has resolved.
+func (q *UploadQueue) taggedArtistResolver(ctx context.Context) func(name string) (uuid.UUID, error) {
+	resolved := make(map[string]uuid.UUID)
+	return func(name string) (uuid.UUID, error) {
+		if name == "" {
+			return uuid.Nil, errors.New("no artist_id given and the file carries no artist tag")
+		}
+		if id, ok := resolved[name]; ok {
+			return id, nil
+		}
+		artist, err := q.artists.CreateArtist(ctx, name)
+		if err != nil {
+			logger.Error("bulk create artist failed", zap.String("artist", name), zap.Error(err))
+			return uuid.Nil, errors.New("failed to resolve artist")
+		}
+		resolved[name] = artist.ID
+		return artist.ID, nil
+	}
+}
@@ -0,0 +1,168 @@
+package ingest
+
+import (
+	"auxstream/internal/db"
+	"auxstream/internal/logger"
+	"auxstream/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Service runs the post-upload processing of a track: packaging its audio for
+// HLS delivery and transcoding the standard renditions. Uploads hand tracks
+// over through Submit and return without waiting; at most a few tracks are
+// processed at once, bounding the extra memory, CPU and store traffic a burst
+// of uploads can cause.
+type Service struct {
+	trackFiles db.TrackFileRepo
+	removals   db.BlobRemovalRepo
+	transcoder *Transcoder // nil disables transcoding
+	slots      chan struct{}
+	running    sync.WaitGroup // tracks submitted and not yet processed
+}
+
+// NewService returns a Service processing up to concurrency tracks at a time
+// (at least one). A nil transcoder skips the transcoding stage, leaving only
+// the original upload streamable. Blobs stored for output that could not be
+// recorded are scheduled for removal through removals.
+func NewService(trackFiles db.TrackFileRepo, removals db.BlobRemovalRepo, transcoder *Transcoder, concurrency int) *Service {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Service{
+		trackFiles: trackFiles,
+		removals:   removals,
+		transcoder: transcoder,
+		slots:      make(chan struct{}, concurrency),
+	}
+}
+
+// Submit processes a freshly stored track in the background. Failures are
+// logged rather than returned: the track is already playable from its original
+// upload, just without the derived renditions.
+func (s *Service) Submit(trackID uuid.UUID, file string) {
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		s.slots <- struct{}{}
+		defer func() { <-s.slots }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		if err := s.Process(ctx, trackID, file); err != nil {
+			logger.Error("track ingest failed",
+				zap.String("track_id", trackID.String()),
+				zap.Error(err),
+			)
+		}
+	}()
+}
+
+// Wait blocks until every track submitted so far has been processed, so that a
+// process shutting down does not cut their processing short.
+func (s *Service) Wait() {
+	s.running.Wait()
+}
+
+// Process packages the original upload of one track as HLS and transcodes its
+// renditions, replacing any earlier output. A failing step does not stop the
+// others; their errors are joined.
+func (s *Service) Process(ctx context.Context, trackID uuid.UUID, file string) error {
+	src, err := storage.Store.Read(file)
+	if err != nil {
+		return fmt.Errorf("read upload: %w", err)
+	}
+	defer src.Close()
+
+	var errs []error
+	format := strings.TrimPrefix(path.Ext(file), ".")
+	if err := s.packageHLS(ctx, trackID, src, format); err != nil {
+		errs = append(errs, fmt.Errorf("package hls: %w", err))
+	}
+
+	if s.transcoder != nil {
+		for _, r := range Renditions {
+			if err := s.transcode(ctx, trackID, src.Name(), r); err != nil {
+				errs = append(errs, fmt.Errorf("transcode %s: %w", r.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// packageHLS packages src as the original HLS rendition. Formats HLS cannot
+// carry as is are skipped, not failed.
+func (s *Service) packageHLS(ctx context.Context, trackID uuid.UUID, src storage.File, format string) error {
+	files, err := PackageHLS(storage.Store, src, src.Size(), db.RenditionOriginal, format)
+	if errors.Is(err, ErrUnsupportedFormat) {
+		logger.Info("skipping hls packaging",
+			zap.String("track_id", trackID.String()),
+			zap.String("format", format),
+		)
+		return nil
+	}
+	if err != nil {
+		s.discard(ctx, files)
+		return err
+	}
+	if err := s.record(ctx, trackID, db.RenditionOriginal, files); err != nil {
+		return err
+	}
+
+	logger.Info("packaged track for hls",
+		zap.String("track_id", trackID.String()),
+		zap.Int("segments", len(files)-1),
+	)
+	return nil
+}
+
+// transcode encodes the local file input as rendition r.
+func (s *Service) transcode(ctx context.Context, trackID uuid.UUID, input string, r Rendition) error {
+	data, err := s.transcoder.Transcode(ctx, input, r.BitrateKbps)
+	if err != nil {
+		return err
+	}
+	name, err := storage.Store.Save(data, TranscodeExt)
+	if err != nil {
+		return fmt.Errorf("store rendition: %w", err)
+	}
+	return s.record(ctx, trackID, r.Name, []db.TrackFile{{
+		Kind: db.TrackFileAudio,
+		File: name,
+		Size: int64(len(data)),
+	}})
+}
+
+// record stores the rows for a freshly written rendition, discarding its blobs
+// if they cannot be recorded.
+func (s *Service) record(ctx context.Context, trackID uuid.UUID, rendition string, files []db.TrackFile) error {
+	if err := s.trackFiles.ReplaceRendition(ctx, trackID, rendition, files); err != nil {
+		s.discard(ctx, files)
+		return fmt.Errorf("record %s files: %w", rendition, err)
+	}
+	return nil
+}
+
+// discard schedules the blobs of files, which were stored but not recorded,
+// for removal. They are not removed at once since other tracks may share them.
+// Failing to is only logged; scrub still finds them as orphans.
+func (s *Service) discard(ctx context.Context, files []db.TrackFile) {
+	if len(files) == 0 {
+		return
+	}
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.File
+	}
+	if err := s.removals.ScheduleBlobRemovals(ctx, names); err != nil {
+		logger.Error("schedule blob removals failed", zap.Strings("files", names), zap.Error(err))
+	}
+}
@@ -0,0 +1,70 @@
+package ingest
+
+import (
+	"auxstream/internal/db"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// TranscodeExt is the container transcoded renditions are stored in: AAC in
+// MP4 with the index up front, so players can seek over ranged requests.
+const TranscodeExt = "m4a"
+
+// Rendition is one standard encoding produced from every upload.
+type Rendition struct {
+	Name        string // one of the db.Rendition* qualities
+	BitrateKbps int
+}
+
+// Renditions lists the encodings the transcoding stage produces, lowest first.
+var Renditions = []Rendition{
+	{Name: db.RenditionLow, BitrateKbps: 64},
+	{Name: db.RenditionMedium, BitrateKbps: 128},
+	{Name: db.RenditionHigh, BitrateKbps: 256},
+}
+
+// Transcoder encodes audio by running a locally installed ffmpeg.
+type Transcoder struct {
+	binary string
+}
+
+// NewTranscoder resolves binary (a name on PATH or a path) up front, so a
+// missing encoder is reported at startup rather than on every upload.
+func NewTranscoder(binary string) (*Transcoder, error) {
+	resolved, err := exec.LookPath(binary)
+	if err != nil {
+		return nil, err
+	}
+	return &Transcoder{binary: resolved}, nil
+}
+
+// Transcode encodes the audio file at input as AAC at the given bitrate and
+// returns the TranscodeExt-encoded bytes. Metadata, cover art and any video
+// stream are dropped: renditions carry sound only.
+func (t *Transcoder) Transcode(ctx context.Context, input string, bitrateKbps int) ([]byte, error) {
+	out, err := os.CreateTemp("", "auxstream-*."+TranscodeExt)
+	if err != nil {
+		return nil, err
+	}
+	_ = out.Close()
+	defer os.Remove(out.Name())
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.binary,
+		"-nostdin", "-hide_banner", "-loglevel", "error", "-y",
+		"-i", input,
+		"-vn", "-map_metadata", "-1",
+		"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", bitrateKbps),
+		"-movflags", "+faststart",
+		out.Name(),
+	)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return os.ReadFile(out.Name())
+}
@@ -0,0 +1,91 @@
+package ingest
+
+import (
+	"auxstream/internal/audio"
+	"auxstream/internal/db"
+	"auxstream/internal/images"
+	"auxstream/internal/storage"
+	"io"
+	"log"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// DetectFormat reports the audio format of a payload from its leading magic
+// bytes, returning the canonical file extension and whether it is a supported
+// type. This is a content check, so renamed/non-audio payloads are rejected.
+func DetectFormat(head []byte) (ext string, ok bool) {
+	if len(head) >= 3 && head[0] == 'I' && head[1] == 'D' && head[2] == '3' {
+		return "mp3", true
+	}
+	if len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0 {
+		return "mp3", true
+	}
+	if len(head) >= 4 && string(head[0:4]) == "fLaC" {
+		return "flac", true
+	}
+	if len(head) >= 4 && string(head[0:4]) == "OggS" {
+		return "ogg", true
+	}
+	if len(head) >= 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "WAVE" {
+		return "wav", true
+	}
+	if len(head) >= 8 && string(head[4:8]) == "ftyp" {
+		return "m4a", true
+	}
+	return "", false
+}
+
+// SniffFormat detects the audio format of an upload from its leading bytes.
+func SniffFormat(r io.ReaderAt) (string, bool) {
+	head := make([]byte, 512)
+	n, _ := r.ReadAt(head, 0)
+	return DetectFormat(head[:n])
+}
+
+// ReadMetadata reads the tags of an upload. Files whose tags cannot be parsed
+// still upload; they just contribute no metadata.
+func ReadMetadata(r io.ReaderAt, size int64, ext string) *audio.Metadata {
+	meta, err := audio.ReadMetadata(r, size, ext)
+	if err != nil {
+		log.Printf("read %s metadata: %v", ext, err)
+		return &audio.Metadata{}
+	}
+	return meta
+}
+
+// StoreArtwork renders embedded cover art at the standard sizes and saves it
+// to the file store (see images.Store), returning nil when there is none or it
+// is not a usable JPEG, PNG or WebP image.
+func StoreArtwork(meta *audio.Metadata) db.Images {
+	if len(meta.Artwork) == 0 {
+		return nil
+	}
+	stored, err := images.Store(storage.Store, meta.Artwork)
+	if err != nil {
+		log.Printf("store artwork error: %v", err)
+		return nil
+	}
+	return stored
+}
+
+// DurationSeconds rounds a measured duration to whole seconds.
+func DurationSeconds(meta *audio.Metadata) int {
+	return int(math.Round(meta.Duration.Seconds()))
+}
+
+// FileStem returns an uploaded filename without its directory or extension.
+func FileStem(name string) string {
+	name = filepath.Base(name)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
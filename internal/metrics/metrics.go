@@ -133,6 +133,29 @@ var (
 		},
 	)
 
+	StorageReplicationsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auxstream_storage_replications_total",
+			Help: "Total number of blob copies to secondary file stores",
+		},
+		[]string{"status"},
+	)
+
+	StorageReplicationLag = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "auxstream_storage_replication_lag_seconds",
+			Help:    "Time from a blob being saved to the primary file store until its copies finish",
+			Buckets: []float64{.1, .5, 1, 5, 10, 30, 60, 300, 900},
+		},
+	)
+
+	StorageReplicationQueue = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "auxstream_storage_replication_queue",
+			Help: "Number of blobs waiting to be copied to secondary file stores",
+		},
+	)
+
 	AuthAttemptsTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "auxstream_auth_attempts_total",
@@ -215,6 +238,21 @@ func RecordTrackUpload() {
 	TracksUploaded.Inc()
 }
 
+// RecordStorageReplication records one blob's copies to the secondary stores
+// finishing, lag seconds after it was saved.
+func RecordStorageReplication(success bool, lag float64) {
+	status := "failed"
+	if success {
+		status = "success"
+	}
+	StorageReplicationsTotal.WithLabelValues(status).Inc()
+	StorageReplicationLag.Observe(lag)
+}
+
+func SetStorageReplicationQueue(n int) {
+	StorageReplicationQueue.Set(float64(n))
+}
+
 func RecordAuthAttempt(method, status string) {
 	AuthAttemptsTotal.WithLabelValues(method, status).Inc()
 }
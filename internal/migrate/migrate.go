@@ -0,0 +1,314 @@
+// Package migrate copies the blobs the database refers to from one file store
+// to another and repoints the rows at the copies, so the backend can be
+// switched.
+package migrate
+
+import (
+	"auxstream/internal/db"
+	"auxstream/internal/storage"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Blob actions.
+const (
+	ActionCopy   = "copy"   // copied to the destination
+	ActionReuse  = "reuse"  // the destination already held the content under another name
+	ActionSkip   = "skip"   // already migrated: the rows name a blob the destination holds
+	ActionFailed = "failed" // left where it was; see Error
+)
+
+// Options tunes a migration.
+type Options struct {
+	Concurrency int  // blobs copied at once; at least 1
+	BatchSize   int  // rows repointed per transaction; at least 1
+	DryRun      bool // work out what would be done without copying or rewriting anything
+	CopyOnly    bool // copy and verify, but leave the rows naming the source
+}
+
+// Blob is what was done with one source blob.
+type Blob struct {
+	File       string `json:"file"`            // identifier in the source
+	Moved      string `json:"moved,omitempty"` // identifier in the destination
+	References int    `json:"references"`      // rows naming the blob
+	Action     string `json:"action"`          // one of the Action* values
+	Error      string `json:"error,omitempty"`
+}
+
+// Report is the machine-readable outcome of a migration.
+type Report struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	DryRun     bool      `json:"dry_run"`
+	References int       `json:"references"` // rows naming a stored blob
+	Blobs      int       `json:"blobs"`      // distinct blobs among them
+	Copied     int       `json:"copied"`
+	Reused     int       `json:"reused"`
+	Skipped    int       `json:"skipped"`
+	Rewritten  int       `json:"rewritten"` // rows repointed (or, in a dry or copy-only run, to repoint)
+	Failures   []Blob    `json:"failures"`
+}
+
+// Healthy reports whether every blob was migrated.
+func (r *Report) Healthy() bool {
+	return len(r.Failures) == 0
+}
+
+// Run copies every blob refs names from src to dst, verifies each copy's size
+// and SHA-256 by reading it back, and repoints the rows at the copies in
+// batches of opts.BatchSize. Each distinct blob is copied once however many
+// rows share it. External thumbnails are left alone.
+//
+// A migration can be interrupted and run again: rows already repointed name a
+// blob dst holds and are skipped, and content dst already holds (found by
+// listing it, when it is a storage.Lister) is verified rather than copied.
+// Uploads stored in src while a migration runs are picked up by the next run.
+//
+// A repointed row names its blob as dst does, which only a server reading
+// from dst (directly, or as a secondary of a ReplicatedStore, for
+// content-addressed names) can resolve. With opts.CopyOnly the rows are left
+// alone, so a later run can repoint them once the server reads from dst.
+func Run(ctx context.Context, refs db.BlobRefRepo, src, dst storage.FileSystem, opts Options) (*Report, error) {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	report := &Report{StartedAt: time.Now(), DryRun: opts.DryRun, Failures: []Blob{}}
+
+	// Group references by blob so shared blobs are copied once.
+	byFile := make(map[string][]db.BlobRef)
+	var order []string
+	err := refs.EachBlobRef(ctx, func(ref db.BlobRef) error {
+		if !ref.Stored() {
+			return nil
+		}
+		report.References++
+		if _, seen := byFile[ref.File]; !seen {
+			order = append(order, ref.File)
+		}
+		byFile[ref.File] = append(byFile[ref.File], ref)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list references: %w", err)
+	}
+	report.Blobs = len(order)
+
+	held, err := index(ctx, dst)
+	if err != nil {
+		return nil, fmt.Errorf("list destination: %w", err)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		jobs    = make(chan string)
+		results = make(chan Blob)
+	)
+	for range opts.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				results <- migrate(src, dst, held, file, byFile[file], opts.DryRun)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, file := range order {
+			select {
+			case jobs <- file:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var (
+		pending  []db.BlobMove
+		flushErr error
+	)
+	flush := func() {
+		if len(pending) == 0 || flushErr != nil {
+			return
+		}
+		if !opts.DryRun && !opts.CopyOnly {
+			if err := refs.SetBlobFiles(ctx, pending); err != nil {
+				flushErr = fmt.Errorf("repoint references: %w", err)
+				cancel()
+				return
+			}
+		}
+		report.Rewritten += len(pending)
+		pending = pending[:0]
+	}
+	for blob := range results {
+		switch blob.Action {
+		case ActionCopy:
+			report.Copied++
+		case ActionReuse:
+			report.Reused++
+		case ActionSkip:
+			report.Skipped++
+		case ActionFailed:
+			report.Failures = append(report.Failures, blob)
+			continue
+		}
+		for _, ref := range byFile[blob.File] {
+			if ref.File != blob.Moved {
+				pending = append(pending, db.BlobMove{Ref: ref, File: blob.Moved})
+			}
+		}
+		if len(pending) >= opts.BatchSize {
+			flush()
+		}
+	}
+	flush()
+	if flushErr != nil {
+		return nil, flushErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// held is what the destination already stores: its blob names, and for each
+// content-addressed one, the name its checksum is stored under.
+type held struct {
+	names map[string]bool
+	sums  map[string]string
+}
+
+// index lists dst, when it can be listed.
+func index(ctx context.Context, dst storage.FileSystem) (held, error) {
+	h := held{names: map[string]bool{}, sums: map[string]string{}}
+	lister, ok := dst.(storage.Lister)
+	if !ok {
+		return h, nil
+	}
+	err := lister.List(ctx, func(blob storage.BlobInfo) error {
+		h.names[blob.Name] = true
+		if key := storage.BlobKey(blob.Name); storage.IsChecksum(key) {
+			h.sums[key] = blob.Name
+		}
+		return nil
+	})
+	return h, err
+}
+
+// migrate moves one blob, named file in src and by refs, into dst.
+func migrate(src, dst storage.FileSystem, held held, file string, refs []db.BlobRef, dryRun bool) Blob {
+	blob := Blob{File: file, References: len(refs)}
+	fail := func(err error) Blob {
+		blob.Action, blob.Moved, blob.Error = ActionFailed, "", err.Error()
+		return blob
+	}
+
+	var want string
+	var wantSize int64
+	for _, ref := range refs {
+		if ref.Checksum != "" {
+			want = ref.Checksum
+		}
+		if ref.Size > 0 {
+			wantSize = ref.Size
+		}
+	}
+	if key := storage.BlobKey(file); want == "" && storage.IsChecksum(key) {
+		want = key
+	}
+
+	if held.names[file] {
+		blob.Action, blob.Moved = ActionSkip, file
+		return blob
+	}
+	if name, ok := held.sums[want]; ok && want != "" {
+		blob.Action, blob.Moved = ActionReuse, name
+		if dryRun {
+			return blob
+		}
+		if err := verify(dst, name, want, wantSize); err != nil {
+			return fail(err)
+		}
+		return blob
+	}
+	if dryRun {
+		blob.Action = ActionCopy
+		return blob
+	}
+
+	in, err := src.Read(file)
+	if err != nil {
+		// Without a listing of dst, a row repointed by an earlier run is only
+		// recognised by finding its blob there.
+		if check, dstErr := dst.Read(file); dstErr == nil {
+			_ = check.Close()
+			blob.Action, blob.Moved = ActionSkip, file
+			return blob
+		}
+		return fail(fmt.Errorf("read source: %w", err))
+	}
+	defer in.Close()
+
+	ext := strings.TrimPrefix(path.Ext(file), ".")
+	if ext == "" {
+		ext = "bin" // e.g. Cloudinary public IDs, which carry none
+	}
+	h := sha256.New()
+	moved, err := dst.SaveStream(io.TeeReader(in, h), in.Size(), ext)
+	if err != nil {
+		return fail(fmt.Errorf("write destination: %w", err))
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if want != "" && sum != want {
+		// The source is damaged; leave its rows on it for scrub to report
+		// rather than spread the damage.
+		_ = dst.Remove(moved)
+		return fail(fmt.Errorf("source sha256 %s, expected %s", sum, want))
+	}
+	if err := verify(dst, moved, sum, in.Size()); err != nil {
+		return fail(err)
+	}
+	blob.Action, blob.Moved = ActionCopy, moved
+	return blob
+}
+
+// verify reads name back from store and checks its size (when known) and
+// SHA-256.
+func verify(store storage.FileSystem, name, sum string, size int64) error {
+	file, err := store.Read(name)
+	if err != nil {
+		return fmt.Errorf("read copy: %w", err)
+	}
+	defer file.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, file)
+	if err != nil {
+		return fmt.Errorf("read copy: %w", err)
+	}
+	if size > 0 && n != size {
+		return fmt.Errorf("copy holds %d bytes, expected %d", n, size)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return fmt.Errorf("copy sha256 %s, expected %s", got, sum)
+	}
+	return nil
+}
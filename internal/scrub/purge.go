@@ -0,0 +1,82 @@
+package scrub
+
+import (
+	"auxstream/internal/db"
+	"auxstream/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// Removal outcomes.
+const (
+	RemovalRemoved = "removed" // the blob was removed, or was already gone
+	RemovalKept    = "kept"    // a live row names the blob again, so it stays
+	RemovalFailed  = "failed"  // the store refused; the removal is retried next purge
+)
+
+// Removal is one scheduled blob removal a purge handled.
+type Removal struct {
+	File   string `json:"file"`
+	Action string `json:"action"`          // one of the Removal* outcomes
+	Error  string `json:"error,omitempty"` // why RemovalFailed
+}
+
+// PurgeReport is the machine-readable outcome of a purge.
+type PurgeReport struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Removals   []Removal `json:"removals"`
+}
+
+// Failed reports how many removals failed.
+func (r *PurgeReport) Failed() int {
+	n := 0
+	for _, removal := range r.Removals {
+		if removal.Action == RemovalFailed {
+			n++
+		}
+	}
+	return n
+}
+
+// Purge removes from store the blobs whose scheduled removal is due by now,
+// as recorded when tracks are deleted or their audio replaced. A blob a live
+// row names again is kept, and a removal cancelled meanwhile, by an upload of
+// the same content, is skipped. Each blob is checked and removed holding its
+// removal locked, so such an upload waits for the removal to finish. Either
+// way the schedule is dropped, except when the store fails to remove the
+// blob, which leaves it for the next purge.
+func Purge(ctx context.Context, removals db.BlobRemovalRepo, store storage.FileSystem, now time.Time) (*PurgeReport, error) {
+	report := &PurgeReport{StartedAt: time.Now(), Removals: []Removal{}}
+	err := removals.EachDueBlobRemoval(ctx, now, func(due db.BlobRemoval) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		removal := Removal{File: due.File, Action: RemovalRemoved}
+		err := removals.HoldBlobRemoval(ctx, due.ID, func(scheduled, inUse bool) bool {
+			switch {
+			case !scheduled || inUse:
+				removal.Action = RemovalKept
+			default:
+				if err := store.Remove(due.File); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					removal.Action, removal.Error = RemovalFailed, err.Error()
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("check %s: %w", due.File, err)
+		}
+		report.Removals = append(report.Removals, removal)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("purge: %w", err)
+	}
+	report.FinishedAt = time.Now()
+	return report, nil
+}
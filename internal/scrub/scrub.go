@@ -0,0 +1,252 @@
+// Package scrub checks that the blobs the database refers to are present and
+// intact in the file store, finds blobs nothing refers to, and purges blobs
+// whose scheduled removal is due.
+package scrub
+
+import (
+	"auxstream/internal/db"
+	"auxstream/internal/storage"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Blob statuses reported for a reference.
+const (
+	StatusMissing          = "missing"           // the store has no such blob
+	StatusUnreadable       = "unreadable"        // the blob exists but could not be read
+	StatusSizeMismatch     = "size_mismatch"     // the blob's length differs from the recorded size
+	StatusChecksumMismatch = "checksum_mismatch" // the blob's SHA-256 differs from the expected one
+)
+
+// Orphan actions.
+const (
+	OrphanReport     = "report"     // list orphans only
+	OrphanDelete     = "delete"     // remove orphans from the store
+	OrphanQuarantine = "quarantine" // copy orphans to the quarantine store, then remove them
+)
+
+// Options tunes a scrub.
+type Options struct {
+	Orphans     string             // one of the Orphan* actions; empty means OrphanReport
+	Quarantine  storage.FileSystem // where OrphanQuarantine copies orphans to
+	Concurrency int                // blobs verified at once; at least 1
+	// MinOrphanAge spares unreferenced blobs younger than this, since an upload
+	// in progress stores its blob before writing the row that names it.
+	MinOrphanAge time.Duration
+}
+
+// Problem is a reference whose blob failed verification.
+type Problem struct {
+	db.BlobRef
+	Status string `json:"status"` // one of the Status* values
+	Detail string `json:"detail,omitempty"`
+}
+
+// Orphan is a stored blob no row refers to.
+type Orphan struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Action string `json:"action"`          // what was done: one of the Orphan* actions
+	Moved  string `json:"moved,omitempty"` // identifier in the quarantine store
+	Error  string `json:"error,omitempty"` // why the action failed
+}
+
+// Report is the machine-readable outcome of a scrub.
+type Report struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	References int       `json:"references"` // rows checked
+	Blobs      int       `json:"blobs"`      // distinct blobs verified
+	Problems   []Problem `json:"problems"`
+	// OrphanScan is "done", or "unsupported" when the store cannot list its
+	// blobs.
+	OrphanScan string   `json:"orphan_scan"`
+	Orphans    []Orphan `json:"orphans"`
+}
+
+// Healthy reports whether every reference verified and no orphan was found.
+func (r *Report) Healthy() bool {
+	return len(r.Problems) == 0 && len(r.Orphans) == 0
+}
+
+// Run verifies every blob refs names against store and, when store is a
+// storage.Lister, handles the blobs no reference names as opts.Orphans says.
+// Each distinct blob is read once however many rows share it; its size and
+// SHA-256 are checked against what the rows record, or against the checksum
+// its content-addressed name carries.
+func Run(ctx context.Context, refs db.BlobRefRepo, store storage.FileSystem, opts Options) (*Report, error) {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.Orphans == "" {
+		opts.Orphans = OrphanReport
+	}
+	if opts.Orphans == OrphanQuarantine && opts.Quarantine == nil {
+		return nil, fmt.Errorf("quarantining orphans needs a quarantine store")
+	}
+
+	report := &Report{StartedAt: time.Now(), Problems: []Problem{}, Orphans: []Orphan{}}
+
+	// Group references by blob so shared blobs are read once.
+	byFile := make(map[string][]db.BlobRef)
+	var order []string
+	referenced := make(map[string]bool)
+	err := refs.EachBlobRef(ctx, func(ref db.BlobRef) error {
+		report.References++
+		referenced[storage.BlobKey(ref.File)] = true
+		if !ref.Stored() {
+			return nil
+		}
+		if _, seen := byFile[ref.File]; !seen {
+			order = append(order, ref.File)
+		}
+		byFile[ref.File] = append(byFile[ref.File], ref)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list references: %w", err)
+	}
+	report.Blobs = len(order)
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		jobs = make(chan string)
+	)
+	for range opts.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				problems := verify(store, byFile[file])
+				mu.Lock()
+				report.Problems = append(report.Problems, problems...)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, file := range order {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- file
+	}
+	close(jobs)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	lister, ok := store.(storage.Lister)
+	if !ok {
+		report.OrphanScan = "unsupported"
+		report.FinishedAt = time.Now()
+		return report, nil
+	}
+	err = lister.List(ctx, func(blob storage.BlobInfo) error {
+		if referenced[storage.BlobKey(blob.Name)] || time.Since(blob.Modified) < opts.MinOrphanAge {
+			return nil
+		}
+		report.Orphans = append(report.Orphans, handleOrphan(store, opts, blob))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list blobs: %w", err)
+	}
+	report.OrphanScan = "done"
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// verify reads one blob and checks it against every reference to it.
+func verify(store storage.FileSystem, refs []db.BlobRef) []Problem {
+	fail := func(status, detail string) []Problem {
+		problems := make([]Problem, len(refs))
+		for i, ref := range refs {
+			problems[i] = Problem{BlobRef: ref, Status: status, Detail: detail}
+		}
+		return problems
+	}
+
+	file, err := store.Read(refs[0].File)
+	if err != nil {
+		// Purge removes the blobs of deleted rows once their grace period is
+		// over, so only live rows miss theirs.
+		refs = slices.DeleteFunc(slices.Clone(refs), func(ref db.BlobRef) bool { return ref.Deleted })
+		return fail(StatusMissing, err.Error())
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return fail(StatusUnreadable, err.Error())
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	var problems []Problem
+	for _, ref := range refs {
+		want := ref.Checksum
+		if key := storage.BlobKey(ref.File); want == "" && storage.IsChecksum(key) {
+			want = key
+		}
+		switch {
+		case ref.Size > 0 && ref.Size != size:
+			problems = append(problems, Problem{BlobRef: ref, Status: StatusSizeMismatch,
+				Detail: fmt.Sprintf("stored %d bytes, expected %d", size, ref.Size)})
+		case want != "" && want != sum:
+			problems = append(problems, Problem{BlobRef: ref, Status: StatusChecksumMismatch,
+				Detail: fmt.Sprintf("stored sha256 %s, expected %s", sum, want)})
+		}
+	}
+	return problems
+}
+
+// handleOrphan applies opts.Orphans to one unreferenced blob.
+func handleOrphan(store storage.FileSystem, opts Options, blob storage.BlobInfo) Orphan {
+	orphan := Orphan{Name: blob.Name, Size: blob.Size, Action: OrphanReport}
+	switch opts.Orphans {
+	case OrphanDelete:
+		if err := store.Remove(blob.Name); err != nil {
+			orphan.Error = err.Error()
+			return orphan
+		}
+		orphan.Action = OrphanDelete
+	case OrphanQuarantine:
+		moved, err := quarantine(store, opts.Quarantine, blob.Name)
+		if err != nil {
+			orphan.Error = err.Error()
+			return orphan
+		}
+		orphan.Action, orphan.Moved = OrphanQuarantine, moved
+	}
+	return orphan
+}
+
+// quarantine copies a blob into dst and removes it from src, returning its
+// identifier in dst. The blob is streamed across, since orphans may be whole
+// audio masters.
+func quarantine(src, dst storage.FileSystem, name string) (string, error) {
+	file, err := src.Read(name)
+	if err != nil {
+		return "", err
+	}
+	ext := strings.TrimPrefix(path.Ext(name), ".")
+	if ext == "" {
+		ext = "bin" // e.g. Cloudinary public IDs, which carry none
+	}
+	moved, err := dst.SaveStream(file, file.Size(), ext)
+	_ = file.Close()
+	if err != nil {
+		return "", err
+	}
+	return moved, src.Remove(name)
+}
@@ -2,12 +2,14 @@ package search
 
 import (
 	"auxstream/internal/cache"
+	"auxstream/internal/db"
 	"auxstream/internal/external"
 	"auxstream/internal/logger"
 	"auxstream/internal/metrics"
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -27,6 +29,7 @@ type SearchRequest struct {
 	Query      string `json:"query"`
 	MaxResults int    `json:"max_results"`
 	Source     string `json:"source,omitempty"` // Optional: "local", "youtube", or empty for all
+	Genre      string `json:"genre,omitempty"`  // Optional: only results of this genre
 }
 
 // SearchResponse represents the search results with metadata
@@ -35,10 +38,25 @@ type SearchResponse struct {
 	Results    []external.SearchResult `json:"results"`
 	TotalCount int                     `json:"total_count"`
 	Source     string                  `json:"source"`
+	Genre      string                  `json:"genre,omitempty"`
+	Facets     SearchFacets            `json:"facets"`
 	CachedAt   *time.Time              `json:"cached_at,omitempty"` // set only when served from cache; nil on a fresh search
 	SearchedAt time.Time               `json:"searched_at"`
 }
 
+// SearchFacets break a response's results down by genre, most common first,
+// so a client can offer to narrow the search to one.
+type SearchFacets struct {
+	Genres []FacetCount `json:"genres"`
+}
+
+// FacetCount is how many results have one value of a facet.
+type FacetCount struct {
+	Value string `json:"value"` // the slug, to pass back as the filter
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
 // NewService wires the aggregator and cache together. Pass a nil cache to
 // disable caching entirely; the service then queries sources on every call.
 func NewService(aggregator *external.Aggregator, cache cache.Cache) *Service {
@@ -54,7 +72,8 @@ func NewService(aggregator *external.Aggregator, cache cache.Cache) *Service {
 // Search returns results for req, serving from cache on a hit and otherwise
 // querying the aggregator and caching the response. An empty req.Source fans
 // out to all sources; a cache miss is not an error. The returned response has
-// CachedAt set only when it came from cache.
+// CachedAt set only when it came from cache. A req.Genre keeps only results
+// of that genre.
 func (s *Service) Search(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
 	startTime := time.Now()
 	normalizedQuery := normalizeQuery(req.Query)
@@ -75,11 +94,17 @@ func (s *Service) Search(ctx context.Context, req SearchRequest) (*SearchRespons
 		source = "all"
 	}
 
+	genre := db.TagSlug(req.Genre)
 	cacheKey := s.generateCacheKey(normalizedQuery, req.Source, req.MaxResults)
+	if genre != "" {
+		cacheKey += ":genre:" + genre
+	}
 
 	if s.cache != nil {
 		cachedResp, err := s.getFromCache(cacheKey)
 		if err == nil && cachedResp != nil {
+			// Cached stream URLs may have expired or belong to another listener.
+			s.aggregator.SignLocalResults(ctx, cachedResp.Results)
 			metrics.RecordCacheHit("search")
 			logger.Debug("Search cache hit",
 				zap.String("query", normalizedQuery),
@@ -95,9 +120,9 @@ func (s *Service) Search(ctx context.Context, req SearchRequest) (*SearchRespons
 	var err error
 
 	if req.Source != "" {
-		results, err = s.aggregator.SearchBySource(ctx, normalizedQuery, req.Source, req.MaxResults)
+		results, err = s.aggregator.SearchBySource(ctx, normalizedQuery, req.Source, genre, req.MaxResults)
 	} else {
-		results, err = s.aggregator.Search(ctx, normalizedQuery, req.MaxResults)
+		results, err = s.aggregator.Search(ctx, normalizedQuery, genre, req.MaxResults)
 	}
 
 	if err != nil {
@@ -115,6 +140,8 @@ func (s *Service) Search(ctx context.Context, req SearchRequest) (*SearchRespons
 		Results:    results,
 		TotalCount: len(results),
 		Source:     req.Source,
+		Genre:      genre,
+		Facets:     SearchFacets{Genres: genreFacets(results)},
 		SearchedAt: time.Now(),
 	}
 
@@ -139,6 +166,29 @@ func (s *Service) Search(ctx context.Context, req SearchRequest) (*SearchRespons
 	return response, nil
 }
 
+// genreFacets counts the results of each genre, matching genres however they
+// are spelled (see db.TagSlug) and naming each by its first spelling.
+func genreFacets(results []external.SearchResult) []FacetCount {
+	facets := []FacetCount{}
+	index := make(map[string]int)
+	for _, result := range results {
+		for _, name := range result.Genres {
+			slug := db.TagSlug(name)
+			if slug == "" {
+				continue
+			}
+			if i, ok := index[slug]; ok {
+				facets[i].Count++
+				continue
+			}
+			index[slug] = len(facets)
+			facets = append(facets, FacetCount{Value: slug, Name: name, Count: 1})
+		}
+	}
+	sort.SliceStable(facets, func(i, j int) bool { return facets[i].Count > facets[j].Count })
+	return facets
+}
+
 // getFromCache returns the cached response, stamping CachedAt so callers can
 // distinguish a cache hit from a fresh search. A miss surfaces as an error.
 func (s *Service) getFromCache(cacheKey string) (*SearchResponse, error) {
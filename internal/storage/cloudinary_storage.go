@@ -14,14 +14,14 @@ import (
 
 	"github.com/cloudinary/cloudinary-go/v2"
 	"github.com/cloudinary/cloudinary-go/v2/api"
-	_ "github.com/cloudinary/cloudinary-go/v2/api/admin"
+	"github.com/cloudinary/cloudinary-go/v2/api/admin"
 	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
 )
 
 // CloudinaryStore is a FileSystem backed by Cloudinary. Assets are uploaded as the
 // "video" resource type (Cloudinary's category for audio too). Save returns the
-// asset's secure HTTPS URL as the identifier, which Read fetches over HTTP. Remove,
-// however, expects the Cloudinary public ID rather than that URL.
+// asset's secure HTTPS URL as the identifier, which Read fetches over HTTP. Remove
+// takes either that URL or the Cloudinary public ID.
 type CloudinaryStore struct {
 	cloudinaryInstance *cloudinary.Cloudinary
 	mu                 sync.Mutex
@@ -53,31 +53,45 @@ func (cld *CloudinaryStore) Save(raw []byte, ext string) (filename string, err e
 	if len(raw) < 1 {
 		return "", errors.New("empty file")
 	}
+	return cld.upload(Checksum(raw), bytes.NewReader(raw))
+}
 
-	filename = genFileName(ext)
-
-	freader := bytes.NewReader(raw)
+// SaveStream spools r to a temporary file to learn its content name, then
+// uploads the file, which the SDK sends in chunks once it is large.
+func (cld *CloudinaryStore) SaveStream(r io.Reader, size int64, ext string) (filename string, err error) {
+	tmp, sum, err := spool(r, size, "")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	return cld.upload(sum, tmp)
+}
 
+// upload stores file (an io.Reader or *os.File) under publicID and returns the
+// asset's secure URL. Cloudinary public IDs carry no extension; it derives
+// that from the asset.
+func (cld *CloudinaryStore) upload(publicID string, file any) (string, error) {
 	uploadRes, err := cld.cloudinaryInstance.Upload.Upload(
 		context.Background(),
-		freader,
+		file,
 		uploader.UploadParams{
-			// Cloudinary public IDs carry no extension; it derives that from the asset.
-			PublicID:       strings.TrimSuffix(filename, "."+ext),
+			PublicID:       publicID,
 			ResourceType:   "video",
 			UniqueFilename: api.Bool(false),
-			Overwrite:      api.Bool(true),
+			// The public ID is the content hash, so an existing asset under it
+			// already holds these bytes; keep it rather than re-ingesting.
+			Overwrite: api.Bool(false),
 		},
 	)
 	if err != nil {
 		return "", fmt.Errorf("failed to upload file, %v", err)
 	}
 
-	filename = uploadRes.SecureURL
 	cld.mu.Lock()
 	cld.uploads++
 	cld.mu.Unlock()
-	return filename, nil
+	return uploadRes.SecureURL, nil
 }
 
 func (cld *CloudinaryStore) Read(locationURL string) (File, error) {
@@ -87,16 +101,26 @@ func (cld *CloudinaryStore) Read(locationURL string) (File, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch asset: HTTP %d", resp.StatusCode)
+	}
+
 	// Stream the fetched asset into a local temp file and return that as the File;
-	// the caller owns closing (and cleaning up) it.
-	file, err := NewFile(os.TempDir() + genFileName("tmp"))
+	// closing it removes the temp file.
+	file, err := NewTempFile()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temp file: %w", err)
 	}
 
 	if _, err = io.Copy(file, resp.Body); err != nil {
+		_ = file.Close()
 		return nil, fmt.Errorf("failed to write asset to file: %w", err)
 	}
+	// Rewind so the caller reads from the start rather than the end of the copy.
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to rewind asset file: %w", err)
+	}
 
 	cld.mu.Lock()
 	cld.downloads++
@@ -109,26 +133,112 @@ func (cld *CloudinaryStore) BulkSave(buf chan<- FileMeta, listOfFileMeta []FileM
 	var wg sync.WaitGroup
 	for _, fd := range listOfFileMeta {
 		wg.Add(1)
-		go func(raw []byte, title, ext string) {
+		go func(raw []byte, title, ext string, index int) {
 			defer wg.Done()
 			fileUrl, err := cld.Save(raw, ext)
 			if err != nil {
 				log.Printf("bulk save error: %s", err.Error())
-				buf <- FileMeta{AudioTitle: title}
+				buf <- FileMeta{AudioTitle: title, Index: index}
 				return
 			}
-			buf <- FileMeta{Name: fileUrl, Content: raw, AudioTitle: title, Ext: ext}
-		}(fd.Content, fd.AudioTitle, fd.Ext)
+			buf <- FileMeta{Name: fileUrl, Content: raw, AudioTitle: title, Ext: ext, Index: index}
+		}(fd.Content, fd.AudioTitle, fd.Ext, fd.Index)
 	}
 	wg.Wait()
 	close(buf)
 }
 
 func (cld *CloudinaryStore) Remove(locationUrl string) error {
-	_, err := cld.cloudinaryInstance.Upload.Destroy(context.Background(), uploader.DestroyParams{PublicID: locationUrl})
+	publicID := locationUrl
+	if strings.HasPrefix(locationUrl, "http://") || strings.HasPrefix(locationUrl, "https://") {
+		publicID = BlobKey(locationUrl) // content-addressed public IDs sit in no folder
+	}
+	_, err := cld.cloudinaryInstance.Upload.Destroy(context.Background(), uploader.DestroyParams{PublicID: publicID})
 	if err != nil {
 		log.Printf("remove resource error: %s", err.Error())
 		return err
 	}
 	return nil
 }
+
+// Stat asks for the asset's headers with a HEAD request to its URL.
+func (cld *CloudinaryStore) Stat(locationURL string) (BlobInfo, error) {
+	resp, err := http.Head(locationURL) //nolint:gosec // URL comes from Cloudinary upload result
+	if err != nil {
+		return BlobInfo{}, fmt.Errorf("failed to stat asset: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return BlobInfo{}, fmt.Errorf("%s: %w", locationURL, os.ErrNotExist)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return BlobInfo{}, fmt.Errorf("failed to stat asset: HTTP %d", resp.StatusCode)
+	}
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return BlobInfo{Name: locationURL, Size: resp.ContentLength, Modified: modified}, nil
+}
+
+func (cld *CloudinaryStore) Exists(locationURL string) (bool, error) {
+	return statExists(cld.Stat(locationURL))
+}
+
+// OpenRange fetches the range from the asset's URL with an HTTP Range request.
+func (cld *CloudinaryStore) OpenRange(locationURL string, off, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	req, err := http.NewRequest(http.MethodGet, locationURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch asset: %w", err)
+	}
+	switch {
+	case length > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+length-1))
+	case off > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch asset: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		return limitRange(resp.Body, length), nil
+	case resp.StatusCode == http.StatusOK:
+		// The whole asset came back, the range having been ignored.
+		if _, err := io.CopyN(io.Discard, resp.Body, off); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to fetch asset: %w", err)
+		}
+		return limitRange(resp.Body, length), nil
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", locationURL, os.ErrNotExist)
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("failed to fetch asset: HTTP %d", resp.StatusCode)
+}
+
+// List pages through the account's uploaded "video" assets (the type Save
+// uses), naming each by its public ID, which is what Remove expects.
+func (cld *CloudinaryStore) List(ctx context.Context, fn func(BlobInfo) error) error {
+	params := admin.AssetsParams{AssetType: "video", DeliveryType: "upload", MaxResults: 500}
+	for {
+		res, err := cld.cloudinaryInstance.Admin.Assets(ctx, params)
+		if err != nil {
+			return err
+		}
+		if res.Error.Message != "" {
+			return errors.New(res.Error.Message)
+		}
+		for _, asset := range res.Assets {
+			if err := fn(BlobInfo{Name: asset.PublicID, Size: int64(asset.Bytes), Modified: asset.CreatedAt}); err != nil {
+				return err
+			}
+		}
+		if res.NextCursor == "" {
+			return nil
+		}
+		params.NextCursor = res.NextCursor
+	}
+}
@@ -0,0 +1,716 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	s3API "github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Blobs in an EncryptedStore are sealed in chunks of chunkSize bytes, each with
+// its own tagSize-byte GCM tag, so any range can be read by fetching and
+// opening only the chunks it spans.
+const (
+	chunkSize = 64 << 10
+	tagSize   = 16
+)
+
+// keySuffix names a blob's key file: the blob's own name plus the suffix.
+const keySuffix = ".key"
+
+// keyCacheSize bounds the data keys an EncryptedStore keeps unwrapped in
+// memory, so serving ranges of a hot blob does not fetch its key file each
+// time.
+const keyCacheSize = 4096
+
+// Keyring holds the master keys that wrap blob data keys, by ID. The active
+// key wraps new data keys; the others only unwrap, until a rotation (see
+// EncryptedStore.RotateKeys) has moved every key file off them.
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+// ParseKeyring reads master keys written as comma-separated id:key entries,
+// each key 32 base64-encoded bytes (e.g. from openssl rand -base64 32). The
+// first entry is the active key.
+func ParseKeyring(spec string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string][]byte)}
+	for i, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		// Errors name keys by ID or position, never echoing key material.
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("encryption key #%d: want id:base64key", i+1)
+		}
+		if _, dup := k.keys[id]; dup {
+			return nil, fmt.Errorf("encryption key %q listed twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %q: want 32 base64-encoded bytes", id)
+		}
+		if k.active == "" {
+			k.active = id
+		}
+		k.keys[id] = key
+	}
+	if k.active == "" {
+		return nil, errors.New("no encryption keys given")
+	}
+	return k, nil
+}
+
+// Active returns the ID of the key new data keys are wrapped with.
+func (k *Keyring) Active() string {
+	return k.active
+}
+
+// sealable is implemented by the stores an EncryptedStore can sit on: they
+// write bytes under a name of the caller's choosing, which the sealed copy of
+// a blob must keep so it is still found by its checksum.
+type sealable interface {
+	FileSystem
+	Lister
+	namer
+	// put writes everything read from r under name, replacing whatever was
+	// there.
+	put(name string, r io.Reader) error
+	// create writes data under name unless something already is, reporting
+	// whether it did.
+	create(name string, data []byte) (bool, error)
+}
+
+// EncryptedStore is a FileSystem that seals every blob with AES-256-GCM before
+// it reaches the store beneath, so blobs are encrypted on disk and in the
+// bucket. Each blob has its own random data key, kept beside it in a key file
+// wrapped by the active master key of a Keyring. Blobs keep the
+// content-addressed names the store beneath would give them, so identifiers,
+// replication and maintenance work as they do unencrypted; note that those
+// names are checksums of the plaintext.
+//
+// Blobs are sealed in chunks (see chunkSize), so ranged reads fetch and
+// decrypt only the chunks they span. Each chunk's nonce is its index and its
+// additional data the blob's size, so chunks cannot be reordered, dropped or
+// moved between blobs unnoticed. Content is spooled to a temporary file while
+// its checksum is taken, as the stores beneath do.
+type EncryptedStore struct {
+	inner sealable
+	keys  *Keyring
+
+	cache  map[string]dataKey // unwrapped data keys, by blob name
+	reads  int
+	writes int
+	mu     sync.Mutex
+}
+
+// dataKey is a blob's unwrapped data key and the size of its plaintext.
+type dataKey struct {
+	key  []byte
+	size int64
+}
+
+// keyFile is the JSON stored beside each blob under its name plus keySuffix.
+type keyFile struct {
+	Version int    `json:"v"`
+	KeyID   string `json:"key_id"`  // master key the data key is wrapped with
+	Wrapped []byte `json:"wrapped"` // GCM nonce followed by the sealed data key
+	Size    int64  `json:"size"`    // plaintext size of the blob
+}
+
+// NewEncryptedStore encrypts blobs saved to inner with data keys wrapped by
+// keys. inner must be a LocalStore or an S3Store.
+func NewEncryptedStore(inner FileSystem, keys *Keyring) (*EncryptedStore, error) {
+	st, ok := inner.(sealable)
+	if !ok {
+		return nil, fmt.Errorf("encryption at rest needs a local or s3 store, not %T", inner)
+	}
+	return &EncryptedStore{inner: st, keys: keys, cache: make(map[string]dataKey)}, nil
+}
+
+// EncryptedStores returns the EncryptedStores among store's backends: store
+// itself, or the primary and secondaries of a ReplicatedStore.
+func EncryptedStores(store FileSystem) []*EncryptedStore {
+	backends := []FileSystem{store}
+	if r, ok := store.(*ReplicatedStore); ok {
+		backends = append([]FileSystem{r.primary}, r.secondaries...)
+	}
+	var out []*EncryptedStore
+	for _, b := range backends {
+		if e, ok := b.(*EncryptedStore); ok {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func (e *EncryptedStore) blobName(sum, ext string) string {
+	return e.inner.blobName(sum, ext)
+}
+
+func (e *EncryptedStore) Reads() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.reads
+}
+
+func (e *EncryptedStore) Writes() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.writes
+}
+
+func (e *EncryptedStore) Save(raw []byte, ext string) (filename string, err error) {
+	if len(raw) < 1 {
+		return "", fmt.Errorf("empty file")
+	}
+	return e.seal(Checksum(raw), ext, bytes.NewReader(raw), int64(len(raw)))
+}
+
+func (e *EncryptedStore) SaveStream(r io.Reader, size int64, ext string) (filename string, err error) {
+	tmp, sum, err := spool(r, size, "")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	return e.seal(sum, ext, tmp, size)
+}
+
+// seal encrypts the size bytes of r, whose checksum is sum, into the store
+// beneath. Content saved before keeps its data key, so saving it again writes
+// the same ciphertext, and concurrent first saves agree on one key through
+// create. A cached key is only used while its key file is still there: once
+// the blob and its key have been removed, by a purge in another process say,
+// saving the content again draws and stores a new key.
+func (e *EncryptedStore) seal(sum, ext string, r io.Reader, size int64) (string, error) {
+	name := e.inner.blobName(sum, ext)
+	stored, err := e.inner.Exists(name + keySuffix)
+	if err != nil {
+		return "", err
+	}
+	if !stored {
+		e.forget(name)
+	}
+	dk, err := e.dataKey(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		dk, err = e.newDataKey(name, size)
+	}
+	if err != nil {
+		return "", err
+	}
+	aead, err := newGCM(dk.key)
+	if err != nil {
+		return "", err
+	}
+	sealer := &sealReader{
+		src:    r,
+		aead:   aead,
+		aad:    sizeAAD(size),
+		plain:  make([]byte, chunkSize),
+		sealed: make([]byte, 0, chunkSize+tagSize),
+	}
+	if err := e.inner.put(name, sealer); err != nil {
+		return "", err
+	}
+
+	e.mu.Lock()
+	e.writes++
+	e.mu.Unlock()
+	return name, nil
+}
+
+// newDataKey draws a data key for the blob name and stores it wrapped. If
+// another save stored one first, that one is returned instead.
+func (e *EncryptedStore) newDataKey(name string, size int64) (dataKey, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return dataKey{}, err
+	}
+	kf, err := e.wrap(name, key, size)
+	if err != nil {
+		return dataKey{}, err
+	}
+	raw, err := json.Marshal(kf)
+	if err != nil {
+		return dataKey{}, err
+	}
+	created, err := e.inner.create(name+keySuffix, raw)
+	if err != nil {
+		return dataKey{}, fmt.Errorf("store key for %s: %w", name, err)
+	}
+	if !created {
+		return e.dataKey(name)
+	}
+	dk := dataKey{key: key, size: size}
+	e.remember(name, dk)
+	return dk, nil
+}
+
+// dataKey returns the unwrapped data key of the blob name, from the cache if
+// it was used lately. A blob without a key file yields an error matching
+// os.ErrNotExist, unless its key is cached; seal makes sure it is not.
+func (e *EncryptedStore) dataKey(name string) (dataKey, error) {
+	e.mu.Lock()
+	dk, ok := e.cache[name]
+	e.mu.Unlock()
+	if ok {
+		return dk, nil
+	}
+
+	kf, err := e.readKeyFile(name)
+	if err != nil {
+		return dataKey{}, err
+	}
+	if dk, err = e.unwrap(name, kf); err != nil {
+		return dataKey{}, err
+	}
+	e.remember(name, dk)
+	return dk, nil
+}
+
+func (e *EncryptedStore) remember(name string, dk dataKey) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.cache) >= keyCacheSize {
+		clear(e.cache)
+	}
+	e.cache[name] = dk
+}
+
+func (e *EncryptedStore) forget(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.cache, name)
+}
+
+func (e *EncryptedStore) readKeyFile(name string) (keyFile, error) {
+	body, err := e.inner.OpenRange(name+keySuffix, 0, -1)
+	if err != nil {
+		return keyFile{}, err
+	}
+	defer body.Close()
+	var kf keyFile
+	if err := json.NewDecoder(io.LimitReader(body, 4<<10)).Decode(&kf); err != nil {
+		return keyFile{}, fmt.Errorf("read key for %s: %w", name, err)
+	}
+	return kf, nil
+}
+
+// wrap seals key under the active master key, bound to the blob name.
+func (e *EncryptedStore) wrap(name string, key []byte, size int64) (keyFile, error) {
+	aead, err := newGCM(e.keys.keys[e.keys.active])
+	if err != nil {
+		return keyFile{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return keyFile{}, err
+	}
+	return keyFile{
+		Version: 1,
+		KeyID:   e.keys.active,
+		Wrapped: aead.Seal(nonce, nonce, key, []byte(BlobKey(name))),
+		Size:    size,
+	}, nil
+}
+
+// unwrap recovers the data key of the blob name from its key file.
+func (e *EncryptedStore) unwrap(name string, kf keyFile) (dataKey, error) {
+	master, ok := e.keys.keys[kf.KeyID]
+	if !ok {
+		return dataKey{}, fmt.Errorf("key for %s is wrapped with unknown master key %q", name, kf.KeyID)
+	}
+	aead, err := newGCM(master)
+	if err != nil {
+		return dataKey{}, err
+	}
+	if len(kf.Wrapped) < aead.NonceSize() {
+		return dataKey{}, fmt.Errorf("key for %s is truncated", name)
+	}
+	nonce, sealed := kf.Wrapped[:aead.NonceSize()], kf.Wrapped[aead.NonceSize():]
+	key, err := aead.Open(nil, nonce, sealed, []byte(BlobKey(name)))
+	if err != nil {
+		return dataKey{}, fmt.Errorf("unwrap key for %s: %w", name, err)
+	}
+	return dataKey{key: key, size: kf.Size}, nil
+}
+
+// Read decrypts the whole blob into a temporary file, which closing removes.
+func (e *EncryptedStore) Read(fileName string) (File, error) {
+	body, err := e.OpenRange(fileName, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	file, err := NewTempFile()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(file, body); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.reads++
+	e.mu.Unlock()
+	return file, nil
+}
+
+func (e *EncryptedStore) BulkSave(buf chan<- FileMeta, listOfFileMeta []FileMeta) {
+	var wg sync.WaitGroup
+	for _, fd := range listOfFileMeta {
+		wg.Add(1)
+		go func(raw []byte, title, ext string, index int) {
+			defer wg.Done()
+			fileName, err := e.Save(raw, ext)
+			if err != nil {
+				log.Println(err)
+				buf <- FileMeta{AudioTitle: title, Index: index}
+				return
+			}
+			buf <- FileMeta{Name: fileName, Content: raw, AudioTitle: title, Ext: ext, Index: index}
+		}(fd.Content, fd.AudioTitle, fd.Ext, fd.Index)
+	}
+	wg.Wait()
+	close(buf)
+}
+
+// Remove deletes the blob and then its key file. A key file left behind by a
+// blob already gone is deleted too, and the blob's error matching
+// os.ErrNotExist returned.
+func (e *EncryptedStore) Remove(fileName string) error {
+	blobErr := e.inner.Remove(fileName)
+	if blobErr != nil && !errors.Is(blobErr, fs.ErrNotExist) {
+		return blobErr
+	}
+	e.forget(fileName)
+	if err := e.inner.Remove(fileName + keySuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return blobErr
+}
+
+// Stat reports the plaintext size, which follows from the sealed one. The
+// store's checksum, being of the ciphertext, is dropped.
+func (e *EncryptedStore) Stat(fileName string) (BlobInfo, error) {
+	info, err := e.inner.Stat(fileName)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	if info.Size, err = plainSize(info.Size); err != nil {
+		return BlobInfo{}, fmt.Errorf("%s: %w", fileName, err)
+	}
+	info.Checksum = ""
+	return info, nil
+}
+
+func (e *EncryptedStore) Exists(fileName string) (bool, error) {
+	return e.inner.Exists(fileName)
+}
+
+// OpenRange fetches the chunks the range spans and decrypts them as they are
+// read. A chunk that fails to authenticate fails the read.
+func (e *EncryptedStore) OpenRange(fileName string, off, length int64) (io.ReadCloser, error) {
+	dk, err := e.dataKey(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if off < 0 {
+		return nil, fmt.Errorf("negative offset %d", off)
+	}
+	left := dk.size - off
+	if length >= 0 && length < left {
+		left = length
+	}
+	if left <= 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	aead, err := newGCM(dk.key)
+	if err != nil {
+		return nil, err
+	}
+
+	first := off / chunkSize
+	last := (off + left - 1) / chunkSize
+	body, err := e.inner.OpenRange(fileName, first*(chunkSize+tagSize), sealedSize(min(dk.size, (last+1)*chunkSize))-first*(chunkSize+tagSize))
+	if err != nil {
+		return nil, err
+	}
+	return &openReader{
+		body:   body,
+		aead:   aead,
+		aad:    sizeAAD(dk.size),
+		size:   dk.size,
+		index:  first,
+		skip:   int(off - first*chunkSize),
+		left:   left,
+		sealed: make([]byte, chunkSize+tagSize),
+	}, nil
+}
+
+// List reports the blobs of the store beneath at their plaintext sizes. Key
+// files are not blobs and are skipped.
+func (e *EncryptedStore) List(ctx context.Context, fn func(BlobInfo) error) error {
+	return e.inner.List(ctx, func(info BlobInfo) error {
+		if strings.HasSuffix(info.Name, keySuffix) {
+			return nil
+		}
+		if size, err := plainSize(info.Size); err == nil {
+			info.Size = size
+		}
+		info.Checksum = ""
+		return fn(info)
+	})
+}
+
+// RotateKeys rewraps every data key not wrapped with the active master key,
+// calling fn with each blob, the key it was wrapped with and the active key it
+// is moved to. With dryRun set nothing is rewritten. Blob contents are
+// untouched, so once it completes the old master keys can be dropped from the
+// Keyring. It returns how many keys were (or would be) rewrapped and how many
+// were seen.
+func (e *EncryptedStore) RotateKeys(ctx context.Context, dryRun bool, fn func(name, from, to string)) (rotated, seen int, err error) {
+	err = e.inner.List(ctx, func(info BlobInfo) error {
+		name, ok := strings.CutSuffix(info.Name, keySuffix)
+		if !ok {
+			return nil
+		}
+		seen++
+		kf, err := e.readKeyFile(name)
+		if err != nil {
+			return err
+		}
+		if kf.KeyID == e.keys.active {
+			return nil
+		}
+		rotated++
+		if fn != nil {
+			fn(name, kf.KeyID, e.keys.active)
+		}
+		if dryRun {
+			return nil
+		}
+		dk, err := e.unwrap(name, kf)
+		if err != nil {
+			return err
+		}
+		if kf, err = e.wrap(name, dk.key, kf.Size); err != nil {
+			return err
+		}
+		raw, err := json.Marshal(kf)
+		if err != nil {
+			return err
+		}
+		return e.inner.put(info.Name, bytes.NewReader(raw))
+	})
+	return rotated, seen, err
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce is the nonce of chunk index; data keys are never shared between
+// different content, so a nonce never repeats under one key for two different
+// plaintexts.
+func chunkNonce(index int64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], uint64(index))
+	return nonce
+}
+
+// sizeAAD binds each chunk to the blob's plaintext size, so truncation is
+// detected.
+func sizeAAD(size int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(size))
+}
+
+// sealedSize is the stored size of size bytes of plaintext.
+func sealedSize(size int64) int64 {
+	chunks := (size + chunkSize - 1) / chunkSize
+	return size + chunks*tagSize
+}
+
+// plainSize reverses sealedSize.
+func plainSize(sealed int64) (int64, error) {
+	full, rem := sealed/(chunkSize+tagSize), sealed%(chunkSize+tagSize)
+	if rem == 0 {
+		return full * chunkSize, nil
+	}
+	if rem <= tagSize {
+		return 0, fmt.Errorf("sealed size %d is not a whole number of chunks", sealed)
+	}
+	return full*chunkSize + rem - tagSize, nil
+}
+
+// sealReader yields the chunked ciphertext of src.
+type sealReader struct {
+	src    io.Reader
+	aead   cipher.AEAD
+	aad    []byte
+	index  int64
+	plain  []byte
+	sealed []byte
+	out    []byte // sealed bytes not yet read
+	done   bool
+}
+
+func (s *sealReader) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(s.src, s.plain)
+		switch {
+		case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+			s.done = true
+		case err != nil:
+			return 0, err
+		}
+		if n == 0 {
+			return 0, io.EOF
+		}
+		s.out = s.aead.Seal(s.sealed[:0], chunkNonce(s.index), s.plain[:n], s.aad)
+		s.index++
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// openReader decrypts the chunks of a blob from body, which starts at chunk
+// index, yielding left bytes from skip bytes into it.
+type openReader struct {
+	body   io.ReadCloser
+	aead   cipher.AEAD
+	aad    []byte
+	size   int64
+	index  int64
+	skip   int
+	left   int64
+	sealed []byte
+	plain  []byte
+	out    []byte // plaintext not yet read
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	if o.left <= 0 {
+		return 0, io.EOF
+	}
+	if len(o.out) == 0 {
+		n := min(chunkSize, o.size-o.index*chunkSize) + tagSize
+		if _, err := io.ReadFull(o.body, o.sealed[:n]); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		plain, err := o.aead.Open(o.plain[:0], chunkNonce(o.index), o.sealed[:n], o.aad)
+		if err != nil {
+			return 0, fmt.Errorf("decrypt chunk %d: %w", o.index, err)
+		}
+		o.plain = plain
+		o.out = plain[o.skip:]
+		o.skip = 0
+		o.index++
+	}
+	n := copy(p, o.out[:min(int64(len(o.out)), o.left)])
+	o.out = o.out[n:]
+	o.left -= int64(n)
+	return n, nil
+}
+
+func (o *openReader) Close() error {
+	return o.body.Close()
+}
+
+// put writes r to a temporary file beside the blobs and renames it to name,
+// so readers never observe a partial write.
+func (l *LocalStore) put(name string, r io.Reader) error {
+	tmp, err := os.CreateTemp(l.baseLocation, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // a no-op once renamed
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(l.baseLocation, name))
+}
+
+// create links a fully written temporary file into place, which fails rather
+// than replace an existing file.
+func (l *LocalStore) create(name string, data []byte) (bool, error) {
+	tmp, err := os.CreateTemp(l.baseLocation, ".tmp-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+	err = os.Link(tmp.Name(), filepath.Join(l.baseLocation, name))
+	if errors.Is(err, fs.ErrExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s3 *S3Store) put(name string, r io.Reader) error {
+	_, err := s3.upload(name, r)
+	return err
+}
+
+// create puts data with If-None-Match: *, which the store refuses when the
+// key already exists.
+func (s3 *S3Store) create(name string, data []byte) (bool, error) {
+	req, _ := s3API.New(s3.session).PutObjectRequest(&s3API.PutObjectInput{
+		Bucket: aws.String(s3.bucketId),
+		Key:    aws.String(name),
+		Body:   bytes.NewReader(data),
+	})
+	req.HTTPRequest.Header.Set("If-None-Match", "*")
+	if err := req.Send(); err != nil {
+		if isS3Code(err, "PreconditionFailed") {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	return true, nil
+}
@@ -2,7 +2,17 @@ package storage
 
 import (
 	"auxstream/config"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 // FileMeta carries one file through a BulkSave batch and its per-file result.
@@ -11,17 +21,28 @@ type FileMeta struct {
 	AudioTitle string // caller-supplied logical title, preserved verbatim across the round trip
 	Content    []byte // raw bytes; the input on the way in, echoed back on success
 	Ext        string // file extension without the leading dot, e.g. "mp3"
+	Index      int    // caller-chosen key, echoed back so results can be matched to inputs
 }
 
 // FileSystem stores opaque blobs and addresses each by a backend-issued identifier.
 // The string returned by Save is that identifier; Read and Remove consume the same
-// value. Its form is backend-specific (a bare filename, an S3 URL, a Cloudinary URL),
+// value. Its form is backend-specific (a bare filename, an S3 key, a Cloudinary URL),
 // so callers must treat it as opaque and never construct or parse it themselves.
+//
+// Blobs are content addressed: every backend stores a blob under the SHA-256 of
+// its bytes (see ContentName), so saving identical content twice yields the same
+// identifier and a single stored copy. A blob may therefore back several records,
+// and Remove must only be called once nothing refers to it any more.
 type FileSystem interface {
 	// Save persists raw and returns the identifier under which it is now addressable.
 	// ext sets the stored extension (defaulting to mp3 when empty). Empty input is
 	// rejected rather than stored.
 	Save(raw []byte, ext string) (filename string, err error)
+	// SaveStream is Save for content read from r, which must yield exactly size
+	// bytes. The content is spooled through a temporary file rather than held in
+	// memory, so arbitrarily large uploads use constant memory. A short or long
+	// reader is an error and stores nothing.
+	SaveStream(r io.Reader, size int64, ext string) (filename string, err error)
 	// Read fetches the blob named by an identifier from a prior Save. The returned
 	// File holds an open handle the caller owns and must Close.
 	Read(fileName string) (file File, err error)
@@ -35,31 +56,208 @@ type FileSystem interface {
 	BulkSave(buf chan<- FileMeta, listOfFileMeta []FileMeta)
 	// Remove deletes the blob named by an identifier from a prior Save.
 	Remove(fileName string) error
+	// Stat describes a blob without fetching it. A missing blob yields an error
+	// matching os.ErrNotExist.
+	Stat(fileName string) (BlobInfo, error)
+	// Exists reports whether the store holds a blob, erring only when that
+	// cannot be told.
+	Exists(fileName string) (bool, error)
+	// OpenRange returns length bytes of a blob starting at off, or everything
+	// from off when length is negative, fetching only those bytes. A missing
+	// blob yields an error matching os.ErrNotExist.
+	OpenRange(fileName string, off, length int64) (io.ReadCloser, error)
+}
+
+// BlobInfo describes one stored blob, as enumerated by a Lister or reported by
+// Stat.
+type BlobInfo struct {
+	Name     string // identifier Remove accepts for this blob
+	Size     int64
+	Modified time.Time // when the blob was last written
+	Checksum string    // hex SHA-256 of the content, when the store reports one
+}
+
+// Lister is implemented by stores that can enumerate the blobs they hold, which
+// storage maintenance needs to find blobs nothing refers to. fn is called once
+// per blob; an error from it stops the listing and is returned.
+type Lister interface {
+	List(ctx context.Context, fn func(BlobInfo) error) error
+}
+
+// BlobKey reduces any identifier a store issues (a bare filename, an S3 key or
+// URL, a Cloudinary URL or public ID) to the name it was stored under, without
+// extension: for content-addressed blobs, their Checksum. Identifiers for the
+// same blob always share a key, so references and listings can be matched on it.
+func BlobKey(identifier string) string {
+	if u, err := url.Parse(identifier); err == nil && u.Scheme != "" {
+		identifier = u.Path
+	}
+	base := path.Base(identifier)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// IsChecksum reports whether s has the form of a Checksum.
+func IsChecksum(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// Checksum returns the hex-encoded SHA-256 of raw.
+func Checksum(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// ChecksumReader returns the Checksum of everything read from r.
+func ChecksumReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ContentName is the name a blob with content raw is stored under: its
+// Checksum plus ext, defaulting to mp3 when ext is empty.
+func ContentName(raw []byte, ext string) string {
+	return checksumName(Checksum(raw), ext)
+}
+
+func checksumName(sum, ext string) string {
+	if ext == "" {
+		ext = "mp3"
+	}
+	return sum + "." + ext
+}
+
+// spool copies exactly size bytes of r into a new temporary file in dir (the
+// OS default when empty), hashing them on the way. It returns the file open
+// and rewound, and the content's Checksum; the caller closes and removes it.
+func spool(r io.Reader, size int64, dir string) (*os.File, string, error) {
+	if size < 1 {
+		return nil, "", fmt.Errorf("empty file")
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return nil, "", err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(r, size+1))
+	if err == nil && n != size {
+		err = fmt.Errorf("read %d bytes, expected %d", n, size)
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return nil, "", err
+	}
+	return tmp, hex.EncodeToString(h.Sum(nil)), nil
 }
 
 // File is an open handle to a stored blob, readable and writable in place.
 // The caller that obtains one (e.g. from Read) owns it and is responsible for Close.
+// It is seekable so it can back ranged HTTP responses (see http.ServeContent), and
+// supports random access for parsers that jump around a container.
 type File interface {
 	// Name reports the handle's underlying path or identifier, which may differ
 	// from the identifier passed to Read (e.g. a local temp path for remote backends).
 	Name() string
 	Size() int64
 	io.ReadWriter
+	io.ReaderAt
+	io.Seeker
+	io.Closer
 }
 
-// SetFileStore swaps the package-level Store to the configured backend. Unrecognized
-// or incompletely configured backends are ignored, leaving the local-disk default.
+// SetFileStore swaps the package-level Store to the configured backend.
+// FileStore may list several backends, comma-separated (e.g. "s3,local"): the
+// first takes writes and the rest receive copies, through a ReplicatedStore,
+// which also serves a configured FileCacheDir. Unrecognized or incompletely
+// configured backends are ignored, leaving the local-disk default. With
+// EncryptionKeys set, every backend is wrapped in an EncryptedStore.
 func SetFileStore(config config.Config) error {
+	var keys *Keyring
+	if config.EncryptionKeys != "" {
+		var err error
+		if keys, err = ParseKeyring(config.EncryptionKeys); err != nil {
+			return err
+		}
+	}
 
-	if config.FileStore == "s3" && config.S3bucket != "" {
-		Store = NewS3Store(config.S3bucket)
-	} else if config.FileStore == "cloudinary" && config.CloudinaryURL != "" {
-		st, err := NewCloudinaryStore(config.CloudinaryURL)
+	var stores []FileSystem
+	for _, name := range strings.Split(config.FileStore, ",") {
+		st, err := configuredStore(strings.TrimSpace(name), config)
 		if err != nil {
 			return err
 		}
-		Store = st
+		if st != nil {
+			stores = append(stores, st)
+		}
 	}
+	if len(stores) == 0 {
+		stores = []FileSystem{Store}
+	}
+	if keys != nil {
+		for i, st := range stores {
+			enc, err := NewEncryptedStore(st, keys)
+			if err != nil {
+				return err
+			}
+			stores[i] = enc
+		}
+	}
+	if len(stores) == 1 && config.FileCacheDir == "" {
+		Store = stores[0]
+		return nil
+	}
+
+	st, err := NewReplicatedStore(ReplicatedConfig{
+		Primary:       stores[0],
+		Secondaries:   stores[1:],
+		CacheDir:      config.FileCacheDir,
+		CacheMaxBytes: config.FileCacheMaxBytes,
+	})
+	if err != nil {
+		return err
+	}
+	Store = st
+	return nil
+}
 
+// CloseStore stops the background work of Store, such as copying saves to the
+// secondaries of a ReplicatedStore, once what is queued is done. Call it on
+// shutdown, after the last save.
+func CloseStore() error {
+	if c, ok := Store.(io.Closer); ok {
+		return c.Close()
+	}
 	return nil
 }
+
+// configuredStore builds the backend called name from config, or returns nil
+// when name is not a backend or config lacks its settings.
+func configuredStore(name string, config config.Config) (FileSystem, error) {
+	switch {
+	case name == "s3" && config.S3bucket != "":
+		return NewS3Store(S3Config{
+			Bucket:          config.S3bucket,
+			Endpoint:        config.S3Endpoint,
+			Region:          config.S3Region,
+			ForcePathStyle:  config.S3PathStyle,
+			KeyPrefix:       config.S3KeyPrefix,
+			AccessKeyID:     config.S3AccessKeyID,
+			SecretAccessKey: config.S3SecretAccessKey,
+		}), nil
+	case name == "cloudinary" && config.CloudinaryURL != "":
+		return NewCloudinaryStore(config.CloudinaryURL)
+	case name == "local":
+		return NewLocalStore(filepath.Join(rootDir, "uploads")), nil
+	}
+	return nil, nil
+}
@@ -1,13 +1,17 @@
 package storage
 
 import (
-	"fmt"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
-
-	"github.com/google/uuid"
+	"time"
 )
 
 // LocalStore is a FileSystem backed by a directory on the local disk. Its Save
@@ -41,19 +45,37 @@ func (l *LocalStore) Writes() int {
 	return l.writes
 }
 
+// Save writes raw under its ContentName; see SaveStream.
 func (l *LocalStore) Save(raw []byte, ext string) (filename string, err error) {
-	if len(raw) < 1 {
-		return filename, fmt.Errorf("empty file")
-	}
-	filename = genFileName(ext)
-	file, err := NewFile(filepath.Join(l.baseLocation, filename))
+	return l.SaveStream(bytes.NewReader(raw), int64(len(raw)), ext)
+}
+
+// SaveStream spools r into a temporary file beside the blobs and renames it to
+// its content name, so a concurrent save of the same bytes never observes a
+// partial blob. Content already stored is not written again.
+func (l *LocalStore) SaveStream(r io.Reader, size int64, ext string) (filename string, err error) {
+	tmp, sum, err := spool(r, size, l.baseLocation)
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
+	defer os.Remove(tmp.Name()) // a no-op once renamed
+	if err = tmp.Close(); err != nil {
+		return "", err
+	}
 
-	if _, err = file.Write(raw); err != nil {
+	filename = checksumName(sum, ext)
+	path := filepath.Join(l.baseLocation, filename)
+	if _, err = os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		if err = os.Rename(tmp.Name(), path); err != nil {
+			return "", err
+		}
+	} else if err != nil {
 		return "", err
+	} else {
+		// Refresh the modification time so maintenance that spares recently
+		// written blobs also spares one just saved again.
+		now := time.Now()
+		_ = os.Chtimes(path, now, now)
 	}
 
 	l.mu.Lock()
@@ -62,42 +84,97 @@ func (l *LocalStore) Save(raw []byte, ext string) (filename string, err error) {
 	return filename, nil
 }
 
-func (l *LocalStore) Read(fileName string) (file File, err error) {
-	file, err = OpenFile(filepath.Join(l.baseLocation, fileName))
+func (l *LocalStore) Read(fileName string) (File, error) {
+	// Return a bare nil on failure: a nil *LocalFile wrapped in the File interface
+	// would compare non-nil and mislead callers that check the handle.
+	file, err := OpenFile(filepath.Join(l.baseLocation, fileName))
+	if err != nil {
+		return nil, err
+	}
 	l.mu.Lock()
 	l.reads++
 	l.mu.Unlock()
-	return
+	return file, nil
 }
 
 func (l *LocalStore) BulkSave(buf chan<- FileMeta, listOfFileMeta []FileMeta) {
 	var wg sync.WaitGroup
 	for _, fd := range listOfFileMeta {
 		wg.Add(1)
-		go func(raw []byte, title, ext string) {
+		go func(raw []byte, title, ext string, index int) {
 			defer wg.Done()
 			fileName, err := l.Save(raw, ext)
 			if err != nil {
 				log.Println(err)
-				buf <- FileMeta{AudioTitle: title}
+				buf <- FileMeta{AudioTitle: title, Index: index}
 				return
 			}
-			buf <- FileMeta{Name: fileName, Content: raw, AudioTitle: title, Ext: ext}
-		}(fd.Content, fd.AudioTitle, fd.Ext)
+			buf <- FileMeta{Name: fileName, Content: raw, AudioTitle: title, Ext: ext, Index: index}
+		}(fd.Content, fd.AudioTitle, fd.Ext, fd.Index)
 	}
 	wg.Wait()
 	close(buf)
 }
 
+// List reports every blob in the store's directory. Temporary files of saves
+// still in flight and subdirectories are skipped.
+func (l *LocalStore) List(ctx context.Context, fn func(BlobInfo) error) error {
+	entries, err := os.ReadDir(l.baseLocation)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue // removed since the directory was read
+		}
+		if err := fn(BlobInfo{Name: e.Name(), Size: info.Size(), Modified: info.ModTime()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (l *LocalStore) Remove(fileName string) error {
 	return os.Remove(filepath.Join(l.baseLocation, fileName))
 }
 
+func (l *LocalStore) Stat(fileName string) (BlobInfo, error) {
+	info, err := os.Stat(filepath.Join(l.baseLocation, fileName))
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return BlobInfo{Name: fileName, Size: info.Size(), Modified: info.ModTime()}, nil
+}
+
+func (l *LocalStore) Exists(fileName string) (bool, error) {
+	return statExists(l.Stat(fileName))
+}
+
+func (l *LocalStore) OpenRange(fileName string, off, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(l.baseLocation, fileName))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(off, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return limitRange(file, length), nil
+}
+
 // LocalFile is a File backed by an *os.File. Remote backends also use it as the
 // destination for assets they download into a local temp path before returning.
 type LocalFile struct {
 	filePath string
 	content  *os.File
+	temp     bool // remove the file on Close; set for remote-backend downloads
 }
 
 // NewFile creates (or truncates) the file at filePath for writing.
@@ -110,6 +187,16 @@ func NewFile(filePath string) (file *LocalFile, err error) {
 	return file, nil
 }
 
+// NewTempFile creates a scratch file under the OS temp directory that is deleted
+// when closed, so remote backends can stage downloads without leaking them.
+func NewTempFile() (*LocalFile, error) {
+	content, err := os.CreateTemp("", "auxstream-*")
+	if err != nil {
+		return nil, err
+	}
+	return &LocalFile{filePath: content.Name(), content: content, temp: true}, nil
+}
+
 // OpenFile opens an existing file at filePath read-only.
 func OpenFile(filePath string) (*LocalFile, error) {
 	content, err := os.Open(filePath)
@@ -132,26 +219,30 @@ func (f *LocalFile) Read(p []byte) (n int, err error) {
 	return f.content.Read(p)
 }
 
+func (f *LocalFile) ReadAt(p []byte, off int64) (n int, err error) {
+	return f.content.ReadAt(p, off)
+}
+
 func (f *LocalFile) Write(p []byte) (n int, err error) {
 	return f.content.Write(p)
 }
 
+func (f *LocalFile) Seek(offset int64, whence int) (int64, error) {
+	return f.content.Seek(offset, whence)
+}
+
 func (f *LocalFile) Close() error {
-	return f.content.Close()
+	err := f.content.Close()
+	if f.temp {
+		_ = os.Remove(f.filePath)
+	}
+	return err
 }
 
 func (f *LocalFile) WriteAt(p []byte, off int64) (n int, err error) {
 	return f.content.WriteAt(p, off)
 }
 
-// genFileName builds a collision-resistant name via UUID, defaulting ext to mp3.
-func genFileName(ext string) string {
-	if ext == "" {
-		ext = "mp3"
-	}
-	return "aud_file_" + uuid.New().String() + "." + ext
-}
-
 var cwd, _ = os.Getwd()
 var rootDir, _ = filepath.Abs(cwd)
 
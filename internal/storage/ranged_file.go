@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// Blob is a stored blob opened for serving. It is seekable, so it can back
+// ranged HTTP responses (see http.ServeContent), but unlike the File from Read
+// it is not fetched up front: reads pull only the bytes they ask for from the
+// store.
+type Blob interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+	Size() int64
+	ModTime() time.Time
+}
+
+// Open opens fileName in store as a Blob, costing one Stat until it is read.
+// A missing blob yields an error matching os.ErrNotExist.
+func Open(store FileSystem, fileName string) (Blob, error) {
+	info, err := store.Stat(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &rangedBlob{store: store, name: fileName, info: info}, nil
+}
+
+// rangedBlob reads a blob through OpenRange. Sequential reads share one ranged
+// request running to the end of the blob; seeking elsewhere starts another on
+// the next read.
+type rangedBlob struct {
+	store FileSystem
+	name  string
+	info  BlobInfo
+	pos   int64
+	body  io.ReadCloser
+	at    int64 // offset body will yield next
+}
+
+func (b *rangedBlob) Size() int64 {
+	return b.info.Size
+}
+
+func (b *rangedBlob) ModTime() time.Time {
+	return b.info.Modified
+}
+
+func (b *rangedBlob) Read(p []byte) (int, error) {
+	if b.pos >= b.info.Size {
+		return 0, io.EOF
+	}
+	if b.body != nil && b.at != b.pos {
+		_ = b.body.Close()
+		b.body = nil
+	}
+	if b.body == nil {
+		body, err := b.store.OpenRange(b.name, b.pos, -1)
+		if err != nil {
+			return 0, err
+		}
+		b.body, b.at = body, b.pos
+	}
+	n, err := b.body.Read(p)
+	b.pos += int64(n)
+	b.at = b.pos
+	if errors.Is(err, io.EOF) && b.pos < b.info.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (b *rangedBlob) ReadAt(p []byte, off int64) (int, error) {
+	if off >= b.info.Size {
+		return 0, io.EOF
+	}
+	want := min(int64(len(p)), b.info.Size-off)
+	body, err := b.store.OpenRange(b.name, off, want)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	n, err := io.ReadFull(body, p[:want])
+	if err == nil && want < int64(len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (b *rangedBlob) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.pos
+	case io.SeekEnd:
+		offset += b.info.Size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	b.pos = offset
+	return offset, nil
+}
+
+func (b *rangedBlob) Close() error {
+	if b.body == nil {
+		return nil
+	}
+	err := b.body.Close()
+	b.body = nil
+	return err
+}
+
+// statExists turns a Stat result into an Exists one.
+func statExists(_ BlobInfo, err error) (bool, error) {
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// limitedReadCloser closes the reader it limits.
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// limitRange returns rc cut to length bytes, or rc itself when length is
+// negative.
+func limitRange(rc io.ReadCloser, length int64) io.ReadCloser {
+	if length < 0 {
+		return rc
+	}
+	return limitedReadCloser{io.LimitReader(rc, length), rc}
+}
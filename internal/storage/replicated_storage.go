@@ -0,0 +1,508 @@
+package storage
+
+import (
+	"auxstream/internal/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// replicationQueueSize bounds the saves waiting to be copied to the secondaries;
+// once it is full, saves wait for room rather than drop a copy.
+const replicationQueueSize = 1024
+
+// replicationAttempts is how often a copy to a secondary is tried before the
+// blob is given up on. The failure is logged and counted; nothing copies the
+// blob again, so the secondary lacks it until it is saved anew.
+const replicationAttempts = 3
+
+// namer is implemented by stores that can name a content-addressed blob from
+// its checksum and extension. ReplicatedStore uses it to find a blob in a
+// secondary under the name that secondary gave its copy, since rows only
+// record the primary's identifier.
+type namer interface {
+	blobName(sum, ext string) string
+}
+
+func (l *LocalStore) blobName(sum, ext string) string {
+	return checksumName(sum, ext)
+}
+
+func (s3 *S3Store) blobName(sum, ext string) string {
+	return s3.prefix + checksumName(sum, ext)
+}
+
+func (cld *CloudinaryStore) blobName(sum, _ string) string {
+	// Delivered without a format, the asset comes back as uploaded.
+	asset, err := cld.cloudinaryInstance.Video(sum)
+	if err != nil {
+		return ""
+	}
+	u, err := asset.String()
+	if err != nil {
+		return ""
+	}
+	return u
+}
+
+// ReplicatedConfig assembles a ReplicatedStore.
+type ReplicatedConfig struct {
+	Primary     FileSystem   // takes every write; its identifiers are the store's
+	Secondaries []FileSystem // receive a copy of each write in the background
+	// CacheDir, when set, keeps a local copy of blobs read from remote backends
+	// so hot ones are not downloaded on every Read.
+	CacheDir string
+	// CacheMaxBytes bounds CacheDir; the least recently read blobs are evicted
+	// beyond it. Zero means unbounded.
+	CacheMaxBytes int64
+}
+
+// ReplicatedStore is a FileSystem over several backends. Saves go to the
+// primary, and return its identifier, then are copied to each secondary in the
+// background. Reads try the primary and then each secondary, through the local
+// read cache when one is configured. Only content-addressed blobs are
+// replicated and cached, since finding a copy elsewhere relies on the name
+// being derived from the content.
+type ReplicatedStore struct {
+	primary     FileSystem
+	secondaries []FileSystem
+	cache       *LocalStore
+	cacheMax    int64
+	cacheMu     sync.Mutex // serializes eviction
+
+	queue     chan replication
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+
+	reads   int
+	writes  int
+	filling map[string]bool // blobs being read into the cache in the background
+	mu      sync.Mutex
+}
+
+// replication is one saved blob waiting to be copied to the secondaries.
+type replication struct {
+	name  string
+	saved time.Time
+}
+
+// NewReplicatedStore starts a store over conf's backends, with one goroutine
+// copying saves to the secondaries until Close.
+func NewReplicatedStore(conf ReplicatedConfig) (*ReplicatedStore, error) {
+	if conf.Primary == nil {
+		return nil, errors.New("replicated store needs a primary")
+	}
+	r := &ReplicatedStore{
+		primary:     conf.Primary,
+		secondaries: conf.Secondaries,
+		cacheMax:    conf.CacheMaxBytes,
+		queue:       make(chan replication, replicationQueueSize),
+	}
+	if conf.CacheDir != "" {
+		if err := os.MkdirAll(conf.CacheDir, 0o755); err != nil {
+			return nil, fmt.Errorf("create read cache: %w", err)
+		}
+		r.cache = NewLocalStore(conf.CacheDir)
+	}
+	r.wg.Add(1)
+	go r.replicate()
+	return r, nil
+}
+
+func (r *ReplicatedStore) Reads() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reads
+}
+
+func (r *ReplicatedStore) Writes() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.writes
+}
+
+func (r *ReplicatedStore) Save(raw []byte, ext string) (filename string, err error) {
+	filename, err = r.primary.Save(raw, ext)
+	if err != nil {
+		return "", err
+	}
+	r.saved(filename)
+	return filename, nil
+}
+
+func (r *ReplicatedStore) SaveStream(rd io.Reader, size int64, ext string) (filename string, err error) {
+	filename, err = r.primary.SaveStream(rd, size, ext)
+	if err != nil {
+		return "", err
+	}
+	r.saved(filename)
+	return filename, nil
+}
+
+func (r *ReplicatedStore) saved(name string) {
+	r.mu.Lock()
+	r.writes++
+	r.mu.Unlock()
+	r.Replicate(name)
+}
+
+// Replicate queues the primary's blob name to be copied to the secondaries,
+// for blobs written to the primary other than through Save.
+func (r *ReplicatedStore) Replicate(name string) {
+	if len(r.secondaries) == 0 || !IsChecksum(BlobKey(name)) {
+		return
+	}
+	r.queue <- replication{name: name, saved: time.Now()}
+	metrics.SetStorageReplicationQueue(len(r.queue))
+}
+
+// Close waits for queued copies to finish and stops replicating. The store
+// must not be saved to afterwards.
+func (r *ReplicatedStore) Close() error {
+	r.closeOnce.Do(func() { close(r.queue) })
+	r.wg.Wait()
+	return nil
+}
+
+func (r *ReplicatedStore) replicate() {
+	defer r.wg.Done()
+	for job := range r.queue {
+		metrics.SetStorageReplicationQueue(len(r.queue))
+		err := r.copyToSecondaries(job.name)
+		if err != nil {
+			log.Printf("replicate %s: %v", job.name, err)
+		}
+		metrics.RecordStorageReplication(err == nil, time.Since(job.saved).Seconds())
+	}
+}
+
+func (r *ReplicatedStore) copyToSecondaries(name string) error {
+	file, err := r.primary.Read(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var errs []error
+	for _, sec := range r.secondaries {
+		var err error
+		for attempt := 1; attempt <= replicationAttempts; attempt++ {
+			if _, err = file.Seek(0, io.SeekStart); err != nil {
+				break
+			}
+			if _, err = sec.SaveStream(file, file.Size(), blobExt(name)); err == nil {
+				break
+			}
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Read serves fileName from the read cache, or else from the first backend
+// holding it, caching what a remote backend returns.
+func (r *ReplicatedStore) Read(fileName string) (File, error) {
+	sum, ext := BlobKey(fileName), blobExt(fileName)
+	cacheable := r.cache != nil && IsChecksum(sum)
+	if cacheable {
+		if file, err := r.cache.Read(checksumName(sum, ext)); err == nil {
+			metrics.RecordCacheHit("storage")
+			touch(file)
+			r.read()
+			return file, nil
+		}
+	}
+
+	store, file, err := r.readThrough(fileName, sum, ext)
+	if err != nil {
+		return nil, err
+	}
+	r.read()
+	if _, local := store.(*LocalStore); !cacheable || local {
+		return file, nil
+	}
+	metrics.RecordCacheMiss("storage")
+	return r.cacheCopy(file, sum, ext), nil
+}
+
+func (r *ReplicatedStore) read() {
+	r.mu.Lock()
+	r.reads++
+	r.mu.Unlock()
+}
+
+// readThrough reads fileName from the primary, falling back to each secondary
+// in turn, and reports which backend answered. The primary's error is returned
+// when none holds it.
+func (r *ReplicatedStore) readThrough(fileName, sum, ext string) (FileSystem, File, error) {
+	file, primaryErr := r.primary.Read(fileName)
+	if primaryErr == nil {
+		return r.primary, file, nil
+	}
+	if !IsChecksum(sum) {
+		return nil, nil, primaryErr
+	}
+	for _, sec := range r.secondaries {
+		n, ok := sec.(namer)
+		if !ok {
+			continue
+		}
+		if file, err := sec.Read(n.blobName(sum, ext)); err == nil {
+			return sec, file, nil
+		}
+	}
+	return nil, nil, primaryErr
+}
+
+// cacheCopy copies file into the read cache and returns the cached copy,
+// closing file. The cache is only an optimisation: should the copy fail, file
+// is returned rewound instead.
+func (r *ReplicatedStore) cacheCopy(file File, sum, ext string) File {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return file
+	}
+	name, err := r.cache.SaveStream(file, file.Size(), ext)
+	if err != nil || BlobKey(name) != sum {
+		if err == nil {
+			_ = r.cache.Remove(name) // not the blob asked for; keep it out
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			log.Printf("read cache: %v", err)
+		}
+		return file
+	}
+	cached, err := r.cache.Read(name)
+	if err != nil {
+		_, _ = file.Seek(0, io.SeekStart)
+		return file
+	}
+	_ = file.Close()
+	touch(cached)
+	r.evict()
+	return cached
+}
+
+// touch marks a cached blob as just read, so eviction takes it last. The time
+// is set explicitly, since filesystems may stamp writes more coarsely.
+func touch(file File) {
+	now := time.Now()
+	_ = os.Chtimes(file.Name(), now, now)
+}
+
+// evict removes the least recently read blobs from the cache until it fits
+// within cacheMax.
+func (r *ReplicatedStore) evict() {
+	if r.cacheMax <= 0 {
+		return
+	}
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+
+	var blobs []BlobInfo
+	var total int64
+	_ = r.cache.List(context.Background(), func(b BlobInfo) error {
+		blobs = append(blobs, b)
+		total += b.Size
+		return nil
+	})
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Modified.Before(blobs[j].Modified) })
+	for _, b := range blobs {
+		if total <= r.cacheMax {
+			return
+		}
+		// Readers holding the blob open keep reading it after removal.
+		if err := r.cache.Remove(b.Name); err == nil {
+			total -= b.Size
+		}
+	}
+}
+
+func (r *ReplicatedStore) BulkSave(buf chan<- FileMeta, listOfFileMeta []FileMeta) {
+	var wg sync.WaitGroup
+	for _, fd := range listOfFileMeta {
+		wg.Add(1)
+		go func(raw []byte, title, ext string, index int) {
+			defer wg.Done()
+			fileName, err := r.Save(raw, ext)
+			if err != nil {
+				log.Println(err)
+				buf <- FileMeta{AudioTitle: title, Index: index}
+				return
+			}
+			buf <- FileMeta{Name: fileName, Content: raw, AudioTitle: title, Ext: ext, Index: index}
+		}(fd.Content, fd.AudioTitle, fd.Ext, fd.Index)
+	}
+	wg.Wait()
+	close(buf)
+}
+
+// Remove deletes fileName from the primary and, best effort, its copies from
+// the secondaries and the read cache.
+func (r *ReplicatedStore) Remove(fileName string) error {
+	if err := r.primary.Remove(fileName); err != nil {
+		return err
+	}
+	sum, ext := BlobKey(fileName), blobExt(fileName)
+	if !IsChecksum(sum) {
+		return nil
+	}
+	for _, sec := range r.secondaries {
+		if n, ok := sec.(namer); ok {
+			if err := sec.Remove(n.blobName(sum, ext)); err != nil {
+				log.Printf("remove %s from secondary: %v", fileName, err)
+			}
+		}
+	}
+	if r.cache != nil {
+		_ = r.cache.Remove(checksumName(sum, ext))
+	}
+	return nil
+}
+
+// Stat describes fileName as the first of the read cache and the backends to
+// hold it does.
+func (r *ReplicatedStore) Stat(fileName string) (BlobInfo, error) {
+	sum, ext := BlobKey(fileName), blobExt(fileName)
+	if r.cache != nil && IsChecksum(sum) {
+		if info, err := r.cache.Stat(checksumName(sum, ext)); err == nil {
+			info.Name = fileName
+			return info, nil
+		}
+	}
+	info, primaryErr := r.primary.Stat(fileName)
+	if primaryErr == nil || !IsChecksum(sum) {
+		return info, primaryErr
+	}
+	for _, sec := range r.secondaries {
+		if n, ok := sec.(namer); ok {
+			if info, err := sec.Stat(n.blobName(sum, ext)); err == nil {
+				info.Name = fileName
+				return info, nil
+			}
+		}
+	}
+	return BlobInfo{}, primaryErr
+}
+
+func (r *ReplicatedStore) Exists(fileName string) (bool, error) {
+	return statExists(r.Stat(fileName))
+}
+
+// OpenRange reads the range from the read cache or else the first backend
+// holding the blob. A range read from a remote backend does not wait for the
+// blob to be cached; it is cached in the background instead, so the next
+// reader finds it.
+func (r *ReplicatedStore) OpenRange(fileName string, off, length int64) (io.ReadCloser, error) {
+	sum, ext := BlobKey(fileName), blobExt(fileName)
+	cacheable := r.cache != nil && IsChecksum(sum)
+	if cacheable {
+		if body, err := r.cache.OpenRange(checksumName(sum, ext), off, length); err == nil {
+			metrics.RecordCacheHit("storage")
+			return body, nil
+		}
+	}
+
+	stores := []FileSystem{r.primary}
+	names := []string{fileName}
+	if IsChecksum(sum) {
+		for _, sec := range r.secondaries {
+			if n, ok := sec.(namer); ok {
+				stores = append(stores, sec)
+				names = append(names, n.blobName(sum, ext))
+			}
+		}
+	}
+	var primaryErr error
+	for i, st := range stores {
+		body, err := st.OpenRange(names[i], off, length)
+		if i == 0 {
+			primaryErr = err
+		}
+		if err != nil {
+			continue
+		}
+		if _, local := st.(*LocalStore); cacheable && !local {
+			metrics.RecordCacheMiss("storage")
+			r.fillCache(fileName)
+		}
+		return body, nil
+	}
+	return nil, primaryErr
+}
+
+// fillCache reads fileName into the read cache in the background, once however
+// many readers ask at the same time.
+func (r *ReplicatedStore) fillCache(fileName string) {
+	r.mu.Lock()
+	if r.filling == nil {
+		r.filling = map[string]bool{}
+	}
+	if r.filling[fileName] {
+		r.mu.Unlock()
+		return
+	}
+	r.filling[fileName] = true
+	r.mu.Unlock()
+
+	go func() {
+		defer func() {
+			r.mu.Lock()
+			delete(r.filling, fileName)
+			r.mu.Unlock()
+		}()
+		sum, ext := BlobKey(fileName), blobExt(fileName)
+		_, file, err := r.readThrough(fileName, sum, ext)
+		if err != nil {
+			log.Printf("read cache fill %s: %v", fileName, err)
+			return
+		}
+		_ = r.cacheCopy(file, sum, ext).Close()
+	}()
+}
+
+// List enumerates the primary's blobs, which are the ones rows name.
+func (r *ReplicatedStore) List(ctx context.Context, fn func(BlobInfo) error) error {
+	lister, ok := r.primary.(Lister)
+	if !ok {
+		return fmt.Errorf("primary store %T cannot list its blobs", r.primary)
+	}
+	return lister.List(ctx, fn)
+}
+
+// blobExt returns the extension (without the dot) of a blob identifier,
+// defaulting to mp3 as Save does.
+func blobExt(identifier string) string {
+	if u, err := url.Parse(identifier); err == nil && u.Scheme != "" {
+		identifier = u.Path
+	}
+	if ext := path.Ext(identifier); len(ext) > 1 {
+		return ext[1:]
+	}
+	return "mp3"
+}
+
+// PrimaryStore returns the backend that takes store's writes: store itself, or
+// the primary of a ReplicatedStore.
+func PrimaryStore(store FileSystem) FileSystem {
+	if r, ok := store.(*ReplicatedStore); ok {
+		return r.primary
+	}
+	return store
+}
+
+// Replicate queues a blob written straight to store's primary to be copied to
+// its secondaries; it does nothing for other stores.
+func Replicate(store FileSystem, name string) {
+	if r, ok := store.(*ReplicatedStore); ok {
+		r.Replicate(name)
+	}
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	s3API "github.com/aws/aws-sdk-go/service/s3"
+)
+
+// stagingPrefix is where staged uploads live in a bucket, under the store's key
+// prefix. Listings skip it, so storage maintenance never mistakes an upload in
+// progress for an orphan.
+const stagingPrefix = ".staging/"
+
+// minS3Part is the smallest part S3 accepts in a multipart upload, other than
+// the last.
+const minS3Part = 5 << 20
+
+// s3Stager stages an upload as an S3 multipart upload in the store's bucket,
+// one part per appended piece, so nothing is held on the API instance between
+// requests. Every piece but the last must be at least minS3Part bytes. A
+// bucket lifecycle rule aborting incomplete multipart uploads cleans up after
+// abandoned ones.
+type s3Stager struct {
+	store *S3Store
+}
+
+// s3StageState is the state an s3Stager keeps for one upload.
+type s3StageState struct {
+	UploadID string         `json:"upload_id"`
+	Size     int64          `json:"size"`
+	Staged   int64          `json:"staged"`
+	Parts    []s3StagedPart `json:"parts"`
+}
+
+type s3StagedPart struct {
+	Number int64  `json:"n"`
+	ETag   string `json:"etag"`
+}
+
+func (s3 *S3Store) stagingKey(id string) string {
+	return s3.prefix + stagingPrefix + id
+}
+
+func (s *s3Stager) Begin(ctx context.Context, id string, size int64) (string, error) {
+	out, err := s3API.New(s.store.session).CreateMultipartUploadWithContext(ctx, &s3API.CreateMultipartUploadInput{
+		Bucket: aws.String(s.store.bucketId),
+		Key:    aws.String(s.store.stagingKey(id)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to start staged upload: %w", err)
+	}
+	return encodeStageState(s3StageState{UploadID: aws.StringValue(out.UploadId), Size: size})
+}
+
+// Append spools r to a temporary file and sends it as the next part. A piece
+// under minS3Part that does not finish the upload cannot be a part: it is
+// ErrChunkTooSmall when complete and dropped when r failed, leaving the client
+// to resend it.
+func (s *s3Stager) Append(ctx context.Context, id, state string, offset int64, r io.Reader) (string, int64, error) {
+	st, err := decodeStageState(state)
+	if err != nil {
+		return state, 0, err
+	}
+	if offset != st.Staged {
+		return state, 0, fmt.Errorf("staged upload holds %d bytes, expected %d", st.Staged, offset)
+	}
+
+	tmp, err := os.CreateTemp("", "auxstream-part-*")
+	if err != nil {
+		return state, 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	n, readErr := io.Copy(tmp, r)
+	if n == 0 {
+		return state, 0, readErr
+	}
+	if n < minS3Part && offset+n < st.Size {
+		if readErr != nil {
+			return state, 0, readErr
+		}
+		return state, 0, ErrChunkTooSmall
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return state, 0, err
+	}
+
+	number := int64(len(st.Parts) + 1)
+	out, err := s3API.New(s.store.session).UploadPartWithContext(ctx, &s3API.UploadPartInput{
+		Bucket:        aws.String(s.store.bucketId),
+		Key:           aws.String(s.store.stagingKey(id)),
+		UploadId:      aws.String(st.UploadID),
+		PartNumber:    aws.Int64(number),
+		Body:          tmp,
+		ContentLength: aws.Int64(n),
+	})
+	if err != nil {
+		return state, 0, fmt.Errorf("failed to stage part %d: %w", number, err)
+	}
+	st.Parts = append(st.Parts, s3StagedPart{Number: number, ETag: aws.StringValue(out.ETag)})
+	st.Staged += n
+
+	newState, err := encodeStageState(st)
+	if err != nil {
+		return state, 0, err
+	}
+	return newState, n, readErr
+}
+
+// Open completes the multipart upload and downloads the assembled object to
+// a temporary file. An upload already completed by an earlier Open is simply
+// downloaded again.
+func (s *s3Stager) Open(ctx context.Context, id, state string) (File, error) {
+	st, err := decodeStageState(state)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]*s3API.CompletedPart, len(st.Parts))
+	for i, p := range st.Parts {
+		parts[i] = &s3API.CompletedPart{PartNumber: aws.Int64(p.Number), ETag: aws.String(p.ETag)}
+	}
+	_, err = s3API.New(s.store.session).CompleteMultipartUploadWithContext(ctx, &s3API.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.store.bucketId),
+		Key:             aws.String(s.store.stagingKey(id)),
+		UploadId:        aws.String(st.UploadID),
+		MultipartUpload: &s3API.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil && !isS3Code(err, s3API.ErrCodeNoSuchUpload) {
+		return nil, fmt.Errorf("failed to assemble staged upload: %w", err)
+	}
+	return s.store.Read(s.store.stagingKey(id))
+}
+
+func (s *s3Stager) Discard(ctx context.Context, id, state string) error {
+	client := s3API.New(s.store.session)
+	if st, err := decodeStageState(state); err == nil {
+		_, err = client.AbortMultipartUploadWithContext(ctx, &s3API.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.store.bucketId),
+			Key:      aws.String(s.store.stagingKey(id)),
+			UploadId: aws.String(st.UploadID),
+		})
+		if err != nil && !isS3Code(err, s3API.ErrCodeNoSuchUpload) {
+			return err
+		}
+	}
+	_, err := client.DeleteObjectWithContext(ctx, &s3API.DeleteObjectInput{
+		Bucket: aws.String(s.store.bucketId),
+		Key:    aws.String(s.store.stagingKey(id)),
+	})
+	return err
+}
+
+func encodeStageState(st s3StageState) (string, error) {
+	b, err := json.Marshal(st)
+	return string(b), err
+}
+
+func decodeStageState(state string) (s3StageState, error) {
+	var st s3StageState
+	if err := json.Unmarshal([]byte(state), &st); err != nil {
+		return st, fmt.Errorf("invalid staged upload state: %w", err)
+	}
+	return st, nil
+}
+
+// isS3Code reports whether err is an S3 error with the given code.
+func isS3Code(err error, code string) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == code
+}
@@ -2,34 +2,105 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
+	"net/url"
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	s3API "github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
-// S3Store is a FileSystem backed by an AWS S3 bucket. Save returns the object's S3
-// URL (its Location) as the identifier; Read and Remove instead key off the object
-// key, so a URL from Save is not directly reusable as the argument to either.
+// S3Store is a FileSystem backed by an S3 bucket, on AWS or any S3-compatible
+// store. Identifiers are object keys, which Read and Remove take as they are.
+// Tracks saved before that hold the object's URL instead; Read and Remove still
+// accept those, and KeyFromLocation recovers the key for rewriting them.
 type S3Store struct {
 	session   *session.Session
 	bucketId  string
+	prefix    string
 	uploads   int
 	downloads int
 	mu        sync.Mutex
 }
 
-// NewS3Store targets bucketId, drawing region and credentials from the AWS default
-// chain (environment, shared config, instance role). Construction is fatal if no
-// session can be established.
-func NewS3Store(bucketId string) *S3Store {
-	sess := session.Must(session.NewSession())
-	return &S3Store{session: sess, bucketId: bucketId}
+// S3Config locates the bucket an S3Store keeps its blobs in.
+type S3Config struct {
+	Bucket string
+	// Endpoint replaces the AWS endpoint, for S3-compatible stores such as
+	// MinIO, Ceph or R2; blank uses AWS.
+	Endpoint string
+	// Region requests are signed for; blank takes it from the AWS default
+	// chain, falling back to us-east-1 for a custom Endpoint.
+	Region string
+	// ForcePathStyle addresses objects as endpoint/bucket/key rather than
+	// bucket.endpoint/key, as most self-hosted stores require.
+	ForcePathStyle bool
+	// KeyPrefix is prepended to every object key (e.g. "audio/"), so the
+	// bucket can be shared.
+	KeyPrefix string
+	// Static credentials; blank AccessKeyID draws them from the AWS default
+	// chain (environment, shared config, instance role).
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// NewS3Store targets conf.Bucket. Construction is fatal if no session can be
+// established.
+func NewS3Store(conf S3Config) *S3Store {
+	awsConf := aws.NewConfig().WithS3ForcePathStyle(conf.ForcePathStyle)
+	if conf.Endpoint != "" {
+		awsConf = awsConf.WithEndpoint(conf.Endpoint)
+	}
+	if conf.Region != "" {
+		awsConf = awsConf.WithRegion(conf.Region)
+	}
+	if conf.AccessKeyID != "" {
+		awsConf = awsConf.WithCredentials(credentials.NewStaticCredentials(conf.AccessKeyID, conf.SecretAccessKey, conf.SessionToken))
+	}
+	sess := session.Must(session.NewSession(awsConf))
+	if conf.Endpoint != "" && aws.StringValue(sess.Config.Region) == "" {
+		// Self-hosted stores seldom care which region a request is signed
+		// for, but it must be signed for one.
+		sess.Config.Region = aws.String("us-east-1")
+	}
+	return &S3Store{session: sess, bucketId: conf.Bucket, prefix: conf.KeyPrefix}
+}
+
+// objectKey maps an identifier to the key of its object: the identifier itself,
+// or for one saved as a URL, the key in that URL.
+func (s3 *S3Store) objectKey(name string) string {
+	if key, ok := s3.KeyFromLocation(name); ok {
+		return key
+	}
+	return name
+}
+
+// KeyFromLocation returns the object key in location, an object URL of the
+// kind Save used to return, addressed to this store's bucket either
+// virtual-hosted (bucket.host/key) or path-style (host/bucket/key). It reports
+// false for anything else, including keys themselves.
+func (s3 *S3Store) KeyFromLocation(location string) (string, bool) {
+	u, err := url.Parse(location)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	if strings.HasPrefix(u.Hostname(), s3.bucketId+".") {
+		key := strings.TrimPrefix(u.Path, "/")
+		return key, key != ""
+	}
+	key, ok := strings.CutPrefix(u.Path, "/"+s3.bucketId+"/")
+	return key, ok && key != ""
 }
 
 func (s3 *S3Store) Reads() int {
@@ -44,43 +115,57 @@ func (s3 *S3Store) Save(raw []byte, ext string) (filename string, err error) {
 	if len(raw) < 1 {
 		return "", fmt.Errorf("empty file")
 	}
-	filename = genFileName(ext)
+	return s3.upload(s3.prefix+ContentName(raw, ext), bytes.NewReader(raw))
+}
 
-	freader := bytes.NewReader(raw)
+// SaveStream spools r to a temporary file to learn its content name, then
+// uploads the file in parts, so memory stays bounded by the uploader's part
+// buffers whatever the size.
+func (s3 *S3Store) SaveStream(r io.Reader, size int64, ext string) (filename string, err error) {
+	tmp, sum, err := spool(r, size, "")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	return s3.upload(s3.prefix+checksumName(sum, ext), tmp)
+}
 
+// upload writes body to key and returns the key. Identical content
+// maps to the same key, so re-uploading it overwrites the object with the same
+// bytes rather than storing a second copy.
+func (s3 *S3Store) upload(key string, body io.Reader) (string, error) {
 	uploader := s3manager.NewUploader(s3.session)
-	result, err := uploader.Upload(&s3manager.UploadInput{
+	_, err := uploader.Upload(&s3manager.UploadInput{
 		Bucket: aws.String(s3.bucketId),
-		Key:    aws.String(filename),
-		Body:   freader,
+		Key:    aws.String(key),
+		Body:   body,
 	})
-
 	if err != nil {
 		return "", fmt.Errorf("failed to upload file, %v", err)
 	}
 
-	filename = result.Location
-
 	s3.mu.Lock()
 	s3.uploads++
 	s3.mu.Unlock()
-	return
+	return key, nil
 }
 
 func (s3 *S3Store) Read(location string) (File, error) {
 	downloader := s3manager.NewDownloader(s3.session)
 
 	// The downloader needs a WriterAt, so stage the object in a local temp file and
-	// hand that back as the File; the caller owns closing (and cleaning up) it.
-	lfile, err := NewFile(os.TempDir() + location)
+	// hand that back as the File; closing it removes the temp file.
+	lfile, err := NewTempFile()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create file %q: %w", location, err)
 	}
 
 	if _, err = downloader.Download(lfile, &s3API.GetObjectInput{
 		Bucket: aws.String(s3.bucketId),
-		Key:    aws.String(location),
+		Key:    aws.String(s3.objectKey(location)),
 	}); err != nil {
+		_ = lfile.Close()
 		return nil, fmt.Errorf("failed to download file: %w", err)
 	}
 
@@ -95,16 +180,16 @@ func (s3 *S3Store) BulkSave(buf chan<- FileMeta, listOfFileMeta []FileMeta) {
 	var wg sync.WaitGroup
 	for _, fd := range listOfFileMeta {
 		wg.Add(1)
-		go func(raw []byte, title, ext string) {
+		go func(raw []byte, title, ext string, index int) {
 			defer wg.Done()
 			fileName, err := s3.Save(raw, ext)
 			if err != nil {
 				log.Println(err)
-				buf <- FileMeta{AudioTitle: title}
+				buf <- FileMeta{AudioTitle: title, Index: index}
 				return
 			}
-			buf <- FileMeta{Name: fileName, Content: raw, AudioTitle: title, Ext: ext}
-		}(fd.Content, fd.AudioTitle, fd.Ext)
+			buf <- FileMeta{Name: fileName, Content: raw, AudioTitle: title, Ext: ext, Index: index}
+		}(fd.Content, fd.AudioTitle, fd.Ext, fd.Index)
 	}
 	wg.Wait()
 	close(buf)
@@ -114,7 +199,97 @@ func (s3 *S3Store) Remove(fileName string) error {
 	s3Client := s3API.New(s3.session)
 	_, err := s3Client.DeleteObject(&s3API.DeleteObjectInput{
 		Bucket: aws.String(s3.bucketId),
-		Key:    aws.String(fileName),
+		Key:    aws.String(s3.objectKey(fileName)),
 	})
 	return err
 }
+
+func (s3 *S3Store) Stat(fileName string) (BlobInfo, error) {
+	return s3.headObject(context.Background(), s3.objectKey(fileName), false)
+}
+
+func (s3 *S3Store) Exists(fileName string) (bool, error) {
+	return statExists(s3.Stat(fileName))
+}
+
+// OpenRange fetches the range with a ranged GET.
+func (s3 *S3Store) OpenRange(fileName string, off, length int64) (io.ReadCloser, error) {
+	return s3.getRange(context.Background(), s3.objectKey(fileName), off, length)
+}
+
+// headObject describes the object at key, with the checksum the store verified
+// it against when withChecksum is set. A missing object yields an error
+// matching os.ErrNotExist.
+func (s3 *S3Store) headObject(ctx context.Context, key string, withChecksum bool) (BlobInfo, error) {
+	in := &s3API.HeadObjectInput{Bucket: aws.String(s3.bucketId), Key: aws.String(key)}
+	if withChecksum {
+		in.ChecksumMode = aws.String(s3API.ChecksumModeEnabled)
+	}
+	out, err := s3API.New(s3.session).HeadObjectWithContext(ctx, in)
+	if err != nil {
+		if isS3Code(err, "NotFound") || isS3Code(err, s3API.ErrCodeNoSuchKey) {
+			return BlobInfo{}, fmt.Errorf("%s: %w", key, os.ErrNotExist)
+		}
+		return BlobInfo{}, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+	info := BlobInfo{
+		Name:     key,
+		Size:     aws.Int64Value(out.ContentLength),
+		Modified: aws.TimeValue(out.LastModified),
+	}
+	if sum, err := base64.StdEncoding.DecodeString(aws.StringValue(out.ChecksumSHA256)); err == nil && len(sum) > 0 {
+		info.Checksum = hex.EncodeToString(sum)
+	}
+	return info, nil
+}
+
+// getRange streams length bytes of the object at key from off, or the rest of
+// it when length is negative. A missing object yields an error matching
+// os.ErrNotExist.
+func (s3 *S3Store) getRange(ctx context.Context, key string, off, length int64) (io.ReadCloser, error) {
+	in := &s3API.GetObjectInput{Bucket: aws.String(s3.bucketId), Key: aws.String(key)}
+	switch {
+	case length == 0:
+		return io.NopCloser(strings.NewReader("")), nil
+	case length > 0:
+		in.Range = aws.String(fmt.Sprintf("bytes=%d-%d", off, off+length-1))
+	case off > 0:
+		in.Range = aws.String(fmt.Sprintf("bytes=%d-", off))
+	}
+	out, err := s3API.New(s3.session).GetObjectWithContext(ctx, in)
+	if err != nil {
+		if isS3Code(err, s3API.ErrCodeNoSuchKey) {
+			return nil, fmt.Errorf("%s: %w", key, os.ErrNotExist)
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return limitRange(out.Body, length), nil
+}
+
+// List pages through every object under the key prefix, naming each by its
+// key. Uploads being staged (see NewStager) are not blobs and are skipped.
+func (s3 *S3Store) List(ctx context.Context, fn func(BlobInfo) error) error {
+	var fnErr error
+	err := s3API.New(s3.session).ListObjectsV2PagesWithContext(ctx, &s3API.ListObjectsV2Input{
+		Bucket: aws.String(s3.bucketId),
+		Prefix: aws.String(s3.prefix),
+	}, func(page *s3API.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			if strings.HasPrefix(aws.StringValue(obj.Key), s3.stagingKey("")) {
+				continue
+			}
+			if fnErr = fn(BlobInfo{
+				Name:     aws.StringValue(obj.Key),
+				Size:     aws.Int64Value(obj.Size),
+				Modified: aws.TimeValue(obj.LastModified),
+			}); fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
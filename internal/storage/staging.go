@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Stager holds the bytes of an upload until it is ingested: the pieces of a
+// resumable upload until all of them are in, or the files of a bulk upload
+// until a worker gets to them. Whatever a stager needs to remember between calls is kept
+// in an opaque state string that the caller stores alongside the upload and
+// passes back, so an upload begun on one API instance can be continued on
+// another, or processed by a worker, sharing the same staging area.
+type Stager interface {
+	// Begin prepares to stage an upload of size bytes under id, returning its
+	// initial state.
+	Begin(ctx context.Context, id string, size int64) (state string, err error)
+	// Append stages what r yields as the continuation of the upload from
+	// offset, returning the updated state and how many bytes were kept. When
+	// r fails part way (a dropped connection), what was kept before the error
+	// is reported alongside it, so the client can resume from there.
+	Append(ctx context.Context, id, state string, offset int64, r io.Reader) (newState string, n int64, err error)
+	// Open returns the completely staged upload. The caller owns the File and
+	// must Close it; the staged data remains until Discard.
+	Open(ctx context.Context, id, state string) (File, error)
+	// Discard removes everything staged for id.
+	Discard(ctx context.Context, id, state string) error
+}
+
+// ErrChunkTooSmall is returned by stagers with a minimum piece size when a
+// piece other than the last falls below it.
+var ErrChunkTooSmall = errors.New("chunk is smaller than the minimum staged part size")
+
+// NewStager returns the stager suited to store: S3 multipart parts in the
+// store's own bucket when it writes to an S3Store, otherwise files under dir
+// (or DefaultStagingDir when dir is empty).
+func NewStager(store FileSystem, dir string) Stager {
+	if s3, ok := PrimaryStore(store).(*S3Store); ok {
+		return &s3Stager{store: s3}
+	}
+	if dir == "" {
+		dir = DefaultStagingDir()
+	}
+	return NewLocalStager(dir)
+}
+
+// DefaultStagingDir is where uploads are staged locally when no directory is
+// configured.
+func DefaultStagingDir() string {
+	return filepath.Join(os.TempDir(), "auxstream-uploads")
+}
+
+// stagingMaxAge is how long staged data is kept without being finished;
+// resumable uploads expire well before this.
+const stagingMaxAge = 48 * time.Hour
+
+// LocalStager stages each upload as one file, named by its id, in a directory.
+// For uploads to be resumable across API instances the directory must be
+// shared between them.
+type LocalStager struct {
+	dir string
+}
+
+// NewLocalStager returns a stager keeping files under dir, creating it if
+// absent. A directory it cannot create is fatal, as for NewLocalStore.
+func NewLocalStager(dir string) *LocalStager {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatalln(err.Error())
+	}
+	return &LocalStager{dir: dir}
+}
+
+func (l *LocalStager) path(id string) string {
+	return filepath.Join(l.dir, filepath.Base(id))
+}
+
+// Begin creates the upload's empty file. Files left behind by uploads that
+// were abandoned long ago are cleared out on the way.
+func (l *LocalStager) Begin(_ context.Context, id string, _ int64) (string, error) {
+	l.sweep()
+	f, err := os.OpenFile(l.path(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", err
+	}
+	return "", f.Close()
+}
+
+// Append writes r into the file at offset. Anything past offset, left by an
+// earlier request that wrote more than it managed to report, is cut off first.
+func (l *LocalStager) Append(_ context.Context, id, state string, offset int64, r io.Reader) (string, int64, error) {
+	f, err := os.OpenFile(l.path(id), os.O_WRONLY, 0)
+	if err != nil {
+		return state, 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return state, 0, err
+	}
+	if info.Size() < offset {
+		return state, 0, fmt.Errorf("staged upload holds %d bytes, expected %d", info.Size(), offset)
+	}
+	if err = f.Truncate(offset); err != nil {
+		return state, 0, err
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return state, 0, err
+	}
+	n, err := io.Copy(f, r)
+	return state, n, err
+}
+
+func (l *LocalStager) Open(_ context.Context, id, _ string) (File, error) {
+	file, err := OpenFile(l.path(id))
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (l *LocalStager) Discard(_ context.Context, id, _ string) error {
+	err := os.Remove(l.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// sweep removes staged files untouched for longer than stagingMaxAge.
+func (l *LocalStager) sweep() {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-stagingMaxAge)
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if info, err := e.Info(); err == nil && info.ModTime().Before(cutoff) {
+			_ = os.Remove(filepath.Join(l.dir, e.Name()))
+		}
+	}
+}
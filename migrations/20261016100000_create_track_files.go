@@ -0,0 +1,64 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/beesaferoot/gorm-migrate/migration"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migration.RegisterMigration(&migration.Migration{
+		Version:   "20261016100000",
+		Name:      "create_track_files",
+		CreatedAt: time.Now(),
+		// Artifacts derived from a track's upload (HLS playlists and segments),
+		// one set per rendition. The partial unique index keeps a rendition from
+		// holding two live copies of the same artifact.
+		Up: func(db *gorm.DB) error {
+			if err := db.Exec(`CREATE TABLE IF NOT EXISTS "auxstream"."track_files" (
+	id uuid
+	PRIMARY KEY,
+	track_id uuid
+	NOT NULL,
+	kind varchar(32)
+	NOT NULL,
+	rendition varchar(32)
+	NOT NULL,
+	sequence integer
+	DEFAULT 0,
+	duration_ms integer
+	DEFAULT 0,
+	file text
+	NOT NULL,
+	size bigint
+	DEFAULT 0,
+	created_at timestamp,
+	updated_at timestamp,
+	deleted_at timestamp,
+	CONSTRAINT "fk_auxstream.track_files_track_id_fkey"
+		FOREIGN KEY ("track_id")
+		REFERENCES "auxstream"."tracks"(id)
+		ON DELETE CASCADE
+	);`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_auxstream_track_files_deleted_at
+				ON "auxstream"."track_files" ("deleted_at");`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_auxstream_track_files_unique
+				ON "auxstream"."track_files" ("track_id", "rendition", "kind", "sequence")
+				WHERE deleted_at IS NULL;`).Error; err != nil {
+				return err
+			}
+			return nil
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Exec(`DROP TABLE IF EXISTS "auxstream"."track_files";`).Error; err != nil {
+				return err
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/beesaferoot/gorm-migrate/migration"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migration.RegisterMigration(&migration.Migration{
+		Version:   "20261016110000",
+		Name:      "add_stream_quality_to_users",
+		CreatedAt: time.Now(),
+		// Rendition streamed when a request names none; blank means the original.
+		Up: func(db *gorm.DB) error {
+			if err := db.Exec(`ALTER TABLE "auxstream"."users"
+				ADD COLUMN IF NOT EXISTS stream_quality varchar(16) DEFAULT '';`).Error; err != nil {
+				return err
+			}
+			return nil
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Exec(`ALTER TABLE "auxstream"."users" DROP COLUMN IF EXISTS stream_quality;`).Error; err != nil {
+				return err
+			}
+			return nil
+		},
+	})
+}
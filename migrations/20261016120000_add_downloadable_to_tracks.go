@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/beesaferoot/gorm-migrate/migration"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migration.RegisterMigration(&migration.Migration{
+		Version:   "20261016120000",
+		Name:      "add_downloadable_to_tracks",
+		CreatedAt: time.Now(),
+		// Existing tracks stay stream-only until their uploader opts in.
+		Up: func(db *gorm.DB) error {
+			if err := db.Exec(`ALTER TABLE "auxstream"."tracks"
+				ADD COLUMN IF NOT EXISTS downloadable boolean DEFAULT false;`).Error; err != nil {
+				return err
+			}
+			return nil
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Exec(`ALTER TABLE "auxstream"."tracks" DROP COLUMN IF EXISTS downloadable;`).Error; err != nil {
+				return err
+			}
+			return nil
+		},
+	})
+}
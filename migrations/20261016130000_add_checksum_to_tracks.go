@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/beesaferoot/gorm-migrate/migration"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migration.RegisterMigration(&migration.Migration{
+		Version:   "20261016130000",
+		Name:      "add_checksum_to_tracks",
+		CreatedAt: time.Now(),
+		// SHA-256 of each track's uploaded audio, used to spot exact duplicate
+		// uploads. Tracks stored before content addressing keep an empty
+		// checksum and are simply never matched.
+		Up: func(db *gorm.DB) error {
+			if err := db.Exec(`ALTER TABLE "auxstream"."tracks"
+				ADD COLUMN IF NOT EXISTS checksum varchar(64) DEFAULT '';`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_auxstream_tracks_checksum
+				ON "auxstream"."tracks" ("checksum");`).Error; err != nil {
+				return err
+			}
+			return nil
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Exec(`DROP INDEX IF EXISTS "auxstream".idx_auxstream_tracks_checksum;`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`ALTER TABLE "auxstream"."tracks" DROP COLUMN IF EXISTS checksum;`).Error; err != nil {
+				return err
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/beesaferoot/gorm-migrate/migration"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migration.RegisterMigration(&migration.Migration{
+		Version:   "20261016140000",
+		Name:      "add_size_to_tracks",
+		CreatedAt: time.Now(),
+		// Byte length of each track's uploaded audio, checked by the storage
+		// scrub. Existing tracks keep 0, meaning unknown.
+		Up: func(db *gorm.DB) error {
+			if err := db.Exec(`ALTER TABLE "auxstream"."tracks"
+				ADD COLUMN IF NOT EXISTS size bigint DEFAULT 0;`).Error; err != nil {
+				return err
+			}
+			return nil
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Exec(`ALTER TABLE "auxstream"."tracks" DROP COLUMN IF EXISTS size;`).Error; err != nil {
+				return err
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,88 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/beesaferoot/gorm-migrate/migration"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migration.RegisterMigration(&migration.Migration{
+		Version:   "20261016150000",
+		Name:      "create_upload_jobs",
+		CreatedAt: time.Now(),
+		// The queue of bulk uploads awaiting the upload workers, and the
+		// outcome of each file. Workers claim the oldest ready job, hence the
+		// index on (status, available_at).
+		Up: func(db *gorm.DB) error {
+			if err := db.Exec(`CREATE TABLE IF NOT EXISTS "auxstream"."upload_jobs" (
+	id uuid
+	PRIMARY KEY,
+	user_id uuid,
+	artist_id uuid,
+	downloadable boolean
+	DEFAULT false,
+	status varchar(16)
+	NOT NULL,
+	attempts integer
+	DEFAULT 0,
+	error text,
+	available_at timestamp,
+	started_at timestamp,
+	finished_at timestamp,
+	created_at timestamp,
+	updated_at timestamp,
+	CONSTRAINT "fk_auxstream.upload_jobs_user_id_fkey"
+		FOREIGN KEY ("user_id")
+		REFERENCES "auxstream"."users"(id)
+		ON DELETE SET NULL
+	);`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_auxstream_upload_jobs_status
+				ON "auxstream"."upload_jobs" ("status", "available_at");`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`CREATE TABLE IF NOT EXISTS "auxstream"."upload_job_files" (
+	id uuid
+	PRIMARY KEY,
+	job_id uuid
+	NOT NULL,
+	position integer
+	NOT NULL,
+	filename text,
+	title text,
+	size bigint
+	DEFAULT 0,
+	stage text,
+	status varchar(16)
+	NOT NULL,
+	error text,
+	track_id uuid,
+	created_at timestamp,
+	updated_at timestamp,
+	CONSTRAINT "fk_auxstream.upload_job_files_job_id_fkey"
+		FOREIGN KEY ("job_id")
+		REFERENCES "auxstream"."upload_jobs"(id)
+		ON DELETE CASCADE
+	);`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_auxstream_upload_job_files_job_id
+				ON "auxstream"."upload_job_files" ("job_id");`).Error; err != nil {
+				return err
+			}
+			return nil
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Exec(`DROP TABLE IF EXISTS "auxstream"."upload_job_files";`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`DROP TABLE IF EXISTS "auxstream"."upload_jobs";`).Error; err != nil {
+				return err
+			}
+			return nil
+		},
+	})
+}
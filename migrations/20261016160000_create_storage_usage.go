@@ -0,0 +1,64 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/beesaferoot/gorm-migrate/migration"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migration.RegisterMigration(&migration.Migration{
+		Version:   "20261016160000",
+		Name:      "create_storage_usage",
+		CreatedAt: time.Now(),
+		// Per-user storage quotas: a role on each user selecting the quota, the
+		// uploader of each track, and the ledger of what each uploader's tracks
+		// hold. Existing tracks have no uploader and are charged to no one.
+		Up: func(db *gorm.DB) error {
+			if err := db.Exec(`ALTER TABLE "auxstream"."users"
+				ADD COLUMN IF NOT EXISTS role varchar(16) NOT NULL DEFAULT 'user';`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`ALTER TABLE "auxstream"."tracks"
+				ADD COLUMN IF NOT EXISTS uploader_id uuid
+				CONSTRAINT "fk_auxstream.tracks_uploader_id_fkey"
+					REFERENCES "auxstream"."users"(id)
+					ON DELETE SET NULL;`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_auxstream_tracks_uploader_id
+				ON "auxstream"."tracks" ("uploader_id");`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`CREATE TABLE IF NOT EXISTS "auxstream"."storage_usage" (
+	user_id uuid
+	PRIMARY KEY,
+	bytes bigint
+	NOT NULL DEFAULT 0,
+	tracks integer
+	NOT NULL DEFAULT 0,
+	updated_at timestamp,
+	CONSTRAINT "fk_auxstream.storage_usage_user_id_fkey"
+		FOREIGN KEY ("user_id")
+		REFERENCES "auxstream"."users"(id)
+		ON DELETE CASCADE
+	);`).Error; err != nil {
+				return err
+			}
+			return nil
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Exec(`DROP TABLE IF EXISTS "auxstream"."storage_usage";`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`ALTER TABLE "auxstream"."tracks" DROP COLUMN IF EXISTS uploader_id;`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`ALTER TABLE "auxstream"."users" DROP COLUMN IF EXISTS role;`).Error; err != nil {
+				return err
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/beesaferoot/gorm-migrate/migration"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migration.RegisterMigration(&migration.Migration{
+		Version:   "20261016170000",
+		Name:      "add_images",
+		CreatedAt: time.Now(),
+		// Uploaded pictures, stored at the standard square sizes: each column
+		// maps a size in pixels to the store identifier of that rendition.
+		Up: func(db *gorm.DB) error {
+			for _, table := range []string{"tracks", "artists", "playlists"} {
+				if err := db.Exec(`ALTER TABLE "auxstream"."` + table + `"
+				ADD COLUMN IF NOT EXISTS images jsonb;`).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(db *gorm.DB) error {
+			for _, table := range []string{"tracks", "artists", "playlists"} {
+				if err := db.Exec(`ALTER TABLE "auxstream"."` + table + `" DROP COLUMN IF EXISTS images;`).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
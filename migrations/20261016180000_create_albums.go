@@ -0,0 +1,70 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/beesaferoot/gorm-migrate/migration"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migration.RegisterMigration(&migration.Migration{
+		Version:   "20261016180000",
+		Name:      "create_albums",
+		CreatedAt: time.Now(),
+		// Albums group an artist's tracks into releases. Titles are unique per
+		// artist (case-insensitively, among live albums) so uploads naming the
+		// same album find it rather than create another.
+		Up: func(db *gorm.DB) error {
+			if err := db.Exec(`CREATE TABLE IF NOT EXISTS "auxstream"."albums" (
+				id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+				title text NOT NULL,
+				artist_id uuid NOT NULL REFERENCES "auxstream"."artists"(id),
+				type varchar(16) NOT NULL DEFAULT 'album',
+				release_date date,
+				images jsonb,
+				created_at timestamptz,
+				updated_at timestamptz,
+				deleted_at timestamptz
+			);`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_albums_artist_id ON "auxstream"."albums" (artist_id);`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_albums_deleted_at ON "auxstream"."albums" (deleted_at);`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_albums_artist_title
+				ON "auxstream"."albums" (artist_id, lower(title)) WHERE deleted_at IS NULL;`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`ALTER TABLE "auxstream"."tracks"
+				ADD COLUMN IF NOT EXISTS album_id uuid REFERENCES "auxstream"."albums"(id) ON DELETE SET NULL,
+				ADD COLUMN IF NOT EXISTS disc_number integer DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS track_number integer DEFAULT 0;`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_tracks_album_id ON "auxstream"."tracks" (album_id, disc_number, track_number);`).Error; err != nil {
+				return err
+			}
+			return db.Exec(`ALTER TABLE "auxstream"."upload_jobs"
+				ADD COLUMN IF NOT EXISTS album_id uuid;`).Error
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Exec(`ALTER TABLE "auxstream"."upload_jobs" DROP COLUMN IF EXISTS album_id;`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`DROP INDEX IF EXISTS "auxstream"."idx_tracks_album_id";`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`ALTER TABLE "auxstream"."tracks"
+				DROP COLUMN IF EXISTS track_number,
+				DROP COLUMN IF EXISTS disc_number,
+				DROP COLUMN IF EXISTS album_id;`).Error; err != nil {
+				return err
+			}
+			return db.Exec(`DROP TABLE IF EXISTS "auxstream"."albums";`).Error
+		},
+	})
+}
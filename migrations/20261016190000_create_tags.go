@@ -0,0 +1,88 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/beesaferoot/gorm-migrate/migration"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migration.RegisterMigration(&migration.Migration{
+		Version:   "20261016190000",
+		Name:      "create_tags",
+		CreatedAt: time.Now(),
+		// Genres and free-form tags, one table for both told apart by kind,
+		// linked to tracks and artists. Tracks are filtered on them by slug.
+		Up: func(db *gorm.DB) error {
+			if err := db.Exec(`CREATE TABLE IF NOT EXISTS "auxstream"."tags" (
+	id uuid
+	PRIMARY KEY DEFAULT gen_random_uuid(),
+	kind varchar(8)
+	NOT NULL,
+	name text
+	NOT NULL,
+	slug text
+	NOT NULL,
+	created_at timestamp
+	);`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_tags_kind_slug
+				ON "auxstream"."tags" ("kind", "slug");`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`CREATE TABLE IF NOT EXISTS "auxstream"."track_tags" (
+	track_id uuid
+	NOT NULL,
+	tag_id uuid
+	NOT NULL,
+	created_at timestamp,
+	PRIMARY KEY ("track_id", "tag_id"),
+	CONSTRAINT "fk_auxstream.track_tags_track_id_fkey"
+		FOREIGN KEY ("track_id")
+		REFERENCES "auxstream"."tracks"(id)
+		ON DELETE CASCADE,
+	CONSTRAINT "fk_auxstream.track_tags_tag_id_fkey"
+		FOREIGN KEY ("tag_id")
+		REFERENCES "auxstream"."tags"(id)
+		ON DELETE CASCADE
+	);`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_auxstream_track_tags_tag_id
+				ON "auxstream"."track_tags" ("tag_id");`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`CREATE TABLE IF NOT EXISTS "auxstream"."artist_tags" (
+	artist_id uuid
+	NOT NULL,
+	tag_id uuid
+	NOT NULL,
+	created_at timestamp,
+	PRIMARY KEY ("artist_id", "tag_id"),
+	CONSTRAINT "fk_auxstream.artist_tags_artist_id_fkey"
+		FOREIGN KEY ("artist_id")
+		REFERENCES "auxstream"."artists"(id)
+		ON DELETE CASCADE,
+	CONSTRAINT "fk_auxstream.artist_tags_tag_id_fkey"
+		FOREIGN KEY ("tag_id")
+		REFERENCES "auxstream"."tags"(id)
+		ON DELETE CASCADE
+	);`).Error; err != nil {
+				return err
+			}
+			return db.Exec(`CREATE INDEX IF NOT EXISTS idx_auxstream_artist_tags_tag_id
+				ON "auxstream"."artist_tags" ("tag_id");`).Error
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Exec(`DROP TABLE IF EXISTS "auxstream"."artist_tags";`).Error; err != nil {
+				return err
+			}
+			if err := db.Exec(`DROP TABLE IF EXISTS "auxstream"."track_tags";`).Error; err != nil {
+				return err
+			}
+			return db.Exec(`DROP TABLE IF EXISTS "auxstream"."tags";`).Error
+		},
+	})
+}
@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/beesaferoot/gorm-migrate/migration"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migration.RegisterMigration(&migration.Migration{
+		Version:   "20261016200000",
+		Name:      "create_track_artists",
+		CreatedAt: time.Now(),
+		// Credits of artists on tracks besides the track's own artist_id, by
+		// role: further primary artists, featured artists, remixers and
+		// producers. Indexed by artist so an artist's appearances list fast.
+		Up: func(db *gorm.DB) error {
+			if err := db.Exec(`CREATE TABLE IF NOT EXISTS "auxstream"."track_artists" (
+	track_id uuid
+	NOT NULL,
+	artist_id uuid
+	NOT NULL,
+	role varchar(16)
+	NOT NULL,
+	position integer
+	DEFAULT 0,
+	created_at timestamp,
+	PRIMARY KEY ("track_id", "artist_id", "role"),
+	CONSTRAINT "fk_auxstream.track_artists_track_id_fkey"
+		FOREIGN KEY ("track_id")
+		REFERENCES "auxstream"."tracks"(id)
+		ON DELETE CASCADE,
+	CONSTRAINT "fk_auxstream.track_artists_artist_id_fkey"
+		FOREIGN KEY ("artist_id")
+		REFERENCES "auxstream"."artists"(id)
+		ON DELETE CASCADE
+	);`).Error; err != nil {
+				return err
+			}
+			return db.Exec(`CREATE INDEX IF NOT EXISTS idx_auxstream_track_artists_artist_id
+				ON "auxstream"."track_artists" ("artist_id");`).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Exec(`DROP TABLE IF EXISTS "auxstream"."track_artists";`).Error
+		},
+	})
+}
@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/beesaferoot/gorm-migrate/migration"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migration.RegisterMigration(&migration.Migration{
+		Version:   "20261016210000",
+		Name:      "add_artist_profiles",
+		CreatedAt: time.Now(),
+		// Artist profiles: who manages each artist, and what it says about
+		// itself. Existing artists have no owner, which leaves them to admins.
+		Up: func(db *gorm.DB) error {
+			if err := db.Exec(`ALTER TABLE "auxstream"."artists"
+				ADD COLUMN IF NOT EXISTS owner_id uuid
+				CONSTRAINT "fk_auxstream.artists_owner_id_fkey"
+					REFERENCES "auxstream"."users"(id)
+					ON DELETE SET NULL,
+				ADD COLUMN IF NOT EXISTS bio text,
+				ADD COLUMN IF NOT EXISTS country varchar(2),
+				ADD COLUMN IF NOT EXISTS links jsonb,
+				ADD COLUMN IF NOT EXISTS verified boolean DEFAULT false;`).Error; err != nil {
+				return err
+			}
+			return db.Exec(`CREATE INDEX IF NOT EXISTS idx_auxstream_artists_owner_id
+				ON "auxstream"."artists" ("owner_id");`).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Exec(`ALTER TABLE "auxstream"."artists"
+				DROP COLUMN IF EXISTS owner_id,
+				DROP COLUMN IF EXISTS bio,
+				DROP COLUMN IF EXISTS country,
+				DROP COLUMN IF EXISTS links,
+				DROP COLUMN IF EXISTS verified;`).Error
+		},
+	})
+}
@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/beesaferoot/gorm-migrate/migration"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migration.RegisterMigration(&migration.Migration{
+		Version:   "20261016220000",
+		Name:      "create_blob_removals",
+		CreatedAt: time.Now(),
+		// Blobs left behind by deleted tracks and replaced audio, removed from
+		// the file store by `storage purge` once their grace period is over.
+		// Indexed by that deadline, which is all the purge looks rows up by.
+		Up: func(db *gorm.DB) error {
+			if err := db.Exec(`CREATE TABLE IF NOT EXISTS "auxstream"."blob_removals" (
+	id uuid
+	DEFAULT gen_random_uuid(),
+	file text
+	NOT NULL,
+	remove_after timestamp
+	NOT NULL,
+	created_at timestamp,
+	PRIMARY KEY ("id")
+	);`).Error; err != nil {
+				return err
+			}
+			return db.Exec(`CREATE INDEX IF NOT EXISTS idx_auxstream_blob_removals_remove_after
+				ON "auxstream"."blob_removals" ("remove_after");`).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Exec(`DROP TABLE IF EXISTS "auxstream"."blob_removals";`).Error
+		},
+	})
+}
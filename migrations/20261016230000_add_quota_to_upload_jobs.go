@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/beesaferoot/gorm-migrate/migration"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migration.RegisterMigration(&migration.Migration{
+		Version:   "20261016230000",
+		Name:      "add_quota_to_upload_jobs",
+		CreatedAt: time.Now(),
+		// The uploader's storage quota when a bulk upload was queued, which the
+		// worker charges its tracks against. Existing jobs are unlimited.
+		Up: func(db *gorm.DB) error {
+			return db.Exec(`ALTER TABLE "auxstream"."upload_jobs"
+				ADD COLUMN IF NOT EXISTS quota_bytes bigint DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS quota_tracks integer DEFAULT 0;`).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Exec(`ALTER TABLE "auxstream"."upload_jobs"
+				DROP COLUMN IF EXISTS quota_bytes,
+				DROP COLUMN IF EXISTS quota_tracks;`).Error
+		},
+	})
+}
@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/beesaferoot/gorm-migrate/migration"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migration.RegisterMigration(&migration.Migration{
+		Version:   "20261016233000",
+		Name:      "unique_track_checksums",
+		CreatedAt: time.Now(),
+		// Live tracks hold distinct audio, so two uploads of the same file at
+		// once cannot both create a track. Duplicates uploaded before the
+		// check keep their audio but lose their checksum, leaving the oldest
+		// track the one matched.
+		Up: func(db *gorm.DB) error {
+			if err := db.Exec(`UPDATE "auxstream"."tracks" t SET checksum = ''
+				WHERE t.deleted_at IS NULL AND t.checksum <> ''
+				AND EXISTS (SELECT 1 FROM "auxstream"."tracks" o
+					WHERE o.checksum = t.checksum AND o.deleted_at IS NULL
+					AND (o.created_at, o.id) < (t.created_at, t.id));`).Error; err != nil {
+				return err
+			}
+			return db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_tracks_checksum_live
+				ON "auxstream"."tracks" (checksum) WHERE deleted_at IS NULL AND checksum <> '';`).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Exec(`DROP INDEX IF EXISTS "auxstream"."idx_tracks_checksum_live";`).Error
+		},
+	})
+}
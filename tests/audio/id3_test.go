@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"auxstream/internal/audio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var cwd, _ = os.Getwd()
+var testDataPath = filepath.Join(cwd, "..", "testdata")
+
+func TestRetagMP3ReplacesTitleAndKeepsOtherFrames(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	oldTag := audio.ID3v2Size(bytes.NewReader(src), int64(len(src)))
+
+	retagged, size := audio.RetagMP3(bytes.NewReader(src), int64(len(src)), audio.ID3Tags{
+		Title:       "Renamed",
+		Artwork:     []byte{0xFF, 0xD8, 0xFF, 0xE0},
+		ArtworkMIME: "image/jpeg",
+	})
+	out, err := io.ReadAll(io.NewSectionReader(retagged, 0, size))
+	require.NoError(t, err)
+	require.Equal(t, size, int64(len(out)))
+
+	newTag := audio.ID3v2Size(bytes.NewReader(out), size)
+	tag := out[:newTag]
+	require.Equal(t, byte(3), tag[3], "the source's v2.3 tag version is kept")
+	require.Contains(t, string(tag), "TIT2")
+	require.Contains(t, string(tag), "Renamed")
+	require.NotContains(t, string(tag), "Impact Moderato")
+	require.Contains(t, string(tag), "Kevin MacLeod", "artist was not overridden")
+	require.Contains(t, string(tag), "YouTube Audio Library", "album is carried over")
+	require.Contains(t, string(tag), "APIC")
+
+	require.Equal(t, src[oldTag:], out[newTag:], "audio is passed through untouched")
+}
+
+func TestRetagMP3WithoutExistingTag(t *testing.T) {
+	src := []byte{0xFF, 0xFB, 0x90, 0x64, 1, 2, 3}
+	retagged, size := audio.RetagMP3(bytes.NewReader(src), int64(len(src)), audio.ID3Tags{Artist: "Hike"})
+	out, err := io.ReadAll(io.NewSectionReader(retagged, 0, size))
+	require.NoError(t, err)
+
+	require.Equal(t, "ID3", string(out[:3]))
+	require.Equal(t, byte(4), out[3])
+	require.Contains(t, string(out), "TPE1")
+	require.True(t, bytes.HasSuffix(out, src))
+}
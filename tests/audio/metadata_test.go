@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"auxstream/internal/audio"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadMetadataFixtures(t *testing.T) {
+	for _, format := range []string{"mp3", "ogg", "wav"} {
+		t.Run(format, func(t *testing.T) {
+			src, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio."+format))
+			require.NoError(t, err)
+
+			meta, err := audio.ReadMetadata(bytes.NewReader(src), int64(len(src)), format)
+			require.NoError(t, err)
+			require.Equal(t, "Impact Moderato", meta.Title)
+			require.Equal(t, "Kevin MacLeod", meta.Artist)
+			require.Equal(t, "YouTube Audio Library", meta.Album)
+			require.Greater(t, meta.Duration, 20*time.Second)
+		})
+	}
+}
+
+func TestMP3DurationUsesInfoHeader(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+
+	// The fixture's Info header counts 757 frames of 1152 samples at 32 kHz.
+	d, err := audio.MP3Duration(bytes.NewReader(src), int64(len(src)))
+	require.NoError(t, err)
+	require.Equal(t, 27252*time.Millisecond, d)
+}
+
+func TestReadMetadataFLAC(t *testing.T) {
+	streamInfo := make([]byte, 34)
+	// 44.1 kHz (20 bits), stereo, 16 bits per sample, 441000 samples (36 bits).
+	streamInfo[10], streamInfo[11], streamInfo[12] = 0x0A, 0xC4, 0x42
+	streamInfo[13] = 0xF0
+	binary.BigEndian.PutUint32(streamInfo[14:18], 441000)
+
+	comment := vorbisComment("TITLE=Lake", "ARTIST=Hike", "TRACKNUMBER=4/10", "DISCNUMBER=2/2", "DATE=2019-05-01")
+
+	var src []byte
+	src = append(src, "fLaC"...)
+	src = append(src, flacBlockHeader(0, false, len(streamInfo))...)
+	src = append(src, streamInfo...)
+	src = append(src, flacBlockHeader(4, true, len(comment))...)
+	src = append(src, comment...)
+
+	meta, err := audio.ReadMetadata(bytes.NewReader(src), int64(len(src)), "flac")
+	require.NoError(t, err)
+	require.Equal(t, "Lake", meta.Title)
+	require.Equal(t, "Hike", meta.Artist)
+	require.Equal(t, 4, meta.TrackNumber)
+	require.Equal(t, 2, meta.DiscNumber)
+	require.Equal(t, 2019, meta.Year)
+	require.Equal(t, 10*time.Second, meta.Duration)
+}
+
+func TestReadMetadataSplitsFeaturedArtists(t *testing.T) {
+	comment := vorbisComment("TITLE=Essence (feat. Tems)", "ARTIST=Wizkid ft. Justin Bieber & Tems")
+
+	var src []byte
+	src = append(src, "fLaC"...)
+	src = append(src, flacBlockHeader(0, false, 34)...)
+	src = append(src, make([]byte, 34)...)
+	src = append(src, flacBlockHeader(4, true, len(comment))...)
+	src = append(src, comment...)
+
+	meta, err := audio.ReadMetadata(bytes.NewReader(src), int64(len(src)), "flac")
+	require.NoError(t, err)
+	require.Equal(t, "Essence", meta.Title)
+	require.Equal(t, "Wizkid", meta.Artist)
+	require.Equal(t, []string{"Justin Bieber", "Tems"}, meta.Featured)
+}
+
+func TestSplitFeatured(t *testing.T) {
+	for _, tc := range []struct {
+		in       string
+		rest     string
+		featured []string
+	}{
+		{"Essence (feat. Tems)", "Essence", []string{"Tems"}},
+		{"Essence [Ft Tems] (Remix)", "Essence (Remix)", []string{"Tems"}},
+		{"Ye (featuring Olamide, Phyno & Zlatan)", "Ye", []string{"Olamide", "Phyno", "Zlatan"}},
+		{"Burna Boy feat. Ed Sheeran", "Burna Boy", []string{"Ed Sheeran"}},
+		{"Great Feat of Strength", "Great Feat of Strength", nil},
+		{"Left ft Right", "Left ft Right", nil},
+	} {
+		rest, featured := audio.SplitFeatured(tc.in)
+		require.Equal(t, tc.rest, rest, tc.in)
+		require.Equal(t, tc.featured, featured, tc.in)
+	}
+}
+
+func TestReadMetadataMP4(t *testing.T) {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:16], 1000) // timescale
+	binary.BigEndian.PutUint32(mvhd[16:20], 5500) // duration
+
+	cover := []byte{0xFF, 0xD8, 0xFF, 0xE0}
+	ilst := mp4Box("ilst",
+		mp4Box("\xa9nam", mp4Data(1, []byte("Lake"))),
+		mp4Box("\xa9ART", mp4Data(1, []byte("Hike"))),
+		mp4Box("trkn", mp4Data(0, []byte{0, 0, 0, 7, 0, 12, 0, 0})),
+		mp4Box("disk", mp4Data(0, []byte{0, 0, 0, 1, 0, 2})),
+		mp4Box("covr", mp4Data(13, cover)),
+	)
+	meta := mp4Box("meta", []byte{0, 0, 0, 0}, mp4Box("hdlr", make([]byte, 25)), ilst)
+	src := append(mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00")),
+		mp4Box("moov", mp4Box("mvhd", mvhd), mp4Box("udta", meta))...)
+
+	md, err := audio.ReadMetadata(bytes.NewReader(src), int64(len(src)), "m4a")
+	require.NoError(t, err)
+	require.Equal(t, "Lake", md.Title)
+	require.Equal(t, "Hike", md.Artist)
+	require.Equal(t, 7, md.TrackNumber)
+	require.Equal(t, 1, md.DiscNumber)
+	require.Equal(t, 5500*time.Millisecond, md.Duration)
+	require.Equal(t, cover, md.Artwork)
+	require.Equal(t, "image/jpeg", md.ArtworkMIME)
+}
+
+func flacBlockHeader(blockType byte, last bool, n int) []byte {
+	if last {
+		blockType |= 0x80
+	}
+	return []byte{blockType, byte(n >> 16), byte(n >> 8), byte(n)}
+}
+
+func vorbisComment(comments ...string) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, 4)
+	b = append(b, "test"...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(comments)))
+	for _, c := range comments {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(c)))
+		b = append(b, c...)
+	}
+	return b
+}
+
+func mp4Box(typ string, children ...[]byte) []byte {
+	var body []byte
+	for _, c := range children {
+		body = append(body, c...)
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	b = append(b, typ...)
+	return append(b, body...)
+}
+
+func mp4Data(dataType uint32, value []byte) []byte {
+	body := binary.BigEndian.AppendUint32(nil, dataType)
+	body = append(body, 0, 0, 0, 0) // locale
+	return mp4Box("data", append(body, value...))
+}
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"auxstream/internal/audio"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func validate(src []byte, format string) error {
+	return audio.Validate(bytes.NewReader(src), int64(len(src)), format)
+}
+
+func TestValidateAcceptsFixtures(t *testing.T) {
+	for _, format := range []string{"mp3", "ogg", "wav"} {
+		t.Run(format, func(t *testing.T) {
+			src, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio."+format))
+			require.NoError(t, err)
+			require.NoError(t, validate(src, format))
+		})
+	}
+}
+
+func TestValidateRejectsTruncatedFixtures(t *testing.T) {
+	for _, format := range []string{"mp3", "ogg", "wav"} {
+		t.Run(format, func(t *testing.T) {
+			src, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio."+format))
+			require.NoError(t, err)
+
+			err = validate(src[:len(src)*2/3], format)
+			var corrupt *audio.CorruptError
+			require.ErrorAs(t, err, &corrupt)
+			require.Contains(t, corrupt.Reason, "truncated")
+		})
+	}
+}
+
+func TestValidateRejectsDamagedOggPage(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.ogg"))
+	require.NoError(t, err)
+
+	damaged := bytes.Clone(src)
+	damaged[len(damaged)/2] ^= 0xFF
+	require.ErrorContains(t, validate(damaged, "ogg"), "checksum")
+}
+
+func TestValidateRejectsWAVWithoutFormat(t *testing.T) {
+	var src []byte
+	src = append(src, "RIFF"...)
+	src = binary.LittleEndian.AppendUint32(src, 4+8+4)
+	src = append(src, "WAVE"...)
+	src = append(src, "data"...)
+	src = binary.LittleEndian.AppendUint32(src, 4)
+	src = append(src, 0, 0, 0, 0)
+
+	require.ErrorContains(t, validate(src, "wav"), "format chunk")
+}
+
+func TestValidateRejectsFLACWithoutAudio(t *testing.T) {
+	streamInfo := make([]byte, 34)
+	var src []byte
+	src = append(src, "fLaC"...)
+	src = append(src, flacBlockHeader(0, true, len(streamInfo))...)
+	src = append(src, streamInfo...)
+
+	require.ErrorContains(t, validate(src, "flac"), "no audio frame")
+}
+
+func TestValidateMP4SampleTable(t *testing.T) {
+	build := func(chunkOffset uint32) []byte {
+		full := func(b []byte) []byte { return append([]byte{0, 0, 0, 0}, b...) }
+		stco := full(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 1), chunkOffset))
+		stsz := full(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 100), 4))
+		stsc := full(binary.BigEndian.AppendUint32(nil, 1))
+		stsc = binary.BigEndian.AppendUint32(stsc, 1) // first chunk
+		stsc = binary.BigEndian.AppendUint32(stsc, 4) // samples per chunk
+		stsc = binary.BigEndian.AppendUint32(stsc, 1) // sample description
+
+		ftyp := mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00"))
+		stbl := mp4Box("stbl", mp4Box("stsc", stsc), mp4Box("stsz", stsz), mp4Box("stco", stco))
+		moov := mp4Box("moov", mp4Box("trak", mp4Box("mdia", mp4Box("minf", stbl))))
+		return append(append(ftyp, moov...), mp4Box("mdat", make([]byte, 400))...)
+	}
+
+	src := build(0)
+	mdat := uint32(len(src) - 400)
+	require.NoError(t, validate(build(mdat), "m4a"))
+
+	// Four 100-byte samples starting 200 bytes into mdat overrun the file.
+	err := validate(build(mdat+200), "m4a")
+	require.ErrorContains(t, err, "truncated")
+}
@@ -0,0 +1,56 @@
+package tests
+
+import (
+	"auxstream/internal/auth"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamTokenRoundTrip(t *testing.T) {
+	svc := auth.NewStreamTokenService("secret", time.Hour)
+	trackID, userID := uuid.New(), uuid.New()
+
+	token, expiresAt := svc.GenerateStreamToken(trackID, userID)
+	claims, err := svc.ValidateStreamToken(token, trackID)
+	require.NoError(t, err)
+	require.Equal(t, trackID, claims.TrackID)
+	require.Equal(t, userID, claims.UserID)
+	require.Equal(t, expiresAt.Unix(), claims.ExpiresAt.Unix())
+}
+
+func TestStreamTokenRejectsOtherTrack(t *testing.T) {
+	svc := auth.NewStreamTokenService("secret", time.Hour)
+	token, _ := svc.GenerateStreamToken(uuid.New(), uuid.Nil)
+
+	_, err := svc.ValidateStreamToken(token, uuid.New())
+	require.ErrorIs(t, err, auth.ErrInvalidStreamToken)
+}
+
+func TestStreamTokenRejectsTampering(t *testing.T) {
+	trackID := uuid.New()
+	token, _ := auth.NewStreamTokenService("secret", time.Hour).GenerateStreamToken(trackID, uuid.Nil)
+
+	// A different key must not verify the token.
+	_, err := auth.NewStreamTokenService("other", time.Hour).ValidateStreamToken(token, trackID)
+	require.ErrorIs(t, err, auth.ErrInvalidStreamToken)
+
+	// Nor may the payload be swapped under the original signature.
+	other, _ := auth.NewStreamTokenService("secret", time.Hour).GenerateStreamToken(uuid.New(), uuid.Nil)
+	payload, _, _ := strings.Cut(other, ".")
+	_, sig, _ := strings.Cut(token, ".")
+	_, err = auth.NewStreamTokenService("secret", time.Hour).ValidateStreamToken(payload+"."+sig, trackID)
+	require.ErrorIs(t, err, auth.ErrInvalidStreamToken)
+}
+
+func TestStreamTokenExpires(t *testing.T) {
+	svc := auth.NewStreamTokenService("secret", -time.Minute)
+	trackID := uuid.New()
+	token, _ := svc.GenerateStreamToken(trackID, uuid.Nil)
+
+	_, err := svc.ValidateStreamToken(token, trackID)
+	require.ErrorIs(t, err, auth.ErrExpiredStreamToken)
+}
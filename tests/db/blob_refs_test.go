@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"auxstream/internal/db"
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{})
+	require.NoError(t, err)
+	return gormDB, mock
+}
+
+func TestEachBlobRefIncludesAlbumImages(t *testing.T) {
+	gormDB, mock := newMockDB(t)
+	albumID := uuid.New()
+
+	mock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT .* FROM "auxstream"\."track_files"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT .* FROM "auxstream"\."playlists"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT "id","images","deleted_at" FROM "auxstream"\."albums" WHERE images IS NOT NULL`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "images", "deleted_at"}).
+			AddRow(albumID, []byte(`{"640":"large.jpg","300":"small.jpg"}`), nil))
+
+	var refs []db.BlobRef
+	require.NoError(t, db.NewBlobRefRepo(gormDB).EachBlobRef(context.Background(), func(ref db.BlobRef) error {
+		refs = append(refs, ref)
+		return nil
+	}))
+	require.Equal(t, []db.BlobRef{
+		{Owner: db.BlobOwnerAlbumImage, ID: albumID, Variant: "300", File: "small.jpg"},
+		{Owner: db.BlobOwnerAlbumImage, ID: albumID, Variant: "640", File: "large.jpg"},
+	}, refs)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetBlobFileRepointsAlbumImage(t *testing.T) {
+	gormDB, mock := newMockDB(t)
+	albumID := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "auxstream"\."albums" SET "images"=jsonb_set\(images, ARRAY\[\$1\]::text\[\], to_jsonb\(\$2::text\)\),"updated_at"=\$3 WHERE id = \$4`).
+		WithArgs("640", "moved.jpg", sqlmock.AnyArg(), albumID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	ref := db.BlobRef{Owner: db.BlobOwnerAlbumImage, ID: albumID, Variant: "640", File: "large.jpg"}
+	require.NoError(t, db.NewBlobRefRepo(gormDB).SetBlobFile(context.Background(), ref, "moved.jpg"))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBlobInUseChecksAlbumImages(t *testing.T) {
+	gormDB, mock := newMockDB(t)
+
+	// A live album's cover keeps the blob.
+	mock.ExpectQuery(`FROM auxstream\.albums al WHERE al\.deleted_at IS NULL\s+AND EXISTS \(SELECT 1 FROM jsonb_each_text\(al\.images\) i WHERE i\.value = \$\d+\)\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	inUse, err := db.NewBlobRemovalRepo(gormDB).BlobInUse(context.Background(), "cover.jpg")
+	require.NoError(t, err)
+	require.True(t, inUse)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHoldBlobRemovalLocksAndRechecks(t *testing.T) {
+	gormDB, mock := newMockDB(t)
+	id := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "auxstream"\."blob_removals" WHERE id = \$1 LIMIT \$2 FOR UPDATE`).
+		WithArgs(id, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "file"}).AddRow(id, "gone.mp3"))
+	mock.ExpectQuery(`SELECT\s+EXISTS \(SELECT 1 FROM auxstream\.tracks`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(`DELETE FROM "auxstream"\."blob_removals" WHERE id = \$1`).
+		WithArgs(id).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	var held []bool
+	require.NoError(t, db.NewBlobRemovalRepo(gormDB).HoldBlobRemoval(context.Background(), id, func(scheduled, inUse bool) bool {
+		held = append(held, scheduled, inUse)
+		return true
+	}))
+	require.Equal(t, []bool{true, false}, held)
+
+	// A removal cancelled meanwhile is reported as no longer scheduled.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "auxstream"\."blob_removals" WHERE id = \$1 LIMIT \$2 FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "file"}))
+	mock.ExpectCommit()
+
+	held = nil
+	require.NoError(t, db.NewBlobRemovalRepo(gormDB).HoldBlobRemoval(context.Background(), id, func(scheduled, inUse bool) bool {
+		held = append(held, scheduled, inUse)
+		return true
+	}))
+	require.Equal(t, []bool{false, false}, held)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,37 @@
+package fakes
+
+import (
+	"auxstream/internal/db"
+	"context"
+)
+
+// BlobRefs is a BlobRefRepo over a fixed list, which SetBlobFile edits in
+// place.
+type BlobRefs []db.BlobRef
+
+func (r BlobRefs) EachBlobRef(_ context.Context, fn func(db.BlobRef) error) error {
+	for _, ref := range r {
+		if err := fn(ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r BlobRefs) SetBlobFile(_ context.Context, ref db.BlobRef, file string) error {
+	for i := range r {
+		if r[i].Owner == ref.Owner && r[i].ID == ref.ID && r[i].Variant == ref.Variant {
+			r[i].File = file
+		}
+	}
+	return nil
+}
+
+func (r BlobRefs) SetBlobFiles(ctx context.Context, moves []db.BlobMove) error {
+	for _, m := range moves {
+		if err := r.SetBlobFile(ctx, m.Ref, m.File); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package fakes
+
+import (
+	"auxstream/internal/db"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BlobRemovals is a BlobRemovalRepo over a list of scheduled removals, which
+// HoldBlobRemoval drops from. InUse names the blobs live rows refer to.
+type BlobRemovals struct {
+	Scheduled []db.BlobRemoval
+	InUse     map[string]bool
+}
+
+func (r *BlobRemovals) EachDueBlobRemoval(_ context.Context, now time.Time, fn func(db.BlobRemoval) error) error {
+	for _, removal := range slices.Clone(r.Scheduled) {
+		if removal.RemoveAfter.After(now) {
+			continue
+		}
+		if err := fn(removal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *BlobRemovals) ScheduleBlobRemovals(_ context.Context, files []string) error {
+	for _, file := range files {
+		r.Scheduled = append(r.Scheduled, db.BlobRemoval{ID: uuid.New(), File: file, RemoveAfter: time.Now().Add(db.BlobRemovalGrace)})
+	}
+	return nil
+}
+
+func (r *BlobRemovals) BlobInUse(_ context.Context, file string) (bool, error) {
+	return r.InUse[file], nil
+}
+
+func (r *BlobRemovals) HoldBlobRemoval(_ context.Context, id uuid.UUID, fn func(scheduled, inUse bool) bool) error {
+	i := slices.IndexFunc(r.Scheduled, func(removal db.BlobRemoval) bool { return removal.ID == id })
+	if i < 0 {
+		fn(false, false)
+		return nil
+	}
+	if fn(true, r.InUse[r.Scheduled[i].File]) {
+		r.Scheduled = slices.Delete(r.Scheduled, i, i+1)
+	}
+	return nil
+}
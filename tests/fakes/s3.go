@@ -0,0 +1,170 @@
+// Package fakes holds in-process stand-ins for the services tests would
+// otherwise need running.
+package fakes
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// S3 is just enough of an S3-compatible store, addressed path-style as MinIO
+// is, for what S3Store does with single-part objects: PUT (including
+// server-side copies and the x-amz-checksum-sha256 check), HEAD, ranged GET,
+// DELETE and ListObjectsV2.
+type S3 struct {
+	*httptest.Server
+	bucket   string
+	mu       sync.Mutex
+	objects  map[string][]byte
+	requests map[string]int // by method
+}
+
+// NewS3 serves an empty bucket until the test ends, with credentials for it in
+// the environment.
+func NewS3(t *testing.T, bucket string) *S3 {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test-secret")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	f := &S3{bucket: bucket, objects: map[string][]byte{}, requests: map[string]int{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Object returns what the bucket holds under key.
+func (f *S3) Object(key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, ok := f.objects[key]
+	return body, ok
+}
+
+// Requests counts the requests made with method.
+func (f *S3) Requests(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[method]
+}
+
+// Put stores body under key, as if uploaded.
+func (f *S3) Put(key string, body []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = body
+}
+
+func (f *S3) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests[r.Method]++
+	f.mu.Unlock()
+	if r.Method == http.MethodGet && r.URL.Path == "/"+f.bucket && r.URL.Query().Get("list-type") == "2" {
+		f.list(w, r.URL.Query().Get("prefix"))
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket+"/")
+	if !ok {
+		s3Error(w, http.StatusBadRequest, "InvalidRequest", "expected a path-style request")
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		if src := r.Header.Get("X-Amz-Copy-Source"); src != "" {
+			src, _ = url.PathUnescape(src)
+			body, ok := f.objects[strings.TrimPrefix(strings.TrimPrefix(src, "/"), f.bucket+"/")]
+			if !ok {
+				s3Error(w, http.StatusNotFound, "NoSuchKey", "copy source does not exist")
+				return
+			}
+			f.objects[key] = body
+			fmt.Fprint(w, `<CopyObjectResult><ETag>"etag"</ETag></CopyObjectResult>`)
+			return
+		}
+		if _, exists := f.objects[key]; exists && r.Header.Get("If-None-Match") == "*" {
+			s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed", "object already exists")
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if want := r.Header.Get("X-Amz-Checksum-Sha256"); want != "" {
+			sum := sha256.Sum256(body)
+			if base64.StdEncoding.EncodeToString(sum[:]) != want {
+				s3Error(w, http.StatusBadRequest, "BadDigest", "checksum does not match")
+				return
+			}
+		}
+		f.objects[key] = body
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodHead:
+		body, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sum := sha256.Sum256(body)
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Header.Get("X-Amz-Checksum-Mode") == "ENABLED" {
+			w.Header().Set("X-Amz-Checksum-Sha256", base64.StdEncoding.EncodeToString(sum[:]))
+		}
+	case http.MethodGet:
+		body, ok := f.objects[key]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey", "no such key")
+			return
+		}
+		start, end := 0, len(body)-1
+		if n, _ := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); n > 0 {
+			end = min(end, len(body)-1)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(body)))
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(body[start : end+1])
+			return
+		}
+		_, _ = w.Write(body)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+// list answers a ListObjectsV2 request in one page.
+func (f *S3) list(w http.ResponseWriter, prefix string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>%s</Name><Prefix>%s</Prefix><KeyCount>%d</KeyCount><IsTruncated>false</IsTruncated>`,
+		f.bucket, prefix, len(keys))
+	for _, key := range keys {
+		fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size><LastModified>%s</LastModified></Contents>`,
+			key, len(f.objects[key]), time.Now().UTC().Format(time.RFC3339))
+	}
+	fmt.Fprint(w, `</ListBucketResult>`)
+}
+
+func s3Error(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, msg)
+}
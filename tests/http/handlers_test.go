@@ -1,16 +1,30 @@
 package tests
 
 import (
+	"auxstream/internal/auth"
 	"auxstream/internal/cache"
 	"auxstream/internal/http"
+	"auxstream/internal/http/handlers"
 	fs "auxstream/internal/storage"
+	"auxstream/tests/fakes"
+	"bytes"
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
 	"log"
+	"mime/multipart"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -33,6 +47,7 @@ var mockDB *sql.DB
 var sqlMock sqlmock.Sqlmock
 var router *gin.Engine
 var gormDB *gorm.DB
+var redisServer *miniredis.Miniredis
 
 func setupTest(_ *testing.T) func(t *testing.T) {
 	var err error
@@ -51,9 +66,9 @@ func setupTest(_ *testing.T) func(t *testing.T) {
 		log.Fatalf("Failed to create GORM mock DB: %v", err)
 	}
 
-	mr, _ := miniredis.Run()
+	redisServer, _ = miniredis.Run()
 	opts := &redis.Options{
-		Addr: mr.Addr(),
+		Addr: redisServer.Addr(),
 	}
 	r := cache.NewRedis(opts)
 	server := http.NewMockServer(gormDB, r)
@@ -73,6 +88,10 @@ func TestHTTPAddTrack(t *testing.T) {
 	artistID := uuid.New()
 	trackID := uuid.New()
 
+	// No track holds this audio yet.
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks" WHERE checksum = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
 	// Mock artist lookup
 	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists"`).
 		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
@@ -82,6 +101,9 @@ func TestHTTPAddTrack(t *testing.T) {
 	// Mock track creation
 	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."tracks"`).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(trackID))
+	// Any removal a delete scheduled for the same content is cancelled.
+	sqlMock.ExpectExec(`DELETE FROM "auxstream"\."blob_removals" WHERE file IN`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
 	sqlMock.ExpectCommit()
 
 	// Mock real store with test store
@@ -121,9 +143,8 @@ func TestHTTPSearchByArtist(t *testing.T) {
 
 	artistID := uuid.New()
 
-	// Expect Preloaded artist
-	// Mock the JOIN query for artist search
-	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks" JOIN auxstream.artists`).
+	// Artists match as the track's own or as credited on it.
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks" WHERE \(EXISTS \(SELECT 1 FROM auxstream\.artists a WHERE a\.name ILIKE \$1 AND \(a\.id = tracks\.artist_id OR\s+EXISTS \(SELECT 1 FROM auxstream\.track_artists`).
 		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "file", "created_at", "updated_at"}).
 			AddRow(uuid.New(), "Title", artistID, "Test file", time.Now(), time.Now()).
 			AddRow(uuid.New(), "Title", artistID, "Test file", time.Now(), time.Now()).
@@ -149,15 +170,17 @@ func TestHTTPTrackUploadBatch(t *testing.T) {
 	defer teardown(t)
 	testRecordCnt := 30
 
-	// Mock batch insert: CreateInBatches issues one INSERT ... RETURNING "id",
-	// and GORM scans one returned id per row, so return a UUID for each track.
-	insertedIDs := sqlmock.NewRows([]string{"id"})
+	// The job and its files are recorded in one transaction; nothing is
+	// validated or stored until a worker takes the job.
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."upload_jobs"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	fileIDs := sqlmock.NewRows([]string{"id"})
 	for range testRecordCnt {
-		insertedIDs.AddRow(uuid.New())
+		fileIDs.AddRow(uuid.New())
 	}
-	sqlMock.ExpectBegin()
-	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."tracks" \(.+\) VALUES .+ RETURNING "id"`).
-		WillReturnRows(insertedIDs)
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."upload_job_files" \(.+\) VALUES .+ RETURNING "id"`).
+		WillReturnRows(fileIDs)
 	sqlMock.ExpectCommit()
 
 	fs.Store = fs.NewLocalStore(os.TempDir())
@@ -168,6 +191,7 @@ func TestHTTPTrackUploadBatch(t *testing.T) {
 	audioFilePath := filepath.Join(testDataPath, "audio", "audio.mp3")
 
 	tserver := httptest.NewServer(router)
+	defer tserver.Close()
 
 	for range testRecordCnt {
 		file, err := os.Open(audioFilePath)
@@ -190,16 +214,62 @@ func TestHTTPTrackUploadBatch(t *testing.T) {
 	data := &map[string]any{}
 	err = post.ToJSON(data)
 	require.NoError(t, err)
-	require.Equal(t, 200, post.Response().StatusCode)
+	require.Equal(t, 202, post.Response().StatusCode)
+	require.Equal(t, 0, fs.Store.Writes())
 
-	// Every uploaded file shares the filename "audio"; the bulk path must still
-	// persist all of them by correlating on title, not filename.
-	require.Equal(t, float64(testRecordCnt), (*data)["data"].(map[string]any)["rows"])
+	// Every uploaded file shares the filename "audio"; the job must still keep
+	// all of them, correlated by position and title.
+	job := (*data)["data"].(map[string]any)
+	require.Equal(t, "queued", job["status"])
+	require.Equal(t, "/api/v1/uploads/"+job["id"].(string), post.Response().Header.Get("Location"))
+	files := job["files"].([]any)
+	require.Len(t, files, testRecordCnt)
+	for i, f := range files {
+		require.Equal(t, float64(i), f.(map[string]any)["index"])
+		require.Equal(t, fmt.Sprintf("#%d", i), f.(map[string]any)["title"])
+		require.Equal(t, "queued", f.(map[string]any)["status"])
+	}
 
 	// Ensure all expectations were met
 	require.NoError(t, sqlMock.ExpectationsWereMet())
 }
 
+func TestHTTPUploadJobStatus(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	jobID := uuid.New()
+	trackID := uuid.New()
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."upload_jobs" WHERE id = \$1`).
+		WithArgs(jobID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "attempts", "created_at", "updated_at"}).
+			AddRow(jobID, "done", 1, time.Now(), time.Now()))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."upload_job_files" WHERE "upload_job_files"\."job_id" = \$1 ORDER BY position`).
+		WithArgs(jobID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "job_id", "position", "filename", "status", "error", "track_id"}).
+			AddRow(uuid.New(), jobID, 0, "good.mp3", "saved", "", trackID).
+			AddRow(uuid.New(), jobID, 1, "cut.mp3", "rejected", "corrupt audio: truncated: last frame cut short", nil))
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	res, err := req.Get(tserver.URL + "/uploads/" + jobID.String())
+	require.NoError(t, err)
+	require.Equal(t, 200, res.Response().StatusCode)
+
+	data := &map[string]any{}
+	require.NoError(t, res.ToJSON(data))
+	job := (*data)["data"].(map[string]any)
+	require.Equal(t, "done", job["status"])
+	files := job["files"].([]any)
+	require.Len(t, files, 2)
+	require.Equal(t, trackID.String(), files[0].(map[string]any)["track_id"])
+	require.Equal(t, "rejected", files[1].(map[string]any)["status"])
+	require.Contains(t, files[1].(map[string]any)["error"], "truncated")
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
 func TestHTTPFetchTracks(t *testing.T) {
 	teardown := setupTest(t)
 	defer teardown(t)
@@ -236,3 +306,1712 @@ func TestHTTPFetchTracks(t *testing.T) {
 	// Ensure all expectations were met
 	require.NoError(t, sqlMock.ExpectationsWereMet())
 }
+
+func TestHTTPStreamTrackRange(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	fs.Store = fs.NewLocalStore(os.TempDir())
+	audioBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	fileName, err := fs.Store.Save(audioBytes, "mp3")
+	require.NoError(t, err)
+	defer fs.Store.Remove(fileName)
+
+	artistID := uuid.New()
+	trackID := uuid.New()
+
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."tracks"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "file", "created_at", "updated_at"}).
+			AddRow(trackID, "Title", artistID, fileName, time.Now(), time.Now()))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(artistID, "Hike", time.Now(), time.Now()))
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	// Signed with the same secret NewMockServer configures.
+	token, _ := auth.NewStreamTokenService("test-secret", time.Hour).GenerateStreamToken(trackID, uuid.Nil)
+	resp, err := req.Get(tserver.URL+"/tracks/"+trackID.String()+"/stream",
+		req.Header{"Range": "bytes=0-99"}, req.QueryParam{"token": token})
+	require.NoError(t, err)
+	require.Equal(t, 206, resp.Response().StatusCode)
+	require.Equal(t, "audio/mpeg", resp.Response().Header.Get("Content-Type"))
+	require.Equal(t, "bytes", resp.Response().Header.Get("Accept-Ranges"))
+	require.NotEmpty(t, resp.Response().Header.Get("ETag"))
+	require.Equal(t, fmt.Sprintf("bytes 0-99/%d", len(audioBytes)), resp.Response().Header.Get("Content-Range"))
+	require.Equal(t, audioBytes[:100], resp.Bytes())
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPStreamTrackFetchesOnlyTheRange(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	bucket := fakes.NewS3(t, "tracks")
+	fs.Store = fs.NewS3Store(fs.S3Config{Bucket: "tracks", Endpoint: bucket.URL, ForcePathStyle: true})
+	audioBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	fileName, err := fs.Store.Save(audioBytes, "mp3")
+	require.NoError(t, err)
+
+	artistID := uuid.New()
+	trackID := uuid.New()
+	for range 2 {
+		sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."tracks"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "file", "created_at", "updated_at"}).
+				AddRow(trackID, "Title", artistID, fileName, time.Now(), time.Now()))
+		sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."artists"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+				AddRow(artistID, "Hike", time.Now(), time.Now()))
+	}
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+	token, _ := auth.NewStreamTokenService("test-secret", time.Hour).GenerateStreamToken(trackID, uuid.Nil)
+	streamURL := tserver.URL + "/tracks/" + trackID.String() + "/stream"
+
+	head, err := req.Head(streamURL, req.QueryParam{"token": token})
+	require.NoError(t, err)
+	require.Equal(t, 200, head.Response().StatusCode)
+	require.Equal(t, strconv.Itoa(len(audioBytes)), head.Response().Header.Get("Content-Length"))
+	require.Equal(t, 0, bucket.Requests("GET"))
+
+	resp, err := req.Get(streamURL, req.Header{"Range": "bytes=100-199"}, req.QueryParam{"token": token})
+	require.NoError(t, err)
+	require.Equal(t, 206, resp.Response().StatusCode)
+	require.Equal(t, audioBytes[100:200], resp.Bytes())
+	require.Equal(t, 1, bucket.Requests("GET"))
+	require.Equal(t, 0, fs.Store.Reads()) // never downloaded whole
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPStreamTrackRejectsMissingToken(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	resp, err := req.Get(tserver.URL + "/tracks/" + uuid.New().String() + "/stream")
+	require.NoError(t, err)
+	require.Equal(t, 403, resp.Response().StatusCode)
+
+	// Rejected before any lookup: no query may reach the database.
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPHLSPlaylistCarriesToken(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	fs.Store = fs.NewLocalStore(os.TempDir())
+	playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.000,\noriginal/0.mp3\n#EXT-X-ENDLIST\n"
+	fileName, err := fs.Store.Save([]byte(playlist), "m3u8")
+	require.NoError(t, err)
+	defer fs.Store.Remove(fileName)
+
+	trackID := uuid.New()
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."track_files"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "track_id", "kind", "rendition", "sequence", "file"}).
+			AddRow(uuid.New(), trackID, "hls_playlist", "original", 0, fileName))
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	token, _ := auth.NewStreamTokenService("test-secret", time.Hour).GenerateStreamToken(trackID, uuid.Nil)
+	resp, err := req.Get(tserver.URL+"/tracks/"+trackID.String()+"/hls/index.m3u8", req.QueryParam{"token": token})
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.Response().StatusCode)
+	require.Equal(t, "application/vnd.apple.mpegurl", resp.Response().Header.Get("Content-Type"))
+	require.Contains(t, resp.String(), "\noriginal/0.mp3?token="+url.QueryEscape(token)+"\n")
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPStreamTrackQualityPicksRendition(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	fs.Store = fs.NewLocalStore(os.TempDir())
+	original, err := fs.Store.Save([]byte("original audio"), "mp3")
+	require.NoError(t, err)
+	defer fs.Store.Remove(original)
+	rendition, err := fs.Store.Save([]byte("low rendition"), "m4a")
+	require.NoError(t, err)
+	defer fs.Store.Remove(rendition)
+
+	artistID := uuid.New()
+	trackID := uuid.New()
+
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."tracks"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "file", "created_at", "updated_at"}).
+			AddRow(trackID, "Title", artistID, original, time.Now(), time.Now()))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(artistID, "Hike", time.Now(), time.Now()))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."track_files"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "track_id", "kind", "rendition", "sequence", "file", "created_at"}).
+			AddRow(uuid.New(), trackID, "audio", "low", 0, rendition, time.Now()))
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	token, _ := auth.NewStreamTokenService("test-secret", time.Hour).GenerateStreamToken(trackID, uuid.Nil)
+	resp, err := req.Get(tserver.URL+"/tracks/"+trackID.String()+"/stream",
+		req.QueryParam{"token": token, "quality": "low"})
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.Response().StatusCode)
+	require.Equal(t, "audio/mp4", resp.Response().Header.Get("Content-Type"))
+	require.Equal(t, "low rendition", resp.String())
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPStreamTrackRejectsUnknownQuality(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	trackID := uuid.New()
+	token, _ := auth.NewStreamTokenService("test-secret", time.Hour).GenerateStreamToken(trackID, uuid.Nil)
+	resp, err := req.Get(tserver.URL+"/tracks/"+trackID.String()+"/stream",
+		req.QueryParam{"token": token, "quality": "lossless"})
+	require.NoError(t, err)
+	require.Equal(t, 400, resp.Response().StatusCode)
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPDownloadTrackRetagsMP3(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	fs.Store = fs.NewLocalStore(os.TempDir())
+	audioBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	fileName, err := fs.Store.Save(audioBytes, "mp3")
+	require.NoError(t, err)
+	defer fs.Store.Remove(fileName)
+
+	artistID := uuid.New()
+	trackID := uuid.New()
+
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."tracks"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "file", "downloadable", "created_at", "updated_at"}).
+			AddRow(trackID, "Night Drive", artistID, fileName, true, time.Now(), time.Now()))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(artistID, "Hike", time.Now(), time.Now()))
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	resp, err := req.Get(tserver.URL + "/tracks/" + trackID.String() + "/download")
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.Response().StatusCode)
+	require.Equal(t, `attachment; filename="Hike - Night Drive.mp3"`, resp.Response().Header.Get("Content-Disposition"))
+	require.Equal(t, "audio/mpeg", resp.Response().Header.Get("Content-Type"))
+	body := resp.Bytes()
+	require.Equal(t, "ID3", string(body[:3]))
+	require.Contains(t, string(body[:256]), "Night Drive")
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPDownloadTrackRequiresDownloadable(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	artistID := uuid.New()
+	trackID := uuid.New()
+
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."tracks"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "file", "downloadable", "created_at", "updated_at"}).
+			AddRow(trackID, "Night Drive", artistID, "file.mp3", false, time.Now(), time.Now()))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(artistID, "Hike", time.Now(), time.Now()))
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	resp, err := req.Get(tserver.URL + "/tracks/" + trackID.String() + "/download")
+	require.NoError(t, err)
+	require.Equal(t, 403, resp.Response().StatusCode)
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPAddTrackFillsFieldsFromTags(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	artistID := uuid.New()
+	trackID := uuid.New()
+
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks" WHERE checksum = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	// No artist_id: the artist named by the file's tags is looked up (or
+	// created) under the lock on its name.
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`SELECT pg_advisory_xact_lock\(hashtext\(\$1\)\)`).
+		WithArgs("auxstream.artists.name:Kevin MacLeod").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists" WHERE name = \$1`).
+		WithArgs("Kevin MacLeod", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(artistID, "Kevin MacLeod", time.Now(), time.Now()))
+	sqlMock.ExpectCommit()
+
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."tracks"`).
+		WithArgs("Impact Moderato", artistID, sqlmock.AnyArg(), 27, "", nil, 0, false, sqlmock.AnyArg(), sqlmock.AnyArg(),
+			nil, nil, 0, 0, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(trackID))
+	sqlMock.ExpectExec(`DELETE FROM "auxstream"\."blob_removals" WHERE file IN`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectCommit()
+
+	fs.Store = fs.NewLocalStore(os.TempDir())
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	file, err := os.Open(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+
+	post, err := req.Post(tserver.URL+"/upload_track", req.FileUpload{
+		FieldName: "audio",
+		File:      file,
+		FileName:  "audio.mp3",
+	})
+	require.NoError(t, err)
+	require.Equal(t, 200, post.Response().StatusCode)
+
+	data := &map[string]any{}
+	require.NoError(t, post.ToJSON(data))
+	metadata := (*data)["metadata"].(map[string]any)
+	require.Equal(t, "YouTube Audio Library", metadata["album"])
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPAddTrackReturnsExistingDuplicate(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	audioBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	artistID := uuid.New()
+	trackID := uuid.New()
+
+	// The same bytes were uploaded before: that track comes back, nothing is
+	// stored or inserted.
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks" WHERE checksum = \$1`).
+		WithArgs(fs.Checksum(audioBytes), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "file", "checksum", "created_at", "updated_at"}).
+			AddRow(trackID, "Impact Moderato", artistID, fs.ContentName(audioBytes, "mp3"), fs.Checksum(audioBytes), time.Now(), time.Now()))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(artistID, "Kevin MacLeod", time.Now(), time.Now()))
+
+	fs.Store = fs.NewLocalStore(os.TempDir())
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	post, err := req.Post(tserver.URL+"/upload_track", req.FileUpload{
+		FieldName: "audio",
+		File:      io.NopCloser(bytes.NewReader(audioBytes)),
+		FileName:  "again.mp3",
+	})
+	require.NoError(t, err)
+	require.Equal(t, 200, post.Response().StatusCode)
+	require.Equal(t, 0, fs.Store.Writes())
+
+	data := &map[string]any{}
+	require.NoError(t, post.ToJSON(data))
+	require.Equal(t, true, (*data)["duplicate"])
+	require.Equal(t, trackID.String(), (*data)["data"].(map[string]any)["id"])
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPAddTrackLosingRaceReturnsDuplicate(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	audioBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	artistID := uuid.New()
+	trackID := uuid.New()
+
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks" WHERE checksum = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(artistID, "Hike", time.Now(), time.Now()))
+	// Another upload of the same audio created its track meanwhile.
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."tracks"`).
+		WillReturnError(errors.New(`ERROR: duplicate key value violates unique constraint "idx_tracks_checksum_live" (SQLSTATE 23505)`))
+	sqlMock.ExpectRollback()
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."blob_removals"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	sqlMock.ExpectCommit()
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks" WHERE checksum = \$1`).
+		WithArgs(fs.Checksum(audioBytes), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "file", "checksum", "created_at", "updated_at"}).
+			AddRow(trackID, "Impact Moderato", artistID, fs.ContentName(audioBytes, "mp3"), fs.Checksum(audioBytes), time.Now(), time.Now()))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(artistID, "Hike", time.Now(), time.Now()))
+
+	fs.Store = fs.NewLocalStore(t.TempDir())
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	post, err := req.Post(tserver.URL+"/upload_track",
+		req.Param{"title": "Raced", "artist_id": artistID.String()},
+		req.FileUpload{FieldName: "audio", File: io.NopCloser(bytes.NewReader(audioBytes)), FileName: "audio.mp3"})
+	require.NoError(t, err)
+	require.Equal(t, 200, post.Response().StatusCode, post.String())
+
+	data := &map[string]any{}
+	require.NoError(t, post.ToJSON(data))
+	require.Equal(t, true, (*data)["duplicate"])
+	require.Equal(t, trackID.String(), (*data)["data"].(map[string]any)["id"])
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+// tusHeader builds the headers every resumable upload request carries.
+func tusHeader(kv ...string) req.Header {
+	h := req.Header{"Tus-Resumable": "1.0.0"}
+	for i := 0; i+1 < len(kv); i += 2 {
+		h[kv[i]] = kv[i+1]
+	}
+	return h
+}
+
+func TestHTTPResumableUpload(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	audioBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	artistID := uuid.New()
+	trackID := uuid.New()
+
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks" WHERE checksum = \$1`).
+		WithArgs(fs.Checksum(audioBytes), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(artistID, "Hike", time.Now(), time.Now()))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."tracks"`).
+		WithArgs("Resumed", artistID, fs.ContentName(audioBytes, "mp3"), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 0, false,
+			fs.Checksum(audioBytes), int64(len(audioBytes)), nil, nil, 0, 0, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(trackID))
+	sqlMock.ExpectExec(`DELETE FROM "auxstream"\."blob_removals" WHERE file IN`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectCommit()
+
+	fs.Store = fs.NewLocalStore(os.TempDir())
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	metadata := fmt.Sprintf("filename %s,title %s,artist_id %s",
+		base64.StdEncoding.EncodeToString([]byte("audio.mp3")),
+		base64.StdEncoding.EncodeToString([]byte("Resumed")),
+		base64.StdEncoding.EncodeToString([]byte(artistID.String())))
+	created, err := req.Post(tserver.URL+"/uploads/tus", tusHeader(
+		"Upload-Length", strconv.Itoa(len(audioBytes)),
+		"Upload-Metadata", metadata,
+	))
+	require.NoError(t, err)
+	require.Equal(t, 201, created.Response().StatusCode)
+	location := created.Response().Header.Get("Location")
+	require.Contains(t, location, "/uploads/tus/")
+	uploadURL := tserver.URL + location
+
+	patch := func(offset int, chunk []byte) *req.Resp {
+		res, err := req.Patch(uploadURL, tusHeader(
+			"Upload-Offset", strconv.Itoa(offset),
+			"Content-Type", "application/offset+octet-stream",
+		), chunk)
+		require.NoError(t, err)
+		return res
+	}
+
+	half := len(audioBytes) / 2
+	res := patch(0, audioBytes[:half])
+	require.Equal(t, 204, res.Response().StatusCode)
+	require.Equal(t, strconv.Itoa(half), res.Response().Header.Get("Upload-Offset"))
+
+	// A client that lost track of the upload asks where to resume.
+	head, err := req.Head(uploadURL, tusHeader())
+	require.NoError(t, err)
+	require.Equal(t, 200, head.Response().StatusCode)
+	require.Equal(t, strconv.Itoa(half), head.Response().Header.Get("Upload-Offset"))
+	require.Equal(t, strconv.Itoa(len(audioBytes)), head.Response().Header.Get("Upload-Length"))
+
+	require.Equal(t, 409, patch(0, audioBytes[:half]).Response().StatusCode)
+
+	res = patch(half, audioBytes[half:])
+	require.Equal(t, 204, res.Response().StatusCode)
+	require.Equal(t, 1, fs.Store.Writes())
+
+	status, err := req.Get(uploadURL)
+	require.NoError(t, err)
+	require.Equal(t, 200, status.Response().StatusCode)
+	data := &map[string]any{}
+	require.NoError(t, status.ToJSON(data))
+	require.Equal(t, true, (*data)["data"].(map[string]any)["complete"])
+	require.Equal(t, trackID.String(), (*data)["data"].(map[string]any)["track_id"])
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPResumableUploadRejectsCorruptFile(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	audioBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	truncated := audioBytes[:len(audioBytes)-100]
+
+	fs.Store = fs.NewLocalStore(os.TempDir())
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	// Without the protocol header the server refuses to take part.
+	refused, err := req.Post(tserver.URL+"/uploads/tus", req.Header{"Upload-Length": "10"})
+	require.NoError(t, err)
+	require.Equal(t, 412, refused.Response().StatusCode)
+
+	created, err := req.Post(tserver.URL+"/uploads/tus", tusHeader("Upload-Length", strconv.Itoa(len(truncated))))
+	require.NoError(t, err)
+	require.Equal(t, 201, created.Response().StatusCode)
+	uploadURL := tserver.URL + created.Response().Header.Get("Location")
+
+	res, err := req.Patch(uploadURL, tusHeader(
+		"Upload-Offset", "0",
+		"Content-Type", "application/offset+octet-stream",
+	), truncated)
+	require.NoError(t, err)
+	require.Equal(t, 422, res.Response().StatusCode)
+	require.Equal(t, 0, fs.Store.Writes())
+
+	// The refused upload is gone rather than left to be resumed.
+	head, err := req.Head(uploadURL, tusHeader())
+	require.NoError(t, err)
+	require.Equal(t, 404, head.Response().StatusCode)
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPDirectUpload(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	audioBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	artistID := uuid.New()
+	trackID := uuid.New()
+
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks" WHERE checksum = \$1`).
+		WithArgs(fs.Checksum(audioBytes), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(artistID, "Hike", time.Now(), time.Now()))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."tracks"`).
+		WithArgs("audio", artistID, fs.ContentName(audioBytes, "mp3"), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 0, false,
+			fs.Checksum(audioBytes), int64(len(audioBytes)), nil, nil, 0, 0, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(trackID))
+	sqlMock.ExpectExec(`DELETE FROM "auxstream"\."blob_removals" WHERE file IN`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectCommit()
+
+	bucket := fakes.NewS3(t, "tracks")
+	fs.Store = fs.NewS3Store(fs.S3Config{Bucket: "tracks", Endpoint: bucket.URL, ForcePathStyle: true})
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	created, err := req.Post(tserver.URL+"/uploads/direct", req.BodyJSON(map[string]any{
+		"filename":  "audio.mp3",
+		"size":      len(audioBytes),
+		"sha256":    fs.Checksum(audioBytes),
+		"artist_id": artistID.String(),
+	}))
+	require.NoError(t, err)
+	require.Equal(t, 201, created.Response().StatusCode)
+	data := &map[string]any{}
+	require.NoError(t, created.ToJSON(data))
+	upload := (*data)["data"].(map[string]any)
+	require.Contains(t, upload["url"], bucket.URL+"/tracks/")
+	completeURL := tserver.URL + created.Response().Header.Get("Location") + "/complete"
+
+	early, err := req.Post(completeURL)
+	require.NoError(t, err)
+	require.Equal(t, 409, early.Response().StatusCode)
+
+	header := req.Header{}
+	for k, v := range upload["headers"].(map[string]any) {
+		header[k] = v.(string)
+	}
+	// The signed checksum keeps the bucket from taking any other file.
+	refused, err := req.Put(upload["url"].(string), header, audioBytes[:len(audioBytes)-1])
+	require.NoError(t, err)
+	require.Equal(t, 400, refused.Response().StatusCode)
+	put, err := req.Put(upload["url"].(string), header, audioBytes)
+	require.NoError(t, err)
+	require.Equal(t, 200, put.Response().StatusCode)
+
+	done, err := req.Post(completeURL)
+	require.NoError(t, err)
+	require.Equal(t, 200, done.Response().StatusCode)
+	require.NoError(t, done.ToJSON(data))
+	require.Equal(t, trackID.String(), (*data)["data"].(map[string]any)["id"])
+
+	stored, ok := bucket.Object(fs.ContentName(audioBytes, "mp3"))
+	require.True(t, ok)
+	require.Equal(t, audioBytes, stored)
+	require.Equal(t, 1, fs.Store.Writes())
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPDirectUploadRejectsUnknownFormat(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	content := []byte("definitely not audio")
+	bucket := fakes.NewS3(t, "tracks")
+	fs.Store = fs.NewS3Store(fs.S3Config{Bucket: "tracks", Endpoint: bucket.URL, ForcePathStyle: true})
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	created, err := req.Post(tserver.URL+"/uploads/direct", req.BodyJSON(map[string]any{
+		"filename": "notes.txt",
+		"size":     len(content),
+		"sha256":   fs.Checksum(content),
+		"artist":   "Hike",
+	}))
+	require.NoError(t, err)
+	require.Equal(t, 201, created.Response().StatusCode)
+	data := &map[string]any{}
+	require.NoError(t, created.ToJSON(data))
+	upload := (*data)["data"].(map[string]any)
+
+	header := req.Header{}
+	for k, v := range upload["headers"].(map[string]any) {
+		header[k] = v.(string)
+	}
+	put, err := req.Put(upload["url"].(string), header, content)
+	require.NoError(t, err)
+	require.Equal(t, 200, put.Response().StatusCode)
+
+	completeURL := tserver.URL + created.Response().Header.Get("Location") + "/complete"
+	res, err := req.Post(completeURL)
+	require.NoError(t, err)
+	require.Equal(t, 400, res.Response().StatusCode)
+	require.Equal(t, 0, fs.Store.Writes())
+
+	// The refused file is removed from the bucket along with the upload.
+	_, ok := bucket.Object(".staging/" + path.Base(created.Response().Header.Get("Location")))
+	require.False(t, ok)
+	gone, err := req.Post(completeURL)
+	require.NoError(t, err)
+	require.Equal(t, 404, gone.Response().StatusCode)
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPAddTrackChargesUploader(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	userID := uuid.New()
+	artistID := uuid.New()
+	token, err := auth.NewJWTService("test-secret", time.Hour, time.Hour).GenerateAccessToken(userID, "fan@example.com")
+	require.NoError(t, err)
+	audioBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).AddRow(userID, "fan@example.com", "user"))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."storage_usage"`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "bytes", "tracks"}).AddRow(userID, 1024, 3))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks" WHERE checksum = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(artistID, "Hike", time.Now(), time.Now()))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."tracks"`).
+		WithArgs("Charged", artistID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 0, false,
+			fs.Checksum(audioBytes), int64(len(audioBytes)), userID, nil, 0, 0, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	// The upload is charged to its uploader along with the insert, once the
+	// locked ledger shows it still fits the quota.
+	sqlMock.ExpectExec(`DELETE FROM "auxstream"\."blob_removals" WHERE file IN`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectExec(`INSERT INTO "auxstream"\."storage_usage" .* DO NOTHING`).
+		WithArgs(userID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."storage_usage" WHERE user_id = \$1 .*FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "bytes", "tracks"}).AddRow(userID, 1024, 3))
+	sqlMock.ExpectExec(`INSERT INTO "auxstream"\."storage_usage"`).
+		WithArgs(userID, int64(len(audioBytes)), 1, int64(len(audioBytes)), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+
+	fs.Store = fs.NewLocalStore(t.TempDir())
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	post, err := req.Post(tserver.URL+"/upload_track",
+		req.Header{"Authorization": "Bearer " + token},
+		req.Param{"title": "Charged", "artist_id": artistID.String()},
+		req.FileUpload{FieldName: "audio", File: io.NopCloser(bytes.NewReader(audioBytes)), FileName: "audio.mp3"})
+	require.NoError(t, err)
+	require.Equal(t, 200, post.Response().StatusCode)
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPAddTrackRejectsOverQuota(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	userID := uuid.New()
+	token, err := auth.NewJWTService("test-secret", time.Hour, time.Hour).GenerateAccessToken(userID, "fan@example.com")
+	require.NoError(t, err)
+
+	// Already at the user quota's track limit.
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).AddRow(userID, "fan@example.com", "user"))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."storage_usage"`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "bytes", "tracks"}).AddRow(userID, 1024, 1000))
+
+	fs.Store = fs.NewLocalStore(t.TempDir())
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	audioBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	post, err := req.Post(tserver.URL+"/upload_track",
+		req.Header{"Authorization": "Bearer " + token},
+		req.Param{"artist": "Hike"},
+		req.FileUpload{FieldName: "audio", File: io.NopCloser(bytes.NewReader(audioBytes)), FileName: "audio.mp3"})
+	require.NoError(t, err)
+	require.Equal(t, 403, post.Response().StatusCode)
+	require.Contains(t, post.String(), "storage quota exceeded")
+	require.Equal(t, 0, fs.Store.Writes())
+
+	// A bulk upload is refused as a whole, before anything is staged.
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).AddRow(userID, "fan@example.com", "user"))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."storage_usage"`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "bytes", "tracks"}).AddRow(userID, 1024, 1000))
+	bulk, err := req.Post(tserver.URL+"/upload_batch_track",
+		req.Header{"Authorization": "Bearer " + token},
+		req.FileUpload{FieldName: "track_files", File: io.NopCloser(bytes.NewReader(audioBytes)), FileName: "audio.mp3"})
+	require.NoError(t, err)
+	require.Equal(t, 403, bulk.Response().StatusCode)
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPAddTrackRechecksQuotaWhenCharging(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	userID := uuid.New()
+	artistID := uuid.New()
+	token, err := auth.NewJWTService("test-secret", time.Hour, time.Hour).GenerateAccessToken(userID, "fan@example.com")
+	require.NoError(t, err)
+
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).AddRow(userID, "fan@example.com", "user"))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."storage_usage"`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "bytes", "tracks"}).AddRow(userID, 1024, 999))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks" WHERE checksum = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(artistID, "Hike", time.Now(), time.Now()))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."tracks"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	sqlMock.ExpectExec(`DELETE FROM "auxstream"\."blob_removals" WHERE file IN`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectExec(`INSERT INTO "auxstream"\."storage_usage" .* DO NOTHING`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	// A concurrent upload took the last track the quota allows meanwhile.
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."storage_usage" WHERE user_id = \$1 .*FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "bytes", "tracks"}).AddRow(userID, 2048, 1000))
+	sqlMock.ExpectRollback()
+	// The audio it stored is scheduled for removal, not left behind.
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."blob_removals"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	sqlMock.ExpectCommit()
+
+	fs.Store = fs.NewLocalStore(t.TempDir())
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	audioBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	post, err := req.Post(tserver.URL+"/upload_track",
+		req.Header{"Authorization": "Bearer " + token},
+		req.Param{"title": "Raced", "artist_id": artistID.String()},
+		req.FileUpload{FieldName: "audio", File: io.NopCloser(bytes.NewReader(audioBytes)), FileName: "audio.mp3"})
+	require.NoError(t, err)
+	require.Equal(t, 403, post.Response().StatusCode)
+	require.Contains(t, post.String(), "storage quota exceeded: 2048 bytes in 1000 tracks used")
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPAddTrackFailureDiscardsNewAlbumAndAudio(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	artistID := uuid.New()
+	albumID := uuid.New()
+
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks" WHERE checksum = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(artistID, "Hike", time.Now(), time.Now()))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."albums" WHERE \(artist_id = \$1 AND lower\(title\) = lower\(\$2\)\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."albums"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(albumID))
+	sqlMock.ExpectCommit()
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."tracks"`).
+		WillReturnError(errors.New("connection reset"))
+	sqlMock.ExpectRollback()
+	// The audio it stored is scheduled for removal...
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."blob_removals"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	sqlMock.ExpectCommit()
+	// ...and the album it created deleted while no track is filed under it.
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`UPDATE "auxstream"\."albums" SET "deleted_at"=\$1 WHERE \(id = \$2 AND NOT EXISTS \(SELECT 1 FROM auxstream\.tracks t WHERE t\.album_id = albums\.id AND t\.deleted_at IS NULL\)\)`).
+		WithArgs(sqlmock.AnyArg(), albumID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+
+	fs.Store = fs.NewLocalStore(t.TempDir())
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	audioBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	post, err := req.Post(tserver.URL+"/upload_track",
+		req.Param{"title": "Lost", "artist_id": artistID.String(), "album": "Night Drive"},
+		req.FileUpload{FieldName: "audio", File: io.NopCloser(bytes.NewReader(audioBytes)), FileName: "audio.mp3"})
+	require.NoError(t, err)
+	require.Equal(t, 500, post.Response().StatusCode, post.String())
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestParseQuotasKeepsDefaults(t *testing.T) {
+	quotas, err := handlers.ParseQuotas("admin:0:0, moderator:1024:10")
+	require.NoError(t, err)
+	// Leaving user out keeps its default rather than lifting its limit.
+	require.Equal(t, handlers.Quota{Bytes: 5 << 30, Tracks: 1000}, quotas["user"])
+	require.Equal(t, handlers.Quota{}, quotas["admin"])
+	require.Equal(t, handlers.Quota{Bytes: 1024, Tracks: 10}, quotas["moderator"])
+
+	_, err = handlers.ParseQuotas("user:lots:10")
+	require.Error(t, err)
+}
+
+func TestHTTPUsage(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	userID := uuid.New()
+	token, err := auth.NewJWTService("test-secret", time.Hour, time.Hour).GenerateAccessToken(userID, "dj@example.com")
+	require.NoError(t, err)
+
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).AddRow(userID, "dj@example.com", "admin"))
+	// No upload yet, so no ledger row.
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."storage_usage"`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "bytes", "tracks"}))
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	res, err := req.Get(tserver.URL+"/me/usage", req.Header{"Authorization": "Bearer " + token})
+	require.NoError(t, err)
+	require.Equal(t, 200, res.Response().StatusCode)
+	data := &map[string]any{}
+	require.NoError(t, res.ToJSON(data))
+	require.Equal(t, map[string]any{
+		"role":   "admin",
+		"bytes":  float64(0),
+		"tracks": float64(0),
+		"quota":  map[string]any{"bytes": float64(0), "tracks": float64(0)},
+	}, (*data)["data"])
+
+	anonymous, err := req.Get(tserver.URL + "/me/usage")
+	require.NoError(t, err)
+	require.Equal(t, 401, anonymous.Response().StatusCode)
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPUploadTrackArtwork(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	userID := uuid.New()
+	trackID := uuid.New()
+	artistID := uuid.New()
+	token, err := auth.NewJWTService("test-secret", time.Hour, time.Hour).GenerateAccessToken(userID, "fan@example.com")
+	require.NoError(t, err)
+
+	// A 400x200 PNG; the artwork is its central square.
+	var picture bytes.Buffer
+	require.NoError(t, png.Encode(&picture, image.NewGray(image.Rect(0, 0, 400, 200))))
+
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."tracks"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "file", "uploader_id"}).
+			AddRow(trackID, "Title", artistID, "audio.mp3", userID))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(artistID, "Hike"))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`UPDATE "auxstream"\."tracks" SET "images"=\$1,"thumbnail"=\$2`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+
+	fs.Store = fs.NewLocalStore(t.TempDir())
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	res, err := req.Put(tserver.URL+"/tracks/"+trackID.String()+"/artwork",
+		req.Header{"Authorization": "Bearer " + token},
+		req.FileUpload{FieldName: "image", File: io.NopCloser(bytes.NewReader(picture.Bytes())), FileName: "cover.png"})
+	require.NoError(t, err)
+	require.Equal(t, 200, res.Response().StatusCode, res.String())
+	data := &map[string]any{}
+	require.NoError(t, res.ToJSON(data))
+	urls := (*data)["data"].(map[string]any)
+	require.Len(t, urls, 3)
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+
+	// Each size is linked under the image route, which serves it.
+	url := urls["300"].(string)
+	require.True(t, strings.HasPrefix(url, "/api/v1/images/"), url)
+	img, err := req.Get(tserver.URL + "/images/" + strings.TrimPrefix(url, "/api/v1/images/"))
+	require.NoError(t, err)
+	require.Equal(t, 200, img.Response().StatusCode)
+	require.Equal(t, "image/jpeg", img.Response().Header.Get("Content-Type"))
+	decoded, err := jpeg.Decode(bytes.NewReader(img.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 300, 300), decoded.Bounds())
+
+	// Audio is not reachable through the image route.
+	audioName, err := fs.Store.Save([]byte("ID3 not an image"), "mp3")
+	require.NoError(t, err)
+	notImage, err := req.Get(tserver.URL + "/images/" + audioName)
+	require.NoError(t, err)
+	require.Equal(t, 404, notImage.Response().StatusCode)
+}
+
+func TestHTTPUploadTrackArtworkRejectsNonImages(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	userID := uuid.New()
+	trackID := uuid.New()
+	token, err := auth.NewJWTService("test-secret", time.Hour, time.Hour).GenerateAccessToken(userID, "fan@example.com")
+	require.NoError(t, err)
+
+	expectTrack := func(uploader uuid.UUID) {
+		sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."tracks"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "file", "uploader_id"}).
+				AddRow(trackID, "Title", uuid.New(), "audio.mp3", uploader))
+		sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."artists"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+	}
+
+	fs.Store = fs.NewLocalStore(t.TempDir())
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+	put := func(content []byte) *req.Resp {
+		res, err := req.Put(tserver.URL+"/tracks/"+trackID.String()+"/artwork",
+			req.Header{"Authorization": "Bearer " + token},
+			req.FileUpload{FieldName: "image", File: io.NopCloser(bytes.NewReader(content)), FileName: "cover.png"})
+		require.NoError(t, err)
+		return res
+	}
+
+	expectTrack(userID)
+	require.Equal(t, 400, put([]byte("GIF89a")).Response().StatusCode)
+	expectTrack(userID)
+	require.Equal(t, 422, put([]byte("\x89PNG\r\n\x1a\ntruncated")).Response().StatusCode)
+
+	// Someone else's track, and the caller is no admin.
+	expectTrack(uuid.New())
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).AddRow(userID, "fan@example.com", "user"))
+	require.Equal(t, 403, put([]byte("GIF89a")).Response().StatusCode)
+
+	require.Equal(t, 0, fs.Store.Writes())
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPGetAlbum(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	albumID := uuid.New()
+	artistID := uuid.New()
+	first, second := uuid.New(), uuid.New()
+
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."albums" WHERE id = \$1`).
+		WithArgs(albumID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "type", "release_date"}).
+			AddRow(albumID, "Night Drive", artistID, "ep", time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists" WHERE "artists"\."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(artistID, "Hike"))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks" WHERE "tracks"\."album_id" = \$1 .*ORDER BY disc_number, track_number`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "album_id", "disc_number", "track_number"}).
+			AddRow(first, "Intro", artistID, albumID, 1, 1).
+			AddRow(second, "Outro", artistID, albumID, 1, 2))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists" WHERE "artists"\."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(artistID, "Hike"))
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	res, err := req.Get(tserver.URL + "/albums/" + albumID.String())
+	require.NoError(t, err)
+	require.Equal(t, 200, res.Response().StatusCode)
+
+	data := &map[string]any{}
+	require.NoError(t, res.ToJSON(data))
+	album := (*data)["data"].(map[string]any)
+	require.Equal(t, "Night Drive", album["title"])
+	require.Equal(t, "ep", album["type"])
+	require.Equal(t, "Hike", album["artist"].(map[string]any)["name"])
+	tracks := album["tracks"].([]any)
+	require.Len(t, tracks, 2)
+	require.Equal(t, first.String(), tracks[0].(map[string]any)["id"])
+	require.Equal(t, float64(2), tracks[1].(map[string]any)["track_number"])
+	require.NotEmpty(t, tracks[0].(map[string]any)["stream_url"])
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPGetArtistAlbums(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	artistID := uuid.New()
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(artistID, "Hike"))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."albums" WHERE artist_id = \$1 .*ORDER BY release_date DESC NULLS LAST, created_at DESC LIMIT \$2 OFFSET \$3`).
+		WithArgs(artistID, 5, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "type"}).
+			AddRow(uuid.New(), "Night Drive", artistID, "album"))
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	res, err := req.Get(tserver.URL + "/artists/" + artistID.String() + "/albums?pagesize=5&pagenumber=2")
+	require.NoError(t, err)
+	require.Equal(t, 200, res.Response().StatusCode)
+	data := &map[string]any{}
+	require.NoError(t, res.ToJSON(data))
+	require.Len(t, (*data)["data"].([]any), 1)
+
+	// An unknown artist is a 404, before any album lookup.
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+	res, err = req.Get(tserver.URL + "/artists/" + uuid.NewString() + "/albums")
+	require.NoError(t, err)
+	require.Equal(t, 404, res.Response().StatusCode)
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPAddTrackToAlbum(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	albumID := uuid.New()
+	artistID := uuid.New()
+
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks" WHERE checksum = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."albums" WHERE id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "type"}).
+			AddRow(albumID, "Night Drive", artistID, "album"))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(artistID, "Hike"))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks" WHERE "tracks"\."album_id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	// The track is filed under the album's artist rather than its tagged one.
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists" WHERE "artists"\."id" = \$1`).
+		WithArgs(artistID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(artistID, "Hike"))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."tracks"`).
+		WithArgs("Impact Moderato", artistID, sqlmock.AnyArg(), 27, "", nil, 0, false, sqlmock.AnyArg(), sqlmock.AnyArg(),
+			nil, albumID, 1, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	sqlMock.ExpectExec(`DELETE FROM "auxstream"\."blob_removals" WHERE file IN`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectCommit()
+
+	fs.Store = fs.NewLocalStore(t.TempDir())
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	file, err := os.Open(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	post, err := req.Post(tserver.URL+"/upload_track",
+		req.Param{"album_id": albumID.String(), "track_number": "3"},
+		req.FileUpload{FieldName: "audio", File: file, FileName: "audio.mp3"})
+	require.NoError(t, err)
+	require.Equal(t, 200, post.Response().StatusCode)
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPAddTrackCreditsArtists(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	artistID := uuid.New()
+	featuredID := uuid.New()
+	producerID := uuid.New()
+	trackID := uuid.New()
+
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks" WHERE checksum = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	for _, artist := range []struct {
+		id   uuid.UUID
+		name string
+	}{{artistID, "Wizkid"}, {featuredID, "Tems"}, {producerID, "P2J"}} {
+		sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists" WHERE "artists"\."id" = \$1`).
+			WithArgs(artist.id, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(artist.id, artist.name))
+	}
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."tracks"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(trackID))
+	// The credits are created with the track, in the order given.
+	sqlMock.ExpectExec(`INSERT INTO "auxstream"\."track_artists" \("track_id","artist_id","role","position","created_at"\) VALUES \(\$1,\$2,\$3,\$4,\$5\),\(\$6,\$7,\$8,\$9,\$10\) ON CONFLICT`).
+		WithArgs(trackID, featuredID, "featured", 0, sqlmock.AnyArg(), trackID, producerID, "producer", 1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	sqlMock.ExpectExec(`DELETE FROM "auxstream"\."blob_removals" WHERE file IN`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectCommit()
+
+	fs.Store = fs.NewLocalStore(t.TempDir())
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	file, err := os.Open(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	post, err := req.Post(tserver.URL+"/upload_track",
+		req.Param{
+			"artist_id":           artistID.String(),
+			"featured_artist_ids": featuredID.String() + "," + artistID.String(),
+			"producer_ids":        producerID.String(),
+		},
+		req.FileUpload{FieldName: "audio", File: file, FileName: "audio.mp3"})
+	require.NoError(t, err)
+	require.Equal(t, 200, post.Response().StatusCode)
+
+	var body struct {
+		Data struct {
+			Credits []struct {
+				ArtistID uuid.UUID `json:"artist_id"`
+				Role     string    `json:"role"`
+			} `json:"credits"`
+		} `json:"data"`
+	}
+	require.NoError(t, post.ToJSON(&body))
+	require.Len(t, body.Data.Credits, 2)
+	require.Equal(t, featuredID, body.Data.Credits[0].ArtistID)
+	require.Equal(t, "featured", body.Data.Credits[0].Role)
+	require.Equal(t, producerID, body.Data.Credits[1].ArtistID)
+	require.Equal(t, "producer", body.Data.Credits[1].Role)
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPTrackUploadBatchCreatesAlbum(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	artistID := uuid.New()
+	albumID := uuid.New()
+
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists" WHERE "artists"\."id" = \$1`).
+		WithArgs(artistID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(artistID, "Hike"))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."albums" WHERE \(artist_id = \$1 AND lower\(title\) = lower\(\$2\)\)`).
+		WithArgs(artistID, "Night Drive", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."albums"`).
+		WithArgs("Night Drive", artistID, "ep", time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC), nil,
+			sqlmock.AnyArg(), sqlmock.AnyArg(), nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(albumID))
+	sqlMock.ExpectCommit()
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."upload_jobs"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."upload_job_files"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	sqlMock.ExpectCommit()
+
+	fs.Store = fs.NewLocalStore(t.TempDir())
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+	upload := func(params req.Param) *req.Resp {
+		file, err := os.Open(filepath.Join(testDataPath, "audio", "audio.mp3"))
+		require.NoError(t, err)
+		post, err := req.Post(tserver.URL+"/upload_batch_track", params,
+			req.FileUpload{FieldName: "track_files", File: file, FileName: "audio.mp3"})
+		require.NoError(t, err)
+		return post
+	}
+
+	post := upload(req.Param{
+		"artist_id":    artistID.String(),
+		"album":        "Night Drive",
+		"album_type":   "ep",
+		"release_date": "2024-05-17",
+	})
+	require.Equal(t, 202, post.Response().StatusCode)
+	data := &map[string]any{}
+	require.NoError(t, post.ToJSON(data))
+	require.Equal(t, albumID.String(), (*data)["data"].(map[string]any)["album_id"])
+
+	// A new album needs the artist it is filed under.
+	require.Equal(t, 400, upload(req.Param{"album": "Night Drive"}).Response().StatusCode)
+	require.Equal(t, 400, upload(req.Param{"artist_id": artistID.String(), "album": "Night Drive", "album_type": "lp"}).Response().StatusCode)
+
+	// An unknown album_id is a 404.
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."albums" WHERE id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	require.Equal(t, 404, upload(req.Param{"album_id": uuid.NewString()}).Response().StatusCode)
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPFetchTracksFiltersByGenreAndTag(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	artistID := uuid.New()
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	// Every sort narrows to the tracks carrying the genre and tag, matched by
+	// slug however the query spells them.
+	for _, sort := range []string{"", "trending", "recent"} {
+		sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."tracks" WHERE \(EXISTS \(.+t\.kind = \$1 AND t\.slug = \$2\)\) AND \(EXISTS \(.+t\.kind = \$3 AND t\.slug = \$4\)\)`).
+			WithArgs("genre", "hip-hop", "tag", "late-night", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "file"}).
+				AddRow(uuid.New(), "Title", artistID, "Test file"))
+		sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."artists"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(artistID, "Hike"))
+
+		query := url.Values{"pagesize": {"2"}, "pagenumber": {"1"}, "genre": {"Hip Hop"}, "tag": {"late_night"}}
+		if sort != "" {
+			query.Set("sort", sort)
+			query.Set("days", "-1") // all time, so trending adds no window to the query
+		}
+		resp, err := req.Get(tserver.URL + "/tracks?" + query.Encode())
+		require.NoError(t, err)
+		require.Equal(t, 200, resp.Response().StatusCode, sort)
+	}
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPSetTrackTags(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	userID := uuid.New()
+	trackID := uuid.New()
+	artistID := uuid.New()
+	genreID, tagID := uuid.New(), uuid.New()
+	token, err := auth.NewJWTService("test-secret", time.Hour, time.Hour).GenerateAccessToken(userID, "fan@example.com")
+	require.NoError(t, err)
+
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."tracks"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "file", "uploader_id"}).
+			AddRow(trackID, "Title", artistID, "audio.mp3", userID))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(artistID, "Hike"))
+
+	// Genres are replaced and tags left alone. "Hip Hop" and "hip-hop" are one
+	// genre, created unless it exists.
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."tags" .+ ON CONFLICT DO NOTHING`).
+		WithArgs("genre", "Hip Hop", "hip-hop", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	sqlMock.ExpectQuery(`SELECT \* FROM "auxstream"\."tags" WHERE kind = \$1 AND slug IN \(\$2\) ORDER BY name`).
+		WithArgs("genre", "hip-hop").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "name", "slug"}).AddRow(genreID, "genre", "Hip Hop", "hip-hop"))
+	sqlMock.ExpectExec(`DELETE FROM auxstream\.track_tags WHERE track_id = \$1 AND tag_id IN \(SELECT id FROM auxstream\.tags WHERE kind = \$2\)`).
+		WithArgs(trackID, "genre").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	sqlMock.ExpectExec(`INSERT INTO "auxstream"\."track_tags"`).
+		WithArgs(trackID, genreID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+	sqlMock.ExpectQuery(`SELECT "tags"\.".+ FROM "auxstream"\."tags" JOIN auxstream\.track_tags link ON link\.tag_id = tags\.id WHERE link\.track_id = \$1 ORDER BY kind, name`).
+		WithArgs(trackID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "name", "slug"}).
+			AddRow(genreID, "genre", "Hip Hop", "hip-hop").
+			AddRow(tagID, "tag", "Late Night", "late-night"))
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+	put := func(body string) *req.Resp {
+		res, err := req.Put(tserver.URL+"/tracks/"+trackID.String()+"/tags",
+			req.Header{"Authorization": "Bearer " + token, "Content-Type": "application/json"}, body)
+		require.NoError(t, err)
+		return res
+	}
+
+	res := put(`{"genres": ["Hip Hop", "hip-hop"]}`)
+	require.Equal(t, 200, res.Response().StatusCode, res.String())
+	data := &map[string]any{}
+	require.NoError(t, res.ToJSON(data))
+	filed := (*data)["data"].(map[string]any)
+	require.Len(t, filed["genres"], 1)
+	require.Equal(t, "hip-hop", filed["genres"].([]any)[0].(map[string]any)["slug"])
+	require.Len(t, filed["tags"], 1)
+
+	// Blank names are refused before anything is looked up.
+	require.Equal(t, 400, put(`{"tags": ["  "]}`).Response().StatusCode)
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPUpdateArtist(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	ownerID := uuid.New()
+	artistID := uuid.New()
+	token, err := auth.NewJWTService("test-secret", time.Hour, time.Hour).GenerateAccessToken(ownerID, "fan@example.com")
+	require.NoError(t, err)
+	cacheKey := "artist-id-" + artistID.String()
+	require.NoError(t, redisServer.Set(cacheKey, `{"name":"Hike"}`))
+
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists" WHERE "artists"\."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner_id"}).AddRow(artistID, "Hike", ownerID))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`UPDATE "auxstream"\."artists" SET "bio"=\$1,"country"=\$2,"links"=\$3,"updated_at"=\$4 WHERE id = \$5`).
+		WithArgs("From Lagos.", "NG", `{"instagram":"https://instagram.com/hike"}`, sqlmock.AnyArg(), artistID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists" WHERE "artists"\."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner_id", "bio", "country", "links"}).
+			AddRow(artistID, "Hike", ownerID, "From Lagos.", "NG", `{"instagram":"https://instagram.com/hike"}`))
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+	res, err := req.Patch(tserver.URL+"/artists/"+artistID.String(),
+		req.Header{"Authorization": "Bearer " + token, "Content-Type": "application/json"},
+		`{"bio": "From Lagos.", "country": "ng", "links": {"Instagram": "https://instagram.com/hike"}}`)
+	require.NoError(t, err)
+	require.Equal(t, 200, res.Response().StatusCode, res.String())
+
+	var body struct {
+		Data struct {
+			Country string            `json:"country"`
+			Links   map[string]string `json:"links"`
+		} `json:"data"`
+	}
+	require.NoError(t, res.ToJSON(&body))
+	require.Equal(t, "NG", body.Data.Country)
+	require.Equal(t, "https://instagram.com/hike", body.Data.Links["instagram"])
+	// Uploads must not resolve the artist to its stale cached copy.
+	require.False(t, redisServer.Exists(cacheKey))
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPUpdateArtistRejectsTakenName(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	ownerID := uuid.New()
+	artistID := uuid.New()
+	token, err := auth.NewJWTService("test-secret", time.Hour, time.Hour).GenerateAccessToken(ownerID, "fan@example.com")
+	require.NoError(t, err)
+
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists" WHERE "artists"\."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner_id"}).AddRow(artistID, "Hike", ownerID))
+	// The name is checked under a lock on it, in the rename's transaction.
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`SELECT pg_advisory_xact_lock\(hashtext\(\$1\)\)`).
+		WithArgs("auxstream.artists.name:Burna").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectQuery(`SELECT count\(\*\) FROM "auxstream"\."artists" WHERE \(name = \$1 AND id <> \$2\) AND "artists"\."deleted_at" IS NULL`).
+		WithArgs("Burna", artistID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	sqlMock.ExpectRollback()
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+	res, err := req.Patch(tserver.URL+"/artists/"+artistID.String(),
+		req.Header{"Authorization": "Bearer " + token, "Content-Type": "application/json"},
+		`{"name": "Burna"}`)
+	require.NoError(t, err)
+	require.Equal(t, 409, res.Response().StatusCode, res.String())
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPUpdateArtistRequiresOwnerOrAdmin(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	ownerID := uuid.New()
+	artistID := uuid.New()
+	jwt := auth.NewJWTService("test-secret", time.Hour, time.Hour)
+	ownerToken, err := jwt.GenerateAccessToken(ownerID, "owner@example.com")
+	require.NoError(t, err)
+	strangerID := uuid.New()
+	strangerToken, err := jwt.GenerateAccessToken(strangerID, "fan@example.com")
+	require.NoError(t, err)
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+	patch := func(token, body string) int {
+		res, err := req.Patch(tserver.URL+"/artists/"+artistID.String(),
+			req.Header{"Authorization": "Bearer " + token, "Content-Type": "application/json"}, body)
+		require.NoError(t, err)
+		return res.Response().StatusCode
+	}
+
+	// Someone else's artist is off limits.
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner_id"}).AddRow(artistID, "Hike", ownerID))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).AddRow(strangerID, "fan@example.com", "user"))
+	require.Equal(t, 403, patch(strangerToken, `{"bio": "Not mine."}`))
+
+	// Its owner may not verify it.
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner_id"}).AddRow(artistID, "Hike", ownerID))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).AddRow(ownerID, "owner@example.com", "artist"))
+	require.Equal(t, 403, patch(ownerToken, `{"verified": true}`))
+
+	// Bad fields are refused before anything is looked up.
+	require.Equal(t, 400, patch(ownerToken, `{"country": "Nigeria"}`))
+	require.Equal(t, 400, patch(ownerToken, `{"links": {"site": "javascript:alert(1)"}}`))
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPDeleteArtistCascades(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	adminID := uuid.New()
+	artistID := uuid.New()
+	uploaderID := uuid.New()
+	token, err := auth.NewJWTService("test-secret", time.Hour, time.Hour).GenerateAccessToken(adminID, "admin@example.com")
+	require.NoError(t, err)
+
+	// Artists made from upload tags have no owner and are left to admins.
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(artistID, "Hike"))
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).AddRow(adminID, "admin@example.com", "admin"))
+
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`SELECT "id","file","thumbnail","images","size","uploader_id" FROM "auxstream"\."tracks" WHERE artist_id = \$1`).
+		WithArgs(artistID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "file", "thumbnail", "images", "size", "uploader_id"}).
+			AddRow(uuid.New(), "a.mp3", "", nil, 300, uploaderID).
+			AddRow(uuid.New(), "b.mp3", "", nil, 200, uploaderID))
+	sqlMock.ExpectQuery(`SELECT "file" FROM "auxstream"\."track_files" WHERE track_id IN \(\$1,\$2\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"file"}))
+	sqlMock.ExpectExec(`UPDATE "auxstream"\."tracks" SET "deleted_at"=\$1 WHERE id IN \(\$2,\$3\)`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	sqlMock.ExpectExec(`UPDATE "auxstream"\."track_files" SET "deleted_at"=\$1 WHERE track_id IN \(\$2,\$3\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectExec(`UPDATE "auxstream"\."playlist_tracks" SET "deleted_at"=\$1 WHERE track_id IN \(\$2,\$3\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	// Its uploader gets the storage of both tracks back.
+	sqlMock.ExpectExec(`INSERT INTO "auxstream"\."storage_usage"`).
+		WithArgs(uploaderID, int64(-500), -2, int64(-500), -2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Their audio is scheduled for removal from the store.
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."blob_removals"`).
+		WithArgs("a.mp3", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			"b.mp3", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()).AddRow(uuid.New()))
+	sqlMock.ExpectExec(`UPDATE "auxstream"\."tracks" SET "album_id"=\$1,"disc_number"=\$2,"track_number"=\$3,"updated_at"=\$4 WHERE album_id IN \(SELECT id FROM auxstream\.albums WHERE artist_id = \$5\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectExec(`UPDATE "auxstream"\."albums" SET "deleted_at"=\$1 WHERE artist_id = \$2`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectExec(`DELETE FROM "auxstream"\."track_artists" WHERE artist_id = \$1`).
+		WithArgs(artistID).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	sqlMock.ExpectExec(`DELETE FROM "auxstream"\."artist_tags" WHERE artist_id = \$1`).
+		WithArgs(artistID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectExec(`UPDATE "auxstream"\."artists" SET "deleted_at"=\$1 WHERE id = \$2`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+	res, err := req.Delete(tserver.URL+"/artists/"+artistID.String(),
+		req.Header{"Authorization": "Bearer " + token})
+	require.NoError(t, err)
+	require.Equal(t, 200, res.Response().StatusCode, res.String())
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPUpdateTrack(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	userID := uuid.New()
+	trackID := uuid.New()
+	artistID := uuid.New()
+	token, err := auth.NewJWTService("test-secret", time.Hour, time.Hour).GenerateAccessToken(userID, "fan@example.com")
+	require.NoError(t, err)
+
+	expectTrack := func(title string) {
+		sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."tracks"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "file", "duration", "uploader_id"}).
+				AddRow(trackID, title, artistID, "audio.mp3", 245, userID))
+		sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."artists"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(artistID, "Hike"))
+	}
+	expectTrack("Title")
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`UPDATE "auxstream"\."tracks" SET "duration"=\$1,"title"=\$2,"updated_at"=\$3 WHERE id = \$4`).
+		WithArgs(245, "Night Drive", sqlmock.AnyArg(), trackID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+	expectTrack("Night Drive")
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."track_artists" WHERE track_id IN \(\$1\)`).
+		WithArgs(trackID).
+		WillReturnRows(sqlmock.NewRows([]string{"track_id", "artist_id", "role"}))
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+	patch := func(body string) *req.Resp {
+		res, err := req.Patch(tserver.URL+"/tracks/"+trackID.String(),
+			req.Header{"Authorization": "Bearer " + token, "Content-Type": "application/json"}, body)
+		require.NoError(t, err)
+		return res
+	}
+
+	for _, body := range []string{`{"title": "  "}`, `{"duration": -1}`, `{"artist_id": "hike"}`} {
+		res := patch(body)
+		require.Equal(t, 400, res.Response().StatusCode, body)
+	}
+
+	res := patch(`{"title": " Night Drive ", "duration": 245}`)
+	require.Equal(t, 200, res.Response().StatusCode, res.String())
+	var body struct {
+		Data struct {
+			Title string `json:"title"`
+		} `json:"data"`
+	}
+	require.NoError(t, res.ToJSON(&body))
+	require.Equal(t, "Night Drive", body.Data.Title)
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPUpdateTrackReplacesAudio(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	userID := uuid.New()
+	trackID := uuid.New()
+	artistID := uuid.New()
+	token, err := auth.NewJWTService("test-secret", time.Hour, time.Hour).GenerateAccessToken(userID, "fan@example.com")
+	require.NoError(t, err)
+
+	audioPath := filepath.Join(testDataPath, "audio", "audio.mp3")
+	info, err := os.Stat(audioPath)
+	require.NoError(t, err)
+	// The old audio is larger, so the replacement needs no quota.
+	oldSize := info.Size() + 1000
+
+	expectTrack := func() {
+		sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."tracks"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "file", "checksum", "size", "uploader_id"}).
+				AddRow(trackID, "Title", artistID, "old.mp3", "old", oldSize, userID))
+		sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."artists"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(artistID, "Hike"))
+	}
+	expectTrack()
+	sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."tracks" WHERE checksum = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`SELECT "id","file","size","uploader_id" FROM "auxstream"\."tracks" WHERE id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "file", "size", "uploader_id"}).
+			AddRow(trackID, "old.mp3", oldSize, userID))
+	sqlMock.ExpectQuery(`SELECT "file" FROM "auxstream"\."track_files" WHERE track_id = \$1`).
+		WithArgs(trackID).
+		WillReturnRows(sqlmock.NewRows([]string{"file"}).AddRow("old-0.ts"))
+	sqlMock.ExpectExec(`UPDATE "auxstream"\."tracks" SET "checksum"=\$1,"duration"=\$2,"file"=\$3,"size"=\$4,"updated_at"=\$5 WHERE id = \$6`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), info.Size(), sqlmock.AnyArg(), trackID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// What was packaged from the old audio goes with it.
+	sqlMock.ExpectExec(`UPDATE "auxstream"\."track_files" SET "deleted_at"=\$1 WHERE track_id = \$2`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// The new audio may be a blob a delete scheduled for removal.
+	sqlMock.ExpectExec(`DELETE FROM "auxstream"\."blob_removals" WHERE file IN`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectExec(`INSERT INTO "auxstream"\."storage_usage"`).
+		WithArgs(userID, int64(-1000), 0, int64(-1000), 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."blob_removals"`).
+		WithArgs("old.mp3", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			"old-0.ts", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()).AddRow(uuid.New()))
+	sqlMock.ExpectCommit()
+	expectTrack()
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."track_artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"track_id", "artist_id", "role"}))
+
+	fs.Store = fs.NewLocalStore(t.TempDir())
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+
+	// req only writes multipart bodies for POST and PUT.
+	raw, err := os.ReadFile(audioPath)
+	require.NoError(t, err)
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, err := mw.CreateFormFile("audio", "audio.mp3")
+	require.NoError(t, err)
+	_, err = part.Write(raw)
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+	res, err := req.Patch(tserver.URL+"/tracks/"+trackID.String(),
+		req.Header{"Authorization": "Bearer " + token, "Content-Type": mw.FormDataContentType()},
+		form.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, 200, res.Response().StatusCode, res.String())
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPUpdateTrackRequiresUploaderOrAdmin(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	userID := uuid.New()
+	trackID := uuid.New()
+	artistID := uuid.New()
+	token, err := auth.NewJWTService("test-secret", time.Hour, time.Hour).GenerateAccessToken(userID, "fan@example.com")
+	require.NoError(t, err)
+
+	for range 2 {
+		sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."tracks"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "file", "uploader_id"}).
+				AddRow(trackID, "Title", artistID, "audio.mp3", uuid.New()))
+		sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."artists"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(artistID, "Hike"))
+		sqlMock.ExpectQuery(`SELECT .* FROM "auxstream"\."users"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).AddRow(userID, "fan@example.com", "user"))
+	}
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+	headers := req.Header{"Authorization": "Bearer " + token, "Content-Type": "application/json"}
+	res, err := req.Patch(tserver.URL+"/tracks/"+trackID.String(), headers, `{"title": "Mine now"}`)
+	require.NoError(t, err)
+	require.Equal(t, 403, res.Response().StatusCode, res.String())
+	res, err = req.Delete(tserver.URL+"/tracks/"+trackID.String(), headers)
+	require.NoError(t, err)
+	require.Equal(t, 403, res.Response().StatusCode, res.String())
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestHTTPDeleteTrack(t *testing.T) {
+	teardown := setupTest(t)
+	defer teardown(t)
+
+	userID := uuid.New()
+	trackID := uuid.New()
+	artistID := uuid.New()
+	token, err := auth.NewJWTService("test-secret", time.Hour, time.Hour).GenerateAccessToken(userID, "fan@example.com")
+	require.NoError(t, err)
+
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."tracks"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "artist_id", "file", "uploader_id"}).
+			AddRow(trackID, "Title", artistID, "audio.mp3", userID))
+	sqlMock.ExpectQuery(`SELECT (.+) FROM "auxstream"\."artists"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(artistID, "Hike"))
+
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectQuery(`SELECT "id","file","thumbnail","images","size","uploader_id" FROM "auxstream"\."tracks" WHERE id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "file", "thumbnail", "images", "size", "uploader_id"}).
+			AddRow(trackID, "audio.mp3", "cover-600.jpg", `{"300": "cover-300.jpg", "600": "cover-600.jpg"}`, 500, userID))
+	sqlMock.ExpectQuery(`SELECT "file" FROM "auxstream"\."track_files" WHERE track_id IN \(\$1\)`).
+		WithArgs(trackID).
+		WillReturnRows(sqlmock.NewRows([]string{"file"}).AddRow("audio-0.ts"))
+	sqlMock.ExpectExec(`UPDATE "auxstream"\."tracks" SET "deleted_at"=\$1 WHERE id IN \(\$2\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectExec(`UPDATE "auxstream"\."track_files" SET "deleted_at"=\$1 WHERE track_id IN \(\$2\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// It leaves the playlists it was on.
+	sqlMock.ExpectExec(`UPDATE "auxstream"\."playlist_tracks" SET "deleted_at"=\$1 WHERE track_id IN \(\$2\)`).
+		WithArgs(sqlmock.AnyArg(), trackID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	sqlMock.ExpectExec(`INSERT INTO "auxstream"\."storage_usage"`).
+		WithArgs(userID, int64(-500), -1, int64(-500), -1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Its audio, cover art and HLS segments are removed after the grace period.
+	sqlMock.ExpectQuery(`INSERT INTO "auxstream"\."blob_removals"`).
+		WithArgs("audio.mp3", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			"cover-600.jpg", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			"cover-300.jpg", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			"audio-0.ts", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).
+			AddRow(uuid.New()).AddRow(uuid.New()).AddRow(uuid.New()).AddRow(uuid.New()))
+	sqlMock.ExpectCommit()
+
+	tserver := httptest.NewServer(router)
+	defer tserver.Close()
+	res, err := req.Delete(tserver.URL+"/tracks/"+trackID.String(),
+		req.Header{"Authorization": "Bearer " + token})
+	require.NoError(t, err)
+	require.Equal(t, 200, res.Response().StatusCode, res.String())
+
+	require.NoError(t, sqlMock.ExpectationsWereMet())
+}
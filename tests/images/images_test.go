@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"auxstream/internal/images"
+	fs "auxstream/internal/storage"
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// A 1x1 lossless WebP.
+const pixelWebP = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestDecodeChecksContent(t *testing.T) {
+	_, err := images.Decode([]byte("GIF89a not supported"))
+	require.ErrorIs(t, err, images.ErrUnsupported)
+
+	// Right magic, broken body.
+	_, err = images.Decode([]byte("\x89PNG\r\n\x1a\nnot really"))
+	require.Error(t, err)
+	require.NotErrorIs(t, err, images.ErrUnsupported)
+
+	webp, err := base64.StdEncoding.DecodeString(pixelWebP)
+	require.NoError(t, err)
+	img, err := images.Decode(webp)
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 1, 1), img.Bounds())
+}
+
+func TestRenderCropsToSquares(t *testing.T) {
+	// A wide picture: red on the left and right, blue in the middle third.
+	src := image.NewRGBA(image.Rect(0, 0, 900, 300))
+	for x := range 900 {
+		for y := range 300 {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 300 && x < 600 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+	img, err := images.Decode(encodePNG(t, src))
+	require.NoError(t, err)
+
+	renditions, err := images.Render(img)
+	require.NoError(t, err)
+	require.Len(t, renditions, len(images.Sizes))
+	for i, r := range renditions {
+		require.Equal(t, images.Sizes[i], r.Size)
+		require.Equal(t, "jpg", r.Ext) // opaque
+		out, err := jpeg.Decode(bytes.NewReader(r.Data))
+		require.NoError(t, err)
+		require.Equal(t, image.Rect(0, 0, r.Size, r.Size), out.Bounds())
+		// Only the central square, all blue, is kept.
+		red, _, blue, _ := out.At(r.Size/2, r.Size/2).RGBA()
+		require.Less(t, red, uint32(0x2000))
+		require.Greater(t, blue, uint32(0xe000))
+	}
+}
+
+func TestStoreKeepsTransparency(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	src.Set(5, 5, color.NRGBA{G: 255, A: 128})
+
+	store := fs.NewLocalStore(t.TempDir())
+	stored, err := images.Store(store, encodePNG(t, src))
+	require.NoError(t, err)
+	require.Len(t, stored, len(images.Sizes))
+
+	name, ok := stored.Size(640)
+	require.True(t, ok)
+	require.Equal(t, name, stored.Largest())
+	file, err := store.Read(name)
+	require.NoError(t, err)
+	defer file.Close()
+	out, err := png.Decode(file)
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 640, 640), out.Bounds())
+}
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"auxstream/internal/audio"
+	"auxstream/internal/db"
+	"auxstream/internal/ingest"
+	"auxstream/internal/storage"
+	"auxstream/tests/fakes"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+var cwd, _ = os.Getwd()
+var testDataPath = filepath.Join(cwd, "..", "testdata")
+
+func TestPackageHLSSplitsOnFrameBoundaries(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+
+	var frameBytes int
+	err = audio.WalkMP3Frames(bytes.NewReader(src), int64(len(src)), func(f audio.MP3Frame) error {
+		frameBytes += f.Length
+		return nil
+	})
+	require.NoError(t, err)
+
+	store := storage.NewLocalStore(t.TempDir())
+	files, err := ingest.PackageHLS(store, bytes.NewReader(src), int64(len(src)), db.RenditionOriginal, "mp3")
+	require.NoError(t, err)
+	require.Greater(t, len(files), 2)
+
+	playlist := files[len(files)-1]
+	require.Equal(t, db.TrackFileHLSPlaylist, playlist.Kind)
+
+	var audioBytes int
+	for i, seg := range files[:len(files)-1] {
+		require.Equal(t, db.TrackFileHLSSegment, seg.Kind)
+		require.Equal(t, i, seg.Sequence)
+		require.LessOrEqual(t, seg.DurationMs, 7000)
+
+		data := readBlob(t, store, seg.File)
+		tag := audio.ID3v2Size(bytes.NewReader(data), int64(len(data)))
+		require.Greater(t, tag, int64(0), "segment %d lacks its timestamp tag", i)
+		_, ok := audio.ParseMP3FrameHeader(data[tag:])
+		require.True(t, ok, "segment %d does not start on a frame", i)
+		audioBytes += len(data) - int(tag)
+	}
+	require.Equal(t, frameBytes, audioBytes)
+
+	m3u8 := string(readBlob(t, store, playlist.File))
+	require.True(t, strings.HasPrefix(m3u8, "#EXTM3U\n"))
+	require.Contains(t, m3u8, "original/0.mp3\n")
+	require.True(t, strings.HasSuffix(m3u8, "#EXT-X-ENDLIST\n"))
+}
+
+func TestPackageHLSRejectsUnsupportedFormat(t *testing.T) {
+	store := storage.NewLocalStore(t.TempDir())
+	_, err := ingest.PackageHLS(store, bytes.NewReader(nil), 0, db.RenditionOriginal, "wav")
+	require.ErrorIs(t, err, ingest.ErrUnsupportedFormat)
+	require.Equal(t, 0, store.Writes())
+}
+
+// failingPlaylists is a store that refuses to save playlists.
+type failingPlaylists struct {
+	storage.FileSystem
+}
+
+func (s failingPlaylists) Save(data []byte, ext string) (string, error) {
+	if ext == "m3u8" {
+		return "", errors.New("store unavailable")
+	}
+	return s.FileSystem.Save(data, ext)
+}
+
+func TestPackageHLSLeavesSegmentsOfFailedPackaging(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+
+	// Segments are named by their content, so another track may hold the same
+	// ones; they are returned for the caller to schedule for removal.
+	store := storage.NewLocalStore(t.TempDir())
+	files, err := ingest.PackageHLS(failingPlaylists{store}, bytes.NewReader(src), int64(len(src)), db.RenditionOriginal, "mp3")
+	require.Error(t, err)
+	require.NotEmpty(t, files)
+	for _, seg := range files {
+		require.Equal(t, db.TrackFileHLSSegment, seg.Kind)
+		ok, err := store.Exists(seg.File)
+		require.NoError(t, err)
+		require.True(t, ok, "segment %d was removed", seg.Sequence)
+	}
+}
+
+// unrecordable is a TrackFileRepo whose writes fail.
+type unrecordable struct {
+	db.TrackFileRepo
+}
+
+func (unrecordable) ReplaceRendition(context.Context, uuid.UUID, string, []db.TrackFile) error {
+	return errors.New("database unavailable")
+}
+
+func TestServiceSchedulesRemovalOfUnrecordedOutput(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+
+	defer func(store storage.FileSystem) { storage.Store = store }(storage.Store)
+	store := storage.NewLocalStore(t.TempDir())
+	storage.Store = store
+	file, err := store.Save(src, "mp3")
+	require.NoError(t, err)
+
+	removals := &fakes.BlobRemovals{}
+	err = ingest.NewService(unrecordable{}, removals, nil, 1).Process(context.Background(), uuid.New(), file)
+	require.Error(t, err)
+
+	// The packaged blobs stay until a purge finds nothing else holds them.
+	require.Greater(t, len(removals.Scheduled), 2)
+	for _, removal := range removals.Scheduled {
+		ok, err := store.Exists(removal.File)
+		require.NoError(t, err)
+		require.True(t, ok, "%s was removed", removal.File)
+	}
+}
+
+func readBlob(t *testing.T, store storage.FileSystem, name string) []byte {
+	t.Helper()
+	f, err := store.Read(name)
+	require.NoError(t, err)
+	defer f.Close()
+	data := make([]byte, f.Size())
+	_, err = f.ReadAt(data, 0)
+	require.NoError(t, err)
+	return data
+}
@@ -0,0 +1,266 @@
+package tests
+
+import (
+	"auxstream/internal/db"
+	"auxstream/internal/ingest"
+	"auxstream/internal/storage"
+	"auxstream/tests/fakes"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// jobs is an UploadJobRepo holding a single job in memory, failing to record
+// file outcomes while fileErr is set.
+type jobs struct {
+	job     *db.UploadJob
+	fileErr error
+}
+
+func (j *jobs) CreateJob(_ context.Context, job *db.UploadJob) error {
+	job.Status = db.UploadJobQueued
+	j.job = job
+	return nil
+}
+
+func (j *jobs) GetJob(_ context.Context, _ uuid.UUID) (*db.UploadJob, error) {
+	return j.job, nil
+}
+
+func (j *jobs) ClaimJob(_ context.Context, _ time.Duration) (*db.UploadJob, error) {
+	if j.job == nil || j.job.Status != db.UploadJobQueued {
+		return nil, nil
+	}
+	j.job.Status = db.UploadJobProcessing
+	j.job.Attempts++
+	return j.job, nil
+}
+
+func (j *jobs) UpdateJob(_ context.Context, _ *db.UploadJob) error { return nil }
+
+func (j *jobs) UpdateJobFile(_ context.Context, _ *db.UploadJobFile) error { return j.fileErr }
+
+// tracks records bulk-created tracks, failing while err is set, and holds
+// the tracks found by checksum. Other TrackRepo methods are not used by the
+// queue.
+type tracks struct {
+	db.TrackRepo
+	created  []db.BulkTrackInput
+	err      error
+	existing map[string]*db.Track
+}
+
+func (t *tracks) GetTrackByChecksum(_ context.Context, checksum string) (*db.Track, error) {
+	if track, ok := t.existing[checksum]; ok {
+		return track, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (t *tracks) BulkCreateTracks(_ context.Context, inputs []db.BulkTrackInput, _ uuid.UUID) (int64, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	t.created = append(t.created, inputs...)
+	return int64(len(inputs)), nil
+}
+
+// enqueue stages each payload as one file of a new job for artistID.
+func enqueue(t *testing.T, queue *ingest.UploadQueue, artistID uuid.UUID, payloads map[string][]byte, order ...string) *db.UploadJob {
+	job := &db.UploadJob{ID: uuid.New(), ArtistID: &artistID}
+	for i, name := range order {
+		file := db.UploadJobFile{Position: i, Filename: name}
+		require.NoError(t, queue.Stage(context.Background(), &file, bytes.NewReader(payloads[name]), int64(len(payloads[name]))))
+		job.Files = append(job.Files, file)
+	}
+	require.NoError(t, queue.Enqueue(context.Background(), job))
+	return job
+}
+
+func TestUploadQueueSavesGoodFilesAndRejectsCorruptOnes(t *testing.T) {
+	storage.Store = storage.NewLocalStore(t.TempDir())
+	stagingDir := t.TempDir()
+	repo := &jobs{}
+	trackRepo := &tracks{}
+	removals := &fakes.BlobRemovals{}
+	queue := ingest.NewUploadQueue(repo, storage.NewLocalStager(stagingDir), trackRepo, nil, nil, removals, nil)
+
+	audioBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	job := enqueue(t, queue, uuid.New(), map[string][]byte{
+		"good.mp3": audioBytes,
+		"cut.mp3":  audioBytes[:len(audioBytes)/2],
+	}, "good.mp3", "cut.mp3")
+
+	found, err := queue.ProcessNext(context.Background())
+	require.NoError(t, err)
+	require.True(t, found)
+
+	require.Equal(t, db.UploadJobDone, job.Status)
+	require.NotNil(t, job.FinishedAt)
+	require.Len(t, trackRepo.created, 1)
+	require.Equal(t, "Impact Moderato", trackRepo.created[0].Title, "the title tag wins over the filename")
+
+	good, cut := job.Files[0], job.Files[1]
+	require.Equal(t, db.UploadFileSaved, good.Status)
+	require.Equal(t, trackRepo.created[0].ID, *good.TrackID)
+	require.Equal(t, db.UploadFileRejected, cut.Status)
+	require.Contains(t, cut.Error, "truncated")
+	require.Nil(t, cut.TrackID)
+
+	// Both outcomes are final, so nothing stays staged.
+	staged, err := os.ReadDir(stagingDir)
+	require.NoError(t, err)
+	require.Empty(t, staged)
+
+	found, err = queue.ProcessNext(context.Background())
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+func TestUploadQueueRetriesThenFailsJob(t *testing.T) {
+	storage.Store = storage.NewLocalStore(t.TempDir())
+	repo := &jobs{}
+	trackRepo := &tracks{err: errors.New("database is down")}
+	removals := &fakes.BlobRemovals{}
+	queue := ingest.NewUploadQueue(repo, storage.NewLocalStager(t.TempDir()), trackRepo, nil, nil, removals, nil)
+
+	audioBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	job := enqueue(t, queue, uuid.New(), map[string][]byte{"a.mp3": audioBytes}, "a.mp3")
+
+	_, err = queue.ProcessNext(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, db.UploadJobQueued, job.Status)
+	require.Contains(t, job.Error, "database is down")
+	require.True(t, job.AvailableAt.After(time.Now()), "a retry should back off")
+	require.Equal(t, db.UploadFileQueued, job.Files[0].Status)
+
+	for job.Status == db.UploadJobQueued {
+		_, err = queue.ProcessNext(context.Background())
+		require.NoError(t, err)
+	}
+	require.Equal(t, db.UploadJobFailed, job.Status)
+	require.Equal(t, 3, job.Attempts)
+	require.Equal(t, db.UploadFileFailed, job.Files[0].Status)
+	require.Empty(t, trackRepo.created)
+	// Every attempt stored the audio, and discarded it again.
+	require.Len(t, removals.Scheduled, 3)
+	require.Equal(t, removals.Scheduled[0].File, removals.Scheduled[2].File)
+}
+
+func TestUploadQueueRejectsFilesPastQuota(t *testing.T) {
+	storage.Store = storage.NewLocalStore(t.TempDir())
+	stagingDir := t.TempDir()
+	repo := &jobs{}
+	trackRepo := &tracks{err: &db.QuotaError{Usage: db.StorageUsage{Bytes: 1024, Tracks: 1000}, Limit: db.UsageLimit{Tracks: 1000}, Tracks: 1}}
+	removals := &fakes.BlobRemovals{}
+	queue := ingest.NewUploadQueue(repo, storage.NewLocalStager(stagingDir), trackRepo, nil, nil, removals, nil)
+
+	audioBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	job := enqueue(t, queue, uuid.New(), map[string][]byte{"a.mp3": audioBytes}, "a.mp3")
+
+	_, err = queue.ProcessNext(context.Background())
+	require.NoError(t, err)
+	// A retry could not make room, so the job is done on the first attempt.
+	require.Equal(t, db.UploadJobDone, job.Status)
+	require.Equal(t, 1, job.Attempts)
+	require.Equal(t, db.UploadFileRejected, job.Files[0].Status)
+	require.Contains(t, job.Files[0].Error, "storage quota exceeded")
+	require.Len(t, removals.Scheduled, 1)
+
+	staged, err := os.ReadDir(stagingDir)
+	require.NoError(t, err)
+	require.Empty(t, staged)
+}
+
+func TestUploadQueueNumbersAlbumTracks(t *testing.T) {
+	storage.Store = storage.NewLocalStore(t.TempDir())
+	repo := &jobs{}
+	trackRepo := &tracks{}
+	removals := &fakes.BlobRemovals{}
+	queue := ingest.NewUploadQueue(repo, storage.NewLocalStager(t.TempDir()), trackRepo, nil, nil, removals, nil)
+
+	mp3Bytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	wavBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.wav"))
+	require.NoError(t, err)
+	job := enqueue(t, queue, uuid.New(), map[string][]byte{
+		"one.mp3": mp3Bytes,
+		"two.wav": wavBytes,
+	}, "one.mp3", "two.wav")
+	albumID := uuid.New()
+	job.AlbumID = &albumID
+
+	_, err = queue.ProcessNext(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, db.UploadJobDone, job.Status)
+
+	// The files carry no track numbers, so their order in the upload is used.
+	require.Len(t, trackRepo.created, 2)
+	for i, in := range trackRepo.created {
+		require.Equal(t, albumID, in.AlbumID)
+		require.Equal(t, 1, in.DiscNumber)
+		require.Equal(t, i+1, in.TrackNumber)
+	}
+}
+
+func TestUploadQueueKeepsStagedFileUntilOutcomeRecorded(t *testing.T) {
+	storage.Store = storage.NewLocalStore(t.TempDir())
+	stagingDir := t.TempDir()
+	repo := &jobs{}
+	queue := ingest.NewUploadQueue(repo, storage.NewLocalStager(stagingDir), &tracks{}, nil, nil, &fakes.BlobRemovals{}, nil)
+
+	audioBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	job := enqueue(t, queue, uuid.New(), map[string][]byte{"cut.mp3": audioBytes[:len(audioBytes)/2]}, "cut.mp3")
+
+	repo.fileErr = errors.New("database is down")
+	_, err = queue.ProcessNext(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, db.UploadJobQueued, job.Status)
+	// The rejection was not recorded, so the next attempt needs the file.
+	staged, err := os.ReadDir(stagingDir)
+	require.NoError(t, err)
+	require.NotEmpty(t, staged)
+}
+
+func TestUploadQueueSkipsAudioAlreadyHeld(t *testing.T) {
+	storage.Store = storage.NewLocalStore(t.TempDir())
+	repo := &jobs{}
+	audioBytes, err := os.ReadFile(filepath.Join(testDataPath, "audio", "audio.mp3"))
+	require.NoError(t, err)
+	checksum, err := storage.ChecksumReader(bytes.NewReader(audioBytes))
+	require.NoError(t, err)
+	existing := &db.Track{ID: uuid.New(), Checksum: checksum}
+	trackRepo := &tracks{}
+	queue := ingest.NewUploadQueue(repo, storage.NewLocalStager(t.TempDir()), trackRepo, nil, nil, &fakes.BlobRemovals{}, nil)
+
+	// Within a job, only the first of two identical files is kept.
+	job := enqueue(t, queue, uuid.New(), map[string][]byte{"a.mp3": audioBytes, "b.mp3": audioBytes}, "a.mp3", "b.mp3")
+	_, err = queue.ProcessNext(context.Background())
+	require.NoError(t, err)
+	require.Len(t, trackRepo.created, 1)
+	require.Equal(t, checksum, trackRepo.created[0].Checksum)
+	require.Equal(t, db.UploadFileSaved, job.Files[0].Status)
+	require.Equal(t, db.UploadFileRejected, job.Files[1].Status)
+	require.Equal(t, "same audio as file 0", job.Files[1].Error)
+
+	// Audio a track holds already is saved as that track, not stored again.
+	trackRepo.existing = map[string]*db.Track{checksum: existing}
+	job = enqueue(t, queue, uuid.New(), map[string][]byte{"a.mp3": audioBytes}, "a.mp3")
+	_, err = queue.ProcessNext(context.Background())
+	require.NoError(t, err)
+	require.Len(t, trackRepo.created, 1)
+	require.Equal(t, db.UploadFileSaved, job.Files[0].Status)
+	require.Equal(t, existing.ID, *job.Files[0].TrackID)
+}
@@ -0,0 +1,24 @@
+package tests
+
+import (
+	"auxstream/internal/ingest"
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscodeProducesSeekableAAC(t *testing.T) {
+	transcoder, err := ingest.NewTranscoder("ffmpeg")
+	if err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+
+	out, err := transcoder.Transcode(context.Background(), filepath.Join(testDataPath, "audio", "audio.wav"), 64)
+	require.NoError(t, err)
+	// faststart puts the moov index ahead of the media data.
+	require.Equal(t, "ftyp", string(out[4:8]))
+	require.Less(t, bytes.Index(out, []byte("moov")), bytes.Index(out, []byte("mdat")))
+}
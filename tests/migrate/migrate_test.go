@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"auxstream/internal/db"
+	"auxstream/internal/migrate"
+	"auxstream/internal/storage"
+	"auxstream/tests/fakes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateCopiesVerifiesAndRepoints(t *testing.T) {
+	src := storage.NewLocalStore(t.TempDir())
+	bucket := fakes.NewS3(t, "tracks")
+	dst := storage.NewS3Store(storage.S3Config{Bucket: "tracks", Endpoint: bucket.URL, ForcePathStyle: true, KeyPrefix: "audio/"})
+
+	audio, err := src.Save([]byte("track audio"), "mp3")
+	require.NoError(t, err)
+	playlist, err := src.Save([]byte("#EXTM3U"), "m3u8")
+	require.NoError(t, err)
+	trackID := uuid.New()
+	refs := fakes.BlobRefs{
+		{Owner: db.BlobOwnerTrack, ID: trackID, TrackID: trackID, File: audio, Size: 11},
+		{Owner: db.BlobOwnerTrackFile, ID: uuid.New(), TrackID: trackID, File: playlist},
+		{Owner: db.BlobOwnerTrackFile, ID: uuid.New(), TrackID: trackID, File: audio},
+		{Owner: db.BlobOwnerThumbnail, ID: trackID, TrackID: trackID, File: "https://i.ytimg.com/vi/abc/hqdefault.jpg"},
+	}
+
+	// A dry run plans the copies and touches nothing.
+	report, err := migrate.Run(context.Background(), refs, src, dst, migrate.Options{DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, 3, report.References)
+	require.Equal(t, 2, report.Blobs)
+	require.Equal(t, 2, report.Copied)
+	require.Equal(t, 3, report.Rewritten)
+	require.Equal(t, 0, dst.Writes())
+	require.Equal(t, audio, refs[0].File)
+
+	report, err = migrate.Run(context.Background(), refs, src, dst, migrate.Options{Concurrency: 2, BatchSize: 2})
+	require.NoError(t, err)
+	require.True(t, report.Healthy())
+	require.Equal(t, 2, report.Copied)
+	require.Equal(t, 3, report.Rewritten)
+	require.Equal(t, "audio/"+audio, refs[0].File)
+	require.Equal(t, "audio/"+playlist, refs[1].File)
+	require.Equal(t, "audio/"+audio, refs[2].File)
+	require.Equal(t, "https://i.ytimg.com/vi/abc/hqdefault.jpg", refs[3].File)
+	stored, ok := bucket.Object("audio/" + audio)
+	require.True(t, ok)
+	require.Equal(t, "track audio", string(stored))
+
+	// Running again finds nothing left to do.
+	report, err = migrate.Run(context.Background(), refs, src, dst, migrate.Options{})
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Skipped)
+	require.Equal(t, 0, report.Copied)
+	require.Equal(t, 0, report.Rewritten)
+}
+
+func TestMigrateResumesAndRefusesDamagedBlobs(t *testing.T) {
+	dir := t.TempDir()
+	src := storage.NewLocalStore(dir)
+	bucket := fakes.NewS3(t, "tracks")
+	dst := storage.NewS3Store(storage.S3Config{Bucket: "tracks", Endpoint: bucket.URL, ForcePathStyle: true, KeyPrefix: "audio/"})
+
+	copied, err := src.Save([]byte("copied before the interruption"), "mp3")
+	require.NoError(t, err)
+	damaged, err := src.Save([]byte("original audio"), "mp3")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, damaged), []byte("bit-rotted audio"), 0o644))
+	// An interrupted run copied this blob but never repointed its row.
+	bucket.Put("audio/"+copied, []byte("copied before the interruption"))
+
+	refs := fakes.BlobRefs{
+		{Owner: db.BlobOwnerTrack, ID: uuid.New(), File: copied},
+		{Owner: db.BlobOwnerTrack, ID: uuid.New(), File: damaged},
+	}
+	report, err := migrate.Run(context.Background(), refs, src, dst, migrate.Options{})
+	require.NoError(t, err)
+
+	require.Equal(t, 1, report.Reused)
+	require.Equal(t, 0, report.Copied)
+	require.Equal(t, "audio/"+copied, refs[0].File)
+	require.False(t, report.Healthy())
+	require.Len(t, report.Failures, 1)
+	require.Equal(t, damaged, report.Failures[0].File)
+	require.Equal(t, migrate.ActionFailed, report.Failures[0].Action)
+	require.Equal(t, damaged, refs[1].File)
+	_, ok := bucket.Object("audio/" + storage.ContentName([]byte("bit-rotted audio"), "mp3"))
+	require.False(t, ok)
+}
+
+func TestMigrateCopyOnlyLeavesRowsAlone(t *testing.T) {
+	src := storage.NewLocalStore(t.TempDir())
+	bucket := fakes.NewS3(t, "tracks")
+	dst := storage.NewS3Store(storage.S3Config{Bucket: "tracks", Endpoint: bucket.URL, ForcePathStyle: true, KeyPrefix: "audio/"})
+
+	audio, err := src.Save([]byte("track audio"), "mp3")
+	require.NoError(t, err)
+	trackID := uuid.New()
+	refs := fakes.BlobRefs{{Owner: db.BlobOwnerTrack, ID: trackID, TrackID: trackID, File: audio}}
+
+	report, err := migrate.Run(context.Background(), refs, src, dst, migrate.Options{CopyOnly: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Copied)
+	require.Equal(t, 1, report.Rewritten)
+	require.Equal(t, audio, refs[0].File)
+	_, ok := bucket.Object("audio/" + audio)
+	require.True(t, ok)
+
+	// The repointing run reuses the copy.
+	report, err = migrate.Run(context.Background(), refs, src, dst, migrate.Options{})
+	require.NoError(t, err)
+	require.Equal(t, 0, report.Copied)
+	require.Equal(t, 1, report.Reused)
+	require.Equal(t, "audio/"+audio, refs[0].File)
+}
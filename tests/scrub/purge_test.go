@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"auxstream/internal/db"
+	"auxstream/internal/scrub"
+	"auxstream/internal/storage"
+	"auxstream/tests/fakes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeRemovesDueBlobs(t *testing.T) {
+	dir := t.TempDir()
+	store := storage.NewLocalStore(dir)
+
+	deleted, err := store.Save([]byte("deleted track"), "mp3")
+	require.NoError(t, err)
+	reuploaded, err := store.Save([]byte("uploaded again"), "mp3")
+	require.NoError(t, err)
+	recent, err := store.Save([]byte("deleted a moment ago"), "mp3")
+	require.NoError(t, err)
+	gone := storage.ContentName([]byte("removed by hand"), "mp3")
+
+	now := time.Now()
+	due := now.Add(-time.Hour)
+	removals := &fakes.BlobRemovals{
+		Scheduled: []db.BlobRemoval{
+			{ID: uuid.New(), File: deleted, RemoveAfter: due},
+			{ID: uuid.New(), File: reuploaded, RemoveAfter: due},
+			{ID: uuid.New(), File: gone, RemoveAfter: due},
+			{ID: uuid.New(), File: recent, RemoveAfter: now.Add(db.BlobRemovalGrace)},
+		},
+		InUse: map[string]bool{reuploaded: true},
+	}
+
+	report, err := scrub.Purge(context.Background(), removals, store, now)
+	require.NoError(t, err)
+
+	require.Equal(t, []scrub.Removal{
+		{File: deleted, Action: scrub.RemovalRemoved},
+		{File: reuploaded, Action: scrub.RemovalKept},
+		{File: gone, Action: scrub.RemovalRemoved},
+	}, report.Removals)
+	require.Zero(t, report.Failed())
+
+	require.NoFileExists(t, filepath.Join(dir, deleted))
+	require.FileExists(t, filepath.Join(dir, reuploaded))
+	require.FileExists(t, filepath.Join(dir, recent))
+	// Only the removal still in its grace period is left scheduled.
+	require.Len(t, removals.Scheduled, 1)
+	require.Equal(t, recent, removals.Scheduled[0].File)
+}
+
+// cancelling lists the removals due, then has each cancelled, as by an upload
+// of the same content, before the purge gets to it.
+type cancelling struct {
+	*fakes.BlobRemovals
+}
+
+func (r cancelling) EachDueBlobRemoval(ctx context.Context, now time.Time, fn func(db.BlobRemoval) error) error {
+	due := r.Scheduled
+	r.Scheduled = nil
+	for _, removal := range due {
+		if err := fn(removal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestPurgeSkipsCancelledRemovals(t *testing.T) {
+	dir := t.TempDir()
+	store := storage.NewLocalStore(dir)
+	uploaded, err := store.Save([]byte("uploaded again during the purge"), "mp3")
+	require.NoError(t, err)
+
+	removals := cancelling{&fakes.BlobRemovals{Scheduled: []db.BlobRemoval{
+		{ID: uuid.New(), File: uploaded, RemoveAfter: time.Now().Add(-time.Hour)},
+	}}}
+	report, err := scrub.Purge(context.Background(), removals, store, time.Now())
+	require.NoError(t, err)
+
+	require.Equal(t, []scrub.Removal{{File: uploaded, Action: scrub.RemovalKept}}, report.Removals)
+	require.FileExists(t, filepath.Join(dir, uploaded))
+}
+
+func TestScrubIgnoresPurgedBlobsOfDeletedRows(t *testing.T) {
+	store := storage.NewLocalStore(t.TempDir())
+	purged := storage.ContentName([]byte("purged"), "mp3")
+
+	trackID := uuid.New()
+	report, err := scrub.Run(context.Background(), fakes.BlobRefs{
+		{Owner: db.BlobOwnerTrack, ID: trackID, TrackID: trackID, File: purged, Deleted: true},
+	}, store, scrub.Options{})
+	require.NoError(t, err)
+
+	require.Empty(t, report.Problems)
+	require.True(t, report.Healthy())
+}
@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"auxstream/internal/db"
+	"auxstream/internal/scrub"
+	"auxstream/internal/storage"
+	"auxstream/tests/fakes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrubReportsDamagedMissingAndOrphanedBlobs(t *testing.T) {
+	dir := t.TempDir()
+	store := storage.NewLocalStore(dir)
+
+	good, err := store.Save([]byte("intact audio"), "mp3")
+	require.NoError(t, err)
+	damaged, err := store.Save([]byte("original audio"), "mp3")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, damaged), []byte("bit-rotted audio"), 0o644))
+	orphan, err := store.Save([]byte("nobody refers to me"), "mp3")
+	require.NoError(t, err)
+
+	trackID := uuid.New()
+	report, err := scrub.Run(context.Background(), fakes.BlobRefs{
+		{Owner: db.BlobOwnerTrack, ID: trackID, TrackID: trackID, File: good, Size: 12},
+		{Owner: db.BlobOwnerTrackFile, ID: uuid.New(), TrackID: trackID, File: good},
+		{Owner: db.BlobOwnerTrack, ID: uuid.New(), File: damaged},
+		{Owner: db.BlobOwnerTrack, ID: uuid.New(), File: storage.ContentName([]byte("gone"), "mp3")},
+		{Owner: db.BlobOwnerThumbnail, ID: trackID, File: "https://i.ytimg.com/vi/abc/hqdefault.jpg"},
+	}, store, scrub.Options{Concurrency: 2})
+	require.NoError(t, err)
+
+	require.Equal(t, 5, report.References)
+	require.Equal(t, 3, report.Blobs) // the shared blob is read once; the external thumbnail not at all
+	require.Len(t, report.Problems, 2)
+	statuses := map[string]string{}
+	for _, p := range report.Problems {
+		statuses[p.File] = p.Status
+	}
+	require.Equal(t, scrub.StatusChecksumMismatch, statuses[damaged])
+	require.Equal(t, scrub.StatusMissing, statuses[storage.ContentName([]byte("gone"), "mp3")])
+
+	require.Equal(t, "done", report.OrphanScan)
+	require.Len(t, report.Orphans, 1)
+	require.Equal(t, orphan, report.Orphans[0].Name)
+	require.Equal(t, scrub.OrphanReport, report.Orphans[0].Action)
+	require.False(t, report.Healthy())
+}
+
+func TestScrubQuarantinesOrphans(t *testing.T) {
+	dir := t.TempDir()
+	store := storage.NewLocalStore(dir)
+	quarantine := storage.NewLocalStore(t.TempDir())
+
+	orphan, err := store.Save([]byte("stray"), "m4a")
+	require.NoError(t, err)
+	fresh, err := store.Save([]byte("upload in flight"), "mp3")
+	require.NoError(t, err)
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, orphan), old, old))
+
+	report, err := scrub.Run(context.Background(), fakes.BlobRefs{}, store, scrub.Options{
+		Orphans:      scrub.OrphanQuarantine,
+		Quarantine:   quarantine,
+		MinOrphanAge: time.Hour,
+	})
+	require.NoError(t, err)
+
+	require.Len(t, report.Orphans, 1)
+	require.Equal(t, scrub.OrphanQuarantine, report.Orphans[0].Action)
+	require.Equal(t, orphan, report.Orphans[0].Moved)
+	require.NoFileExists(t, filepath.Join(dir, orphan))
+	require.FileExists(t, filepath.Join(dir, fresh))
+
+	file, err := quarantine.Read(report.Orphans[0].Moved)
+	require.NoError(t, err)
+	defer file.Close()
+	require.Equal(t, int64(len("stray")), file.Size())
+}
@@ -0,0 +1,264 @@
+package tests
+
+import (
+	store "auxstream/internal/storage"
+	"auxstream/tests/fakes"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newKey(t *testing.T, id string) string {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return id + ":" + base64.StdEncoding.EncodeToString(key)
+}
+
+func mustKeyring(t *testing.T, spec string) *store.Keyring {
+	keys, err := store.ParseKeyring(spec)
+	require.NoError(t, err)
+	return keys
+}
+
+func readRange(t *testing.T, st store.FileSystem, name string, off, length int64) []byte {
+	body, err := st.OpenRange(name, off, length)
+	require.NoError(t, err)
+	defer body.Close()
+	got, err := io.ReadAll(body)
+	require.NoError(t, err)
+	return got
+}
+
+func TestEncryptedStoreSealsLocalBlobs(t *testing.T) {
+	dir := t.TempDir()
+	encrypted, err := store.NewEncryptedStore(store.NewLocalStore(dir), mustKeyring(t, newKey(t, "k1")))
+	require.NoError(t, err)
+
+	// Spans several chunks, ending part way through one.
+	content := make([]byte, 200_000)
+	_, err = rand.Read(content)
+	require.NoError(t, err)
+
+	name, err := encrypted.SaveStream(bytes.NewReader(content), int64(len(content)), "mp3")
+	require.NoError(t, err)
+	require.Equal(t, store.ContentName(content, "mp3"), name)
+
+	onDisk, err := os.ReadFile(filepath.Join(dir, name))
+	require.NoError(t, err)
+	require.Greater(t, len(onDisk), len(content))
+	require.False(t, bytes.Contains(onDisk, content[:64]), "blob stored in the clear")
+	_, err = os.Stat(filepath.Join(dir, name+".key"))
+	require.NoError(t, err)
+
+	info, err := encrypted.Stat(name)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), info.Size)
+
+	file, err := encrypted.Read(name)
+	require.NoError(t, err)
+	got, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	require.Equal(t, content, got)
+
+	for _, r := range []struct{ off, length int64 }{
+		{0, 10},
+		{65530, 20},  // across a chunk boundary
+		{131072, -1}, // from a chunk boundary to the end
+		{199_990, 100},
+		{5, 150_000},
+	} {
+		want := content[r.off:]
+		if r.length >= 0 && r.length < int64(len(want)) {
+			want = want[:r.length]
+		}
+		require.Equal(t, want, readRange(t, encrypted, name, r.off, r.length), "range %d+%d", r.off, r.length)
+	}
+
+	// Saving the same content again keeps its key, and so its ciphertext.
+	again, err := encrypted.Save(content, "mp3")
+	require.NoError(t, err)
+	require.Equal(t, name, again)
+	rewritten, err := os.ReadFile(filepath.Join(dir, name))
+	require.NoError(t, err)
+	require.Equal(t, onDisk, rewritten)
+
+	var listed []store.BlobInfo
+	require.NoError(t, encrypted.List(context.Background(), func(b store.BlobInfo) error {
+		listed = append(listed, b)
+		return nil
+	}))
+	require.Len(t, listed, 1)
+	require.Equal(t, name, listed[0].Name)
+	require.Equal(t, int64(len(content)), listed[0].Size)
+
+	require.NoError(t, encrypted.Remove(name))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestEncryptedStoreConcurrentFirstSaves(t *testing.T) {
+	dir, keys := t.TempDir(), newKey(t, "k1")
+	encrypted, err := store.NewEncryptedStore(store.NewLocalStore(dir), mustKeyring(t, keys))
+	require.NoError(t, err)
+
+	// Saves racing to store a new blob must agree on one data key.
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := encrypted.Save([]byte("raced audio"), "mp3")
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// Read through a fresh store, so the key comes from disk.
+	fresh, err := store.NewEncryptedStore(store.NewLocalStore(dir), mustKeyring(t, keys))
+	require.NoError(t, err)
+	require.Equal(t, []byte("raced audio"), readRange(t, fresh, store.ContentName([]byte("raced audio"), "mp3"), 0, -1))
+}
+
+func TestEncryptedStoreSavesAgainAfterRemovalElsewhere(t *testing.T) {
+	dir, keys := t.TempDir(), newKey(t, "k1")
+	encrypted, err := store.NewEncryptedStore(store.NewLocalStore(dir), mustKeyring(t, keys))
+	require.NoError(t, err)
+	name, err := encrypted.Save([]byte("purged audio"), "mp3")
+	require.NoError(t, err)
+
+	// Another process removes the blob and its key; this one still caches the
+	// key, and saving the content again must store it anew.
+	other, err := store.NewEncryptedStore(store.NewLocalStore(dir), mustKeyring(t, keys))
+	require.NoError(t, err)
+	require.NoError(t, other.Remove(name))
+	again, err := encrypted.Save([]byte("purged audio"), "mp3")
+	require.NoError(t, err)
+	require.Equal(t, name, again)
+	_, err = os.Stat(filepath.Join(dir, name+".key"))
+	require.NoError(t, err)
+
+	fresh, err := store.NewEncryptedStore(store.NewLocalStore(dir), mustKeyring(t, keys))
+	require.NoError(t, err)
+	require.Equal(t, []byte("purged audio"), readRange(t, fresh, name, 0, -1))
+
+	// A key file whose blob is already gone is removed all the same.
+	require.NoError(t, os.Remove(filepath.Join(dir, name)))
+	require.ErrorIs(t, fresh.Remove(name), os.ErrNotExist)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestEncryptedStoreDetectsTampering(t *testing.T) {
+	dir := t.TempDir()
+	encrypted, err := store.NewEncryptedStore(store.NewLocalStore(dir), mustKeyring(t, newKey(t, "k1")))
+	require.NoError(t, err)
+	name, err := encrypted.Save([]byte("licensed audio"), "mp3")
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, name)
+	sealed, err := os.ReadFile(path)
+	require.NoError(t, err)
+	sealed[3] ^= 0xff
+	require.NoError(t, os.WriteFile(path, sealed, 0o644))
+
+	_, err = encrypted.Read(name)
+	require.ErrorContains(t, err, "decrypt chunk 0")
+
+	// Without the master key that wrapped it, the blob cannot be opened.
+	other, err := store.NewEncryptedStore(store.NewLocalStore(dir), mustKeyring(t, newKey(t, "k2")))
+	require.NoError(t, err)
+	_, err = other.OpenRange(name, 0, -1)
+	require.ErrorContains(t, err, `unknown master key "k1"`)
+}
+
+func TestEncryptedStoreOverS3(t *testing.T) {
+	bucket := fakes.NewS3(t, "tracks")
+	s3Store := store.NewS3Store(store.S3Config{Bucket: "tracks", Endpoint: bucket.URL, ForcePathStyle: true, KeyPrefix: "audio/"})
+	keys := newKey(t, "k1")
+	encrypted, err := store.NewEncryptedStore(s3Store, mustKeyring(t, keys))
+	require.NoError(t, err)
+
+	name, err := encrypted.Save([]byte("bucket audio"), "mp3")
+	require.NoError(t, err)
+	require.Equal(t, "audio/"+store.ContentName([]byte("bucket audio"), "mp3"), name)
+
+	sealed, ok := bucket.Object(name)
+	require.True(t, ok)
+	require.NotContains(t, string(sealed), "bucket audio")
+	_, ok = bucket.Object(name + ".key")
+	require.True(t, ok)
+
+	// Another instance saving the same content reuses the stored key, so the
+	// object it writes is the same.
+	peer, err := store.NewEncryptedStore(s3Store, mustKeyring(t, keys))
+	require.NoError(t, err)
+	_, err = peer.Save([]byte("bucket audio"), "mp3")
+	require.NoError(t, err)
+	resealed, _ := bucket.Object(name)
+	require.Equal(t, sealed, resealed)
+
+	require.Equal(t, []byte("audio"), readRange(t, encrypted, name, 7, 5))
+}
+
+func TestEncryptedStoreRotateKeys(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, newKeySpec := newKey(t, "2025"), newKey(t, "2026")
+	before, err := store.NewEncryptedStore(store.NewLocalStore(dir), mustKeyring(t, oldKey))
+	require.NoError(t, err)
+	name, err := before.Save([]byte("rotated audio"), "mp3")
+	require.NoError(t, err)
+
+	rotating, err := store.NewEncryptedStore(store.NewLocalStore(dir), mustKeyring(t, newKeySpec+","+oldKey))
+	require.NoError(t, err)
+	var moved []string
+	rotated, seen, err := rotating.RotateKeys(context.Background(), true, func(name, from, to string) {
+		moved = append(moved, name+":"+from+"->"+to)
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, rotated)
+	require.Equal(t, 1, seen)
+	require.Equal(t, []string{name + ":2025->2026"}, moved)
+
+	rotated, _, err = rotating.RotateKeys(context.Background(), false, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, rotated)
+	rotated, _, err = rotating.RotateKeys(context.Background(), false, nil)
+	require.NoError(t, err)
+	require.Zero(t, rotated)
+
+	// The old key is no longer needed.
+	after, err := store.NewEncryptedStore(store.NewLocalStore(dir), mustKeyring(t, newKeySpec))
+	require.NoError(t, err)
+	require.Equal(t, []byte("rotated audio"), readRange(t, after, name, 0, -1))
+}
+
+func TestParseKeyring(t *testing.T) {
+	keys, err := store.ParseKeyring(newKey(t, "new") + ", " + newKey(t, "old"))
+	require.NoError(t, err)
+	require.Equal(t, "new", keys.Active())
+
+	for _, spec := range []string{
+		"",
+		"nokey",
+		"short:" + base64.StdEncoding.EncodeToString([]byte("too short")),
+		newKey(t, "dup") + "," + newKey(t, "dup"),
+	} {
+		_, err := store.ParseKeyring(spec)
+		require.Error(t, err, spec)
+	}
+
+	_, err = store.NewEncryptedStore(&store.ReplicatedStore{}, keys)
+	require.Error(t, err)
+}
@@ -3,7 +3,9 @@ package tests
 import (
 	"auxstream/internal/storage"
 	store "auxstream/internal/storage"
+	"bytes"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -57,8 +59,79 @@ func TestBulkSave(t *testing.T) {
 	}
 	require.Equal(t, 10, lstore.Writes())
 	require.Equal(t, 10, len(fileNames))
+	// Identical content is stored once, under one name.
 	for _, fileName := range fileNames {
-		err := lstore.Remove(fileName)
-		require.NoError(t, err)
+		require.Equal(t, fileNames[0], fileName)
 	}
+	require.NoError(t, lstore.Remove(fileNames[0]))
+}
+
+func TestSaveIsContentAddressed(t *testing.T) {
+	var lstore store.FileSystem = store.NewLocalStore(baseLocation)
+	content := []byte("same bytes")
+
+	file1, err := lstore.Save(content, "mp3")
+	require.NoError(t, err)
+	defer lstore.Remove(file1)
+	file2, err := lstore.Save(content, "mp3")
+	require.NoError(t, err)
+	require.Equal(t, file1, file2)
+	require.Equal(t, store.Checksum(content)+".mp3", file1)
+
+	other, err := lstore.Save([]byte("other bytes"), "mp3")
+	require.NoError(t, err)
+	defer lstore.Remove(other)
+	require.NotEqual(t, file1, other)
+}
+
+func TestSaveStream(t *testing.T) {
+	dir := t.TempDir()
+	lstore := store.NewLocalStore(dir)
+	content := bytes.Repeat([]byte("streamed audio "), 1<<16)
+
+	fileName, err := lstore.SaveStream(bytes.NewReader(content), int64(len(content)), "flac")
+	require.NoError(t, err)
+	require.Equal(t, store.ContentName(content, "flac"), fileName)
+	stored, err := os.ReadFile(filepath.Join(dir, fileName))
+	require.NoError(t, err)
+	require.Equal(t, content, stored)
+
+	// A reader that ends early stores nothing, not even a temporary file.
+	_, err = lstore.SaveStream(bytes.NewReader(content[:10]), int64(len(content)), "flac")
+	require.Error(t, err)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestLocalStoreRangedReads(t *testing.T) {
+	lstore := store.NewLocalStore(t.TempDir())
+	fileName, err := lstore.Save([]byte("0123456789"), "mp3")
+	require.NoError(t, err)
+
+	info, err := lstore.Stat(fileName)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), info.Size)
+	ok, err := lstore.Exists(fileName)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = lstore.Exists("missing.mp3")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	body, err := lstore.OpenRange(fileName, 2, 3)
+	require.NoError(t, err)
+	got := new(bytes.Buffer)
+	_, err = got.ReadFrom(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	require.Equal(t, "234", got.String())
+
+	body, err = lstore.OpenRange(fileName, 7, -1)
+	require.NoError(t, err)
+	got.Reset()
+	_, err = got.ReadFrom(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	require.Equal(t, "789", got.String())
 }
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	store "auxstream/internal/storage"
+	"auxstream/tests/fakes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicatedStoreCopiesToSecondariesAndFallsBack(t *testing.T) {
+	primaryDir, secondaryDir := t.TempDir(), t.TempDir()
+	replicated, err := store.NewReplicatedStore(store.ReplicatedConfig{
+		Primary:     store.NewLocalStore(primaryDir),
+		Secondaries: []store.FileSystem{store.NewLocalStore(secondaryDir)},
+	})
+	require.NoError(t, err)
+
+	name, err := replicated.Save([]byte("replicated audio"), "mp3")
+	require.NoError(t, err)
+	require.Equal(t, store.ContentName([]byte("replicated audio"), "mp3"), name)
+	require.NoError(t, replicated.Close()) // waits for the copy
+
+	copied, err := os.ReadFile(filepath.Join(secondaryDir, name))
+	require.NoError(t, err)
+	require.Equal(t, "replicated audio", string(copied))
+
+	// With the primary's copy lost, reads are served by the secondary.
+	require.NoError(t, os.Remove(filepath.Join(primaryDir, name)))
+	file, err := replicated.Read(name)
+	require.NoError(t, err)
+	got, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	require.Equal(t, "replicated audio", string(got))
+
+	_, err = replicated.Read(store.ContentName([]byte("never saved"), "mp3"))
+	require.Error(t, err)
+}
+
+func TestReplicatedStoreCachesRemoteReads(t *testing.T) {
+	bucket := fakes.NewS3(t, "tracks")
+	s3Store := store.NewS3Store(store.S3Config{Bucket: "tracks", Endpoint: bucket.URL, ForcePathStyle: true})
+	cacheDir := t.TempDir()
+	replicated, err := store.NewReplicatedStore(store.ReplicatedConfig{
+		Primary:       s3Store,
+		CacheDir:      cacheDir,
+		CacheMaxBytes: 20,
+	})
+	require.NoError(t, err)
+	defer replicated.Close()
+
+	hot, err := replicated.Save([]byte("hot track audio"), "mp3")
+	require.NoError(t, err)
+
+	for range 3 {
+		file, err := replicated.Read(hot)
+		require.NoError(t, err)
+		got, err := io.ReadAll(file)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+		require.Equal(t, "hot track audio", string(got))
+	}
+	require.Equal(t, 1, s3Store.Reads()) // downloaded once, then served from the cache
+	require.Equal(t, 3, replicated.Reads())
+
+	// Caching a second blob pushes the cache past its bound, evicting the first.
+	other, err := replicated.Save([]byte("another track"), "mp3")
+	require.NoError(t, err)
+	file, err := replicated.Read(other)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	entries, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, store.BlobKey(other)+".mp3", entries[0].Name())
+}
@@ -2,13 +2,143 @@ package tests
 
 import (
 	store "auxstream/internal/storage"
+	"auxstream/tests/fakes"
+	"context"
+	"io"
+	"net/http"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 )
 
 func TestCreates3Store(t *testing.T) {
-	var s3Store store.FileSystem = store.NewS3Store("test-bucket")
+	var s3Store store.FileSystem = store.NewS3Store(store.S3Config{Bucket: "test-bucket"})
 	require.Equal(t, 0, s3Store.Reads())
 	require.Equal(t, 0, s3Store.Writes())
 }
+
+func TestS3StoreKeysRoundTrip(t *testing.T) {
+	bucket := fakes.NewS3(t, "tracks")
+	s3Store := store.NewS3Store(store.S3Config{
+		Bucket:          "tracks",
+		Endpoint:        bucket.URL,
+		ForcePathStyle:  true,
+		KeyPrefix:       "audio/",
+		AccessKeyID:     "minio",
+		SecretAccessKey: "minio-secret",
+	})
+	content := []byte("hello world")
+
+	key, err := s3Store.Save(content, "mp3")
+	require.NoError(t, err)
+	require.Equal(t, "audio/"+store.ContentName(content, "mp3"), key)
+	stored, ok := bucket.Object(key)
+	require.True(t, ok)
+	require.Equal(t, content, stored)
+
+	file, err := s3Store.Read(key)
+	require.NoError(t, err)
+	got, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	require.Equal(t, content, got)
+
+	// Objects outside the prefix, and staged uploads, are not the store's blobs.
+	bucket.Put("other/data.bin", []byte("not ours"))
+	bucket.Put("audio/.staging/upload", []byte("in flight"))
+	var listed []string
+	require.NoError(t, s3Store.List(context.Background(), func(b store.BlobInfo) error {
+		listed = append(listed, b.Name)
+		return nil
+	}))
+	require.Equal(t, []string{key}, listed)
+
+	require.NoError(t, s3Store.Remove(key))
+	_, ok = bucket.Object(key)
+	require.False(t, ok)
+}
+
+func TestS3StoreAcceptsLegacyLocations(t *testing.T) {
+	bucket := fakes.NewS3(t, "tracks")
+	s3Store := store.NewS3Store(store.S3Config{Bucket: "tracks", Endpoint: bucket.URL, ForcePathStyle: true})
+	bucket.Put("old.mp3", []byte("saved before keys"))
+
+	file, err := s3Store.Read(bucket.URL + "/tracks/old.mp3")
+	require.NoError(t, err)
+	got, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	require.Equal(t, "saved before keys", string(got))
+
+	require.NoError(t, s3Store.Remove(bucket.URL+"/tracks/old.mp3"))
+	_, ok := bucket.Object("old.mp3")
+	require.False(t, ok)
+}
+
+func TestS3StoreKeyFromLocation(t *testing.T) {
+	s3Store := store.NewS3Store(store.S3Config{Bucket: "tracks", Region: "eu-west-1"})
+	for location, want := range map[string]string{
+		"https://tracks.s3.eu-west-1.amazonaws.com/abc.mp3":       "abc.mp3",
+		"https://s3.eu-west-1.amazonaws.com/tracks/audio/abc.mp3": "audio/abc.mp3",
+		"http://minio:9000/tracks/my%20song.mp3":                  "my song.mp3",
+	} {
+		key, ok := s3Store.KeyFromLocation(location)
+		require.True(t, ok, location)
+		require.Equal(t, want, key, location)
+	}
+	for _, location := range []string{
+		"abc.mp3",
+		"audio/abc.mp3",
+		"https://other.s3.amazonaws.com/abc.mp3",
+		"https://i.ytimg.com/vi/abc/hqdefault.jpg",
+		"https://tracks.s3.amazonaws.com/",
+	} {
+		_, ok := s3Store.KeyFromLocation(location)
+		require.False(t, ok, location)
+	}
+}
+
+func TestS3StoreRangedReads(t *testing.T) {
+	bucket := fakes.NewS3(t, "tracks")
+	s3Store := store.NewS3Store(store.S3Config{Bucket: "tracks", Endpoint: bucket.URL, ForcePathStyle: true})
+	content := []byte("0123456789abcdefghij")
+	key, err := s3Store.Save(content, "mp3")
+	require.NoError(t, err)
+
+	info, err := s3Store.Stat(key)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), info.Size)
+	ok, err := s3Store.Exists(key)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = s3Store.Exists("missing.mp3")
+	require.NoError(t, err)
+	require.False(t, ok)
+	_, err = s3Store.Stat("missing.mp3")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	body, err := s3Store.OpenRange(key, 5, 4)
+	require.NoError(t, err)
+	got, err := io.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	require.Equal(t, "5678", string(got))
+
+	blob, err := store.Open(s3Store, key)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), blob.Size())
+	_, err = blob.Seek(-5, io.SeekEnd)
+	require.NoError(t, err)
+	got, err = io.ReadAll(blob)
+	require.NoError(t, err)
+	require.Equal(t, "fghij", string(got))
+	part := make([]byte, 3)
+	_, err = blob.ReadAt(part, 10)
+	require.NoError(t, err)
+	require.Equal(t, "abc", string(part))
+	require.NoError(t, blob.Close())
+
+	require.Equal(t, 0, s3Store.Reads()) // nothing was downloaded whole
+	require.Equal(t, 3, bucket.Requests(http.MethodGet))
+}